@@ -0,0 +1,206 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+var adjustmentType = "adjustment" //composite key object type for manual corrections, keyed by entity, timestamp then id
+
+// AdjustLimits are the reason codes and cap that apply to manual balance corrections
+type AdjustLimits struct {
+	Reasons []string `json:"reasons"` //reason codes an adjustment must give, no codes disables adjust_balance
+	Max     float64  `json:"max"`     //largest correction in either direction, 0 is no cap
+}
+
+// AdjustmentRecord is the journal of one manual correction, kept apart from the txn history so reviewers can list them
+type AdjustmentRecord struct {
+	ID        string  `json:"id"`
+	Entity    string  `json:"entity"`
+	Points    float64 `json:"points"` //negative for a debit
+	Reason    string  `json:"reason"`
+	Note      string  `json:"note"`
+	Actor     string  `json:"actor"` //caller fingerprint
+	TxID      string  `json:"txid"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// ============================================================================================================================
+// Set Adjustment Policy - admin only, the cap on manual corrections and the reason codes they may give
+// ============================================================================================================================
+func (t *SimpleChaincode) setAdjustmentPolicy(stub *programStub, args []string) ([]byte, error) {
+	//    0         1          2
+	// "Max", "ReasonCode", "ReasonCode", ...
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 1")
+	}
+	max, err := parseAmount(args[0])
+	if (err != nil) || (max < 0) {
+		return nil, amountError("1st argument must be a non-negative numeric string", err)
+	}
+	for _, code := range args[1:] {
+		if len(code) <= 0 {
+			return nil, errors.New("Reason codes must be non-empty strings")
+		}
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.Adjustments = AdjustLimits{args[1:], max}
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Adjust Balance - admin only, credit or with a negative amount debit an entity to correct a mistake. The reason code
+// must be one of the configured codes and the note says what is being corrected.
+// ============================================================================================================================
+func (t *SimpleChaincode) adjustBalance(stub *programStub, args []string) ([]byte, error) {
+	//   0        1           2          3            4
+	// "Name", "Points", "ReasonCode", "Note" *"ExpectedVersion"*
+	if len(args) != 4 && len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 or 5")
+	}
+	points, err := parseAmount(args[1])
+	if (err != nil) || (points == 0) {
+		return nil, amountError("2nd argument must be a non-zero numeric string", err)
+	}
+	if len(args[3]) <= 0 {
+		return nil, errors.New("4th argument must be a non-empty string")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	known := false
+	for _, code := range config.Adjustments.Reasons {
+		if code == args[2] {
+			known = true
+		}
+	}
+	if !known {
+		return nil, errors.New("3rd argument must be a configured adjustment reason code")
+	}
+	if config.Adjustments.Max > 0 && math.Abs(points) > config.Adjustments.Max {
+		return nil, errors.New("Adjustments are capped at " + formatAmount(config.Adjustments.Max) + " points")
+	}
+	if points > 0 {
+		err = checkDirectMint(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 5 {
+		err = checkVersion(entity, args[4])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if points < 0 && transferablePoints(entity) < -points {
+		return nil, errors.New("Insufficient points")
+	}
+	actor, err := callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start adjust balance")
+	before := entity
+	entity.PtBal = entity.PtBal + points
+	err = putEntity(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustment := AdjustmentRecord{newID(stub, adjustmentType), entity.Name, points, args[2], args[3], actor, stub.UUID, timestamp}
+	key, err := createCompositeKey(adjustmentType, []string{entity.Name, timestampKey(timestamp), adjustment.ID})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(adjustment)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := TxnRecord{Type: "adjust", To: entity.Name, Points: points, Reference: adjustment.ID + ":" + args[2]}
+	if points < 0 {
+		rec = TxnRecord{Type: "adjust", From: entity.Name, Points: -points, Reference: adjustment.ID + ":" + args[2]}
+	}
+	err = recordTxn(stub, rec, balanceChange(before, entity))
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(stub, points, "adjust")
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end adjust balance")
+	return []byte(adjustment.ID), nil
+}
+
+// ============================================================================================================================
+// List Adjustments - admin only, every manual correction made to an entity, oldest first
+// ============================================================================================================================
+func (t *SimpleChaincode) listAdjustments(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the entity to query")
+	}
+	name, err := resolveAlias(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, adjustmentType, []string{name})
+	if err != nil {
+		return nil, errors.New("Failed to get adjustments")
+	}
+	defer keysIter.Close()
+
+	adjustments := []AdjustmentRecord{}
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get adjustments")
+		}
+		var adjustment AdjustmentRecord
+		err = unmarshalState(key, valAsBytes, &adjustment)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, adjustment)
+	}
+	jsonAsBytes, _ := json.Marshal(adjustments)
+	return jsonAsBytes, nil
+}
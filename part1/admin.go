@@ -0,0 +1,150 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var adminRole = "admin" //value of the "role" certificate attribute that grants admin rights
+
+// ============================================================================================================================
+// callerID - sha256 fingerprint of the caller's certificate, identifies an individual admin rather than the role
+// ============================================================================================================================
+func callerID(stub *programStub) (string, error) {
+	cert, err := stub.GetCallerCertificate()
+	if err != nil || len(cert) == 0 {
+		return "", errors.New("Caller certificate not available")
+	}
+	sum := sha256.Sum256(cert)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+var endorsementPolicyType = "endorsement" //composite key object type for per entity endorsement policies
+var orgAttribute = "org"                  //certificate attribute naming the caller's organization
+
+// EndorsementPolicy lists the organizations that must endorse any change to an entity
+type EndorsementPolicy struct {
+	Entity string   `json:"entity"`
+	Orgs   []string `json:"orgs"`
+}
+
+// ============================================================================================================================
+// Set Endorsement Policy - admin only, require extra organizations to endorse changes to a high value entity
+//
+// Key level endorsement (SetStateValidationParameter) is not available in the v0.5 shim, so the chaincode enforces
+// the policy itself: every write to a covered entity's key is refused unless the caller's "org" certificate attribute
+// is one of the policy's organizations, see checkEndorsement.
+// ============================================================================================================================
+func (t *SimpleChaincode) setEndorsementPolicy(stub *programStub, args []string) ([]byte, error) {
+	//    0       1      2
+	// "Name", "Org1", "Org2", ...   (no orgs clears the policy)
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 1")
+	}
+	_, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := createCompositeKey(endorsementPolicyType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, errors.New("Failed to clear endorsement policy")
+		}
+		return nil, nil
+	}
+	for i := 1; i < len(args); i++ {
+		if len(args[i]) <= 0 {
+			return nil, errors.New("Organization names must be non-empty strings")
+		}
+	}
+
+	policy := EndorsementPolicy{args[0], args[1:]}
+	jsonAsBytes, _ := json.Marshal(policy)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// checkEndorsement - error unless the caller may change the key, only entity keys carry an endorsement policy and only
+// callers from one of its organizations may write them
+// ============================================================================================================================
+func checkEndorsement(stub *programStub, key string) error {
+	if isReservedKey(key) {
+		return nil
+	}
+	policyKey, err := createCompositeKey(endorsementPolicyType, []string{key})
+	if err != nil {
+		return err
+	}
+	policyAsBytes, err := stub.GetState(policyKey)
+	if err != nil {
+		return errors.New("Failed to get endorsement policy")
+	}
+	if policyAsBytes == nil {
+		return nil
+	}
+	var policy EndorsementPolicy
+	err = unmarshalState(policyKey, policyAsBytes, &policy)
+	if err != nil {
+		return err
+	}
+	org := callerAttribute(stub, orgAttribute)
+	for _, allowed := range policy.Orgs {
+		if org != "" && org == allowed {
+			return nil
+		}
+	}
+	stub.log.error("caller from " + strconv.Quote(org) + " tried to change " + strconv.Quote(key))
+	return errors.New("ENDORSEMENT: changes to " + key + " must come from one of its endorsing organizations")
+}
+
+// ============================================================================================================================
+// Get Endorsement Policy - return the endorsement policy recorded for an entity, null if none
+// ============================================================================================================================
+func (t *SimpleChaincode) getEndorsementPolicy(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the entity to query")
+	}
+	key, err := createCompositeKey(endorsementPolicyType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	policyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get endorsement policy")
+	}
+	if policyAsBytes == nil {
+		return []byte("null"), nil
+	}
+	return policyAsBytes, nil
+}
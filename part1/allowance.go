@@ -0,0 +1,193 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var allowanceType = "allowance" //composite key object type for spending allowances, keyed by owner then spender
+
+// Allowance lets a spender move up to Cap of the owner's points (family accounts, corporate cards)
+type Allowance struct {
+	Owner   string  `json:"owner"`
+	Spender string  `json:"spender"`
+	Cap     float64 `json:"cap"`
+	Spent   float64 `json:"spent"`
+}
+
+// ============================================================================================================================
+// getAllowanceRecord - fetch the allowance an owner granted a spender, nil if there is none
+// ============================================================================================================================
+func getAllowanceRecord(stub *programStub, owner string, spender string) (*Allowance, string, error) {
+	key, err := createCompositeKey(allowanceType, []string{owner, spender})
+	if err != nil {
+		return nil, "", err
+	}
+	allowanceAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, key, errors.New("Failed to get allowance")
+	}
+	if allowanceAsBytes == nil {
+		return nil, key, nil
+	}
+	var allowance Allowance
+	err = unmarshalState(key, allowanceAsBytes, &allowance)
+	if err != nil {
+		return nil, key, err
+	}
+	return &allowance, key, nil
+}
+
+// ============================================================================================================================
+// Grant Allowance - owner authorizes a spender to use up to N of its points, replaces any earlier grant
+// ============================================================================================================================
+func (t *SimpleChaincode) grantAllowance(stub *programStub, args []string) ([]byte, error) {
+	//   0         1         2
+	// "Owner", "Spender", "Cap"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	owner, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	spender, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if owner.Name == spender.Name {
+		return nil, errors.New("Cannot grant an allowance to yourself")
+	}
+	limit, err := parseAmount(args[2])
+	if (err != nil) || (limit <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+
+	_, key, err := getAllowanceRecord(stub, owner.Name, spender.Name)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(Allowance{owner.Name, spender.Name, limit, 0})
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Spend From Allowance - spender moves points from the owner's account to a recipient within the granted cap
+// ============================================================================================================================
+func (t *SimpleChaincode) spendFromAllowance(stub *programStub, args []string) ([]byte, error) {
+	//   0         1           2           3
+	// "Owner", "Spender", "Recipient", "Points"
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	}
+
+	stub.log.debug("start spend from allowance")
+	points, err := parseAmount(args[3])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("4th argument must be a positive numeric string", err)
+	}
+	owner, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := getEntity(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if owner.Name == recipient.Name {
+		return nil, errors.New("Recipient must differ from owner")
+	}
+	err = checkNotBlocked(stub, owner.Name, args[1], recipient.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	allowance, key, err := getAllowanceRecord(stub, owner.Name, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if allowance == nil {
+		return nil, errors.New(args[1] + " has no allowance from " + owner.Name)
+	}
+	if allowance.Spent+points > allowance.Cap {
+		return nil, errors.New("Allowance exceeded")
+	}
+
+	allowance.Spent = allowance.Spent + points
+	jsonAsBytes, _ := json.Marshal(allowance)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	//the same pipeline as transfer, so vesting, funds, fees, limits and every registered rule apply
+	err = transferPoints(stub, TransferContext{From: owner, To: recipient, Points: points}, "allowance:"+allowance.Spender)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end spend from allowance")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Revoke Allowance - owner withdraws a spender's allowance
+// ============================================================================================================================
+func (t *SimpleChaincode) revokeAllowance(stub *programStub, args []string) ([]byte, error) {
+	//   0         1
+	// "Owner", "Spender"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	allowance, key, err := getAllowanceRecord(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if allowance == nil {
+		return nil, errors.New(args[1] + " has no allowance from " + args[0])
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return nil, errors.New("Failed to revoke allowance")
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Allowance - return the allowance an owner granted a spender, null if none
+// ============================================================================================================================
+func (t *SimpleChaincode) getAllowance(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting owner and spender")
+	}
+	allowance, _, err := getAllowanceRecord(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if allowance == nil {
+		return []byte("null"), nil
+	}
+	jsonAsBytes, _ := json.Marshal(allowance)
+	return jsonAsBytes, nil
+}
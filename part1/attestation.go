@@ -0,0 +1,102 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Attestation is the balance statement handed to a partner chaincode
+type Attestation struct {
+	Entity    string  `json:"entity"`
+	TxnBal    float64 `json:"txnbal"`
+	PtBal     float64 `json:"ptbal"`
+	TxID      string  `json:"txid"`
+	Timestamp int64   `json:"timestamp"`
+	Digest    string  `json:"digest"` //sha256 over entity|txnbal|ptbal, what the partner compares against
+}
+
+// ============================================================================================================================
+// Attest Balance - record or verify an entity's balance with a partner chaincode
+//
+// The v0.5 shim has no channels, so the partner is addressed by chaincode name only. The partner chaincode is expected
+// to expose "record_attestation" (invoke) and "get_attestation" (query), both keyed by entity name.
+// ============================================================================================================================
+func (t *SimpleChaincode) attestBalance(stub *programStub, args []string) ([]byte, error) {
+	//   0         1              2
+	// "Name", "Chaincode", "record|verify"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	if len(args[1]) <= 0 {
+		return nil, errors.New("2nd argument must be a non-empty string")
+	}
+
+	stub.log.debug("start attest balance")
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	att := Attestation{entity.Name, entity.TxnBal, entity.PtBal, stub.UUID, timestamp, balanceDigest(entity)}
+
+	switch args[2] {
+	case "record":
+		attAsBytes, _ := json.Marshal(att)
+		_, err = stub.InvokeChaincode(args[1], "record_attestation", []string{att.Entity, att.Digest, string(attAsBytes)})
+		if err != nil {
+			stub.log.warning("Partner chaincode rejected attestation")
+			return nil, errors.New("Partner chaincode rejected attestation: " + err.Error())
+		}
+		stub.log.debug("end attest balance")
+		return attAsBytes, nil
+	case "verify":
+		recordedAsBytes, err := stub.QueryChaincode(args[1], "get_attestation", []string{att.Entity})
+		if err != nil {
+			return nil, errors.New("Failed to get attestation from partner chaincode: " + err.Error())
+		}
+		var recorded Attestation
+		err = json.Unmarshal(recordedAsBytes, &recorded)
+		if err != nil {
+			return nil, errors.New("Partner chaincode returned a malformed attestation")
+		}
+		valid := recorded.Entity == att.Entity && recorded.Digest == att.Digest
+		stub.log.debug("end attest balance")
+		return []byte(`{"entity": "` + att.Entity + `", "valid": ` + strconv.FormatBool(valid) + `}`), nil
+	}
+	return nil, errors.New("3rd argument must be record or verify")
+}
+
+// ============================================================================================================================
+// balanceDigest - hash the balance fields of an entity so both sides can compare without trusting each other's JSON
+// ============================================================================================================================
+func balanceDigest(entity Entity) string {
+	str := entity.Name + "|" + formatAmount(entity.TxnBal) + "|" + formatAmount(entity.PtBal)
+	sum := sha256.Sum256([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
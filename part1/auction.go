@@ -0,0 +1,567 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// A merchant auctions one unit of a catalog item, the unit is taken out of stock for the length of the auction. Every
+// bid locks the bidder's points. In an ascending auction each bid must beat the leader by the increment and the
+// outbid leader is released at once. A sealed bid is a commitment, hex sha256 of "points|salt", with a deposit of at
+// least the bid locked alongside it, so neither the ledger nor the tx args show the amount while bids are taken. Once
+// bidding ended each bidder reveals points and salt in reveal_bid, an unrevealed bid only gets its deposit back, the
+// highest revealed bid wins and ties go to the earlier bid. Bids are taken while the tx timestamp is inside the
+// auction window and close_auction only succeeds once it (and for a sealed auction the reveal window) has passed, so
+// every peer agrees on which bids count whatever order the blocks arrive in. A winner that can no longer settle, for
+// example because it was blacklisted since, is skipped for the next bid so an auction can always close.
+var auctionType = "auction" //composite key object type for auctions, keyed by auction id
+var bidType = "bid"         //composite key object type for auction bids, keyed by auction id then bidder
+
+var sealedRevealWindow int64 = 24 * 60 * 60 //seconds after a sealed auction's bidding ends in which bids are revealed
+
+// Auction sells one unit of a catalog item for points
+type Auction struct {
+	ID         string  `json:"id"`
+	Merchant   string  `json:"merchant"`
+	Item       string  `json:"item"`
+	Kind       string  `json:"kind"` //ascending or sealed
+	MinBid     float64 `json:"min_bid"`
+	Increment  float64 `json:"increment,omitempty"` //ascending only, how much a bid must beat the leader by
+	StartsAt   int64   `json:"starts_at"`
+	EndsAt     int64   `json:"ends_at"` //exclusive
+	Status     string  `json:"status"`  //open or closed
+	Bids       int     `json:"bids"`
+	HighBid    float64 `json:"high_bid,omitempty"` //hidden in query results while a sealed auction is open
+	HighBidder string  `json:"high_bidder,omitempty"`
+	ClosedAt   int64   `json:"closed_at,omitempty"`
+
+	RevealEndsAt int64 `json:"reveal_ends_at,omitempty"` //sealed only, exclusive end of the reveal window
+}
+
+// Bid is the points one bidder has locked on an auction
+type Bid struct {
+	Auction  string  `json:"auction"`
+	Bidder   string  `json:"bidder"`
+	Points   float64 `json:"points"` //a sealed bid's stays 0 until it is revealed
+	PlacedAt int64   `json:"placed_at"`
+	TxID     string  `json:"txid"`
+
+	Deposit    float64 `json:"deposit,omitempty"`    //sealed only, points locked while the bid is sealed
+	Commitment string  `json:"commitment,omitempty"` //sealed only, hex sha256 of "points|salt"
+	Revealed   bool    `json:"revealed,omitempty"`
+}
+
+// byBidRank orders bids highest first, ties by the earlier bid then bidder name
+type byBidRank []Bid
+
+func (b byBidRank) Len() int      { return len(b) }
+func (b byBidRank) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byBidRank) Less(i, j int) bool {
+	if b[i].Points != b[j].Points {
+		return b[i].Points > b[j].Points
+	}
+	if b[i].PlacedAt != b[j].PlacedAt {
+		return b[i].PlacedAt < b[j].PlacedAt
+	}
+	return b[i].Bidder < b[j].Bidder
+}
+
+// ============================================================================================================================
+// lockedBy - the points a bid holds locked, sealed bids placed before commitments locked their amount
+// ============================================================================================================================
+func lockedBy(bid Bid) float64 {
+	if bid.Commitment != "" {
+		return bid.Deposit
+	}
+	return bid.Points
+}
+
+// ============================================================================================================================
+// bidCommitment - the commitment a sealed bid of points with the bidder's salt reveals to
+// ============================================================================================================================
+func bidCommitment(points float64, salt string) string {
+	sum := sha256.Sum256([]byte(formatAmount(points) + "|" + salt))
+	return hex.EncodeToString(sum[:])
+}
+
+// ============================================================================================================================
+// getAuction - fetch an auction by id
+// ============================================================================================================================
+func getAuction(stub *programStub, id string) (Auction, error) {
+	var auction Auction
+	key, err := createCompositeKey(auctionType, []string{id})
+	if err != nil {
+		return auction, err
+	}
+	auctionAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return auction, errors.New("Failed to get auction")
+	}
+	if auctionAsBytes == nil {
+		return auction, errors.New("Auction " + id + " does not exist")
+	}
+	err = unmarshalState(key, auctionAsBytes, &auction)
+	return auction, err
+}
+
+// ============================================================================================================================
+// putAuction - write an auction back to chaincode state
+// ============================================================================================================================
+func putAuction(stub *programStub, auction Auction) error {
+	key, err := createCompositeKey(auctionType, []string{auction.ID})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(auction)
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// auctionBids - every bid on an auction, in bidder order
+// ============================================================================================================================
+func auctionBids(stub *programStub, id string) ([]Bid, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, bidType, []string{id})
+	if err != nil {
+		return nil, errors.New("Failed to get bids")
+	}
+	defer keysIter.Close()
+
+	var bids []Bid
+	for keysIter.HasNext() {
+		key, bidAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get bids")
+		}
+		var bid Bid
+		err = unmarshalState(key, bidAsBytes, &bid)
+		if err != nil {
+			return nil, err
+		}
+		bids = append(bids, bid)
+	}
+	return bids, nil
+}
+
+// ============================================================================================================================
+// publicAuction - an auction as queries show it, a sealed auction's leader stays hidden until it closes
+// ============================================================================================================================
+func publicAuction(auction Auction) Auction {
+	if auction.Kind == "sealed" && auction.Status == "open" {
+		auction.HighBid, auction.HighBidder = 0, ""
+	}
+	return auction
+}
+
+// ============================================================================================================================
+// unlockPoints - release points an entity had locked
+// ============================================================================================================================
+func unlockPoints(stub *programStub, name string, points float64) error {
+	entity, err := getEntity(stub, name)
+	if err != nil {
+		return err
+	}
+	entity.Locked = entity.Locked - points
+	return putEntity(stub, entity)
+}
+
+// ============================================================================================================================
+// Create Auction - merchant auctions one unit of a catalog item between two dates, returns the auction id
+// ============================================================================================================================
+func (t *SimpleChaincode) createAuction(stub *programStub, args []string) ([]byte, error) {
+	//     0           1                2              3            4             5              6
+	// "Merchant", "ItemID", "ascending|sealed", "MinBid", "2016-07-01", "2016-07-07", *"Increment"*
+	if len(args) != 6 && len(args) != 7 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 6 or 7")
+	}
+	if args[2] != "ascending" && args[2] != "sealed" {
+		return nil, errors.New("3rd argument must be ascending or sealed")
+	}
+	minBid, err := parseAmount(args[3])
+	if (err != nil) || (minBid <= 0) {
+		return nil, amountError("4th argument must be a positive numeric string", err)
+	}
+	from, to, err := parseDateRange(args[4], args[5])
+	if err != nil {
+		return nil, err
+	}
+	increment := 0.0
+	if len(args) == 7 {
+		if args[2] != "ascending" {
+			return nil, errors.New("Only ascending auctions take an increment")
+		}
+		increment, err = parseAmount(args[6])
+		if (err != nil) || (increment <= 0) {
+			return nil, amountError("7th argument must be a positive numeric string", err)
+		}
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if to <= now {
+		return nil, errors.New("Auction must end in the future")
+	}
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	item, key, err := getCatalogItem(stub, merchant.Name, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if item.Stock < 1 {
+		return nil, errors.New(item.ID + " is out of stock")
+	}
+
+	item.Stock = item.Stock - 1 //held for the winner
+	jsonAsBytes, _ := json.Marshal(item)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	auction := Auction{ID: newID(stub, auctionType), Merchant: merchant.Name, Item: item.ID, Kind: args[2], MinBid: minBid, Increment: increment,
+		StartsAt: from, EndsAt: to, Status: "open"}
+	if auction.Kind == "sealed" {
+		auction.RevealEndsAt = to + sealedRevealWindow
+	}
+	err = putAuction(stub, auction)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(auction.ID), nil
+}
+
+// ============================================================================================================================
+// Place Bid - lock a bidder's points on an open auction. A sealed bid is a commitment with its deposit, bidding again
+// replaces the commitment and may only raise the deposit.
+// ============================================================================================================================
+func (t *SimpleChaincode) placeBid(stub *programStub, args []string) ([]byte, error) {
+	//   0          1         2             3
+	// "ID", "Bidder", "Points", *"Commitment"*   (sealed auctions take the deposit as points and need the commitment)
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 or 4")
+	}
+	auction, err := getAuction(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if (auction.Kind == "sealed") != (len(args) == 4) {
+		return nil, errors.New("A sealed auction takes a deposit and a commitment, an ascending auction only points")
+	}
+	points, err := parseAmount(args[2])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+	if len(args) == 4 {
+		if _, err := hex.DecodeString(args[3]); err != nil || len(args[3]) != 64 {
+			return nil, errors.New("4th argument must be a hex sha256 hash")
+		}
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if auction.Status != "open" || now < auction.StartsAt || now >= auction.EndsAt {
+		return nil, errors.New("Auction " + auction.ID + " is not taking bids")
+	}
+	bidder, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if bidder.Name == auction.Merchant {
+		return nil, errors.New("A merchant cannot bid on its own auction")
+	}
+	err = checkNotBlocked(stub, bidder.Name)
+	if err != nil {
+		return nil, err
+	}
+	if points < auction.MinBid {
+		return nil, errors.New("Bid must be at least " + formatAmount(auction.MinBid))
+	}
+
+	bidKey, err := createCompositeKey(bidType, []string{auction.ID, bidder.Name})
+	if err != nil {
+		return nil, err
+	}
+	var prev Bid
+	prevAsBytes, err := stub.GetState(bidKey)
+	if err != nil {
+		return nil, errors.New("Failed to get bid")
+	}
+	if prevAsBytes != nil {
+		err = unmarshalState(bidKey, prevAsBytes, &prev)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if auction.Kind == "ascending" {
+		if auction.HighBidder != "" && (points <= auction.HighBid || points < auction.HighBid+auction.Increment) {
+			return nil, errors.New("Bid must be at least " + formatAmount(auction.HighBid+auction.Increment))
+		}
+		if auction.HighBidder != "" && auction.HighBidder != bidder.Name { //the outbid leader gets its points back now
+			err = unlockPoints(stub, auction.HighBidder, auction.HighBid)
+			if err != nil {
+				return nil, err
+			}
+			bidder, err = getEntity(stub, bidder.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if points < lockedBy(prev) {
+		return nil, errors.New("A new sealed bid must lock at least the previous deposit of " + formatAmount(lockedBy(prev)))
+	}
+
+	extra := points - lockedBy(prev) //points already locked by the bidder's earlier bid stay locked
+	if auction.Kind == "ascending" && auction.HighBidder != bidder.Name {
+		extra = points
+	}
+	if transferablePoints(bidder) < extra {
+		return nil, errors.New("Insufficient points")
+	}
+	bidder.Locked = bidder.Locked + extra
+	err = putEntity(stub, bidder)
+	if err != nil {
+		return nil, err
+	}
+	bid := Bid{Auction: auction.ID, Bidder: bidder.Name, Points: points, PlacedAt: now, TxID: stub.UUID}
+	if auction.Kind == "sealed" {
+		bid.Points, bid.Deposit, bid.Commitment = 0, points, args[3]
+	}
+	jsonAsBytes, _ := json.Marshal(bid)
+	err = stub.PutState(bidKey, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	if prevAsBytes == nil {
+		auction.Bids++
+	}
+	if auction.Kind == "ascending" {
+		auction.HighBid, auction.HighBidder = points, bidder.Name
+	}
+	return nil, putAuction(stub, auction)
+}
+
+// ============================================================================================================================
+// Reveal Bid - open a sealed bid once bidding ended, the points must be covered by its deposit and match its commitment
+// ============================================================================================================================
+func (t *SimpleChaincode) revealBid(stub *programStub, args []string) ([]byte, error) {
+	//   0          1         2        3
+	// "ID", "Bidder", "Points", "Salt"
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	}
+	auction, err := getAuction(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	points, err := parseAmount(args[2])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if auction.Kind != "sealed" || auction.Status != "open" || now < auction.EndsAt || now >= auction.RevealEndsAt {
+		return nil, errors.New("Auction " + auction.ID + " is not taking reveals")
+	}
+	bidKey, err := createCompositeKey(bidType, []string{auction.ID, args[1]})
+	if err != nil {
+		return nil, err
+	}
+	bidAsBytes, err := stub.GetState(bidKey)
+	if err != nil {
+		return nil, errors.New("Failed to get bid")
+	}
+	if bidAsBytes == nil {
+		return nil, errors.New(args[1] + " has no bid on auction " + auction.ID)
+	}
+	var bid Bid
+	err = unmarshalState(bidKey, bidAsBytes, &bid)
+	if err != nil {
+		return nil, err
+	}
+	if bid.Revealed || bid.Commitment == "" {
+		return nil, errors.New("Bid of " + bid.Bidder + " is already revealed")
+	}
+	if bidCommitment(points, args[3]) != bid.Commitment {
+		return nil, errors.New("Points and salt do not match the commitment of " + bid.Bidder)
+	}
+	if points < auction.MinBid || points > bid.Deposit {
+		return nil, errors.New("Revealed bid must be between " + formatAmount(auction.MinBid) + " and the deposit of " + formatAmount(bid.Deposit))
+	}
+
+	bid.Points, bid.Revealed = points, true
+	jsonAsBytes, _ := json.Marshal(bid)
+	return nil, stub.PutState(bidKey, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Close Auction - once the window has passed, release every bid and redeem the best one that still settles at the
+// merchant, the held unit goes back into stock when no bid does
+// ============================================================================================================================
+func (t *SimpleChaincode) closeAuction(stub *programStub, args []string) ([]byte, error) {
+	//   0
+	// "ID"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	auction, err := getAuction(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = checkActsFor(stub, "close_auction", auction.Merchant)
+	if err != nil {
+		return nil, err
+	}
+	if auction.Status != "open" {
+		return nil, errors.New("Auction " + auction.ID + " is already closed")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now < auction.EndsAt {
+		return nil, errors.New("Auction " + auction.ID + " is still taking bids")
+	}
+	if now < auction.RevealEndsAt {
+		return nil, errors.New("Auction " + auction.ID + " is still taking reveals")
+	}
+	bids, err := auctionBids(stub, auction.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start close auction " + auction.ID)
+	var ranked []Bid //bids that may win, best first, each still holding its lock
+	if auction.Kind == "sealed" {
+		for _, bid := range bids {
+			if bid.Commitment != "" && !bid.Revealed { //never revealed, only the deposit comes back
+				err = unlockPoints(stub, bid.Bidder, bid.Deposit)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			ranked = append(ranked, bid)
+		}
+		sort.Sort(byBidRank(ranked))
+	} else if auction.HighBidder != "" { //outbid bidders were released as they were outbid
+		ranked = []Bid{{Bidder: auction.HighBidder, Points: auction.HighBid}}
+	}
+	for _, bid := range ranked { //plain lock releases, so a blocked bidder cannot keep the auction open
+		err = unlockPoints(stub, bid.Bidder, lockedBy(bid))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var winner *Bid
+	for i, bid := range ranked {
+		sp := stub.uow.savepoint()
+		_, err = t.redeemPoints(stub, []string{bid.Bidder, auction.Merchant, formatAmount(bid.Points), auctionType + ":" + auction.ID})
+		if err == nil {
+			winner = &ranked[i]
+			break
+		}
+		stub.uow.rollback(sp)
+		stub.log.warning("auction " + auction.ID + " bid of " + bid.Bidder + " did not settle: " + err.Error())
+	}
+
+	if winner != nil {
+		auction.HighBid, auction.HighBidder = winner.Points, winner.Bidder
+	} else {
+		auction.HighBid, auction.HighBidder = 0, ""
+		item, key, err := getCatalogItem(stub, auction.Merchant, auction.Item)
+		if err != nil {
+			return nil, err
+		}
+		item.Stock = item.Stock + 1
+		jsonAsBytes, _ := json.Marshal(item)
+		err = stub.PutState(key, jsonAsBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	auction.Status, auction.ClosedAt = "closed", now
+	err = putAuction(stub, auction)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end close auction " + auction.ID + ", " + strconv.Itoa(auction.Bids) + " bidders")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Auction - an auction and, once it closed, its winner
+// ============================================================================================================================
+func (t *SimpleChaincode) getAuctionQuery(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the auction to query")
+	}
+	auction, err := getAuction(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(publicAuction(auction))
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// List Auctions - every auction, or only those with a status such as open
+// ============================================================================================================================
+func (t *SimpleChaincode) listAuctions(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// "Status"   (optional)
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, auctionType, []string{})
+	if err != nil {
+		return nil, errors.New("Failed to get auctions")
+	}
+	defer keysIter.Close()
+
+	auctions := []Auction{}
+	for keysIter.HasNext() {
+		key, auctionAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get auctions")
+		}
+		var auction Auction
+		err = unmarshalState(key, auctionAsBytes, &auction)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 1 && auction.Status != args[0] {
+			continue
+		}
+		auctions = append(auctions, publicAuction(auction))
+	}
+	jsonAsBytes, _ := json.Marshal(auctions)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,178 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var auditType = "audit"       //composite key object type for admin audit records, keyed by timestamp then id
+var defaultAuditPageSize = 50 //records per page of list_audit_log when no page size is given
+var maxAuditPageSize = 500
+var redactedArg = "[redacted]"
+
+// secretArgs are the positions of arguments that must never be copied into an audit record, by function
+var secretArgs = map[string][]int{
+	"create_gift_card":   {2}, //PIN key
+	"transfer_gift_card": {3}, //PIN proof
+	"spend_gift_card":    {4}, //PIN proof
+}
+
+// AuditRecord is the trail left by one administrative invoke
+type AuditRecord struct {
+	ID        string        `json:"id"`
+	TxID      string        `json:"txid"`
+	Actor     string        `json:"actor"` //caller fingerprint
+	Action    string        `json:"action"`
+	Args      []string      `json:"args"`
+	Changes   []AuditChange `json:"changes"`
+	Timestamp int64         `json:"timestamp"`
+}
+
+// AuditChange is one key written by an administrative invoke, before and after the transaction
+type AuditChange struct {
+	Key    string `json:"key"` //quoted the way repair_record accepts it
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Delete bool   `json:"delete"`
+}
+
+// AuditPage is one page of list_audit_log, pass the bookmark back to get the next one
+type AuditPage struct {
+	Records  []AuditRecord `json:"records"`
+	Bookmark string        `json:"bookmark"` //empty on the last page
+}
+
+// ============================================================================================================================
+// isAdminAction - whether a function is reserved to admins by its current policy, those are the ones audited
+// ============================================================================================================================
+func isAdminAction(stub *programStub, function string) (bool, error) {
+	policy, err := getFunctionPolicy(stub, function)
+	if err != nil || policy == nil {
+		return false, err
+	}
+	return len(policy.Roles) == 1 && policy.Roles[0] == adminRole, nil
+}
+
+// ============================================================================================================================
+// redactArgs - a copy of an invoke's arguments with its secret ones replaced, the arguments themselves are left alone
+// ============================================================================================================================
+func redactArgs(function string, args []string) []string {
+	redacted := append([]string(nil), args...)
+	for _, i := range secretArgs[function] {
+		if i < len(redacted) {
+			redacted[i] = redactedArg
+		}
+	}
+	return redacted
+}
+
+// ============================================================================================================================
+// recordAudit - append an audit record of every key the invoke changed, read from the unit of work before it flushes
+// ============================================================================================================================
+func recordAudit(stub *programStub, function string, args []string) error {
+	admin, err := isAdminAction(stub, function)
+	if err != nil || !admin {
+		return err
+	}
+	actor, err := callerID(stub)
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	rec := AuditRecord{ID: newID(stub, auditType), TxID: stub.UUID, Actor: actor, Action: function, Args: redactArgs(function, args), Changes: []AuditChange{}, Timestamp: timestamp}
+	for _, key := range stub.uow.order {
+		before, err := stub.uow.stub.GetState(key)
+		if err != nil {
+			return err
+		}
+		w := stub.uow.writes[key]
+		rec.Changes = append(rec.Changes, AuditChange{strconv.Quote(key), string(before), string(w.value), w.deleted})
+	}
+	key, err := createCompositeKey(auditType, []string{timestampKey(timestamp), rec.ID})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(rec)
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// List Audit Log - admin only, audit records oldest first, a page at a time
+// ============================================================================================================================
+func (t *SimpleChaincode) listAuditLog(stub *programStub, args []string) ([]byte, error) {
+	//      0            1
+	// *"PageSize"* *"Bookmark"*
+	if len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 2")
+	}
+	for len(args) < 2 {
+		args = append(args, "")
+	}
+	size, err := pageSize(stub, args[0], "1st", defaultAuditPageSize, maxAuditPageSize)
+	if err != nil {
+		return nil, err
+	}
+	startKey, err := createCompositeKey(auditType, nil)
+	if err != nil {
+		return nil, err
+	}
+	endKey := startKey + maxUnicodeRuneValue
+	if args[1] != "" {
+		bookmark, err := hex.DecodeString(args[1])
+		if err != nil || string(bookmark) < startKey || string(bookmark) >= endKey {
+			return nil, errors.New("2nd argument must be a bookmark returned by list_audit_log")
+		}
+		startKey = string(bookmark)
+	}
+
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, errors.New("Failed to get audit log")
+	}
+	defer keysIter.Close()
+
+	page := AuditPage{Records: []AuditRecord{}}
+	for keysIter.HasNext() {
+		key, recAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get audit log")
+		}
+		if len(page.Records) == size {
+			page.Bookmark = hex.EncodeToString([]byte(key))
+			break
+		}
+		var rec AuditRecord
+		err = unmarshalState(key, recAsBytes, &rec)
+		if err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, rec)
+	}
+	jsonAsBytes, _ := json.Marshal(page)
+	return jsonAsBytes, nil
+}
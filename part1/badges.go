@@ -0,0 +1,407 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Achievements are admin defined goals such as a first redemption or ten transfers. Every transaction record counts
+// towards the achievements of its class for the parties on the achievement's side, and reaching the count earns the
+// entity a badge, raises BadgeEarned and, when the achievement carries a bonus, queues the bonus points. Bonuses are
+// paid once the function finished, so crediting them never races the function's own writes to the same entity. A
+// bonus is new supply, so while mints need approval bonuses stay queued and the first transaction after minting is
+// allowed again pays them.
+var achievementType = "achievement"     //composite key object type for achievement definitions, keyed by id
+var badgeType = "badge"                 //composite key object type for earned badges, keyed by entity then achievement
+var badgeProgressType = "badgeprogress" //composite key object type counting records towards a badge, keyed by entity then achievement
+var badgePendingType = "badgepending"   //composite key object type for badge bonuses not paid yet, keyed by entity then achievement
+var badgeTxnType = "badge"              //transaction record type of a badge bonus
+var maxAchievements = 64                //every record is checked against every achievement
+
+func init() {
+	registerTxnHook(TxnHook{"badges", badgeTxn})
+}
+
+// Achievement is a goal counted over an entity's transaction records
+type Achievement struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Class     TxnClass `json:"class"`          //records of this class count
+	Side      string   `json:"side,omitempty"` //from or to, the side of the record the entity must be on, empty for either
+	Count     int      `json:"count"`          //records needed for the badge
+	Bonus     float64  `json:"bonus,omitempty"`
+	UpdatedBy string   `json:"updated_by"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+// Badge is an achievement an entity reached
+type Badge struct {
+	Entity      string  `json:"entity"`
+	Achievement string  `json:"achievement"`
+	Name        string  `json:"name"`
+	Bonus       float64 `json:"bonus,omitempty"`
+	Paid        bool    `json:"paid"` //bonus credited, true straight away for badges without one
+	TxID        string  `json:"txid"`
+	EarnedAt    int64   `json:"earned_at"`
+}
+
+// ============================================================================================================================
+// getAchievements - every achievement definition, in id order
+// ============================================================================================================================
+func getAchievements(stub *programStub) ([]Achievement, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, achievementType, []string{})
+	if err != nil {
+		return nil, errors.New("Failed to get achievements")
+	}
+	defer keysIter.Close()
+
+	achievements := []Achievement{}
+	for keysIter.HasNext() {
+		key, achievementAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get achievements")
+		}
+		var achievement Achievement
+		err = unmarshalState(key, achievementAsBytes, &achievement)
+		if err != nil {
+			return nil, err
+		}
+		achievements = append(achievements, achievement)
+	}
+	return achievements, nil
+}
+
+// ============================================================================================================================
+// countTowardsBadge - count a record towards an entity's achievement, storing the badge when the count is reached
+// ============================================================================================================================
+func countTowardsBadge(stub *programStub, achievement Achievement, name string) error {
+	badgeKey, err := createCompositeKey(badgeType, []string{name, achievement.ID})
+	if err != nil {
+		return err
+	}
+	badgeAsBytes, err := stub.GetState(badgeKey)
+	if err != nil {
+		return errors.New("Failed to get badge")
+	}
+	if badgeAsBytes != nil {
+		return nil
+	}
+
+	progressKey, err := createCompositeKey(badgeProgressType, []string{name, achievement.ID})
+	if err != nil {
+		return err
+	}
+	progressAsBytes, err := stub.GetState(progressKey)
+	if err != nil {
+		return errors.New("Failed to get badge progress")
+	}
+	progress := 0
+	if progressAsBytes != nil {
+		progress, err = strconv.Atoi(string(progressAsBytes))
+		if err != nil {
+			return stateCorruption(progressKey, err)
+		}
+	}
+	progress++
+	if progress < achievement.Count {
+		return stub.PutState(progressKey, []byte(strconv.Itoa(progress)))
+	}
+
+	err = stub.DelState(progressKey)
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	badge := Badge{name, achievement.ID, achievement.Name, achievement.Bonus, achievement.Bonus <= 0, stub.UUID, timestamp}
+	jsonAsBytes, _ := json.Marshal(badge)
+	err = stub.PutState(badgeKey, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	if !badge.Paid {
+		pendingKey, err := createCompositeKey(badgePendingType, []string{name, achievement.ID})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(pendingKey, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	stub.log.info(name + " earned badge " + achievement.ID)
+	return emitEvent(stub, "BadgeEarned", nil, badge)
+}
+
+// ============================================================================================================================
+// badgeTxn - count a transaction record towards the achievements of its class, badge bonuses themselves never count
+// ============================================================================================================================
+func badgeTxn(stub *programStub, rec TxnRecord, balances []BalanceChange) error {
+	if rec.Type == badgeTxnType {
+		return nil
+	}
+	achievements, err := getAchievements(stub)
+	if err != nil {
+		return err
+	}
+	for _, achievement := range achievements {
+		if achievement.Class != rec.Class {
+			continue
+		}
+		if rec.From != "" && achievement.Side != "to" {
+			err = countTowardsBadge(stub, achievement, rec.From)
+			if err != nil {
+				return err
+			}
+		}
+		if rec.To != "" && rec.To != rec.From && achievement.Side != "from" {
+			err = countTowardsBadge(stub, achievement, rec.To)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// payBadgeBonuses - credit the bonus points of every badge earned but not paid yet
+// ============================================================================================================================
+func payBadgeBonuses(stub *programStub) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	if checkDirectMint(config) != nil {
+		return nil
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, badgePendingType, []string{})
+	if err != nil {
+		return errors.New("Failed to get pending badge bonuses")
+	}
+	var pending [][]string
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return errors.New("Failed to get pending badge bonuses")
+		}
+		_, attrs, err := splitCompositeKey(key)
+		if err == nil && len(attrs) == 2 {
+			pending = append(pending, attrs)
+		}
+	}
+	keysIter.Close()
+
+	for _, attrs := range pending {
+		badgeKey, err := createCompositeKey(badgeType, attrs)
+		if err != nil {
+			return err
+		}
+		badgeAsBytes, err := stub.GetState(badgeKey)
+		if err != nil || badgeAsBytes == nil {
+			return errors.New("Failed to get badge")
+		}
+		var badge Badge
+		err = unmarshalState(badgeKey, badgeAsBytes, &badge)
+		if err != nil {
+			return err
+		}
+		entity, err := getEntity(stub, badge.Entity)
+		if err != nil {
+			return err
+		}
+		before := entity
+		entity.PtBal = entity.PtBal + badge.Bonus
+		err = putEntity(stub, entity)
+		if err != nil {
+			return err
+		}
+		err = recordTxn(stub, TxnRecord{Type: badgeTxnType, To: entity.Name, Points: badge.Bonus, Reference: badgeType + ":" + badge.Achievement},
+			balanceChange(before, entity))
+		if err != nil {
+			return err
+		}
+		err = changeSupply(stub, badge.Bonus, badgeTxnType)
+		if err != nil {
+			return err
+		}
+		badge.Paid = true
+		jsonAsBytes, _ := json.Marshal(badge)
+		err = stub.PutState(badgeKey, jsonAsBytes)
+		if err != nil {
+			return err
+		}
+		pendingKey, _ := createCompositeKey(badgePendingType, attrs)
+		err = stub.DelState(pendingKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Put Achievement - admin only, create or replace an achievement from its JSON, e.g.
+// {"id":"first_redeem","name":"First redemption","class":"REDEEM","side":"from","count":1,"bonus":10}
+// Badges already earned are kept when an achievement is replaced.
+// ============================================================================================================================
+func (t *SimpleChaincode) putAchievement(stub *programStub, args []string) ([]byte, error) {
+	//       0
+	// "AchievementJSON"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	var achievement Achievement
+	err := decodeJSONArg(args[0], &achievement)
+	if err != nil {
+		return nil, errors.New("1st argument must be a JSON achievement: " + err.Error())
+	}
+	if len(achievement.ID) <= 0 || len(achievement.ID) > maxEarnRuleID {
+		return nil, errors.New("Achievement id must be 1 to " + strconv.Itoa(maxEarnRuleID) + " characters long")
+	}
+	if !hasTxnClass(achievement.Class) {
+		return nil, errors.New("Achievement class must be a transaction class such as REDEEM")
+	}
+	if achievement.Side != "" && achievement.Side != "from" && achievement.Side != "to" {
+		return nil, errors.New("Achievement side must be from, to or empty")
+	}
+	if achievement.Count < 1 {
+		return nil, errors.New("Achievement count must be at least 1")
+	}
+	if achievement.Bonus < 0 {
+		return nil, errors.New("Achievement bonus must be non-negative")
+	}
+
+	key, err := createCompositeKey(achievementType, []string{achievement.ID})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get achievement")
+	}
+	if existing == nil {
+		achievements, err := getAchievements(stub)
+		if err != nil {
+			return nil, err
+		}
+		if len(achievements) >= maxAchievements {
+			return nil, errors.New("Program already has " + strconv.Itoa(maxAchievements) + " achievements")
+		}
+	}
+	achievement.UpdatedBy, err = callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	achievement.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(achievement)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Delete Achievement - admin only, stop counting towards an achievement, badges already earned are kept
+// ============================================================================================================================
+func (t *SimpleChaincode) deleteAchievement(stub *programStub, args []string) ([]byte, error) {
+	//       0
+	// "AchievementID"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	key, err := createCompositeKey(achievementType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	achievementAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get achievement")
+	}
+	if achievementAsBytes == nil {
+		return nil, errors.New("Achievement " + args[0] + " does not exist")
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return nil, errors.New("Failed to delete achievement")
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// List Achievements - every achievement definition
+// ============================================================================================================================
+func (t *SimpleChaincode) listAchievements(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+	achievements, err := getAchievements(stub)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(achievements)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// List Badges - the badges an entity earned, in achievement id order
+// ============================================================================================================================
+func (t *SimpleChaincode) listBadges(stub *programStub, args []string) ([]byte, error) {
+	//   0
+	// "Name"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, badgeType, []string{entity.Name})
+	if err != nil {
+		return nil, errors.New("Failed to get badges")
+	}
+	defer keysIter.Close()
+
+	badges := []Badge{}
+	for keysIter.HasNext() {
+		key, badgeAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get badges")
+		}
+		var badge Badge
+		err = unmarshalState(key, badgeAsBytes, &badge)
+		if err != nil {
+			return nil, err
+		}
+		badges = append(badges, badge)
+	}
+	jsonAsBytes, _ := json.Marshal(badges)
+	return jsonAsBytes, nil
+}
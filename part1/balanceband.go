@@ -0,0 +1,259 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Entities are indexed by the decade of their point balance, band 0 holding balances under 1k, band 1 1k to 10k and so
+// on, so top holders and low balances are found by scanning a band or two rather than every entity. The entry only
+// moves when a balance change crosses into another band.
+var balanceBandType = "band~name" //composite key object type indexing entities by balance band then name
+var firstBandCeiling = 1000.0     //balances below this are band 0
+var maxBalanceBand = 16           //bands run from 0 to this, maxAbsoluteAmount falls in band 13
+var defaultTopHolders = 10
+var maxTopHolders = 100
+
+// LeaderboardEntry is one ranked entity, entities with equal balances share a rank and the next rank is skipped
+type LeaderboardEntry struct {
+	Rank  int     `json:"rank"`
+	Name  string  `json:"name"`
+	Role  string  `json:"role"`
+	PtBal float64 `json:"ptbal"`
+}
+
+// ============================================================================================================================
+// balanceBand - the band of a point balance
+// ============================================================================================================================
+func balanceBand(ptbal float64) int {
+	if ptbal < firstBandCeiling {
+		return 0
+	}
+	band := int(math.Floor(math.Log10(ptbal/firstBandCeiling))) + 1
+	if band > maxBalanceBand {
+		return maxBalanceBand
+	}
+	return band
+}
+
+// ============================================================================================================================
+// bandKey - the index key of an entity in a band, bands are zero padded so they sort numerically
+// ============================================================================================================================
+func bandKey(band int, name string) (string, error) {
+	return createCompositeKey(balanceBandType, []string{fmt.Sprintf("%02d", band), name})
+}
+
+// ============================================================================================================================
+// indexEntityBand - move an entity's band index entry from its stored record to its new value, either may be nil
+// ============================================================================================================================
+func indexEntityBand(stub *programStub, prev *Entity, entity *Entity) error {
+	if prev != nil && entity != nil && prev.Name == entity.Name && balanceBand(prev.PtBal) == balanceBand(entity.PtBal) {
+		return nil
+	}
+	if prev != nil {
+		key, err := bandKey(balanceBand(prev.PtBal), prev.Name)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+	if entity == nil {
+		return nil
+	}
+	key, err := bandKey(balanceBand(entity.PtBal), entity.Name)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte{0x00})
+}
+
+// ============================================================================================================================
+// bandEntities - the entities indexed in a band, in name order
+// ============================================================================================================================
+func bandEntities(stub *programStub, band int) ([]Entity, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, balanceBandType, []string{fmt.Sprintf("%02d", band)})
+	if err != nil {
+		return nil, errors.New("Failed to get balance bands")
+	}
+	defer keysIter.Close()
+
+	var entities []Entity
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get balance bands")
+		}
+		_, keyParts, err := splitCompositeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		entityAsBytes, err := stub.GetState(keyParts[1])
+		if err != nil {
+			return nil, errors.New("Failed to get entity " + keyParts[1])
+		}
+		if entityAsBytes == nil {
+			continue
+		}
+		var entity Entity
+		err = unmarshalState(keyParts[1], entityAsBytes, &entity)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	}
+	return entities, nil
+}
+
+// byPointsDesc orders entities by point balance, largest first, ties by name so every peer agrees
+type byPointsDesc []Entity
+
+func (e byPointsDesc) Len() int      { return len(e) }
+func (e byPointsDesc) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e byPointsDesc) Less(i, j int) bool {
+	if e[i].PtBal != e[j].PtBal {
+		return e[i].PtBal > e[j].PtBal
+	}
+	return e[i].Name < e[j].Name
+}
+
+// ============================================================================================================================
+// Top Holders - the entities with the largest point balances, scanning bands from the top down until N are found
+// ============================================================================================================================
+func (t *SimpleChaincode) topHolders(stub *programStub, args []string) ([]byte, error) {
+	//  0
+	// *"N"*
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	if len(args) == 0 {
+		args = append(args, "")
+	}
+	n, err := pageSize(stub, args[0], "1st", defaultTopHolders, maxTopHolders)
+	if err != nil {
+		return nil, err
+	}
+
+	holders := []Entity{}
+	for band := maxBalanceBand; band >= 0 && len(holders) < n; band-- {
+		entities, err := bandEntities(stub, band)
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(byPointsDesc(entities))
+		holders = append(holders, entities...)
+	}
+	if len(holders) > n {
+		holders = holders[:n]
+	}
+	jsonAsBytes, _ := json.Marshal(holders)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Get Leaderboard - the N entities with the most points, optionally only one role and one segment. The ranking is read
+// off the balance band index, which every balance change already keeps current, rather than kept in one record that
+// every earn in a block would have to rewrite.
+// ============================================================================================================================
+func (t *SimpleChaincode) getLeaderboard(stub *programStub, args []string) ([]byte, error) {
+	//  0       1          2
+	// *"N"*, *"Role"*, *"Segment"*   (role and segment may be empty)
+	if len(args) > 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 3")
+	}
+	for len(args) < 3 {
+		args = append(args, "")
+	}
+	n, err := pageSize(stub, args[0], "1st", defaultTopHolders, maxTopHolders)
+	if err != nil {
+		return nil, err
+	}
+
+	board := []LeaderboardEntry{}
+	for band := maxBalanceBand; band >= 0 && len(board) < n; band-- {
+		entities, err := bandEntities(stub, band)
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(byPointsDesc(entities))
+		for _, entity := range entities {
+			if len(board) >= n {
+				break
+			}
+			if (args[1] != "" && entity.Role != args[1]) || (args[2] != "" && !hasSegment(entity, args[2])) {
+				continue
+			}
+			entry := LeaderboardEntry{len(board) + 1, entity.Name, entity.Role, entity.PtBal}
+			if len(board) > 0 && board[len(board)-1].PtBal == entity.PtBal {
+				entry.Rank = board[len(board)-1].Rank
+			}
+			board = append(board, entry)
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(board)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// List Balance Band - the entities whose point balance falls in a band, "0" lists every balance under 1k
+// ============================================================================================================================
+func (t *SimpleChaincode) listBalanceBand(stub *programStub, args []string) ([]byte, error) {
+	//   0
+	// "Band"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	band, err := strconv.Atoi(args[0])
+	if (err != nil) || (band < 0) || (band > maxBalanceBand) {
+		return nil, errors.New("1st argument must be an integer between 0 and " + strconv.Itoa(maxBalanceBand))
+	}
+	entities, err := bandEntities(stub, band)
+	if err != nil {
+		return nil, err
+	}
+	if entities == nil {
+		entities = []Entity{}
+	}
+	jsonAsBytes, _ := json.Marshal(entities)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Rebuild Balance Bands - admin only, rebuild the balance band index from the entity records
+// ============================================================================================================================
+func (t *SimpleChaincode) rebuildBalanceBands(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+	indexed, err := rebuildEntityIndex(stub, balanceBandType, indexEntityBand)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.info("rebuilt balance bands, " + strconv.Itoa(indexed) + " entities")
+	return []byte(strconv.Itoa(indexed)), nil
+}
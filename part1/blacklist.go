@@ -0,0 +1,164 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var blacklistType = "blacklist"           //composite key object type for blocked entity names or identity fingerprints
+var blacklistAuditType = "blacklistaudit" //composite key object type for blacklist changes, keyed by timestamp and id
+
+// BlacklistEntry is a blocked party
+type BlacklistEntry struct {
+	Party     string `json:"party"` //entity name or caller certificate fingerprint
+	Reason    string `json:"reason"`
+	AddedBy   string `json:"added_by"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// BlacklistAudit records who changed the blacklist and why
+type BlacklistAudit struct {
+	Action    string `json:"action"` //add or remove
+	Party     string `json:"party"`
+	Reason    string `json:"reason"`
+	Actor     string `json:"actor"`
+	TxID      string `json:"txid"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ============================================================================================================================
+// checkNotBlocked - error with BLOCKED_PARTY if any of the named parties, or the caller's identity, is blacklisted, and
+// with FROZEN_ACCOUNT if a named party is under recovery
+// ============================================================================================================================
+func checkNotBlocked(stub *programStub, parties ...string) error {
+	for _, party := range parties {
+		if party == "" {
+			continue
+		}
+		err := checkNotFrozen(stub, party)
+		if err != nil {
+			return err
+		}
+	}
+	if id, err := callerID(stub); err == nil {
+		parties = append(parties, id)
+	}
+	for _, party := range parties {
+		key, err := createCompositeKey(blacklistType, []string{party})
+		if err != nil {
+			return err
+		}
+		entryAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return errors.New("Failed to get blacklist")
+		}
+		if entryAsBytes != nil {
+			stub.log.warning("blocked party " + party)
+			return errors.New("BLOCKED_PARTY: " + party)
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// auditBlacklist - append a blacklist change to the audit trail
+// ============================================================================================================================
+func auditBlacklist(stub *programStub, action string, party string, reason string) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	actor, _ := callerID(stub)
+	audit := BlacklistAudit{action, party, reason, actor, stub.UUID, timestamp}
+	key, err := createCompositeKey(blacklistAuditType, []string{timestampKey(timestamp), newID(stub, blacklistAuditType)})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(audit)
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Add To Blacklist - admin only, block an entity name or identity fingerprint from all point movements
+// ============================================================================================================================
+func (t *SimpleChaincode) addToBlacklist(stub *programStub, args []string) ([]byte, error) {
+	//    0         1
+	// "Party", "Reason"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	if len(args[0]) <= 0 {
+		return nil, errors.New("1st argument must be a non-empty string")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := createCompositeKey(blacklistType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	actor, _ := callerID(stub)
+	jsonAsBytes, _ := json.Marshal(BlacklistEntry{args[0], args[1], actor, timestamp})
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = auditBlacklist(stub, "add", args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Remove From Blacklist - admin only, unblock a party
+// ============================================================================================================================
+func (t *SimpleChaincode) removeFromBlacklist(stub *programStub, args []string) ([]byte, error) {
+	//    0         1
+	// "Party", "Reason"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	key, err := createCompositeKey(blacklistType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	entryAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get blacklist")
+	}
+	if entryAsBytes == nil {
+		return nil, errors.New(args[0] + " is not blacklisted")
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return nil, errors.New("Failed to remove from blacklist")
+	}
+	err = auditBlacklist(stub, "remove", args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
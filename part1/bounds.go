@@ -0,0 +1,148 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+var maxAbsoluteAmount = 1e15 //no amount or balance may exceed this even without configured bounds, float64 stays exact below it
+
+// AmountBounds are program wide limits on amounts and balances, checked whenever a transaction record or an entity
+// is written so no code path can store an absurd value
+type AmountBounds struct {
+	MaxTransfer float64 `json:"max_transfer"`           //most points one transfer may move, 0 is no limit
+	MaxBalance  float64 `json:"max_balance"`            //most points one entity may hold, 0 is no limit
+	MaxDecimals *int    `json:"max_decimals,omitempty"` //decimal places allowed in point amounts, unset is no limit
+	Rounding    string  `json:"rounding,omitempty"`     //how computed amounts round to MaxDecimals, empty is half-up
+}
+
+// ============================================================================================================================
+// checkFinite - error unless a value is a real number of sane magnitude, ParseFloat happily accepts "NaN" and "1e308"
+// ============================================================================================================================
+func checkFinite(val float64, what string) error {
+	if math.IsNaN(val) || math.IsInf(val, 0) || math.Abs(val) > maxAbsoluteAmount {
+		return errors.New(what + " is out of range")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// hasDecimals - whether a value has at most the given number of decimal places, allowing for float noise
+// ============================================================================================================================
+func hasDecimals(val float64, decimals int) bool {
+	scaled := val * math.Pow(10, float64(decimals))
+	return math.Abs(scaled-math.Floor(scaled+0.5)) < 1e-6
+}
+
+// ============================================================================================================================
+// checkAmount - error unless a point amount is finite and within the configured precision
+// ============================================================================================================================
+func checkAmount(bounds AmountBounds, val float64, what string) error {
+	err := checkFinite(val, what)
+	if err != nil {
+		return err
+	}
+	if bounds.MaxDecimals != nil && !hasDecimals(val, *bounds.MaxDecimals) {
+		return errors.New(what + " has more than " + strconv.Itoa(*bounds.MaxDecimals) + " decimal places")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// checkTxnBounds - validate the amounts of a transaction record before it is written
+// ============================================================================================================================
+func checkTxnBounds(stub *programStub, rec TxnRecord) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	err = checkAmount(config.Bounds, rec.Points, "Points")
+	if err != nil {
+		return err
+	}
+	err = checkFinite(rec.Amount, "Amount")
+	if err != nil {
+		return err
+	}
+	if rec.Type == "transfer" && config.Bounds.MaxTransfer > 0 && rec.Points > config.Bounds.MaxTransfer {
+		return errors.New("Transfer exceeds the maximum of " + formatAmount(config.Bounds.MaxTransfer) + " points")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// checkBalanceBounds - validate an entity's balances before it is written
+// ============================================================================================================================
+func checkBalanceBounds(stub *programStub, entity Entity) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	for _, val := range []float64{entity.PtBal, entity.TxnBal, entity.Locked, entity.Unvested} {
+		err = checkFinite(val, "Balance of "+entity.Name)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Bounds.MaxBalance > 0 && entity.PtBal > config.Bounds.MaxBalance {
+		return errors.New(entity.Name + " would exceed the maximum balance of " + formatAmount(config.Bounds.MaxBalance) + " points")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Set Amount Bounds - admin only, configure the largest transfer, the largest balance and the decimal places allowed
+// ============================================================================================================================
+func (t *SimpleChaincode) setAmountBounds(stub *programStub, args []string) ([]byte, error) {
+	//       0              1              2
+	// "MaxTransfer", "MaxBalance", "MaxDecimals"   (0 for no transfer or balance limit, "none" for any precision)
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	maxTransfer, err := parseAmount(args[0])
+	if (err != nil) || (maxTransfer < 0) || checkFinite(maxTransfer, "") != nil {
+		return nil, amountError("1st argument must be a non-negative numeric string", err)
+	}
+	maxBalance, err := parseAmount(args[1])
+	if (err != nil) || (maxBalance < 0) || checkFinite(maxBalance, "") != nil {
+		return nil, amountError("2nd argument must be a non-negative numeric string", err)
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	bounds := AmountBounds{MaxTransfer: maxTransfer, MaxBalance: maxBalance, Rounding: config.Bounds.Rounding}
+	if args[2] != "none" {
+		decimals, err := strconv.Atoi(args[2])
+		if (err != nil) || (decimals < 0) || (decimals > 8) {
+			return nil, errors.New("3rd argument must be an integer from 0 to 8 or none")
+		}
+		bounds.MaxDecimals = &decimals
+	}
+	config.Bounds = bounds
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,251 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var bridgeType = "bridge" //root program composite key object type for bridge agreements, keyed by source then target program
+
+// BridgeAgreement lets points of the source program be converted into points of the target program at a fixed rate.
+// It only takes effect once an admin of each program has signed it; proposing a new rate clears both signatures.
+type BridgeAgreement struct {
+	Source     string            `json:"source"` //program ids, empty for the root program
+	Target     string            `json:"target"`
+	Rate       float64           `json:"rate"`       //target points minted per source point burned
+	Signatures map[string]string `json:"signatures"` //program id to the caller fingerprint of the admin that signed for it
+	UpdatedAt  int64             `json:"updated_at"`
+}
+
+// ============================================================================================================================
+// checkProgramExists - error unless the id names the root program or a registered one
+// ============================================================================================================================
+func checkProgramExists(stub *programStub, id string) error {
+	if id == "" {
+		return nil
+	}
+	_, err := getProgram(stub, id)
+	return err
+}
+
+// ============================================================================================================================
+// getBridgeAgreement - fetch the agreement from one program to another, with the key it is stored under
+// ============================================================================================================================
+func getBridgeAgreement(stub *programStub, source string, target string) (BridgeAgreement, string, error) {
+	var agreement BridgeAgreement
+	key, err := createCompositeKey(bridgeType, []string{source, target})
+	if err != nil {
+		return agreement, "", err
+	}
+	agreementAsBytes, err := stub.forProgram("").GetState(key)
+	if err != nil {
+		return agreement, key, errors.New("Failed to get bridge agreement")
+	}
+	if agreementAsBytes == nil {
+		return agreement, key, errors.New("No bridge agreement from " + source + " to " + target)
+	}
+	err = unmarshalState(key, agreementAsBytes, &agreement)
+	if err != nil {
+		return agreement, key, err
+	}
+	return agreement, key, nil
+}
+
+// ============================================================================================================================
+// putBridgeAgreement - write an agreement to the root program where both sides can read it
+// ============================================================================================================================
+func putBridgeAgreement(stub *programStub, key string, agreement BridgeAgreement) error {
+	jsonAsBytes, _ := json.Marshal(agreement)
+	return stub.forProgram("").PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Propose Bridge - admin only, offer to convert this program's points into another program's at a rate, signed by the
+// proposing program
+// ============================================================================================================================
+func (t *SimpleChaincode) proposeBridge(stub *programStub, args []string) ([]byte, error) {
+	//      0           1
+	// "TargetProgram", "Rate"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	if args[0] == stub.program {
+		return nil, errors.New("1st argument must name a different program")
+	}
+	err := checkProgramExists(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	rate, err := parseAmount(args[1])
+	if (err != nil) || (rate <= 0) {
+		return nil, amountError("2nd argument must be a positive numeric string", err)
+	}
+	signer, err := callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := createCompositeKey(bridgeType, []string{stub.program, args[0]})
+	if err != nil {
+		return nil, err
+	}
+	agreement := BridgeAgreement{Source: stub.program, Target: args[0], Rate: rate, Signatures: map[string]string{stub.program: signer}, UpdatedAt: timestamp}
+	err = putBridgeAgreement(stub, key, agreement)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Sign Bridge - admin only, the target program accepts a proposed bridge agreement at the proposed rate
+// ============================================================================================================================
+func (t *SimpleChaincode) signBridge(stub *programStub, args []string) ([]byte, error) {
+	//      0           1
+	// "SourceProgram", "Rate"   (the rate being agreed to, so a re-proposed rate cannot be signed unseen)
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	agreement, key, err := getBridgeAgreement(stub, args[0], stub.program)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := parseAmount(args[1])
+	if (err != nil) || (rate != agreement.Rate) {
+		return nil, amountError("2nd argument must match the proposed rate of "+formatAmount(agreement.Rate), err)
+	}
+	signer, err := callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	agreement.Signatures[stub.program] = signer
+	err = putBridgeAgreement(stub, key, agreement)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Bridge Points - burn an entity's points in this program and mint the converted amount to an entity of another program
+// ============================================================================================================================
+func (t *SimpleChaincode) bridgePoints(stub *programStub, args []string) ([]byte, error) {
+	//   0            1              2          3
+	// "From", "TargetProgram", "Recipient", "Points"
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	}
+	points, err := parseAmount(args[3])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("4th argument must be a positive numeric string", err)
+	}
+	agreement, _, err := getBridgeAgreement(stub, stub.program, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := agreement.Signatures[agreement.Source]; !ok {
+		return nil, errors.New("Bridge agreement is not signed by program " + agreement.Source)
+	}
+	if _, ok := agreement.Signatures[agreement.Target]; !ok {
+		return nil, errors.New("Bridge agreement is not signed by program " + agreement.Target)
+	}
+	target := stub.forProgram(agreement.Target)
+
+	from, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := getEntity(target, args[2])
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotBlocked(stub, from.Name)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotBlocked(target, recipient.Name)
+	if err != nil {
+		return nil, err
+	}
+	if transferablePoints(from) < points {
+		return nil, errors.New("Insufficient points")
+	}
+	targetConfig, err := getConfig(target)
+	if err != nil {
+		return nil, err
+	}
+	converted := roundPoints(targetConfig.Bounds, points*agreement.Rate)
+
+	stub.log.debug("start bridge points")
+	fromBefore := from
+	from.PtBal = from.PtBal - points
+	err = putEntity(stub, from)
+	if err != nil {
+		return nil, err
+	}
+	err = recordTxn(stub, TxnRecord{Type: "burn", From: from.Name, Points: points, Reference: "bridge:" + agreement.Target + ":" + recipient.Name}, balanceChange(fromBefore, from))
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(stub, -points, "bridge")
+	if err != nil {
+		return nil, err
+	}
+
+	recipientBefore := recipient
+	recipient.PtBal = recipient.PtBal + converted
+	err = putEntity(target, recipient)
+	if err != nil {
+		return nil, err
+	}
+	err = recordTxn(target, TxnRecord{Type: "mint", To: recipient.Name, Points: converted, Reference: "bridge:" + agreement.Source + ":" + from.Name}, balanceChange(recipientBefore, recipient))
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(target, converted, "bridge")
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end bridge points")
+	return []byte(formatAmount(converted)), nil
+}
+
+// ============================================================================================================================
+// Get Bridge Agreement - read the agreement from one program to another
+// ============================================================================================================================
+func (t *SimpleChaincode) getBridgeAgreementQuery(stub *programStub, args []string) ([]byte, error) {
+	//      0                1
+	// "SourceProgram", "TargetProgram"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	agreement, _, err := getBridgeAgreement(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(agreement)
+	return jsonAsBytes, nil
+}
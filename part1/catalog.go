@@ -0,0 +1,188 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var catalogType = "catalog" //composite key object type for reward catalog items, keyed by merchant then item id
+
+// CatalogItem is a reward a merchant offers in exchange for points
+type CatalogItem struct {
+	Merchant    string  `json:"merchant"`
+	ID          string  `json:"id"`
+	Description string  `json:"description"`
+	Cost        float64 `json:"cost"` //points per unit
+	Stock       int     `json:"stock"`
+	Available   bool    `json:"available"` //in stock, filled in when listed
+}
+
+// ============================================================================================================================
+// getCatalogItem - fetch a merchant's catalog item
+// ============================================================================================================================
+func getCatalogItem(stub *programStub, merchant string, id string) (CatalogItem, string, error) {
+	var item CatalogItem
+	key, err := createCompositeKey(catalogType, []string{merchant, id})
+	if err != nil {
+		return item, "", err
+	}
+	itemAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return item, key, errors.New("Failed to get catalog item")
+	}
+	if itemAsBytes == nil {
+		return item, key, errors.New("Catalog item " + id + " does not exist at " + merchant)
+	}
+	err = unmarshalState(key, itemAsBytes, &item)
+	if err != nil {
+		return item, key, err
+	}
+	return item, key, nil
+}
+
+// ============================================================================================================================
+// Publish Item - merchant adds a reward to its catalog, or updates the description, cost and stock of one
+// ============================================================================================================================
+func (t *SimpleChaincode) publishItem(stub *programStub, args []string) ([]byte, error) {
+	//     0           1           2            3        4
+	// "Merchant", "ItemID", "Description", "Cost", "Stock"
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5")
+	}
+	if len(args[1]) <= 0 {
+		return nil, errors.New("2nd argument must be a non-empty string")
+	}
+	cost, err := parseAmount(args[3])
+	if (err != nil) || (cost <= 0) {
+		return nil, amountError("4th argument must be a positive numeric string", err)
+	}
+	stock, err := strconv.Atoi(args[4])
+	if (err != nil) || (stock < 0) {
+		return nil, errors.New("5th argument must be a non-negative integer")
+	}
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if merchant.Role != merchantRole {
+		return nil, errors.New(merchant.Name + " is not a merchant")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAmount(config.Bounds, cost, "4th argument")
+	if err != nil {
+		return nil, err
+	}
+
+	item := CatalogItem{Merchant: merchant.Name, ID: args[1], Description: args[2], Cost: cost, Stock: stock}
+	key, err := createCompositeKey(catalogType, []string{item.Merchant, item.ID})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(item)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Redeem Item - customer exchanges points for units of a catalog item, stock and points change in the same transaction
+// ============================================================================================================================
+func (t *SimpleChaincode) redeemItem(stub *programStub, args []string) ([]byte, error) {
+	//     0           1          2           3
+	// "Customer", "Merchant", "ItemID" *"Quantity"*
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 or 4")
+	}
+	quantity := 1
+	if len(args) == 4 {
+		q, err := strconv.Atoi(args[3])
+		if (err != nil) || (q < 1) {
+			return nil, errors.New("4th argument must be a positive integer")
+		}
+		quantity = q
+	}
+	merchant, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	item, key, err := getCatalogItem(stub, merchant.Name, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if item.Stock < quantity {
+		return nil, errors.New("Only " + strconv.Itoa(item.Stock) + " of " + item.ID + " left in stock")
+	}
+
+	stub.log.debug("start redeem item")
+	points := formatAmount(item.Cost * float64(quantity))
+	_, err = t.redeemPoints(stub, []string{args[0], merchant.Name, points, catalogType + ":" + item.ID})
+	if err != nil {
+		return nil, err
+	}
+	item.Stock = item.Stock - quantity
+	jsonAsBytes, _ := json.Marshal(item)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end redeem item")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// List Catalog - every catalog item, or one merchant's, with whether it is in stock
+// ============================================================================================================================
+func (t *SimpleChaincode) listCatalog(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// *"Merchant"*
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, catalogType, args)
+	if err != nil {
+		return nil, errors.New("Failed to get catalog")
+	}
+	defer keysIter.Close()
+
+	items := []CatalogItem{}
+	for keysIter.HasNext() {
+		key, itemAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get catalog")
+		}
+		var item CatalogItem
+		err = unmarshalState(key, itemAsBytes, &item)
+		if err != nil {
+			return nil, err
+		}
+		item.Available = item.Stock > 0
+		items = append(items, item)
+	}
+	jsonAsBytes, _ := json.Marshal(items)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,127 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+)
+
+// ============================================================================================================================
+// restrictedTotal - points held in category restricted buckets, these are part of PtBal but only spendable at a
+// merchant tagged with the bucket's category
+// ============================================================================================================================
+func restrictedTotal(entity Entity) float64 {
+	return sumValues(entity.Restricted)
+}
+
+// ============================================================================================================================
+// transferablePoints - points an entity may move to another entity, restricted buckets, locked and unvested points
+// stay put
+// ============================================================================================================================
+func transferablePoints(entity Entity) float64 {
+	return entity.PtBal - restrictedTotal(entity) - entity.Locked - entity.Unvested
+}
+
+// ============================================================================================================================
+// hasCategory - true if the merchant is tagged with the category
+// ============================================================================================================================
+func hasCategory(merchant Entity, category string) bool {
+	for _, c := range merchant.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// spendAtMerchant - deduct points from a customer, restricted buckets matching the merchant's categories go first
+// ============================================================================================================================
+func spendAtMerchant(customer *Entity, merchant Entity, amount float64) error {
+	if !drawPoints(customer, merchant.Categories, amount) {
+		return errors.New("Insufficient points spendable at " + merchant.Name)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// drawPoints - deduct points from an entity, the restricted buckets of the categories go first in the order given, so
+// every peer drains buckets identically. False, with nothing deducted, if the buckets and transferable points fall short.
+// ============================================================================================================================
+func drawPoints(entity *Entity, categories []string, amount float64) bool {
+	spendable := transferablePoints(*entity)
+	for _, category := range categories {
+		spendable = spendable + entity.Restricted[category]
+	}
+	if spendable < amount {
+		return false
+	}
+
+	remaining := amount
+	for _, category := range categories {
+		bucket := entity.Restricted[category]
+		if bucket <= 0 || remaining <= 0 {
+			continue
+		}
+		if bucket > remaining {
+			entity.Restricted[category] = bucket - remaining
+			remaining = 0
+		} else {
+			delete(entity.Restricted, category)
+			remaining = remaining - bucket
+		}
+	}
+	entity.PtBal = entity.PtBal - amount
+	return true
+}
+
+// ============================================================================================================================
+// Set Merchant Categories - admin only, tag a merchant with the spending categories it accepts restricted points for
+// ============================================================================================================================
+func (t *SimpleChaincode) setMerchantCategories(stub *programStub, args []string) ([]byte, error) {
+	//     0           1        2
+	// "Merchant", "fuel", "grocery", ...   (no categories clears them)
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 1")
+	}
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if merchant.Role != merchantRole {
+		return nil, errors.New(merchant.Name + " is not a merchant")
+	}
+
+	merchant.Categories = nil
+	for i := 1; i < len(args); i++ {
+		if len(args[i]) <= 0 {
+			return nil, errors.New("Categories must be non-empty strings")
+		}
+		if !hasCategory(merchant, args[i]) {
+			merchant.Categories = append(merchant.Categories, args[i])
+		}
+	}
+
+	err = putEntity(stub, merchant)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
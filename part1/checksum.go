@@ -0,0 +1,169 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+)
+
+var maxChecksumKeys = 1000 //keys hashed per call, a bigger set is checksummed a page at a time
+
+// StateChecksum is a digest of a set of keys and their values, equal on two peers only if their state matches
+type StateChecksum struct {
+	Checksum string `json:"checksum"` //hex sha256
+	Keys     int    `json:"keys"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Bookmark string `json:"bookmark,omitempty"` //last key hashed when the page is full, pass it back for the next page
+}
+
+// ============================================================================================================================
+// hashKeyValue - feed a key and its value into the checksum, length prefixed so no two key sets hash the same
+// ============================================================================================================================
+func hashKeyValue(h hash.Hash, key string, value []byte) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(key)))
+	h.Write(size[:])
+	h.Write([]byte(key))
+	binary.BigEndian.PutUint64(size[:], uint64(len(value)))
+	h.Write(size[:])
+	h.Write(value)
+}
+
+// ============================================================================================================================
+// programRanges - split [from, to) into the ranges holding the stub's own keys, the root program's key space also holds
+// every other program's keys under the program separator so a root range skips over them
+// ============================================================================================================================
+func programRanges(stub *programStub, from string, to string) [][2]string {
+	if stub.program != "" {
+		return [][2]string{{from, to}}
+	}
+	var ranges [][2]string
+	if from < programSeparator {
+		end := programSeparator
+		if to != "" && to < end {
+			end = to
+		}
+		ranges = append(ranges, [2]string{from, end})
+	}
+	start := programSeparatorEnd
+	if from > start {
+		start = from
+	}
+	if to == "" || to > start {
+		ranges = append(ranges, [2]string{start, to})
+	}
+	return ranges
+}
+
+// ============================================================================================================================
+// State Checksum - sha256 over the entity records in name order, or over the program's keys in [From, To), so
+// organizations can compare their peers' state after bulk operations without exporting it. At most maxChecksumKeys
+// keys are hashed per call, a full page returns a bookmark to continue from and each page is compared on its own.
+// ============================================================================================================================
+func (t *SimpleChaincode) stateChecksum(stub *programStub, args []string) ([]byte, error) {
+	//    0       1        2
+	// *"From", "To"*, *"Bookmark"*   (no range checksums the entity records, then only a bookmark may be given)
+	if len(args) > 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0 to 3")
+	}
+	h := sha256.New()
+	result := StateChecksum{}
+
+	if len(args) <= 1 {
+		bookmark := ""
+		if len(args) == 1 {
+			bookmark = args[0]
+		}
+		entityIndex, err := getEntityIndex(stub)
+		if err != nil {
+			return nil, err
+		}
+		names := namesAfter(entityIndex, bookmark)
+		for i, name := range names {
+			if i >= maxChecksumKeys {
+				result.Bookmark = names[i-1]
+				break
+			}
+			entityAsBytes, err := stub.GetState(name)
+			if err != nil {
+				return nil, errors.New("Failed to get state for " + name)
+			}
+			hashKeyValue(h, name, entityAsBytes)
+			result.Keys++
+		}
+	} else {
+		if len(args[1]) > 0 && args[1] <= args[0] {
+			return nil, errors.New("2nd argument must sort after the 1st, or be empty for the end of the range")
+		}
+		from := args[0]
+		if len(args) == 3 && args[2] != "" {
+			if args[2] < args[0] || (args[1] != "" && args[2] >= args[1]) {
+				return nil, errors.New("3rd argument must be a bookmark inside the range")
+			}
+			from = args[2] + compositeKeyNamespace //the key right after the bookmark
+		}
+		err := hashRanges(stub, h, programRanges(stub, from, args[1]), &result)
+		if err != nil {
+			return nil, err
+		}
+		result.From = args[0]
+		result.To = args[1]
+	}
+
+	result.Checksum = hex.EncodeToString(h.Sum(nil))
+	jsonAsBytes, _ := json.Marshal(result)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// hashRanges - hash the keys of each range in order until the page is full, a full page with keys left keeps the last
+// key hashed as the result's bookmark
+// ============================================================================================================================
+func hashRanges(stub *programStub, h hash.Hash, ranges [][2]string, result *StateChecksum) error {
+	for _, r := range ranges {
+		keysIter, err := stub.RangeQueryState(r[0], r[1])
+		if err != nil {
+			return errors.New("Failed to get state range")
+		}
+		for keysIter.HasNext() {
+			key, valAsBytes, err := keysIter.Next()
+			if err != nil {
+				keysIter.Close()
+				return errors.New("Failed to get state range")
+			}
+			if result.Keys >= maxChecksumKeys {
+				keysIter.Close()
+				return nil
+			}
+			hashKeyValue(h, key, valAsBytes)
+			result.Keys++
+			result.Bookmark = key
+		}
+		keysIter.Close()
+	}
+	result.Bookmark = "" //every key was hashed
+	return nil
+}
@@ -0,0 +1,197 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Values that can grow without bound, such as the entity index, are written with putLargeState. A value up to
+// maxChunkSize is stored as is. A larger one is split into chunks under their own keys, and the key itself holds a
+// header, marked by a prefix no JSON value starts with, listing the sha256 of each chunk and of the whole value.
+// getLargeState joins the chunks and checks every hash, so callers read the value as if it had been stored in one
+// piece, and a value written before it outgrew a single key still reads the same.
+var chunkType = "chunk"               //composite key object type for the chunks of a large value, keyed by key then index
+var chunkedMarker = "\x00chunked\x00" //prefix of a chunked value's header
+var maxChunkSize = 64 * 1024          //bytes per chunk and the largest value stored in one piece
+var maxChunks = 4096                  //a header claiming more is corrupt
+
+// ChunkedHeader describes a value split across chunks
+type ChunkedHeader struct {
+	Size   int      `json:"size"`
+	Hash   string   `json:"hash"`   //hex sha256 of the joined value
+	Chunks []string `json:"chunks"` //hex sha256 of each chunk, in order
+}
+
+// ============================================================================================================================
+// chunkKey - key of one chunk of a large value
+// ============================================================================================================================
+func chunkKey(key string, i int) (string, error) {
+	return createCompositeKey(chunkType, []string{key, fmt.Sprintf("%06d", i)})
+}
+
+// ============================================================================================================================
+// getChunkedHeader - the header stored under a key, nil when the value under it is not chunked
+// ============================================================================================================================
+func getChunkedHeader(key string, valAsBytes []byte) (*ChunkedHeader, error) {
+	if !strings.HasPrefix(string(valAsBytes), chunkedMarker) {
+		return nil, nil
+	}
+	var header ChunkedHeader
+	err := unmarshalState(key, valAsBytes[len(chunkedMarker):], &header)
+	if err != nil {
+		return nil, err
+	}
+	if len(header.Chunks) > maxChunks {
+		return nil, stateCorruption(key, errors.New("chunked value claims "+fmt.Sprint(len(header.Chunks))+" chunks"))
+	}
+	return &header, nil
+}
+
+// ============================================================================================================================
+// deleteChunks - remove the chunks of a header from index from on
+// ============================================================================================================================
+func deleteChunks(stub *programStub, key string, header *ChunkedHeader, from int) error {
+	if header == nil {
+		return nil
+	}
+	for i := from; i < len(header.Chunks); i++ {
+		ck, err := chunkKey(key, i)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(ck)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// getLargeState - read a value written by putLargeState, joining and verifying its chunks
+// ============================================================================================================================
+func getLargeState(stub *programStub, key string) ([]byte, error) {
+	valAsBytes, err := stub.GetState(key)
+	if err != nil || valAsBytes == nil {
+		return valAsBytes, err
+	}
+	header, err := getChunkedHeader(key, valAsBytes)
+	if err != nil || header == nil {
+		return valAsBytes, err
+	}
+
+	value := make([]byte, 0, header.Size)
+	for i, want := range header.Chunks {
+		ck, err := chunkKey(key, i)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := stub.GetState(ck)
+		if err != nil {
+			return nil, errors.New("Failed to get chunk " + fmt.Sprint(i) + " of " + key)
+		}
+		sum := sha256.Sum256(chunk)
+		if hex.EncodeToString(sum[:]) != want {
+			return nil, stateCorruption(ck, errors.New("chunk hash does not match its header"))
+		}
+		value = append(value, chunk...)
+	}
+	sum := sha256.Sum256(value)
+	if len(value) != header.Size || hex.EncodeToString(sum[:]) != header.Hash {
+		return nil, stateCorruption(key, errors.New("joined chunks do not match their header"))
+	}
+	return value, nil
+}
+
+// ============================================================================================================================
+// putLargeState - write a value in one piece when it is small enough, in verified chunks otherwise, and drop whatever
+// chunks the previous value left behind
+// ============================================================================================================================
+func putLargeState(stub *programStub, key string, value []byte) error {
+	prevAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get " + key)
+	}
+	prev, err := getChunkedHeader(key, prevAsBytes)
+	if err != nil {
+		return err
+	}
+	if len(value) <= maxChunkSize {
+		err = deleteChunks(stub, key, prev, 0)
+		if err != nil {
+			return err
+		}
+		return stub.PutState(key, value)
+	}
+	if (len(value)+maxChunkSize-1)/maxChunkSize > maxChunks {
+		return errors.New("Value for " + key + " is too large to store")
+	}
+
+	sum := sha256.Sum256(value)
+	header := ChunkedHeader{Size: len(value), Hash: hex.EncodeToString(sum[:])}
+	for start := 0; start < len(value); start += maxChunkSize {
+		end := start + maxChunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		chunk := value[start:end]
+		ck, err := chunkKey(key, len(header.Chunks))
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(ck, chunk)
+		if err != nil {
+			return err
+		}
+		chunkSum := sha256.Sum256(chunk)
+		header.Chunks = append(header.Chunks, hex.EncodeToString(chunkSum[:]))
+	}
+	err = deleteChunks(stub, key, prev, len(header.Chunks))
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(header)
+	return stub.PutState(key, append([]byte(chunkedMarker), jsonAsBytes...))
+}
+
+// ============================================================================================================================
+// delLargeState - delete a value written by putLargeState along with its chunks
+// ============================================================================================================================
+func delLargeState(stub *programStub, key string) error {
+	prevAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get " + key)
+	}
+	prev, err := getChunkedHeader(key, prevAsBytes)
+	if err != nil {
+		return err
+	}
+	err = deleteChunks(stub, key, prev, 0)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
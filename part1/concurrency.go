@@ -0,0 +1,106 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var versionConflict = "VERSION_CONFLICT" //error code prefix for updates made against a stale version of an entity
+
+// ============================================================================================================================
+// checkVersion - reject an update made against a different version of the entity than the one stored
+// ============================================================================================================================
+func checkVersion(entity Entity, expected string) error {
+	version, err := strconv.ParseInt(expected, 10, 64)
+	if (err != nil) || (version < 0) {
+		return errors.New("Expected version must be a non-negative integer")
+	}
+	if version != entity.Version {
+		return errors.New(versionConflict + ": " + entity.Name + " is at version " + strconv.FormatInt(entity.Version, 10) +
+			", expected " + strconv.FormatInt(version, 10))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Update Entity - admin only, change the role or merchant categories of an entity as of the version the caller read.
+// Balances only change through transactions.
+// ============================================================================================================================
+func (t *SimpleChaincode) updateEntity(stub *programStub, args []string) ([]byte, error) {
+	//   0          1                   2
+	// "Name", "ExpectedVersion", "{"role":..., "categories":[...]}"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = checkVersion(entity, args[1])
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal([]byte(args[2]), &fields)
+	if err != nil || len(fields) == 0 {
+		return nil, errors.New("3rd argument must be a JSON object of the fields to change")
+	}
+	for _, field := range sortedKeys(fields) { //field order decides which error a bad update reports
+		val := fields[field]
+		switch field {
+		case "role":
+			var role string
+			err = json.Unmarshal(val, &role)
+			if err != nil || len(role) <= 0 {
+				return nil, errors.New("role must be a non-empty string")
+			}
+			entity.Role = role
+		case "categories":
+			var categories []string
+			err = json.Unmarshal(val, &categories)
+			if err != nil {
+				return nil, errors.New("categories must be a list of strings")
+			}
+			entity.Categories = nil
+			for _, category := range categories {
+				if len(category) <= 0 {
+					return nil, errors.New("Categories must be non-empty strings")
+				}
+				if !hasCategory(entity, category) {
+					entity.Categories = append(entity.Categories, category)
+				}
+			}
+		default:
+			return nil, errors.New(field + " cannot be updated")
+		}
+	}
+	if len(entity.Categories) > 0 && entity.Role != merchantRole {
+		return nil, errors.New(entity.Name + " is not a merchant")
+	}
+
+	err = putEntity(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strconv.FormatInt(entity.Version+1, 10)), nil
+}
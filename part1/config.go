@@ -0,0 +1,124 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var configStr = systemKeyPrefix + "config" //name for the key/value that will store program wide settings
+
+// Config holds program wide settings maintained by admins
+type Config struct {
+	SettlementChaincode string          `json:"settlement_chaincode"` //chaincode notified on every redemption, empty disables the hook
+	PointValue          float64         `json:"point_value"`          //cash value of one point, used for merchant settlement
+	Treasury            string          `json:"treasury"`             //entity new points are minted into
+	MintThreshold       int             `json:"mint_threshold"`       //admin signatures needed to execute a mint proposal
+	MintProposalTTL     int64           `json:"mint_proposal_ttl"`    //seconds a mint proposal stays open for signatures
+	MaxSupply           float64         `json:"max_supply"`           //cap on total points in circulation, 0 is no cap
+	MaxDailyEarns       int             `json:"max_daily_earns"`      //earns per customer per merchant per day, 0 is no limit
+	TransferFee         FeeRule         `json:"transfer_fee"`
+	Bounds              AmountBounds    `json:"bounds"`
+	ReservationTTL      int64           `json:"reservation_ttl"` //seconds a catalog reservation holds stock, 0 is the default
+	Alerts              AlertLevels     `json:"alerts"`
+	Adjustments         AdjustLimits    `json:"adjustments"`
+	Features            FeatureFlags    `json:"features,omitempty"`
+	LogLevel            string          `json:"log_level,omitempty"`  //debug, info, warning or error, empty for the environment's
+	FXOracle            string          `json:"fx_oracle,omitempty"`  //entity whose signed rates convert foreign currency purchases
+	FXMaxAge            int64           `json:"fx_max_age,omitempty"` //seconds a posted rate stays usable, 0 is the default
+	Withholding         WithholdingRule `json:"withholding"`
+	Paging              PagingLimits    `json:"paging"`
+}
+
+// ============================================================================================================================
+// getConfig - read the program config, a missing config is the zero value
+// ============================================================================================================================
+func getConfig(stub *programStub) (Config, error) {
+	var config Config
+	configAsBytes, err := stub.GetState(configStr)
+	if err != nil {
+		return config, errors.New("Failed to get config")
+	}
+	if configAsBytes == nil {
+		return config, nil
+	}
+	err = unmarshalState(configStr, configAsBytes, &config)
+	if err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// ============================================================================================================================
+// putConfig - write the program config
+// ============================================================================================================================
+func putConfig(stub *programStub, config Config) error {
+	jsonAsBytes, _ := json.Marshal(config)
+	return stub.PutState(configStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Set Settlement Chaincode - admin only, name the chaincode that records fiat settlement for redemptions
+// ============================================================================================================================
+func (t *SimpleChaincode) setSettlementChaincode(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// "Chaincode"   (empty string disables the hook)
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.SettlementChaincode = args[0]
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Set Point Value - admin only, set the cash value of one point
+// ============================================================================================================================
+func (t *SimpleChaincode) setPointValue(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// "0.01"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	value, err := parseAmount(args[0])
+	if (err != nil) || (value < 0) {
+		return nil, amountError("1st argument must be a non-negative numeric string", err)
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.PointValue = value
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
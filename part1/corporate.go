@@ -0,0 +1,216 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Corporate accounts fund employee wallets through allowances: the budget is the allowance cap, employees spend it
+// with spend_from_allowance and the points stay in the corporate account until spent.
+var corporateRole = "corporate" //entity role that may allocate budgets to employee wallets
+
+// EmployeeBudget is one employee's wallet on a corporate statement
+type EmployeeBudget struct {
+	Employee   string  `json:"employee"`
+	Budget     float64 `json:"budget"` //allocated over the wallet's life, less reclaimed budget
+	Spent      float64 `json:"spent"`
+	Remaining  float64 `json:"remaining"`
+	SpentRange float64 `json:"spent_in_range"` //spent between the statement dates
+}
+
+// CorporateStatement consolidates a corporate account's balance and its employee wallets
+type CorporateStatement struct {
+	Corporate  string           `json:"corporate"`
+	From       string           `json:"from"`
+	To         string           `json:"to"`
+	PtBal      float64          `json:"ptbal"`
+	Committed  float64          `json:"committed"` //budgets allocated but not spent yet
+	SpentRange float64          `json:"spent_in_range"`
+	Employees  []EmployeeBudget `json:"employees"`
+}
+
+// ============================================================================================================================
+// getCorporate - fetch an entity and check it is a corporate account
+// ============================================================================================================================
+func getCorporate(stub *programStub, name string) (Entity, error) {
+	corporate, err := getEntity(stub, name)
+	if err != nil {
+		return corporate, err
+	}
+	if corporate.Role != corporateRole {
+		return corporate, errors.New(corporate.Name + " is not a corporate account")
+	}
+	return corporate, nil
+}
+
+// ============================================================================================================================
+// corporateAllowances - every allowance a corporate account granted, in employee order
+// ============================================================================================================================
+func corporateAllowances(stub *programStub, corporate string) ([]Allowance, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, allowanceType, []string{corporate})
+	if err != nil {
+		return nil, errors.New("Failed to get allowances")
+	}
+	defer keysIter.Close()
+
+	var allowances []Allowance
+	for keysIter.HasNext() {
+		key, allowanceAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get allowances")
+		}
+		var allowance Allowance
+		err = unmarshalState(key, allowanceAsBytes, &allowance)
+		if err != nil {
+			return nil, err
+		}
+		allowances = append(allowances, allowance)
+	}
+	return allowances, nil
+}
+
+// ============================================================================================================================
+// Allocate Budget - a corporate account adds points to an employee's wallet, as long as every unspent budget stays
+// covered by the corporate account's points
+// ============================================================================================================================
+func (t *SimpleChaincode) allocateBudget(stub *programStub, args []string) ([]byte, error) {
+	//     0            1          2
+	// "Corporate", "Employee", "Points"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	points, err := parseAmount(args[2])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+	corporate, err := getCorporate(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	employee, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if corporate.Name == employee.Name {
+		return nil, errors.New("Cannot allocate a budget to the corporate account itself")
+	}
+	allowances, err := corporateAllowances(stub, corporate.Name)
+	if err != nil {
+		return nil, err
+	}
+	committed := points
+	for _, allowance := range allowances {
+		committed = committed + allowance.Cap - allowance.Spent
+	}
+	if committed > transferablePoints(corporate) {
+		return nil, errors.New("Budgets of " + formatAmount(committed) + " points would exceed what " + corporate.Name + " holds")
+	}
+
+	allowance, key, err := getAllowanceRecord(stub, corporate.Name, employee.Name)
+	if err != nil {
+		return nil, err
+	}
+	if allowance == nil {
+		allowance = &Allowance{Owner: corporate.Name, Spender: employee.Name}
+	}
+	allowance.Cap = allowance.Cap + points
+	jsonAsBytes, _ := json.Marshal(*allowance)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(formatAmount(allowance.Cap - allowance.Spent)), nil
+}
+
+// ============================================================================================================================
+// Reclaim Budget - a corporate account takes back what an employee has not spent, returns the points reclaimed
+// ============================================================================================================================
+func (t *SimpleChaincode) reclaimBudget(stub *programStub, args []string) ([]byte, error) {
+	//     0            1
+	// "Corporate", "Employee"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	corporate, err := getCorporate(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	allowance, key, err := getAllowanceRecord(stub, corporate.Name, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if allowance == nil {
+		return nil, errors.New(args[1] + " has no budget from " + corporate.Name)
+	}
+	reclaimed := allowance.Cap - allowance.Spent
+	allowance.Cap = allowance.Spent //kept so the statement still shows what was spent
+	jsonAsBytes, _ := json.Marshal(*allowance)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(formatAmount(reclaimed)), nil
+}
+
+// ============================================================================================================================
+// Corporate Statement - a corporate account's employee wallets and what each spent over a date range
+// ============================================================================================================================
+func (t *SimpleChaincode) corporateStatement(stub *programStub, args []string) ([]byte, error) {
+	//     0              1             2
+	// "Corporate", "2016-06-01", "2016-06-30"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	corporate, err := getCorporate(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	from, to, err := parseDateRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	allowances, err := corporateAllowances(stub, corporate.Name)
+	if err != nil {
+		return nil, err
+	}
+	records, err := getTxnRecords(stub, corporate.Name, from, to)
+	if err != nil {
+		return nil, err
+	}
+	spent := map[string]float64{}
+	for _, rec := range records {
+		if rec.Type == "transfer" && rec.From == corporate.Name && strings.HasPrefix(rec.Reference, allowanceType+":") {
+			spent[strings.TrimPrefix(rec.Reference, allowanceType+":")] += rec.Points
+		}
+	}
+
+	statement := CorporateStatement{Corporate: corporate.Name, From: args[1], To: args[2], PtBal: corporate.PtBal, Employees: []EmployeeBudget{}}
+	for _, allowance := range allowances {
+		budget := EmployeeBudget{allowance.Spender, allowance.Cap, allowance.Spent, allowance.Cap - allowance.Spent, spent[allowance.Spender]}
+		statement.Committed = statement.Committed + budget.Remaining
+		statement.SpentRange = statement.SpentRange + budget.SpentRange
+		statement.Employees = append(statement.Employees, budget)
+	}
+	jsonAsBytes, _ := json.Marshal(statement)
+	return jsonAsBytes, nil
+}
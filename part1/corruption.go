@@ -0,0 +1,201 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// A value that cannot be decoded must never be treated as a zero value, or a transfer would happily overwrite a
+// corrupted entity with a fresh empty one. Every read of structured state decodes through unmarshalState, which fails
+// with a STATE_CORRUPTION error naming the offending key (quoted, since composite keys contain null bytes) so an admin
+// can inspect it and fix it with repair_record.
+var repairType = "repair" //composite key object type for the audit trail of repaired records, keyed by timestamp then id
+
+// RepairRecord is the audit trail of one repair_record call
+type RepairRecord struct {
+	ID        string `json:"id"`
+	Key       string `json:"key"` //quoted
+	Before    string `json:"before"`
+	After     string `json:"after"` //empty when the record was deleted
+	Reason    string `json:"reason"`
+	Caller    string `json:"caller"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ============================================================================================================================
+// stateCorruption - the error for a stored value at key that does not decode
+// ============================================================================================================================
+func stateCorruption(key string, err error) error {
+	chaincodeLog.error("STATE_CORRUPTION at " + strconv.Quote(key) + ": " + err.Error())
+	return errors.New("STATE_CORRUPTION: " + strconv.Quote(key) + ": " + err.Error())
+}
+
+// ============================================================================================================================
+// unmarshalState - decode a stored json value, STATE_CORRUPTION if it does not decode
+// ============================================================================================================================
+func unmarshalState(key string, valAsBytes []byte, v interface{}) error {
+	err := json.Unmarshal(valAsBytes, v)
+	if err != nil {
+		return stateCorruption(key, err)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// parseStateFloat - decode a stored number such as a counter, STATE_CORRUPTION if it does not decode
+// ============================================================================================================================
+func parseStateFloat(key string, valAsBytes []byte) (float64, error) {
+	val, err := strconv.ParseFloat(string(valAsBytes), 64)
+	if err != nil {
+		return 0, stateCorruption(key, err)
+	}
+	return val, nil
+}
+
+// ============================================================================================================================
+// getEntityIndex - the names of every entity, empty before the first entity is created
+// ============================================================================================================================
+func getEntityIndex(stub *programStub) ([]string, error) {
+	entityAsBytes, err := getLargeState(stub, entityIndexStr)
+	if err != nil {
+		return nil, errors.New("Failed to get entity index")
+	}
+	var entityIndex []string
+	if entityAsBytes != nil {
+		err = unmarshalState(entityIndexStr, entityAsBytes, &entityIndex)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entityIndex, nil
+}
+
+// ============================================================================================================================
+// Repair Record - admin only, overwrite or delete a corrupted record. Entities and the config must decode as their
+// type before they are written, and the old value is kept in an audit record.
+// ============================================================================================================================
+func (t *SimpleChaincode) repairRecord(stub *programStub, args []string) ([]byte, error) {
+	//    0        1         2
+	// "Key", "{...json}", "Reason"   (key quoted or raw, empty value deletes the record)
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	key := args[0]
+	if unquoted, err := strconv.Unquote(args[0]); err == nil {
+		key = unquoted
+	}
+	if len(key) <= 0 {
+		return nil, errors.New("1st argument must be a non-empty key")
+	}
+	if len(args[2]) <= 0 {
+		return nil, errors.New("3rd argument must give a reason")
+	}
+
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil && key != entityIndexStr {
+		return nil, err
+	}
+	isEntity := false
+	for _, name := range entityIndex {
+		isEntity = isEntity || name == key
+	}
+	var check interface{}
+	if args[1] != "" {
+		if key == configStr {
+			check = &Config{}
+		} else if key == entityIndexStr {
+			check = &[]string{}
+		} else if isEntity {
+			check = &Entity{}
+		}
+		if check != nil {
+			err = decodeJSONArg(args[1], check)
+			if err != nil {
+				return nil, errors.New("2nd argument does not decode as the record stored under this key: " + err.Error())
+			}
+			if entity, ok := check.(*Entity); ok && entity.Name != key {
+				return nil, errors.New("2nd argument must be an entity named " + key)
+			}
+		}
+	}
+	beforeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get record")
+	}
+	caller, _ := callerID(stub)
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	//an entity goes through the same write path as any other change so the counters and indexes follow it, measured
+	//against what was stored as long as that still decodes
+	var prev *Entity
+	if isEntity && beforeAsBytes != nil {
+		var stored Entity
+		if json.Unmarshal(beforeAsBytes, &stored) == nil {
+			prev = &stored
+		}
+	}
+
+	stub.log.debug("start repair record " + strconv.Quote(key))
+	if entity, ok := check.(*Entity); ok {
+		err = writeEntity(stub, prev, *entity)
+	} else if isEntity && args[1] == "" {
+		err = entityChanged(stub, prev, nil)
+		if err == nil {
+			err = stub.DelState(key)
+		}
+	} else if key == entityIndexStr && args[1] == "" {
+		err = delLargeState(stub, key)
+	} else if key == entityIndexStr {
+		err = putLargeState(stub, key, []byte(args[1]))
+	} else if args[1] == "" {
+		err = stub.DelState(key)
+	} else {
+		err = stub.PutState(key, []byte(args[1]))
+	}
+	if err != nil {
+		return nil, err
+	}
+	after := args[1]
+	if _, ok := check.(*Entity); ok { //an entity gains its version and activity stamps on the way in
+		afterAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return nil, errors.New("Failed to get record")
+		}
+		after = string(afterAsBytes)
+	}
+	repair := RepairRecord{ID: newID(stub, repairType), Key: strconv.Quote(key), Before: string(beforeAsBytes), After: after, Reason: args[2], Caller: caller, Timestamp: timestamp}
+	repairKey, err := createCompositeKey(repairType, []string{timestampKey(timestamp), repair.ID})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(repair)
+	err = stub.PutState(repairKey, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end repair record")
+	return nil, nil
+}
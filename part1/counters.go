@@ -0,0 +1,198 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Counters written by every transaction (total supply, merchant issuance) would make concurrent transactions collide
+// on one key. Instead each change is written as its own delta key, readers add the deltas to the compacted base value,
+// and compact_counters periodically folds the deltas into the base.
+var counterType = "counter"           //composite key object type for compacted counter values, keyed by counter name
+var counterDeltaType = "counterdelta" //composite key object type for counter changes, keyed by counter name then id
+
+// ============================================================================================================================
+// addToCounter - record a change to a counter without reading it
+// ============================================================================================================================
+func addToCounter(stub *programStub, delta float64, name ...string) error {
+	if delta == 0 {
+		return nil
+	}
+	key, err := createCompositeKey(counterDeltaType, append(name, newID(stub, counterDeltaType)))
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(formatAmount(delta)))
+}
+
+// ============================================================================================================================
+// readCounter - compacted value of a counter plus every delta written since
+// ============================================================================================================================
+func readCounter(stub *programStub, name ...string) (float64, error) {
+	baseKey, err := createCompositeKey(counterType, name)
+	if err != nil {
+		return 0, err
+	}
+	baseAsBytes, err := stub.GetState(baseKey)
+	if err != nil {
+		return 0, errors.New("Failed to get counter")
+	}
+	var total float64
+	if baseAsBytes != nil {
+		total, err = parseStateFloat(baseKey, baseAsBytes)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	keysIter, err := getStateByPartialCompositeKey(stub, counterDeltaType, name)
+	if err != nil {
+		return 0, errors.New("Failed to get counter deltas")
+	}
+	defer keysIter.Close()
+	for keysIter.HasNext() {
+		key, deltaAsBytes, err := keysIter.Next()
+		if err != nil {
+			return 0, errors.New("Failed to get counter deltas")
+		}
+		_, attrs, err := splitCompositeKey(key)
+		if err != nil || len(attrs) != len(name)+1 { //a longer counter name that shares this prefix
+			continue
+		}
+		delta, err := parseStateFloat(key, deltaAsBytes)
+		if err != nil {
+			return 0, err
+		}
+		total = total + delta
+	}
+	return total, nil
+}
+
+// ============================================================================================================================
+// setCounter - overwrite a counter with a recounted value, dropping the deltas written under exactly that name
+// ============================================================================================================================
+func setCounter(stub *programStub, value float64, name ...string) error {
+	keysIter, err := getStateByPartialCompositeKey(stub, counterDeltaType, name)
+	if err != nil {
+		return errors.New("Failed to get counter deltas")
+	}
+	var deltaKeys []string
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return errors.New("Failed to get counter deltas")
+		}
+		_, attrs, err := splitCompositeKey(key)
+		if err == nil && len(attrs) == len(name)+1 {
+			deltaKeys = append(deltaKeys, key)
+		}
+	}
+	keysIter.Close()
+
+	for _, key := range deltaKeys {
+		err = stub.DelState(key)
+		if err != nil {
+			return errors.New("Failed to delete counter delta")
+		}
+	}
+	baseKey, err := createCompositeKey(counterType, name)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(baseKey, []byte(formatAmount(value)))
+}
+
+// ============================================================================================================================
+// Compact Counters - admin only, fold counter deltas into their base values, every counter when no name is given
+// ============================================================================================================================
+func (t *SimpleChaincode) compactCounters(stub *programStub, args []string) ([]byte, error) {
+	//    0        1
+	// *"issued", "Merchant"*   (counter name parts, or none)
+	stub.log.debug("start compact counters")
+	keysIter, err := getStateByPartialCompositeKey(stub, counterDeltaType, args)
+	if err != nil {
+		return nil, errors.New("Failed to get counter deltas")
+	}
+
+	var names [][]string //in key order, so every peer compacts identically
+	sums := map[string]float64{}
+	var deltaKeys []string
+	for keysIter.HasNext() {
+		key, deltaAsBytes, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return nil, errors.New("Failed to get counter deltas")
+		}
+		_, attrs, err := splitCompositeKey(key)
+		if err != nil || len(attrs) < 2 {
+			continue
+		}
+		delta, err := parseStateFloat(key, deltaAsBytes)
+		if err != nil {
+			keysIter.Close()
+			return nil, err
+		}
+		name := attrs[:len(attrs)-1]
+		baseKey, _ := createCompositeKey(counterType, name)
+		if _, ok := sums[baseKey]; !ok {
+			names = append(names, name)
+		}
+		sums[baseKey] = sums[baseKey] + delta
+		deltaKeys = append(deltaKeys, key)
+	}
+	keysIter.Close()
+
+	for _, name := range names {
+		total, err := readCounter(stub, name...)
+		if err != nil {
+			return nil, err
+		}
+		baseKey, _ := createCompositeKey(counterType, name)
+		err = stub.PutState(baseKey, []byte(formatAmount(total)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range deltaKeys {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, errors.New("Failed to delete counter delta")
+		}
+	}
+	stub.log.debug("end compact counters, " + strconv.Itoa(len(deltaKeys)) + " deltas folded")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Counter - current value of a counter, e.g. "supply" or "issued", "Merchant"
+// ============================================================================================================================
+func (t *SimpleChaincode) getCounter(stub *programStub, args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting the counter name")
+	}
+	total, err := readCounter(stub, args...)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(formatAmount(total)), nil
+}
@@ -0,0 +1,209 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var entityCSVHeader = []string{"name", "role", "txnbal", "ptbal"} //columns of entity imports and exports
+var maxImportRows = 500
+var defaultExportPageSize = 100
+var maxExportPageSize = 1000
+
+// ImportRow is the outcome of one data row of an entity import, rows count from 1 after the header
+type ImportRow struct {
+	Row    int    `json:"row"`
+	Name   string `json:"name"`
+	Status string `json:"status"` //created or rejected
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport is returned by import_entities_csv
+type ImportReport struct {
+	Created  int         `json:"created"`
+	Rejected int         `json:"rejected"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+// ExportPage is one page of export_entities_csv, pass the bookmark back to export the next one
+type ExportPage struct {
+	CSV      string `json:"csv"`
+	Bookmark string `json:"bookmark"` //last name exported, empty once every entity was exported
+}
+
+// ============================================================================================================================
+// checkImportRow - the reason a row cannot be imported, nil if initEntity should accept it
+// ============================================================================================================================
+func checkImportRow(stub *programStub, config Config, row []string, seen map[string]bool) error {
+	if len(row) != len(entityCSVHeader) {
+		return errors.New("Expecting " + strconv.Itoa(len(entityCSVHeader)) + " columns")
+	}
+	if len(row[0]) <= 0 || len(row[1]) <= 0 {
+		return errors.New("name and role must be non-empty")
+	}
+	if err := checkEntityName(row[0]); err != nil {
+		return err
+	}
+	if seen[row[0]] {
+		return errors.New(row[0] + " appears more than once")
+	}
+	canonical, err := resolveAlias(stub, row[0])
+	if err != nil {
+		return err
+	}
+	if canonical != row[0] {
+		return errors.New(row[0] + " was merged into " + canonical)
+	}
+	existing, err := storedEntity(stub, row[0])
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New(row[0] + " already exists")
+	}
+	for i, what := range []string{"txnbal", "ptbal"} {
+		val, err := parseAmount(row[2+i])
+		if (err != nil) || (val < 0) {
+			return amountError(what+" must be a non-negative number", err)
+		}
+		err = checkAmount(config.Bounds, val, what)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Import Entities CSV - admin only, create the entities listed in a base64 encoded CSV of name, role, txnbal, ptbal.
+// Rows that fail validation are reported and skipped, the rest are created in this one transaction.
+// ============================================================================================================================
+func (t *SimpleChaincode) importEntitiesCSV(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// "Base64CSV"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	csvAsBytes, err := base64.StdEncoding.DecodeString(args[0])
+	if err != nil {
+		return nil, errors.New("1st argument must be base64 encoded")
+	}
+	reader := csv.NewReader(bytes.NewReader(csvAsBytes))
+	reader.FieldsPerRecord = -1 //column counts are checked per row so one bad row does not fail the import
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.New("1st argument must be a CSV document: " + err.Error())
+	}
+	if len(rows) > 0 && strings.ToLower(strings.Join(rows[0], ",")) == strings.Join(entityCSVHeader, ",") {
+		rows = rows[1:]
+	}
+	if len(rows) == 0 || len(rows) > maxImportRows {
+		return nil, errors.New("Expecting 1 to " + strconv.Itoa(maxImportRows) + " rows")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start import entities")
+	report := ImportReport{Rows: []ImportRow{}}
+	seen := map[string]bool{}
+	for i, row := range rows {
+		result := ImportRow{Row: i + 1, Status: "created"}
+		if len(row) > 0 {
+			result.Name = row[0]
+		}
+		err = checkImportRow(stub, config, row, seen)
+		if err != nil {
+			result.Status = "rejected"
+			result.Error = err.Error()
+			report.Rejected++
+			report.Rows = append(report.Rows, result)
+			continue
+		}
+		seen[row[0]] = true
+		_, err = t.initEntity(stub, row)
+		if err != nil { //not a bad row, such as the supply cap, so nothing is imported
+			return nil, errors.New("Row " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+		report.Created++
+		report.Rows = append(report.Rows, result)
+	}
+	stub.log.debug("end import entities, " + strconv.Itoa(report.Created) + " created")
+	jsonAsBytes, _ := json.Marshal(report)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Export Entities CSV - admin only, a page of entities as CSV with a header row, in name order
+// ============================================================================================================================
+func (t *SimpleChaincode) exportEntitiesCSV(stub *programStub, args []string) ([]byte, error) {
+	//      0             1
+	// *"PageSize"* *"Bookmark"*
+	if len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 2")
+	}
+	for len(args) < 2 {
+		args = append(args, "")
+	}
+	size, err := pageSize(stub, args[0], "1st", defaultExportPageSize, maxExportPageSize)
+	if err != nil {
+		return nil, err
+	}
+	bookmark := args[1]
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	names := namesAfter(entityIndex, bookmark)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(entityCSVHeader)
+	page := ExportPage{}
+	exported := 0
+	i := 0
+	for ; i < len(names) && exported < size && i < maxScanRecords; i++ { //deleted names count against the scan budget too
+		entity, err := storedEntity(stub, names[i])
+		if err != nil {
+			return nil, err
+		}
+		if entity == nil {
+			continue
+		}
+		writer.Write([]string{entity.Name, entity.Role, formatAmount(entity.TxnBal), formatAmount(entity.PtBal)})
+		exported++
+	}
+	if i < len(names) {
+		page.Bookmark = names[i-1]
+	}
+	writer.Flush()
+	page.CSV = buf.String()
+	jsonAsBytes, _ := json.Marshal(page)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,98 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// Go randomises map iteration, and every peer endorsing a transaction must produce the same writes and the same
+// response bytes, so anything that walks a map to write state, build output or add up numbers goes through these
+
+// ============================================================================================================================
+// sortedKeys - the keys of a map with string keys, in ascending order
+// ============================================================================================================================
+func sortedKeys(m interface{}) []string {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		panic("sortedKeys needs a map with string keys, got " + v.Kind().String())
+	}
+	keys := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ============================================================================================================================
+// sortedStrings - a sorted copy of a list, the list itself is left in its stored order
+// ============================================================================================================================
+func sortedStrings(list []string) []string {
+	sorted := append([]string(nil), list...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// ============================================================================================================================
+// namesAfter - the sorted names that come after a bookmark, all of them for an empty bookmark, so a paged scan
+// resumes at the same place on every peer however the list was stored
+// ============================================================================================================================
+func namesAfter(names []string, bookmark string) []string {
+	sorted := sortedStrings(names)
+	start := sort.SearchStrings(sorted, bookmark)
+	if start < len(sorted) && sorted[start] == bookmark {
+		start++
+	}
+	return sorted[start:]
+}
+
+// ============================================================================================================================
+// sumValues - total of a map of amounts, added in key order so float rounding comes out the same everywhere
+// ============================================================================================================================
+func sumValues(m map[string]float64) float64 {
+	var total float64
+	for _, key := range sortedKeys(m) {
+		total = total + m[key]
+	}
+	return total
+}
+
+// ============================================================================================================================
+// stableJSON - marshal with object keys sorted at every depth, json.Marshal only sorts its own map keys and copies
+// embedded raw messages, such as stored records, as they are; struct fields come out in name order as well
+// ============================================================================================================================
+func stableJSON(v interface{}) ([]byte, error) {
+	jsonAsBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonAsBytes))
+	decoder.UseNumber() //numbers keep their exact text
+	var generic interface{}
+	err = decoder.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
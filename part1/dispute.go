@@ -0,0 +1,317 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var disputeType = "dispute"         //composite key object type for disputes, keyed by dispute id
+var openDisputeType = "disputeopen" //composite key object type indexing open disputes, keyed by merchant then dispute id
+
+// Dispute challenges one transaction record until an admin upholds or reverses it
+type Dispute struct {
+	ID         string `json:"id"`
+	RecordID   string `json:"record_id"`
+	OpenedBy   string `json:"opened_by"`
+	Merchant   string `json:"merchant"` //merchant side of the record, empty if neither party is a merchant
+	Reason     string `json:"reason"`
+	Status     string `json:"status"` //open, upheld or reversed
+	Resolution string `json:"resolution"`
+	OpenedAt   int64  `json:"opened_at"`
+	ResolvedAt int64  `json:"resolved_at"`
+}
+
+// ============================================================================================================================
+// getDispute - fetch a dispute by id
+// ============================================================================================================================
+func getDispute(stub *programStub, id string) (Dispute, error) {
+	var dispute Dispute
+	key, err := createCompositeKey(disputeType, []string{id})
+	if err != nil {
+		return dispute, err
+	}
+	disputeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return dispute, errors.New("Failed to get dispute")
+	}
+	if disputeAsBytes == nil {
+		return dispute, errors.New("Dispute " + id + " does not exist")
+	}
+	err = unmarshalState(key, disputeAsBytes, &dispute)
+	if err != nil {
+		return dispute, err
+	}
+	return dispute, nil
+}
+
+// ============================================================================================================================
+// putDispute - write a dispute and keep the open disputes index in step with its status
+// ============================================================================================================================
+func putDispute(stub *programStub, dispute Dispute) error {
+	key, err := createCompositeKey(disputeType, []string{dispute.ID})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(dispute)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	if dispute.Merchant == "" {
+		return nil
+	}
+	openKey, err := createCompositeKey(openDisputeType, []string{dispute.Merchant, dispute.ID})
+	if err != nil {
+		return err
+	}
+	if dispute.Status == "open" {
+		return stub.PutState(openKey, []byte(dispute.ID))
+	}
+	return stub.DelState(openKey)
+}
+
+// ============================================================================================================================
+// Open Dispute - a party to a transaction record disputes it
+// ============================================================================================================================
+func (t *SimpleChaincode) openDispute(stub *programStub, args []string) ([]byte, error) {
+	//     0           1          2
+	// "RecordID", "OpenedBy", "Reason"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	rec, keys, err := getTxnRecordByID(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	opener, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	from, err := resolveAlias(stub, rec.From) //a merge survivor answers for the records of the entity it absorbed
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolveAlias(stub, rec.To)
+	if err != nil {
+		return nil, err
+	}
+	if from != opener.Name && to != opener.Name {
+		return nil, errors.New(opener.Name + " is not a party to " + rec.ID)
+	}
+	if rec.Type != "earn" && rec.Type != "redeem" && rec.Type != "transfer" {
+		return nil, errors.New(rec.Type + " records cannot be disputed")
+	}
+	if rec.Status != "" {
+		return nil, errors.New("Transaction record " + rec.ID + " is already " + rec.Status)
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start open dispute")
+	dispute := Dispute{ID: newID(stub, disputeType), RecordID: rec.ID, OpenedBy: opener.Name, Reason: args[2], Status: "open", OpenedAt: timestamp}
+	for _, party := range []string{rec.From, rec.To} {
+		if party == "" {
+			continue
+		}
+		entity, err := getEntity(stub, party)
+		if err == nil && entity.Role == merchantRole {
+			dispute.Merchant = entity.Name
+		}
+	}
+	err = putDispute(stub, dispute)
+	if err != nil {
+		return nil, err
+	}
+	rec.Status = "disputed"
+	err = updateTxnRecord(stub, rec, keys)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end open dispute")
+	return []byte(dispute.ID), nil
+}
+
+// ============================================================================================================================
+// Resolve Dispute - admin only, uphold the disputed transaction or reverse its point movement
+// ============================================================================================================================
+func (t *SimpleChaincode) resolveDispute(stub *programStub, args []string) ([]byte, error) {
+	//     0                1               2
+	// "DisputeID", "uphold|reverse", "Resolution"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	if args[1] != "uphold" && args[1] != "reverse" {
+		return nil, errors.New("2nd argument must be uphold or reverse")
+	}
+	dispute, err := getDispute(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if dispute.Status != "open" {
+		return nil, errors.New("Dispute " + dispute.ID + " is already " + dispute.Status)
+	}
+	rec, keys, err := getTxnRecordByID(stub, dispute.RecordID)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start resolve dispute")
+	if args[1] == "reverse" {
+		err = reverseTxn(stub, rec)
+		if err != nil {
+			return nil, err
+		}
+		dispute.Status = "reversed"
+	} else {
+		dispute.Status = "upheld"
+	}
+	dispute.Resolution = args[2]
+	dispute.ResolvedAt = timestamp
+	err = putDispute(stub, dispute)
+	if err != nil {
+		return nil, err
+	}
+	rec.Status = dispute.Status
+	err = updateTxnRecord(stub, rec, keys)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end resolve dispute")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// reverseTxn - undo the point movement of an earn, redeem or transfer record. Reversing an earn takes the points out
+// of circulation again since the merchant never paid them out of its own balance, and takes them from the restricted
+// bucket they went into first. Reversing a redeem also books the negative settlement with the settlement chaincode.
+// ============================================================================================================================
+func reverseTxn(stub *programStub, rec TxnRecord) error {
+	to, err := getEntity(stub, rec.To)
+	if err != nil {
+		return err
+	}
+	var categories []string
+	if rec.Type == "earn" && rec.Category != "" {
+		categories = []string{rec.Category}
+	} else if rec.Type == "earn" && rec.From != "" { //recorded before earns kept their category
+		merchant, err := getEntity(stub, rec.From)
+		if err == nil {
+			categories = merchant.Categories
+		}
+	}
+	toBefore := to
+	if !drawPoints(&to, categories, rec.Points) {
+		return errors.New(to.Name + " no longer holds the points to reverse")
+	}
+	balances := []BalanceChange{}
+
+	reversal := TxnRecord{Type: "reversal", From: to.Name, Points: rec.Points, Reference: rec.ID}
+	if rec.Type == "earn" {
+		err = changeSupply(stub, -rec.Points, "reversal")
+		if err != nil {
+			return err
+		}
+		if rec.From != "" {
+			err = countIssued(stub, rec.From, -rec.Points)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		from, err := getEntity(stub, rec.From)
+		if err != nil {
+			return err
+		}
+		fromBefore := from
+		from.PtBal = from.PtBal + rec.Points
+		if rec.Type == "transfer" {
+			from.TxnBal = from.TxnBal + rec.Amount
+			to.TxnBal = to.TxnBal - rec.Amount
+			reversal.Amount = rec.Amount
+		}
+		err = putEntity(stub, from)
+		if err != nil {
+			return err
+		}
+		reversal.To = from.Name
+		balances = append(balances, balanceChange(fromBefore, from))
+	}
+	err = putEntity(stub, to)
+	if err != nil {
+		return err
+	}
+	balances = append(balances, balanceChange(toBefore, to))
+	err = recordTxn(stub, reversal, balances...)
+	if err != nil {
+		return err
+	}
+
+	if rec.Type == "redeem" {
+		config, err := getConfig(stub)
+		if err != nil {
+			return err
+		}
+		if config.SettlementChaincode != "" {
+			_, err = stub.InvokeChaincode(config.SettlementChaincode, "record_settlement", []string{settlementMerchant(to), formatAmount(-rec.Points), "reversal:" + rec.ID})
+			if err != nil {
+				stub.log.error("Settlement reversal failed")
+				return errors.New("Settlement reversal failed: " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// List Open Disputes - every open dispute involving a merchant
+// ============================================================================================================================
+func (t *SimpleChaincode) listOpenDisputes(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the merchant to query")
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, openDisputeType, []string{args[0]})
+	if err != nil {
+		return nil, errors.New("Failed to get open disputes")
+	}
+	defer keysIter.Close()
+
+	disputes := []Dispute{}
+	for keysIter.HasNext() {
+		_, idAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get open disputes")
+		}
+		dispute, err := getDispute(stub, string(idAsBytes))
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, dispute)
+	}
+	jsonAsBytes, _ := json.Marshal(disputes)
+	return jsonAsBytes, nil
+}
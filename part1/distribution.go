@@ -0,0 +1,171 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+)
+
+var distributionType = "distribution" //composite key object type for pro rata distribution plans, keyed by plan id
+
+// DistributionPlan records how a pool of points was split over weighted recipients
+type DistributionPlan struct {
+	ID          string       `json:"id"`
+	Sender      string       `json:"sender"`
+	Pool        int64        `json:"pool"`
+	Allocations []Allocation `json:"allocations"`
+	TxID        string       `json:"txid"`
+	Timestamp   int64        `json:"timestamp"`
+}
+
+// Allocation is one recipient's share of a distribution
+type Allocation struct {
+	Recipient string  `json:"recipient"`
+	Weight    float64 `json:"weight"`
+	Points    int64   `json:"points"`
+}
+
+// ============================================================================================================================
+// allocateProRata - split a whole number pool over the weights with the largest remainder method, the leftover points
+// go to the largest fractional shares and ties go to the earlier recipient, so every peer computes the same plan
+// ============================================================================================================================
+func allocateProRata(pool int64, allocations []Allocation) {
+	totalWeight := 0.0
+	for _, a := range allocations {
+		totalWeight = totalWeight + a.Weight
+	}
+	remainders := make([]float64, len(allocations))
+	assigned := int64(0)
+	for i := range allocations {
+		share := float64(pool) * allocations[i].Weight / totalWeight
+		allocations[i].Points = int64(math.Floor(share))
+		remainders[i] = share - math.Floor(share)
+		assigned = assigned + allocations[i].Points
+	}
+
+	order := make([]int, len(allocations))
+	for i := range order {
+		order[i] = i
+	}
+	for i := 1; i < len(order); i++ { //stable insertion sort, largest remainder first
+		for j := i; j > 0 && remainders[order[j]] > remainders[order[j-1]]; j-- {
+			order[j], order[j-1] = order[j-1], order[j]
+		}
+	}
+	for i := int64(0); i < pool-assigned; i++ {
+		allocations[order[i%int64(len(order))]].Points++
+	}
+}
+
+// ============================================================================================================================
+// Distribute Pro Rata - split a pool of the sender's points over recipients by weight and credit each share, transfer
+// fees apply to every share as they would to a transfer
+// ============================================================================================================================
+func (t *SimpleChaincode) distributeProRata(stub *programStub, args []string) ([]byte, error) {
+	//     0         1           2            3            4            5
+	// "Sender", "Pool", "Recipient1", "Weight1", "Recipient2", "Weight2", ...
+	if len(args) < 4 || len(args)%2 != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting a sender, a pool then recipient and weight pairs")
+	}
+	if (len(args)-2)/2 > maxSplitRecipients {
+		return nil, errors.New("At most " + strconv.Itoa(maxSplitRecipients) + " recipients per distribution")
+	}
+	pool, err := strconv.ParseInt(args[1], 10, 64)
+	if (err != nil) || (pool <= 0) {
+		return nil, errors.New("2nd argument must be a positive integer")
+	}
+	sender, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if transferablePoints(sender) < float64(pool) {
+		return nil, errors.New("Insufficient points")
+	}
+
+	var allocations []Allocation
+	seen := map[string]bool{}
+	for i := 2; i < len(args); i = i + 2 {
+		weight, err := parseAmount(args[i+1])
+		if (err != nil) || (weight <= 0) || math.IsInf(weight, 0) {
+			return nil, amountError("Weight for "+args[i]+" must be a positive numeric string", err)
+		}
+		recipient, err := getEntity(stub, args[i])
+		if err != nil {
+			return nil, err
+		}
+		if recipient.Name == sender.Name || seen[recipient.Name] {
+			return nil, errors.New(recipient.Name + " cannot receive from this distribution more than once")
+		}
+		seen[recipient.Name] = true
+		allocations = append(allocations, Allocation{Recipient: recipient.Name, Weight: weight})
+	}
+	allocateProRata(pool, allocations)
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start distribute pro rata")
+	for _, a := range allocations {
+		if a.Points <= 0 {
+			continue
+		}
+		_, err = t.transfer(stub, []string{sender.Name, a.Recipient, "0", strconv.FormatInt(a.Points, 10)})
+		if err != nil {
+			return nil, errors.New("Transfer to " + a.Recipient + " failed: " + err.Error())
+		}
+	}
+	plan := DistributionPlan{newID(stub, distributionType), sender.Name, pool, allocations, stub.UUID, timestamp}
+	key, err := createCompositeKey(distributionType, []string{plan.ID})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(plan)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end distribute pro rata")
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Get Distribution - the recorded plan of a pro rata distribution
+// ============================================================================================================================
+func (t *SimpleChaincode) getDistribution(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the distribution to query")
+	}
+	key, err := createCompositeKey(distributionType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	planAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get distribution")
+	}
+	if planAsBytes == nil {
+		return nil, errors.New("Distribution " + args[0] + " does not exist")
+	}
+	return planAsBytes, nil
+}
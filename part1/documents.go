@@ -0,0 +1,177 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var documentType = "document" //composite key object type for anchored documents, keyed by entity then sha256 hash
+var maxDocTypeLen = 64
+var maxDocURILen = 256
+var maxDocumentsPerEntity = 64
+
+// DocumentAnchor proves a document such as a KYC scan or a signed agreement existed unchanged when it was anchored,
+// the document itself stays off chain
+type DocumentAnchor struct {
+	Entity     string `json:"entity"`
+	Hash       string `json:"hash"` //hex sha256 of the document
+	DocType    string `json:"doc_type"`
+	URI        string `json:"uri"`         //where the document is kept off chain, may be empty
+	AnchoredBy string `json:"anchored_by"` //caller fingerprint
+	TxID       string `json:"txid"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// ============================================================================================================================
+// getDocumentAnchors - every document anchored on an entity, in hash order
+// ============================================================================================================================
+func getDocumentAnchors(stub *programStub, name string) ([]DocumentAnchor, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, documentType, []string{name})
+	if err != nil {
+		return nil, errors.New("Failed to get documents")
+	}
+	defer keysIter.Close()
+
+	anchors := []DocumentAnchor{}
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get documents")
+		}
+		var anchor DocumentAnchor
+		err = unmarshalState(key, valAsBytes, &anchor)
+		if err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, anchor)
+	}
+	return anchors, nil
+}
+
+// ============================================================================================================================
+// Anchor Document - record the sha256 hash of an entity's document, its type and where it is kept off chain
+// ============================================================================================================================
+func (t *SimpleChaincode) anchorDocument(stub *programStub, args []string) ([]byte, error) {
+	//   0        1          2         3
+	// "Name", "Sha256", "DocType", "URI"   (URI may be empty)
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	hash := strings.ToLower(args[1])
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+		return nil, errors.New("2nd argument must be a hex encoded sha256 hash")
+	}
+	if len(args[2]) <= 0 || len(args[2]) > maxDocTypeLen {
+		return nil, errors.New("3rd argument must be a non-empty string of at most " + strconv.Itoa(maxDocTypeLen) + " bytes")
+	}
+	if len(args[3]) > maxDocURILen {
+		return nil, errors.New("4th argument must be at most " + strconv.Itoa(maxDocURILen) + " bytes")
+	}
+	key, err := createCompositeKey(documentType, []string{entity.Name, hash})
+	if err != nil {
+		return nil, err
+	}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get document")
+	}
+	if existingAsBytes != nil {
+		return nil, errors.New("Document " + hash + " is already anchored on " + entity.Name)
+	}
+	anchors, err := getDocumentAnchors(stub, entity.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(anchors) >= maxDocumentsPerEntity {
+		return nil, errors.New(entity.Name + " already has " + strconv.Itoa(maxDocumentsPerEntity) + " anchored documents")
+	}
+	actor, err := callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("anchor " + args[2] + " document on " + entity.Name)
+	anchor := DocumentAnchor{entity.Name, hash, args[2], args[3], actor, stub.UUID, timestamp}
+	jsonAsBytes, _ := json.Marshal(anchor)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// List Documents - every document anchored on an entity
+// ============================================================================================================================
+func (t *SimpleChaincode) listDocuments(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	anchors, err := getDocumentAnchors(stub, entity.Name)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(anchors)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Verify Document - the anchor of a document hash on an entity, null when that document was never anchored
+// ============================================================================================================================
+func (t *SimpleChaincode) verifyDocument(stub *programStub, args []string) ([]byte, error) {
+	//   0        1
+	// "Name", "Sha256"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	key, err := createCompositeKey(documentType, []string{entity.Name, strings.ToLower(args[1])})
+	if err != nil {
+		return nil, err
+	}
+	anchorAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get document")
+	}
+	if anchorAsBytes == nil {
+		return []byte("null"), nil
+	}
+	return anchorAsBytes, nil
+}
@@ -0,0 +1,493 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Earn rules let admins run promotions as data: every earn with a purchase amount is matched against the stored rules
+// and each matching rule adds purchase * multiplier + bonus points on top of the points the merchant asked for. A rule
+// with a budget is a campaign, it grants at most that many bonus points in total and stops applying once they are gone.
+// Campaigns can also cap how often one customer benefits and how many customers benefit at all.
+var earnRuleType = "earnrule"       //composite key object type for earn rules, keyed by rule id
+var campaignUseType = "campaignuse" //composite key object type counting a customer's bonuses from a rule, keyed by rule then customer
+var maxEarnRules = 128              //rules are all read on every earn, so keep the set small
+var maxEarnRuleID = 64
+var earnRuleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"} //time.Weekday order
+
+// EarnRule adds points to earns that match all of its conditions, an empty condition matches everything
+type EarnRule struct {
+	ID             string   `json:"id"`
+	Priority       int      `json:"priority"`                  //higher priorities are evaluated first, ties in id order
+	Merchant       string   `json:"merchant,omitempty"`        //the merchant, or the parent of the store location, that issues
+	Category       string   `json:"category,omitempty"`        //a category the issuing merchant is tagged with
+	MinAmount      float64  `json:"min_amount,omitempty"`      //purchase amount at least this
+	MaxAmount      float64  `json:"max_amount,omitempty"`      //purchase amount below this, zero for no upper bound
+	Days           []string `json:"days,omitempty"`            //UTC days of the week, sun to sat
+	MinStreakDays  int      `json:"min_streak_days,omitempty"` //the customer's streak at the merchant, this earn included
+	MinStreakWeeks int      `json:"min_streak_weeks,omitempty"`
+	Multiplier     float64  `json:"multiplier"`                 //points per unit of purchase amount
+	Bonus          float64  `json:"bonus"`                      //flat points
+	Final          bool     `json:"final,omitempty"`            //lower priority rules are skipped once this one matched
+	Budget         float64  `json:"budget,omitempty"`           //total bonus points the rule may grant, zero for no cap
+	Granted        float64  `json:"granted"`                    //bonus points granted so far, kept when the rule is replaced
+	Exhausted      bool     `json:"exhausted,omitempty"`        //the budget is used up, the rule no longer applies
+	MaxPerCustomer int      `json:"max_per_customer,omitempty"` //bonuses one customer may get from the rule, zero for no cap
+	MaxCustomers   int      `json:"max_customers,omitempty"`    //customers that may get a bonus at all, the first ones win
+	Customers      int      `json:"customers"`                  //customers that got a bonus so far, kept when the rule is replaced
+	UpdatedBy      string   `json:"updated_by"`
+	UpdatedAt      int64    `json:"updated_at"`
+}
+
+// EarnRuleGrant is the bonus points one rule adds to an earn
+type EarnRuleGrant struct {
+	Rule   string  `json:"rule"`
+	Points float64 `json:"points"`
+}
+
+// EarnRuleResult is the points the rules add to one earn and the rules that matched, in evaluation order
+type EarnRuleResult struct {
+	Points  float64         `json:"points"`
+	Applied []EarnRuleGrant `json:"applied"`
+}
+
+// CampaignExhausted is the detail of the event raised when a rule's budget runs out
+type CampaignExhausted struct {
+	Rule    string  `json:"rule"`
+	Budget  float64 `json:"budget"`
+	Granted float64 `json:"granted"`
+}
+
+// byRulePriority orders rules highest priority first, ties by id so every peer evaluates them identically
+type byRulePriority []EarnRule
+
+func (r byRulePriority) Len() int      { return len(r) }
+func (r byRulePriority) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byRulePriority) Less(i, j int) bool {
+	if r[i].Priority != r[j].Priority {
+		return r[i].Priority > r[j].Priority
+	}
+	return r[i].ID < r[j].ID
+}
+
+// ============================================================================================================================
+// getEarnRules - every stored rule in evaluation order
+// ============================================================================================================================
+func getEarnRules(stub *programStub) ([]EarnRule, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, earnRuleType, []string{})
+	if err != nil {
+		return nil, errors.New("Failed to get earn rules")
+	}
+	defer keysIter.Close()
+
+	rules := []EarnRule{}
+	for keysIter.HasNext() {
+		key, ruleAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get earn rules")
+		}
+		var rule EarnRule
+		err = unmarshalState(key, ruleAsBytes, &rule)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	sort.Sort(byRulePriority(rules))
+	return rules, nil
+}
+
+// ============================================================================================================================
+// earnRuleMatches - true if an earn of the amount at the merchant on the day, with the customer's streak there, meets
+// every condition of the rule
+// ============================================================================================================================
+func earnRuleMatches(rule EarnRule, merchant Entity, purchase float64, day string, streak PurchaseStreak) bool {
+	if rule.Exhausted {
+		return false
+	}
+	if rule.Merchant != "" && rule.Merchant != merchant.Name && rule.Merchant != merchant.Parent {
+		return false
+	}
+	if rule.Category != "" && !hasCategory(merchant, rule.Category) {
+		return false
+	}
+	if purchase < rule.MinAmount || (rule.MaxAmount > 0 && purchase >= rule.MaxAmount) {
+		return false
+	}
+	if streak.Days < rule.MinStreakDays || streak.Weeks < rule.MinStreakWeeks {
+		return false
+	}
+	if len(rule.Days) > 0 {
+		found := false
+		for _, d := range rule.Days {
+			if d == day {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ============================================================================================================================
+// campaignUses - how many bonuses a customer got from a rule, and the key that counts them
+// ============================================================================================================================
+func campaignUses(stub *programStub, rule string, customer string) (int, string, error) {
+	key, err := createCompositeKey(campaignUseType, []string{rule, customer})
+	if err != nil {
+		return 0, "", err
+	}
+	countAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return 0, key, errors.New("Failed to get campaign participation")
+	}
+	if countAsBytes == nil {
+		return 0, key, nil
+	}
+	count, err := strconv.Atoi(string(countAsBytes))
+	if err != nil {
+		return 0, key, stateCorruption(key, err)
+	}
+	return count, key, nil
+}
+
+// ============================================================================================================================
+// campaignAllows - true if the customer is still within the rule's participation limits, an empty customer is
+// treated as one who has not taken part yet
+// ============================================================================================================================
+func campaignAllows(stub *programStub, rule EarnRule, customer string) (bool, error) {
+	if rule.MaxPerCustomer <= 0 && rule.MaxCustomers <= 0 {
+		return true, nil
+	}
+	uses := 0
+	if customer != "" {
+		var err error
+		uses, _, err = campaignUses(stub, rule.ID, customer)
+		if err != nil {
+			return false, err
+		}
+	}
+	if rule.MaxPerCustomer > 0 && uses >= rule.MaxPerCustomer {
+		return false, nil
+	}
+	if rule.MaxCustomers > 0 && uses == 0 && rule.Customers >= rule.MaxCustomers {
+		return false, nil
+	}
+	return true, nil
+}
+
+// ============================================================================================================================
+// evalEarnRules - the points the stored rules add to a customer's earn at the merchant, a campaign grants no more than
+// is left in its budget and nothing past its participation limits, nothing for earns without a purchase amount such as
+// signed vouchers
+// ============================================================================================================================
+func evalEarnRules(stub *programStub, merchant Entity, customer string, streak PurchaseStreak, purchase float64) (EarnRuleResult, error) {
+	result := EarnRuleResult{Applied: []EarnRuleGrant{}}
+	if purchase <= 0 {
+		return result, nil
+	}
+	rules, err := getEarnRules(stub)
+	if err != nil {
+		return result, err
+	}
+	if len(rules) == 0 {
+		return result, nil
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return result, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return result, err
+	}
+	day := earnRuleDays[time.Unix(timestamp, 0).UTC().Weekday()]
+	for _, rule := range rules {
+		if !earnRuleMatches(rule, merchant, purchase, day, streak) {
+			continue
+		}
+		allowed, err := campaignAllows(stub, rule, customer)
+		if err != nil {
+			return result, err
+		}
+		if !allowed {
+			continue
+		}
+		points := roundPoints(config.Bounds, purchase*rule.Multiplier+rule.Bonus)
+		if rule.Budget > 0 && points > rule.Budget-rule.Granted {
+			points = rule.Budget - rule.Granted
+		}
+		if points <= 0 {
+			continue
+		}
+		result.Points = result.Points + points
+		result.Applied = append(result.Applied, EarnRuleGrant{rule.ID, points})
+		if rule.Final {
+			break
+		}
+	}
+	return result, nil
+}
+
+// ============================================================================================================================
+// chargeEarnRules - take the points a customer's earn was granted out of the campaign budgets and count the customer's
+// participation, a campaign that runs out stops applying and raises CampaignExhausted
+// ============================================================================================================================
+func chargeEarnRules(stub *programStub, customer string, result EarnRuleResult) error {
+	for _, grant := range result.Applied {
+		key, err := createCompositeKey(earnRuleType, []string{grant.Rule})
+		if err != nil {
+			return err
+		}
+		ruleAsBytes, err := stub.GetState(key)
+		if err != nil || ruleAsBytes == nil {
+			return errors.New("Failed to get earn rule " + grant.Rule)
+		}
+		var rule EarnRule
+		err = unmarshalState(key, ruleAsBytes, &rule)
+		if err != nil {
+			return err
+		}
+		rule.Granted = rule.Granted + grant.Points
+		uses, usesKey, err := campaignUses(stub, rule.ID, customer)
+		if err != nil {
+			return err
+		}
+		if uses == 0 {
+			rule.Customers++
+		}
+		err = stub.PutState(usesKey, []byte(strconv.Itoa(uses+1)))
+		if err != nil {
+			return err
+		}
+		if rule.Budget > 0 && rule.Granted >= rule.Budget-booksTolerance {
+			rule.Exhausted = true
+			stub.log.info("campaign " + rule.ID + " exhausted its budget of " + formatAmount(rule.Budget) + " points")
+			err = emitEvent(stub, "CampaignExhausted", nil, CampaignExhausted{rule.ID, rule.Budget, rule.Granted})
+			if err != nil {
+				return err
+			}
+		}
+		jsonAsBytes, _ := json.Marshal(rule)
+		err = stub.PutState(key, jsonAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// checkEarnRule - validate an uploaded rule
+// ============================================================================================================================
+func checkEarnRule(stub *programStub, rule EarnRule) error {
+	if len(rule.ID) <= 0 || len(rule.ID) > maxEarnRuleID {
+		return errors.New("Rule id must be 1 to " + strconv.Itoa(maxEarnRuleID) + " characters long")
+	}
+	if rule.Merchant != "" {
+		merchant, err := getEntity(stub, rule.Merchant)
+		if err != nil {
+			return err
+		}
+		if merchant.Role != merchantRole {
+			return errors.New(merchant.Name + " is not a merchant")
+		}
+	}
+	if rule.MinAmount < 0 || rule.MaxAmount < 0 || (rule.MaxAmount > 0 && rule.MaxAmount <= rule.MinAmount) {
+		return errors.New("Rule amount range must be non-negative with max_amount above min_amount, or zero for no upper bound")
+	}
+	for _, d := range rule.Days {
+		found := false
+		for _, known := range earnRuleDays {
+			if d == known {
+				found = true
+			}
+		}
+		if !found {
+			return errors.New("Rule days must be among " + strings.Join(earnRuleDays, ", "))
+		}
+	}
+	if rule.Multiplier < 0 || rule.Bonus < 0 || (rule.Multiplier == 0 && rule.Bonus == 0) {
+		return errors.New("Rule must have a non-negative multiplier and bonus, not both zero")
+	}
+	if rule.Budget < 0 || rule.MaxPerCustomer < 0 || rule.MaxCustomers < 0 {
+		return errors.New("Rule budget and participation limits must be non-negative, zero for no cap")
+	}
+	if rule.MinStreakDays < 0 || rule.MinStreakWeeks < 0 {
+		return errors.New("Rule streak conditions must be non-negative")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Put Earn Rule - admin only, create or replace a rule from its JSON, e.g.
+// {"id":"weekend","merchant":"shop","min_amount":20,"days":["sat","sun"],"multiplier":0.1,"budget":5000}
+// Replacing a campaign keeps what it granted so far, raising its budget puts an exhausted campaign back to work.
+// ============================================================================================================================
+func (t *SimpleChaincode) putEarnRule(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// "RuleJSON"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	var rule EarnRule
+	err := decodeJSONArg(args[0], &rule)
+	if err != nil {
+		return nil, errors.New("1st argument must be a JSON earn rule: " + err.Error())
+	}
+	err = checkEarnRule(stub, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := createCompositeKey(earnRuleType, []string{rule.ID})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get earn rule")
+	}
+	rule.Granted, rule.Customers = 0, 0
+	if existing != nil {
+		var prev EarnRule
+		err = unmarshalState(key, existing, &prev)
+		if err != nil {
+			return nil, err
+		}
+		rule.Granted = prev.Granted
+		rule.Customers = prev.Customers
+	} else {
+		rules, err := getEarnRules(stub)
+		if err != nil {
+			return nil, err
+		}
+		if len(rules) >= maxEarnRules {
+			return nil, errors.New("Program already has " + strconv.Itoa(maxEarnRules) + " earn rules")
+		}
+	}
+	rule.Exhausted = rule.Budget > 0 && rule.Granted >= rule.Budget-booksTolerance
+	rule.UpdatedBy, err = callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	rule.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(rule)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.info("earn rule " + rule.ID + " stored")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Delete Earn Rule - admin only, stop applying a rule
+// ============================================================================================================================
+func (t *SimpleChaincode) deleteEarnRule(stub *programStub, args []string) ([]byte, error) {
+	//   0
+	// "RuleID"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	key, err := createCompositeKey(earnRuleType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	ruleAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get earn rule")
+	}
+	if ruleAsBytes == nil {
+		return nil, errors.New("Earn rule " + args[0] + " does not exist")
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return nil, errors.New("Failed to delete earn rule")
+	}
+	stub.log.info("earn rule " + args[0] + " deleted")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// List Earn Rules - every rule in the order earns evaluate them
+// ============================================================================================================================
+func (t *SimpleChaincode) listEarnRules(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+	rules, err := getEarnRules(stub)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(rules)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Preview Earn Rules - the points the rules would add to an earn of the purchase amount at the merchant right now,
+// for a customer who has not taken part in any campaign unless one is named
+// ============================================================================================================================
+func (t *SimpleChaincode) previewEarnRules(stub *programStub, args []string) ([]byte, error) {
+	//     0           1            2
+	// "Merchant", "Purchase", *"Customer"*
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if merchant.Role != merchantRole {
+		return nil, errors.New(merchant.Name + " is not a merchant")
+	}
+	purchase, err := parseAmount(args[1])
+	if (err != nil) || (purchase < 0) {
+		return nil, amountError("2nd argument must be a non-negative numeric string", err)
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	customer := Entity{}
+	if len(args) == 3 {
+		customer, err = getEntity(stub, args[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+	result, err := evalEarnRules(stub, merchant, customer.Name, nextStreak(customer, merchant.Name, timestamp), purchase)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(result)
+	return jsonAsBytes, nil
+}
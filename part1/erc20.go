@@ -0,0 +1,125 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+)
+
+// ERC-20 style names over the points ledger so token tooling written against the Fabric token samples works here.
+// The sending side of erc20_transfer, approve and transferFrom is always the caller's own entity. The ERC-20 transfer
+// has its own name because transfer is the program's 4 argument transfer, and a function policy applies to a name.
+
+// ============================================================================================================================
+// balanceOf - point balance of an entity
+// ============================================================================================================================
+func (t *SimpleChaincode) balanceOf(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return []byte(formatAmount(entity.PtBal)), nil
+}
+
+// ============================================================================================================================
+// totalSupply - total points in circulation
+// ============================================================================================================================
+func (t *SimpleChaincode) totalSupply(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+	supply, err := getSupply(stub)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(formatAmount(supply)), nil
+}
+
+// ============================================================================================================================
+// erc20Transfer - erc20_transfer(to, value), move points from the caller's entity
+// ============================================================================================================================
+func (t *SimpleChaincode) erc20Transfer(stub *programStub, args []string) ([]byte, error) {
+	//  0       1
+	// "To", "Value"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	from, err := callerEntity(stub)
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseAmount(args[1])
+	if (err != nil) || (value <= 0) {
+		return nil, amountError("2nd argument must be a positive numeric string", err)
+	}
+	return t.transfer(stub, []string{from.Name, args[0], "0", args[1]})
+}
+
+// ============================================================================================================================
+// approve - approve(spender, value), let the spender move up to value of the caller's points
+// ============================================================================================================================
+func (t *SimpleChaincode) approve(stub *programStub, args []string) ([]byte, error) {
+	//    0          1
+	// "Spender", "Value"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	owner, err := callerEntity(stub)
+	if err != nil {
+		return nil, err
+	}
+	return t.grantAllowance(stub, []string{owner.Name, args[0], args[1]})
+}
+
+// ============================================================================================================================
+// allowance - allowance(owner, spender), points the spender may still move for the owner
+// ============================================================================================================================
+func (t *SimpleChaincode) allowance(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	allowance, _, err := getAllowanceRecord(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if allowance == nil {
+		return []byte("0"), nil
+	}
+	return []byte(formatAmount(allowance.Cap - allowance.Spent)), nil
+}
+
+// ============================================================================================================================
+// transferFrom - transferFrom(from, to, value), the caller spends from an allowance the owner granted it
+// ============================================================================================================================
+func (t *SimpleChaincode) transferFrom(stub *programStub, args []string) ([]byte, error) {
+	//   0       1       2
+	// "From", "To", "Value"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	spender, err := callerEntity(stub)
+	if err != nil {
+		return nil, err
+	}
+	return t.spendFromAllowance(stub, []string{args[0], spender.Name, args[1], args[2]})
+}
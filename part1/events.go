@@ -0,0 +1,80 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+// BalanceChange is an entity's balances either side of a transaction, so listeners can render without querying
+type BalanceChange struct {
+	Entity       string  `json:"entity"`
+	PtBalBefore  float64 `json:"ptbal_before"`
+	PtBalAfter   float64 `json:"ptbal_after"`
+	TxnBalBefore float64 `json:"txnbal_before"`
+	TxnBalAfter  float64 `json:"txnbal_after"`
+}
+
+// CallerInfo identifies the client that submitted the transaction, any part may be empty when security is off
+type CallerInfo struct {
+	ID     string `json:"id,omitempty"` //certificate fingerprint
+	Entity string `json:"entity,omitempty"`
+	Role   string `json:"role,omitempty"`
+}
+
+// EventPayload is one event raised during a transaction
+type EventPayload struct {
+	Name     string          `json:"name"`
+	TxID     string          `json:"txid"`
+	Program  string          `json:"program,omitempty"` //empty for the root program
+	Caller   CallerInfo      `json:"caller"`
+	Balances []BalanceChange `json:"balances,omitempty"`
+	Detail   interface{}     `json:"detail,omitempty"`
+	Notify   []NotifyHint    `json:"notify,omitempty"` //balance changes the entities asked to be told about
+}
+
+// ============================================================================================================================
+// balanceChange - before and after balances of an entity
+// ============================================================================================================================
+func balanceChange(before Entity, after Entity) BalanceChange {
+	return BalanceChange{after.Name, before.PtBal, after.PtBal, before.TxnBal, after.TxnBal}
+}
+
+// ============================================================================================================================
+// callerInfo - whatever the caller's certificate tells us about who they are
+// ============================================================================================================================
+func callerInfo(stub *programStub) CallerInfo {
+	var caller CallerInfo
+	caller.ID, _ = callerID(stub)
+	caller.Entity = callerAttribute(stub, "entityName")
+	caller.Role = callerAttribute(stub, "role")
+	return caller
+}
+
+// ============================================================================================================================
+// emitEvent - raise an event carrying the caller and the balances it changed, it is collected on the transaction's
+// unit of work and published when the unit of work flushes
+//
+// Only one chaincode event survives per transaction (the last SetEvent wins), so the flush publishes a single chaincode
+// event whose payload lists every event of the transaction in the order raised. That chaincode event is named after
+// the FIRST event raised, listeners filtering on a name only see the transactions that started with that event and
+// must read the list for the rest.
+// ============================================================================================================================
+func emitEvent(stub *programStub, name string, balances []BalanceChange, detail interface{}) error {
+	event := EventPayload{name, stub.UUID, stub.program, callerInfo(stub), balances, detail, notifyHints(stub, balances)}
+	stub.uow.events = append(stub.uow.events, event)
+	return nil
+}
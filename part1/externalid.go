@@ -0,0 +1,126 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var externalIDType = "extid"     //composite key object type mapping external ids to entities, keyed by scheme then id
+var externalIDOfType = "extidof" //composite key object type holding an entity's external ids, keyed by entity then scheme
+
+// ============================================================================================================================
+// Set External ID - register the id an outside system knows an entity by (loyalty card number, CRM id) under a scheme
+// such as "card" or "crm", an empty id removes it. An id may only belong to one entity per scheme.
+// ============================================================================================================================
+func (t *SimpleChaincode) setExternalID(stub *programStub, args []string) ([]byte, error) {
+	//   0         1         2
+	// "Name", "Scheme", "ExternalID"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	if len(args[1]) <= 0 {
+		return nil, errors.New("2nd argument must be a non-empty string")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	ofKey, err := createCompositeKey(externalIDOfType, []string{entity.Name, args[1]})
+	if err != nil {
+		return nil, err
+	}
+	if args[2] != "" {
+		idKey, err := createCompositeKey(externalIDType, []string{args[1], args[2]})
+		if err != nil {
+			return nil, err
+		}
+		ownerAsBytes, err := stub.GetState(idKey)
+		if err != nil {
+			return nil, errors.New("Failed to get external id")
+		}
+		if ownerAsBytes != nil && string(ownerAsBytes) != entity.Name {
+			return nil, errors.New(args[1] + " id " + args[2] + " already belongs to another entity")
+		}
+	}
+
+	stub.log.debug("start set external id")
+	oldAsBytes, err := stub.GetState(ofKey)
+	if err != nil {
+		return nil, errors.New("Failed to get external id")
+	}
+	if oldAsBytes != nil { //drop the index entry of the id being replaced
+		oldKey, err := createCompositeKey(externalIDType, []string{args[1], string(oldAsBytes)})
+		if err != nil {
+			return nil, err
+		}
+		err = stub.DelState(oldKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if args[2] == "" {
+		err = stub.DelState(ofKey)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		idKey, _ := createCompositeKey(externalIDType, []string{args[1], args[2]})
+		err = stub.PutState(idKey, []byte(entity.Name))
+		if err != nil {
+			return nil, err
+		}
+		err = stub.PutState(ofKey, []byte(args[2]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	stub.log.debug("end set external id")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Find By External ID - the entity an outside system's id belongs to, as a POS would look up a scanned loyalty card
+// ============================================================================================================================
+func (t *SimpleChaincode) findByExternalID(stub *programStub, args []string) ([]byte, error) {
+	//    0          1
+	// "Scheme", "ExternalID"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	idKey, err := createCompositeKey(externalIDType, []string{args[0], args[1]})
+	if err != nil {
+		return nil, err
+	}
+	nameAsBytes, err := stub.GetState(idKey)
+	if err != nil {
+		return nil, errors.New("Failed to get external id")
+	}
+	if nameAsBytes == nil {
+		return nil, errors.New("No entity has " + args[0] + " id " + args[1])
+	}
+	entity, err := getEntity(stub, string(nameAsBytes)) //entities merged since still resolve to the survivor
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(entity)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,106 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var feesEnabledFlag = "fees_enabled"                  //transfer fees are charged when a fee rule is configured
+var expiryEnabledFlag = "expiry_enabled"              //the dormant account sweep expires points into the reclaim pool
+var approvalsRequiredFlag = "approvals_required"      //every mint needs a second admin's signature
+var receiptHashRequiredFlag = "receipt_hash_required" //earns for a purchase must carry the sha256 of the receipt
+
+// defaultFeatures is the value of every flag the program knows while the config does not set it. Behaviour that
+// predates the flags defaults on so a network upgrading the chaincode keeps working as it did.
+var defaultFeatures = map[string]bool{
+	feesEnabledFlag:         true,
+	expiryEnabledFlag:       true,
+	approvalsRequiredFlag:   false,
+	receiptHashRequiredFlag: false,
+}
+
+// FeatureFlags switches program behaviour per network without a chaincode upgrade, only flags that were set are stored
+type FeatureFlags map[string]bool
+
+// ============================================================================================================================
+// enabled - whether a flag is on, its default when the config does not set it
+// ============================================================================================================================
+func (flags FeatureFlags) enabled(name string) bool {
+	on, ok := flags[name]
+	if !ok {
+		return defaultFeatures[name]
+	}
+	return on
+}
+
+// ============================================================================================================================
+// Set Feature Flag - admin only, turn a program feature on or off
+// ============================================================================================================================
+func (t *SimpleChaincode) setFeatureFlag(stub *programStub, args []string) ([]byte, error) {
+	//       0           1
+	// "fees_enabled", "false"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	if _, ok := defaultFeatures[args[0]]; !ok {
+		return nil, errors.New("1st argument must be one of " + strings.Join(sortedKeys(defaultFeatures), ", "))
+	}
+	on, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return nil, errors.New("2nd argument must be true or false")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	if config.Features == nil {
+		config.Features = FeatureFlags{}
+	}
+	config.Features[args[0]] = on
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Feature Flags - every flag the program knows and whether it is on
+// ============================================================================================================================
+func (t *SimpleChaincode) getFeatureFlags(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	flags := map[string]bool{}
+	for name := range defaultFeatures {
+		flags[name] = config.Features.enabled(name)
+	}
+	jsonAsBytes, _ := json.Marshal(flags)
+	return jsonAsBytes, nil
+}
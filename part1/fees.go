@@ -0,0 +1,109 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+)
+
+// FeeRule is the program fee taken out of peer to peer point transfers
+type FeeRule struct {
+	Kind        string   `json:"kind"`   //flat or percent, empty disables the fee
+	Amount      float64  `json:"amount"` //points for flat, percentage of the transfer for percent
+	Collector   string   `json:"collector"`
+	ExemptRoles []string `json:"exempt_roles"` //no fee when either side has one of these roles, e.g. charity
+}
+
+// ============================================================================================================================
+// transferFee - fee owed on a transfer of points between two entities and the collector entity it goes to, nil
+// collector when no fee applies
+// ============================================================================================================================
+func transferFee(stub *programStub, from Entity, to Entity, points float64) (float64, *Entity, error) {
+	config, err := getConfig(stub)
+	if err != nil {
+		return 0, nil, err
+	}
+	rule := config.TransferFee
+	if !config.Features.enabled(feesEnabledFlag) || rule.Kind == "" || points <= 0 || rule.Collector == from.Name || rule.Collector == to.Name {
+		return 0, nil, nil
+	}
+	for _, role := range rule.ExemptRoles {
+		if role == from.Role || role == to.Role {
+			return 0, nil, nil
+		}
+	}
+
+	fee := rule.Amount
+	if rule.Kind == "percent" {
+		fee = roundPoints(config.Bounds, points*rule.Amount/100)
+	}
+	if fee <= 0 {
+		return 0, nil, nil
+	}
+	if fee >= points {
+		return 0, nil, errors.New("Transfer of " + formatAmount(points) + " points does not cover the fee")
+	}
+	collector, err := getEntity(stub, rule.Collector)
+	if err != nil {
+		return 0, nil, err
+	}
+	return fee, &collector, nil
+}
+
+// ============================================================================================================================
+// Set Transfer Fee - admin only, configure the fee on peer to peer transfers, kind "none" removes it
+// ============================================================================================================================
+func (t *SimpleChaincode) setTransferFee(stub *programStub, args []string) ([]byte, error) {
+	//      0             1           2              3
+	// "flat|percent", "Amount", "Collector", "ExemptRole", ...
+	if len(args) == 1 && args[0] == "none" {
+		config, err := getConfig(stub)
+		if err != nil {
+			return nil, err
+		}
+		config.TransferFee = FeeRule{}
+		return nil, putConfig(stub, config)
+	}
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 3")
+	}
+	if args[0] != "flat" && args[0] != "percent" {
+		return nil, errors.New("1st argument must be flat, percent or none")
+	}
+	amount, err := parseAmount(args[1])
+	if (err != nil) || (amount < 0) || (args[0] == "percent" && amount >= 100) {
+		return nil, amountError("2nd argument must be a non-negative numeric string, below 100 for percent", err)
+	}
+	collector, err := getEntity(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.TransferFee = FeeRule{args[0], amount, collector.Name, args[3:]}
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,234 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Purchases in a foreign currency are converted with the latest rate the program's FX oracle posted. The oracle is an
+// entity with a registered public key; it signs, with the key active at the posting time, the sha256 of
+//
+//	fx_rate|<program>|<currency>|<rate>|<posted at>
+//
+// where the rate is how much of the program's own currency one unit of the foreign currency buys. The feed submits
+// with the oracle role, or an admin does, but the signature is what makes a rate the oracle's. A rate older than the configured maximum age is stale and
+// earns in that currency fail until the oracle posts again.
+var oracleRole = "oracle"                 //value of the "role" certificate attribute held by the off-chain rate feed
+var fxRateType = "fxrate"                 //composite key object type for the latest rate of each currency, keyed by currency
+var defaultFXMaxAge = int64(24 * 60 * 60) //seconds a posted rate stays usable when the config does not say
+
+// FXRate is the latest rate the oracle posted for a currency
+type FXRate struct {
+	Currency   string  `json:"currency"`
+	Rate       float64 `json:"rate"`
+	PostedAt   int64   `json:"posted_at"` //when the oracle signed it
+	Oracle     string  `json:"oracle"`
+	KeyVersion int     `json:"key_version"`
+	TxID       string  `json:"txid"`
+}
+
+// FXConversion is the rate an earn converted its purchase with
+type FXConversion struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"` //purchase in the foreign currency
+	Rate     float64 `json:"rate"`
+	PostedAt int64   `json:"posted_at"`
+}
+
+// ============================================================================================================================
+// fxRateMessage - the string the oracle signs for a rate
+// ============================================================================================================================
+func fxRateMessage(stub *programStub, currency string, rate string, postedAt string) string {
+	return strings.Join([]string{"fx_rate", stub.program, currency, rate, postedAt}, "|")
+}
+
+// ============================================================================================================================
+// checkCurrency - error unless a currency is a three letter ISO 4217 style code
+// ============================================================================================================================
+func checkCurrency(currency string) error {
+	if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return errors.New("Currency must be a three letter upper case code such as EUR")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// getFXRate - the latest posted rate of a currency
+// ============================================================================================================================
+func getFXRate(stub *programStub, currency string) (FXRate, error) {
+	var rate FXRate
+	key, err := createCompositeKey(fxRateType, []string{currency})
+	if err != nil {
+		return rate, err
+	}
+	rateAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return rate, errors.New("Failed to get FX rate")
+	}
+	if rateAsBytes == nil {
+		return rate, errors.New("No FX rate was posted for " + currency)
+	}
+	err = unmarshalState(key, rateAsBytes, &rate)
+	return rate, err
+}
+
+// ============================================================================================================================
+// convertPurchase - a purchase argument in the program's currency, or as "25.00 EUR" converted at the latest fresh rate
+// ============================================================================================================================
+func convertPurchase(stub *programStub, arg string) (float64, *FXConversion, error) {
+	parts := strings.SplitN(arg, " ", 2)
+	amount, err := parseAmount(parts[0])
+	if (err != nil) || (amount < 0) {
+		return 0, nil, amountError("4th argument must be a non-negative numeric string, optionally followed by a currency", err)
+	}
+	if len(parts) == 1 {
+		return amount, nil, nil
+	}
+	err = checkCurrency(parts[1])
+	if err != nil {
+		return 0, nil, err
+	}
+	rate, err := getFXRate(stub, parts[1])
+	if err != nil {
+		return 0, nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return 0, nil, err
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return 0, nil, err
+	}
+	maxAge := config.FXMaxAge
+	if maxAge == 0 {
+		maxAge = defaultFXMaxAge
+	}
+	if now-rate.PostedAt > maxAge {
+		return 0, nil, errors.New("The " + rate.Currency + " rate posted at " + strconv.FormatInt(rate.PostedAt, 10) + " is stale")
+	}
+	return amount * rate.Rate, &FXConversion{rate.Currency, amount, rate.Rate, rate.PostedAt}, nil
+}
+
+// ============================================================================================================================
+// Set FX Oracle - admin only, name the entity whose signed rates convert foreign currency purchases
+// ============================================================================================================================
+func (t *SimpleChaincode) setFXOracle(stub *programStub, args []string) ([]byte, error) {
+	//    0           1
+	// "Name" *"MaxAgeSeconds"*
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	oracle, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	maxAge := int64(0)
+	if len(args) == 2 {
+		maxAge, err = strconv.ParseInt(args[1], 10, 64)
+		if (err != nil) || (maxAge <= 0) {
+			return nil, errors.New("2nd argument must be a positive integer")
+		}
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.FXOracle = oracle.Name
+	config.FXMaxAge = maxAge
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Post FX Rate - store a rate signed by the FX oracle, it must be newer than the rate it replaces
+// ============================================================================================================================
+func (t *SimpleChaincode) postFXRate(stub *programStub, args []string) ([]byte, error) {
+	//     0          1          2           3
+	// "Currency", "Rate", "PostedAt", "Signature"
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	}
+	err := checkCurrency(args[0])
+	if err != nil {
+		return nil, err
+	}
+	rate, err := parseAmount(args[1])
+	if (err != nil) || (rate <= 0) {
+		return nil, amountError("2nd argument must be a positive numeric string", err)
+	}
+	postedAt, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("3rd argument must be a unix timestamp")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if postedAt > now {
+		return nil, errors.New("FX rate is posted in the future")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	if config.FXOracle == "" {
+		return nil, errors.New("No FX oracle is configured")
+	}
+	key, err := verifyEntitySignature(stub, config.FXOracle, fxRateMessage(stub, args[0], args[1], args[2]), args[3], postedAt)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := getFXRate(stub, args[0])
+	if err == nil && postedAt <= latest.PostedAt {
+		return nil, errors.New("A newer " + args[0] + " rate is already posted")
+	}
+
+	record := FXRate{args[0], rate, postedAt, config.FXOracle, key.Version, stub.UUID}
+	rateKey, err := createCompositeKey(fxRateType, []string{record.Currency})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(record)
+	return nil, stub.PutState(rateKey, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Get FX Rate - the latest posted rate of a currency
+// ============================================================================================================================
+func (t *SimpleChaincode) getFXRateQuery(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting currency to query")
+	}
+	rate, err := getFXRate(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(rate)
+	return jsonAsBytes, nil
+}
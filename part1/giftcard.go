@@ -0,0 +1,372 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var giftCardType = "giftcard" //composite key object type for gift cards, keyed by card id
+var maxPINAttempts = 5        //incorrect PINs in a row before a card locks
+
+var pinLockout int64 = 24 * 60 * 60 //seconds a locked card refuses every PIN
+
+// A card's PIN never reaches the ledger. The issuer draws a random salt per card, prints it with the PIN and creates
+// the card with the PIN key, the hex sha256 of "salt|PIN", so the stored key cannot be brute forced from the short PIN
+// alone. To use the card the holder's client sends a PIN proof, the hex HMAC-SHA256 keyed with the PIN key of
+// "ID|PINCounter", and every check moves the counter on so a proof seen in one transaction is useless in the next.
+// Cards created before then keep the key they were stored with, the hex sha256 of "PINSalt|" and the PIN's hex sha256,
+// or only the PIN's hex sha256 when they have no salt.
+
+// GiftCard is backed by points locked in the issuer's account until the holder spends them
+type GiftCard struct {
+	ID        string  `json:"id"`
+	Issuer    string  `json:"issuer"` //entity whose locked points back the card
+	Holder    string  `json:"holder"`
+	Balance   float64 `json:"balance"`
+	PINHash   string  `json:"pinhash,omitempty"` //PIN key, empty if the card has no PIN
+	PINSalt   string  `json:"pinsalt,omitempty"` //public salt of some older cards, new cards keep theirs off the ledger
+	CreatedAt int64   `json:"created_at"`
+
+	PINCounter     int64 `json:"pin_counter,omitempty"` //PIN checks so far, the next proof covers this value
+	PINFailures    int   `json:"pin_failures,omitempty"`
+	PINLockedUntil int64 `json:"pin_locked_until,omitempty"`
+}
+
+// PINRefusal is what a gift card function returns for an incorrect PIN proof, the transaction still commits so the
+// attempt counts against the card
+type PINRefusal struct {
+	Refused      string `json:"refused"`
+	AttemptsLeft int    `json:"attempts_left"`
+}
+
+// ============================================================================================================================
+// getGiftCard - fetch a gift card by id
+// ============================================================================================================================
+func getGiftCard(stub *programStub, id string) (GiftCard, string, error) {
+	var card GiftCard
+	key, err := createCompositeKey(giftCardType, []string{id})
+	if err != nil {
+		return card, "", err
+	}
+	cardAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return card, key, errors.New("Failed to get gift card")
+	}
+	if cardAsBytes == nil {
+		return card, key, errors.New("Gift card " + id + " does not exist")
+	}
+	err = unmarshalState(key, cardAsBytes, &card)
+	if err != nil {
+		return card, key, err
+	}
+	return card, key, nil
+}
+
+// ============================================================================================================================
+// putGiftCard - write a gift card back to chaincode state
+// ============================================================================================================================
+func putGiftCard(stub *programStub, card GiftCard) error {
+	key, err := createCompositeKey(giftCardType, []string{card.ID})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(card)
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// checkGiftCardHolder - error unless the named entity holds the card, a card held by a merged away entity is held by the
+// survivor
+// ============================================================================================================================
+func checkGiftCardHolder(stub *programStub, card *GiftCard, holder string) error {
+	cardHolder, err := resolveAlias(stub, card.Holder)
+	if err != nil {
+		return err
+	}
+	if cardHolder != holder {
+		return errors.New(holder + " does not hold gift card " + card.ID)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// checkGiftCardPIN - whether the proof is the card's PIN proof for its current counter, a card without a PIN accepts
+// any. Every check moves the counter on and maxPINAttempts wrong proofs in a row lock the card for pinLockout, so the
+// caller writes the card back even when the proof is wrong.
+// ============================================================================================================================
+func checkGiftCardPIN(stub *programStub, card *GiftCard, proof string) (bool, error) {
+	if card.PINHash == "" {
+		return true, nil
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return false, err
+	}
+	if card.PINLockedUntil > now {
+		return false, errors.New("Gift card " + card.ID + " is locked after too many incorrect PINs")
+	}
+	key, err := hex.DecodeString(card.PINHash)
+	if err != nil {
+		return false, errors.New("Gift card " + card.ID + " has a corrupt PIN key")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(card.ID + "|" + strconv.FormatInt(card.PINCounter, 10)))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	card.PINCounter++
+	if hmac.Equal([]byte(expected), []byte(proof)) {
+		card.PINFailures = 0
+		return true, nil
+	}
+	card.PINFailures++
+	if card.PINFailures >= maxPINAttempts {
+		card.PINFailures = 0
+		card.PINLockedUntil = now + pinLockout
+	}
+	return false, nil
+}
+
+// ============================================================================================================================
+// refusePIN - record a wrong PIN proof against the card and return the refusal instead of an error, which would roll
+// the attempt back with the rest of the transaction
+// ============================================================================================================================
+func refusePIN(stub *programStub, card GiftCard) ([]byte, error) {
+	err := putGiftCard(stub, card)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.warning("incorrect PIN for gift card " + card.ID)
+	refusal := PINRefusal{"Incorrect PIN for gift card " + card.ID, maxPINAttempts - card.PINFailures}
+	if card.PINFailures == 0 { //that attempt locked the card
+		refusal.AttemptsLeft = 0
+	}
+	jsonAsBytes, _ := json.Marshal(refusal)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Create Gift Card - lock some of the issuer's points into a new card held by the issuer, returns the card id
+// ============================================================================================================================
+func (t *SimpleChaincode) createGiftCard(stub *programStub, args []string) ([]byte, error) {
+	//    0          1          2
+	// "Issuer", "Points" *"PINKey"*
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	points, err := parseAmount(args[1])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("2nd argument must be a positive numeric string", err)
+	}
+	pinHash := ""
+	if len(args) == 3 {
+		pinHash = args[2]
+		if _, err := hex.DecodeString(pinHash); err != nil || len(pinHash) != 64 {
+			return nil, errors.New("3rd argument must be a hex sha256 PIN key")
+		}
+	}
+	issuer, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotBlocked(stub, issuer.Name)
+	if err != nil {
+		return nil, err
+	}
+	if transferablePoints(issuer) < points {
+		return nil, errors.New("Insufficient points")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start create gift card")
+	issuer.Locked = issuer.Locked + points
+	err = putEntity(stub, issuer)
+	if err != nil {
+		return nil, err
+	}
+	card := GiftCard{ID: newID(stub, giftCardType), Issuer: issuer.Name, Holder: issuer.Name, Balance: points, CreatedAt: timestamp}
+	card.PINHash = pinHash
+	err = putGiftCard(stub, card)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end create gift card")
+	return []byte(card.ID), nil
+}
+
+// ============================================================================================================================
+// Transfer Gift Card - the holder hands the card to another entity, the backing points stay locked with the issuer
+// ============================================================================================================================
+func (t *SimpleChaincode) transferGiftCard(stub *programStub, args []string) ([]byte, error) {
+	//   0        1           2          3
+	// "ID", "Holder", "NewHolder" *"PINProof"*
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 or 4")
+	}
+	card, _, err := getGiftCard(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	proof := ""
+	if len(args) == 4 {
+		proof = args[3]
+	}
+	holder, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = checkGiftCardHolder(stub, &card, holder.Name)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := checkGiftCardPIN(stub, &card, proof)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return refusePIN(stub, card)
+	}
+	newHolder, err := getEntity(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotBlocked(stub, holder.Name, newHolder.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	card.Holder = newHolder.Name
+	err = putGiftCard(stub, card)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Spend Gift Card - the holder pays a merchant from the card, the issuer's locked points move to the merchant
+// ============================================================================================================================
+func (t *SimpleChaincode) spendGiftCard(stub *programStub, args []string) ([]byte, error) {
+	//   0        1          2           3         4
+	// "ID", "Holder", "Merchant", "Points" *"PINProof"*
+	if len(args) != 4 && len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 or 5")
+	}
+	card, _, err := getGiftCard(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	proof := ""
+	if len(args) == 5 {
+		proof = args[4]
+	}
+	holder, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = checkGiftCardHolder(stub, &card, holder.Name)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := checkGiftCardPIN(stub, &card, proof)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return refusePIN(stub, card)
+	}
+	merchant, err := getEntity(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if merchant.Role != merchantRole {
+		return nil, errors.New(merchant.Name + " is not a merchant")
+	}
+	points, err := parseAmount(args[3])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("4th argument must be a positive numeric string", err)
+	}
+	if card.Balance < points {
+		return nil, errors.New("Insufficient balance on gift card " + card.ID)
+	}
+	err = checkNotBlocked(stub, holder.Name, merchant.Name, card.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start spend gift card")
+	issuer, err := getEntity(stub, card.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if issuer.Name == merchant.Name {
+		return nil, errors.New("Gift card cannot be spent at its issuer")
+	}
+	issuerBefore, merchantBefore := issuer, merchant
+	issuer.PtBal = issuer.PtBal - points
+	issuer.Locked = issuer.Locked - points
+	merchant.PtBal = merchant.PtBal + points
+	card.Balance = card.Balance - points
+
+	err = putEntity(stub, issuer)
+	if err != nil {
+		return nil, err
+	}
+	err = putEntity(stub, merchant)
+	if err != nil {
+		return nil, err
+	}
+	err = putGiftCard(stub, card)
+	if err != nil {
+		return nil, err
+	}
+	err = recordTxn(stub, TxnRecord{Type: "redeem", From: issuer.Name, To: merchant.Name, Points: points, Reference: "giftcard:" + card.ID},
+		balanceChange(issuerBefore, issuer), balanceChange(merchantBefore, merchant))
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end spend gift card")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Gift Card - return a gift card and its remaining balance, without the PIN key. An older card's salt is public in
+// its creating tx id anyway, its holder needs it to work out the PIN key.
+// ============================================================================================================================
+func (t *SimpleChaincode) getGiftCardQuery(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the card to query")
+	}
+	card, _, err := getGiftCard(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	card.PINHash = ""
+	jsonAsBytes, _ := json.Marshal(card)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,597 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var txnRecordType = "txn"   //composite key object type for transaction history, keyed by party, timestamp and record id
+var txnByIDType = "txnbyid" //composite key object type listing the keys a transaction record is stored under, keyed by id
+
+// TxnRecord is one point movement, stored once under each party so either side can range scan its own history
+type TxnRecord struct {
+	ID        string   `json:"id"`
+	TxID      string   `json:"txid"`
+	Type      string   `json:"type"`  //earn, redeem, transfer, fee, withholding, merge, mint, burn, reversal, grant, adjust, expire or badge
+	Class     TxnClass `json:"class"` //formal type the record type maps to, stamped when the record is stored
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Points    float64  `json:"points"`
+	Amount    float64  `json:"amount"`    //transaction (cash) value that moved alongside the points, if any
+	Reference string   `json:"reference"` //receipt id or other external reference
+	Timestamp int64    `json:"timestamp"`
+	Status    string   `json:"status,omitempty"` //disputed, upheld or reversed once a dispute was opened
+
+	ReceiptHash string        `json:"receipt_hash,omitempty"` //earns only, sha256 of the purchase receipt
+	FX          *FXConversion `json:"fx,omitempty"`           //earns only, how a foreign currency purchase was converted into Amount
+	Category    string        `json:"category,omitempty"`     //earns only, the restricted bucket the points went into
+}
+
+// SettlementReport summarises a merchant's activity for a date range
+type SettlementReport struct {
+	Merchant       string  `json:"merchant"`
+	From           string  `json:"from"`
+	To             string  `json:"to"`
+	PointsIssued   float64 `json:"points_issued"`
+	PointsRedeemed float64 `json:"points_redeemed"`
+	CashOwed       float64 `json:"cash_owed"` //positive when the program owes the merchant
+	TxnCount       int     `json:"txn_count"`
+
+	Rating *MerchantRating    `json:"rating,omitempty"` //all time score from customer ratings, not limited to the date range
+	Stores []SettlementReport `json:"stores,omitempty"` //per store location, already included in the totals above
+}
+
+// StatementLine is a journal posting of one entity shown as the transaction record it booked, with the balance after it
+type StatementLine struct {
+	TxnRecord
+	Change  float64 `json:"change"`
+	Balance float64 `json:"balance"`
+}
+
+// Statement is a bank style point statement for one entity and period
+type Statement struct {
+	Entity         string          `json:"entity"`
+	From           string          `json:"from"`
+	To             string          `json:"to"`
+	OpeningBalance float64         `json:"opening_balance"`
+	ClosingBalance float64         `json:"closing_balance"`
+	Lines          []StatementLine `json:"lines"`
+	Totals         []TxnTypeTotal  `json:"totals"`             //per type totals over the lines
+	Bookmark       string          `json:"bookmark,omitempty"` //set when the statement is partial, pass it back for the rest
+}
+
+// StatementBookmark is where a partial statement stopped, hex encoded JSON so clients treat it as opaque. It carries no
+// balances, every part derives its own from the journal so a client cannot hand back figures of its choosing.
+type StatementBookmark struct {
+	Key string `json:"key"` //first posting key not yet on a statement page
+}
+
+// ============================================================================================================================
+// recordTxn - stamp a transaction record with the tx id and time, store it under each party, book it in the journal
+// and raise a Transaction event with the balances it changed
+// ============================================================================================================================
+func recordTxn(stub *programStub, rec TxnRecord, balances ...BalanceChange) error {
+	class, err := txnClassOf(rec.Type)
+	if err != nil {
+		return err
+	}
+	rec.Class = class
+	err = checkTxnBounds(stub, rec)
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	rec.ID = newID(stub, txnRecordType)
+	rec.TxID = stub.UUID
+	rec.Timestamp = timestamp
+	jsonAsBytes, _ := json.Marshal(rec)
+	err = addToCounter(stub, 1, txnCountCounter, statsDay(timestamp))
+	if err != nil {
+		return err
+	}
+
+	var parties []string //mints and burns only have one side
+	if rec.From != "" {
+		parties = append(parties, rec.From)
+	}
+	if rec.To != "" && rec.To != rec.From {
+		parties = append(parties, rec.To)
+	}
+	var keys []string
+	for _, party := range parties {
+		key, err := createCompositeKey(txnRecordType, []string{party, timestampKey(rec.Timestamp), rec.ID})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(key, jsonAsBytes)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	idKey, err := createCompositeKey(txnByIDType, []string{rec.ID})
+	if err != nil {
+		return err
+	}
+	keysAsBytes, _ := json.Marshal(keys)
+	err = stub.PutState(idKey, keysAsBytes)
+	if err != nil {
+		return err
+	}
+	for _, hook := range txnHooks {
+		err = hook.Run(stub, rec, balances)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// getTxnRecordByID - fetch a transaction record by its id, along with every key a copy of it is stored under
+// ============================================================================================================================
+func getTxnRecordByID(stub *programStub, id string) (TxnRecord, []string, error) {
+	var rec TxnRecord
+	var keys []string
+	idKey, err := createCompositeKey(txnByIDType, []string{id})
+	if err != nil {
+		return rec, nil, err
+	}
+	keysAsBytes, err := stub.GetState(idKey)
+	if err != nil {
+		return rec, nil, errors.New("Failed to get transaction record")
+	}
+	if keysAsBytes == nil {
+		return rec, nil, errors.New("Transaction record " + id + " does not exist")
+	}
+	err = unmarshalState(idKey, keysAsBytes, &keys)
+	if err != nil {
+		return rec, nil, err
+	}
+	if len(keys) == 0 {
+		return rec, nil, stateCorruption(idKey, errors.New("no record keys"))
+	}
+	recAsBytes, err := stub.GetState(keys[0])
+	if err != nil || recAsBytes == nil {
+		return rec, nil, errors.New("Failed to get transaction record")
+	}
+	err = unmarshalState(keys[0], recAsBytes, &rec)
+	if err != nil {
+		return rec, nil, err
+	}
+	stampTxnClass(&rec)
+	return rec, keys, nil
+}
+
+// ============================================================================================================================
+// updateTxnRecord - rewrite every stored copy of a transaction record, e.g. after its status changed
+// ============================================================================================================================
+func updateTxnRecord(stub *programStub, rec TxnRecord, keys []string) error {
+	jsonAsBytes, _ := json.Marshal(rec)
+	for _, key := range keys {
+		err := stub.PutState(key, jsonAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// timestampKey - zero pad a timestamp so keys sort in time order
+// ============================================================================================================================
+func timestampKey(timestamp int64) string {
+	return fmt.Sprintf("%020d", timestamp)
+}
+
+// ============================================================================================================================
+// getTxnRecords - every record stored under a party with from <= timestamp < to
+// ============================================================================================================================
+func getTxnRecords(stub *programStub, party string, from int64, to int64) ([]TxnRecord, error) {
+	var records []TxnRecord
+	startKey, err := createCompositeKey(txnRecordType, []string{party, timestampKey(from)})
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(txnRecordType, []string{party, timestampKey(to)})
+	if err != nil {
+		return nil, err
+	}
+
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, errors.New("Failed to get transaction history")
+	}
+	defer keysIter.Close()
+	for keysIter.HasNext() {
+		key, recAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get transaction history")
+		}
+		var rec TxnRecord
+		err = unmarshalState(key, recAsBytes, &rec)
+		if err != nil {
+			return nil, err
+		}
+		stampTxnClass(&rec)
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// ============================================================================================================================
+// parseDateRange - turn "2006-01-02" start and end dates into a [from, to) range of unix seconds covering both days
+// ============================================================================================================================
+func parseDateRange(start string, end string) (int64, int64, error) {
+	from, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return 0, 0, errors.New("Start date must be formatted as YYYY-MM-DD")
+	}
+	to, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		return 0, 0, errors.New("End date must be formatted as YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return 0, 0, errors.New("End date must not be before start date")
+	}
+	return from.Unix(), to.AddDate(0, 0, 1).Unix(), nil
+}
+
+// ============================================================================================================================
+// settlementFor - points one merchant issued and redeemed between two times and the cash that nets to
+// ============================================================================================================================
+func settlementFor(stub *programStub, config Config, merchant string, from int64, to int64) (SettlementReport, error) {
+	report := SettlementReport{Merchant: merchant}
+	records, err := getTxnRecords(stub, merchant, from, to)
+	if err != nil {
+		return report, err
+	}
+	for _, rec := range records {
+		if rec.Type == "earn" && rec.From == merchant {
+			report.PointsIssued = report.PointsIssued + rec.Points
+			report.TxnCount++
+		} else if rec.Type == "redeem" && rec.To == merchant {
+			report.PointsRedeemed = report.PointsRedeemed + rec.Points
+			report.TxnCount++
+		} else if rec.Type == "reversal" && rec.From == merchant { //a reversed redeem hands the merchant's points back
+			orig, _, err := getTxnRecordByID(stub, rec.Reference)
+			if err == nil && orig.Type == "redeem" {
+				report.PointsRedeemed = report.PointsRedeemed - rec.Points
+				report.TxnCount++
+			}
+		}
+	}
+	report.CashOwed = (report.PointsRedeemed - report.PointsIssued) * config.PointValue
+	return report, nil
+}
+
+// ============================================================================================================================
+// Merchant Settlement Report - points issued and redeemed by a merchant and its store locations over a date range and the
+// resulting cash position
+// ============================================================================================================================
+func (t *SimpleChaincode) merchantSettlementReport(stub *programStub, args []string) ([]byte, error) {
+	//     0            1             2
+	// "Merchant", "2016-06-01", "2016-06-30"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if merchant.Role != merchantRole {
+		return nil, errors.New(merchant.Name + " is not a merchant")
+	}
+	from, to, err := parseDateRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := settlementFor(stub, config, merchant.Name, from, to)
+	if err != nil {
+		return nil, err
+	}
+	report.From, report.To = args[1], args[2]
+	rating, _, err := getMerchantRating(stub, merchant.Name)
+	if err != nil {
+		return nil, err
+	}
+	report.Rating = &rating
+	stores, err := storeNames(stub, merchant.Name) //store locations settle through their parent
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range stores {
+		store, err := settlementFor(stub, config, name, from, to)
+		if err != nil {
+			return nil, err
+		}
+		store.From, store.To = args[1], args[2]
+		report.PointsIssued = report.PointsIssued + store.PointsIssued
+		report.PointsRedeemed = report.PointsRedeemed + store.PointsRedeemed
+		report.CashOwed = report.CashOwed + store.CashOwed
+		report.TxnCount = report.TxnCount + store.TxnCount
+		report.Stores = append(report.Stores, store)
+	}
+
+	stub.log.debug("settlement report for " + merchant.Name + ", " + strconv.Itoa(report.TxnCount) + " transactions")
+	jsonAsBytes, _ := json.Marshal(report)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// pointChange - how a transaction record moved the point balance of the named entity
+// ============================================================================================================================
+func pointChange(rec TxnRecord, name string) float64 {
+	var change float64
+	if rec.To == name {
+		change = change + rec.Points
+	}
+	if rec.From == name && rec.Type != "earn" { //merchants issue points without spending their own
+		change = change - rec.Points
+	}
+	return change
+}
+
+// ============================================================================================================================
+// sumPostings - net change of the journal postings in [startKey, endKey), false if there are more than one invocation may
+// read
+// ============================================================================================================================
+func sumPostings(stub *programStub, startKey string, endKey string) (float64, bool, error) {
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return 0, false, errors.New("Failed to get journal postings")
+	}
+	defer keysIter.Close()
+
+	var sum float64
+	for scanned := 0; keysIter.HasNext(); scanned++ {
+		if scanned == maxScanRecords {
+			return sum, false, nil
+		}
+		key, changeAsBytes, err := keysIter.Next()
+		if err != nil {
+			return 0, false, errors.New("Failed to get journal postings")
+		}
+		change, err := parseStateFloat(key, changeAsBytes)
+		if err != nil {
+			return 0, false, err
+		}
+		sum = sum + change
+	}
+	return sum, true, nil
+}
+
+// ============================================================================================================================
+// balanceBefore - an entity's point balance before the posting key, dated timestamp. It counts forward from the nearest
+// balance already folded (journal checkpoint or closed month) or back from the current balance, whichever stays within
+// the scan budget.
+// ============================================================================================================================
+func balanceBefore(stub *programStub, entity Entity, key string, timestamp int64) (float64, error) {
+	prefix, err := createCompositeKey(postingType, []string{entity.Name})
+	if err != nil {
+		return 0, err
+	}
+	anchor, anchorKey := 0.0, prefix
+	checkpoint, err := getJournalCheckpoint(stub, entity.Name)
+	if err != nil {
+		return 0, err
+	}
+	if checkpoint.Through != "" && checkpoint.Through < key {
+		anchor, anchorKey = checkpoint.Balance, checkpoint.Through+compositeKeyNamespace
+	}
+	closed, err := getPeriodClosed(stub)
+	if err != nil {
+		return 0, err
+	}
+	if closed.Period != "" {
+		period, start := closed.Period, closed.Through
+		if timestamp < closed.Through { //inside a closed month, start from its opening
+			month := time.Unix(timestamp, 0).UTC()
+			period = month.Format("2006-01")
+			start = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC).Unix()
+		}
+		startKey, err := createCompositeKey(postingType, []string{entity.Name, timestampKey(start)})
+		if err != nil {
+			return 0, err
+		}
+		if startKey > anchorKey {
+			balance, ok, err := getPeriodBalance(stub, period, entity.Name)
+			if err != nil {
+				return 0, err
+			}
+			if ok && timestamp < closed.Through {
+				anchor, anchorKey = balance.Opening, startKey
+			} else if ok {
+				anchor, anchorKey = balance.Closing, startKey
+			}
+		}
+	}
+
+	change, ok, err := sumPostings(stub, anchorKey, key)
+	if err != nil || ok {
+		return anchor + change, err
+	}
+	change, ok, err = sumPostings(stub, key, prefix+maxUnicodeRuneValue)
+	if err != nil || ok {
+		return entity.PtBal - change, err
+	}
+	return 0, errors.New("Too many postings to derive the statement balance, close the earlier periods or checkpoint the journal first")
+}
+
+// ============================================================================================================================
+// postingRecord - the transaction record a posting books, opening balances have none so their journal entry stands in
+// ============================================================================================================================
+func postingRecord(stub *programStub, key string) (TxnRecord, error) {
+	var rec TxnRecord
+	_, attributes, err := splitCompositeKey(key)
+	if err != nil || len(attributes) != 3 {
+		return rec, stateCorruption(key, errors.New("not a posting key"))
+	}
+	entryKey, err := createCompositeKey(journalType, attributes[1:])
+	if err != nil {
+		return rec, err
+	}
+	entryAsBytes, err := stub.GetState(entryKey)
+	if err != nil || entryAsBytes == nil {
+		return rec, errors.New("Failed to get journal entry")
+	}
+	var entry JournalEntry
+	err = unmarshalState(entryKey, entryAsBytes, &entry)
+	if err != nil {
+		return rec, err
+	}
+	if entry.RecordID != "" {
+		rec, _, err = getTxnRecordByID(stub, entry.RecordID)
+		return rec, err
+	}
+
+	rec = TxnRecord{ID: entry.ID, Type: entry.Type, Class: entry.Class, Timestamp: entry.Timestamp}
+	for _, line := range entry.Lines {
+		if line.Debit > 0 {
+			rec.From = line.Account
+		}
+		if line.Credit > 0 {
+			rec.To = line.Account
+			rec.Points = rec.Points + line.Credit
+		}
+	}
+	return rec, nil
+}
+
+// ============================================================================================================================
+// Get Statement - an entity's journal postings for a date range as transaction lines with opening, running and closing
+// point balances, a type filter only hides lines, the balances still count every posting. A period with more postings
+// than one invocation may read comes back in parts, each with the lines and totals it covers, the closing balance is
+// where that part ended.
+// ============================================================================================================================
+func (t *SimpleChaincode) getStatement(stub *programStub, args []string) ([]byte, error) {
+	//    0            1             2              3              4
+	// "Name", "2016-06-01", "2016-06-30", *"EARN,REDEEM"* *"Bookmark"*   (types may be empty)
+	if len(args) < 3 || len(args) > 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 to 5")
+	}
+	var types []string
+	if len(args) >= 4 && args[3] != "" {
+		var err error
+		types, err = parseTxnTypes(args[3])
+		if err != nil {
+			return nil, err
+		}
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	from, to, err := parseDateRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	periodKey, err := createCompositeKey(postingType, []string{entity.Name, timestampKey(from)})
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(postingType, []string{entity.Name, timestampKey(to)})
+	if err != nil {
+		return nil, err
+	}
+	opening, err := balanceBefore(stub, entity, periodKey, from)
+	if err != nil {
+		return nil, err
+	}
+
+	startKey, balance := periodKey, opening
+	if len(args) == 5 && args[4] != "" {
+		var mark StatementBookmark
+		var timestamp int64
+		markAsBytes, err := hex.DecodeString(args[4])
+		if err == nil {
+			err = json.Unmarshal(markAsBytes, &mark)
+		}
+		if err == nil {
+			var attributes []string
+			_, attributes, err = splitCompositeKey(mark.Key)
+			if err == nil && len(attributes) == 3 {
+				timestamp, err = strconv.ParseInt(attributes[1], 10, 64)
+			}
+		}
+		if err != nil || mark.Key <= periodKey || mark.Key >= endKey {
+			return nil, errors.New("5th argument must be a bookmark returned by get_statement for this period")
+		}
+		startKey = mark.Key
+		balance, err = balanceBefore(stub, entity, startKey, timestamp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, errors.New("Failed to get journal postings")
+	}
+	defer keysIter.Close()
+
+	statement := Statement{Entity: entity.Name, From: args[1], To: args[2], OpeningBalance: opening, Lines: []StatementLine{}}
+	var shown []TxnRecord
+	for scanned := 0; keysIter.HasNext(); scanned++ {
+		key, changeAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get journal postings")
+		}
+		if scanned == maxScanRecords {
+			markAsBytes, _ := json.Marshal(StatementBookmark{key})
+			statement.Bookmark = hex.EncodeToString(markAsBytes)
+			break
+		}
+		change, err := parseStateFloat(key, changeAsBytes)
+		if err != nil {
+			return nil, err
+		}
+		rec, err := postingRecord(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		balance = balance + change
+		if matchesTxnTypes(rec, types) {
+			statement.Lines = append(statement.Lines, StatementLine{rec, change, balance})
+			shown = append(shown, rec)
+		}
+	}
+	statement.ClosingBalance = balance
+	statement.Totals = txnTypeTotals(shown)
+
+	jsonAsBytes, _ := json.Marshal(statement)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,189 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+)
+
+// Transfers run as a pipeline: every check runs against the transfer before anything is written, then both legs are
+// written and recorded, then every effect runs. recordTxn runs its own hooks once a record is stored, for every kind
+// of transaction. A business rule is added by registering a hook from an init function in its own file rather than by
+// editing transfer. Hooks run in registration order, which the build fixes (the built-in hooks below, then init
+// functions in file name order), so every endorsing peer runs them in the same order.
+
+// TransferContext is the transfer a hook sees: checks may adjust the parties and the fee, the writes use the result
+type TransferContext struct {
+	From      Entity
+	To        Entity
+	Points    float64 //points taken from the sender, the recipient gets Points less Fee
+	Amount    float64 //transaction balance moved alongside
+	Fee       float64
+	Collector *Entity //entity the fee goes to, nil when no fee applies
+}
+
+// TransferHook is a named step of the transfer pipeline
+type TransferHook struct {
+	Name string
+	Run  func(stub *programStub, tc *TransferContext) error
+}
+
+// TxnHook is a named step run after recordTxn stores a transaction record
+type TxnHook struct {
+	Name string
+	Run  func(stub *programStub, rec TxnRecord, balances []BalanceChange) error
+}
+
+var transferChecks = []TransferHook{
+	{"vesting", releaseVestedCheck},
+	{"blacklist", blacklistCheck},
+	{"funds", fundsCheck},
+	{"fee", feeCheck},
+	{"limits", limitsCheck},
+}
+
+var transferEffects = []TransferHook{
+	{"fee", collectFee},
+}
+
+var txnHooks = []TxnHook{
+	{"journal", journalTxn},
+	{"event", emitTxnEvent},
+	{"thresholds", thresholdTxn},
+}
+
+// ============================================================================================================================
+// registerTransferCheck - add a check run before a transfer writes anything, an error stops the transfer
+// ============================================================================================================================
+func registerTransferCheck(hook TransferHook) {
+	transferChecks = append(transferChecks, hook)
+}
+
+// ============================================================================================================================
+// registerTransferEffect - add a step run after both legs of a transfer are written and recorded
+// ============================================================================================================================
+func registerTransferEffect(hook TransferHook) {
+	transferEffects = append(transferEffects, hook)
+}
+
+// ============================================================================================================================
+// registerTxnHook - add a step run after every transaction record is stored
+// ============================================================================================================================
+func registerTxnHook(hook TxnHook) {
+	txnHooks = append(txnHooks, hook)
+}
+
+// ============================================================================================================================
+// runTransferHooks - run each hook in order, stopping at the first error
+// ============================================================================================================================
+func runTransferHooks(stub *programStub, hooks []TransferHook, tc *TransferContext) error {
+	for _, hook := range hooks {
+		err := hook.Run(stub, tc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// releaseVestedCheck - release the sender's vested points before its funds are checked
+// ============================================================================================================================
+func releaseVestedCheck(stub *programStub, tc *TransferContext) error {
+	_, err := releaseVested(stub, &tc.From)
+	return err
+}
+
+// ============================================================================================================================
+// blacklistCheck - refuse transfers involving a blocked entity
+// ============================================================================================================================
+func blacklistCheck(stub *programStub, tc *TransferContext) error {
+	return checkNotBlocked(stub, tc.From.Name, tc.To.Name)
+}
+
+// ============================================================================================================================
+// fundsCheck - refuse transfers of more points than the sender may move
+// ============================================================================================================================
+func fundsCheck(stub *programStub, tc *TransferContext) error {
+	if transferablePoints(tc.From) < tc.Points {
+		if tc.From.PtBal >= tc.Points {
+			return errors.New("Category restricted, locked or unvested points cannot be transferred")
+		}
+		return errors.New("Insufficient points")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// feeCheck - work out the program fee, taken out of the points the recipient gets
+// ============================================================================================================================
+func feeCheck(stub *programStub, tc *TransferContext) error {
+	fee, collector, err := transferFee(stub, tc.From, tc.To, tc.Points)
+	if err != nil {
+		return err
+	}
+	tc.Fee, tc.Collector = fee, collector
+	return nil
+}
+
+// ============================================================================================================================
+// limitsCheck - refuse transfers outside the program's amount bounds before anything is written
+// ============================================================================================================================
+func limitsCheck(stub *programStub, tc *TransferContext) error {
+	return checkTxnBounds(stub, TxnRecord{Type: "transfer", From: tc.From.Name, To: tc.To.Name, Points: tc.Points - tc.Fee, Amount: tc.Amount})
+}
+
+// ============================================================================================================================
+// collectFee - credit the fee to the collector and record it
+// ============================================================================================================================
+func collectFee(stub *programStub, tc *TransferContext) error {
+	if tc.Collector == nil {
+		return nil
+	}
+	collectorBefore := *tc.Collector
+	tc.Collector.PtBal = tc.Collector.PtBal + tc.Fee
+	err := putEntity(stub, *tc.Collector)
+	if err != nil {
+		return err
+	}
+	return recordTxn(stub, TxnRecord{Type: "fee", From: tc.From.Name, To: tc.Collector.Name, Points: tc.Fee},
+		balanceChange(collectorBefore, *tc.Collector))
+}
+
+// ============================================================================================================================
+// journalTxn - post the double entry journal lines of a record
+// ============================================================================================================================
+func journalTxn(stub *programStub, rec TxnRecord, balances []BalanceChange) error {
+	return postJournal(stub, rec.ID, rec.Type, journalLines(rec))
+}
+
+// ============================================================================================================================
+// emitTxnEvent - raise the Transaction event for a record
+// ============================================================================================================================
+func emitTxnEvent(stub *programStub, rec TxnRecord, balances []BalanceChange) error {
+	return emitEvent(stub, "Transaction", balances, rec)
+}
+
+// ============================================================================================================================
+// thresholdTxn - raise balance alert events for the balances a record changed
+// ============================================================================================================================
+func thresholdTxn(stub *programStub, rec TxnRecord, balances []BalanceChange) error {
+	return emitThresholdEvents(stub, balances)
+}
@@ -0,0 +1,133 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var identityType = "identity" //composite key object type binding a certificate fingerprint to an entity name
+
+// ============================================================================================================================
+// callerAttribute - value of a Fabric CA attribute in the caller's certificate, empty if it has none
+// ============================================================================================================================
+func callerAttribute(stub *programStub, name string) string {
+	value, err := stub.ReadCertAttribute(name)
+	if err != nil {
+		return ""
+	}
+	return string(value)
+}
+
+// ============================================================================================================================
+// boundEntityName - the entity a certificate fingerprint was bound to, empty if none
+// ============================================================================================================================
+func boundEntityName(stub *programStub, id string) (string, error) {
+	key, err := createCompositeKey(identityType, []string{id})
+	if err != nil {
+		return "", err
+	}
+	nameAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return "", errors.New("Failed to get identity binding")
+	}
+	return string(nameAsBytes), nil
+}
+
+// ============================================================================================================================
+// callerEntity - the entity the caller acts as, an identity bound with bind_identity wins over the "entityName"
+// certificate attribute
+// ============================================================================================================================
+func callerEntity(stub *programStub) (Entity, error) {
+	if id, err := callerID(stub); err == nil {
+		name, err := boundEntityName(stub, id)
+		if err != nil {
+			return Entity{}, err
+		}
+		if name != "" {
+			return getEntity(stub, name)
+		}
+	}
+	name := callerAttribute(stub, "entityName")
+	if name == "" {
+		return Entity{}, errors.New("Caller is not bound to an entity")
+	}
+	return getEntity(stub, name)
+}
+
+// ============================================================================================================================
+// Bind Identity - admin only, associate a certificate fingerprint with an entity at enrollment
+// ============================================================================================================================
+func (t *SimpleChaincode) bindIdentity(stub *programStub, args []string) ([]byte, error) {
+	//   0          1
+	// "Name", "Fingerprint"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	if len(args[1]) <= 0 {
+		return nil, errors.New("2nd argument must be a non-empty string")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	existing, err := boundEntityName(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if existing != "" && existing != entity.Name {
+		return nil, errors.New("Identity is already bound to " + existing)
+	}
+
+	key, err := createCompositeKey(identityType, []string{args[1]})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, []byte(entity.Name))
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// My Entity - the entity record of the caller
+// ============================================================================================================================
+func (t *SimpleChaincode) myEntity(stub *programStub, args []string) ([]byte, error) {
+	//      0
+	// "name,ptbal"   (optional)
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	entity, err := callerEntity(stub)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(entity)
+	if len(args) == 1 {
+		fields, err := parseFields(args[0], Entity{})
+		if err != nil {
+			return nil, err
+		}
+		return projectFields(jsonAsBytes, fields)
+	}
+	return jsonAsBytes, nil
+}
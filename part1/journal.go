@@ -0,0 +1,318 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// Every point movement is also booked as a double-entry journal entry. Each line is written under its own key and
+// never read back by the transaction that wrote it, so two movements touching one account in a block do not collide,
+// and an account's balance can always be derived from its last checkpoint plus the postings made since. Points come
+// into existence by debiting the issuance account, so its balance is minus the supply and the whole journal nets to
+// zero. The PtBal on the entity is the fast read path, verify_books proves it agrees with the journal.
+var journalType = "journal"                     //composite key object type for journal entries, keyed by timestamp then entry id
+var postingType = "posting"                     //composite key object type for journal lines, keyed by account, timestamp then entry id
+var journalCheckpointType = "journalcheckpoint" //composite key object type for folded account balances, keyed by account
+var issuanceAccount = "_issuance"               //contra account debited when points are created and credited when they are destroyed
+var booksTolerance = 1e-6                       //float noise allowed when comparing balances
+
+// JournalLine moves points out of (debit) or into (credit) one account
+type JournalLine struct {
+	Account string  `json:"account"`
+	Debit   float64 `json:"debit"`
+	Credit  float64 `json:"credit"`
+}
+
+// JournalEntry is one balanced set of lines, booked for a transaction record or an opening balance
+type JournalEntry struct {
+	ID        string        `json:"id"`
+	RecordID  string        `json:"record_id"` //transaction record this entry books, empty for opening balances
+	Type      string        `json:"type"`
+	Class     TxnClass      `json:"class"`
+	Timestamp int64         `json:"timestamp"`
+	Lines     []JournalLine `json:"lines"`
+}
+
+// JournalCheckpoint is an account balance folded from every posting up to and including the Through key
+type JournalCheckpoint struct {
+	Balance float64 `json:"balance"`
+	Through string  `json:"through"`
+}
+
+// BooksDiscrepancy is an account whose entity balance does not match the journal
+type BooksDiscrepancy struct {
+	Account string  `json:"account"`
+	Entity  float64 `json:"entity"`
+	Journal float64 `json:"journal"`
+}
+
+// BooksReport is the result of verify_books
+type BooksReport struct {
+	Balanced      bool               `json:"balanced"`
+	Accounts      int                `json:"accounts"`
+	JournalTotal  float64            `json:"journal_total"` //sum of every account balance including issuance, zero when the books balance
+	Issuance      float64            `json:"issuance"`
+	Supply        float64            `json:"supply"`
+	Discrepancies []BooksDiscrepancy `json:"discrepancies"`
+}
+
+// ============================================================================================================================
+// journalLines - the debit and credit lines that book a transaction record, with issuance taking up any difference
+// ============================================================================================================================
+func journalLines(rec TxnRecord) []JournalLine {
+	var lines []JournalLine
+	var net float64
+	if rec.From != "" && rec.Type != "earn" { //merchants issue points without spending their own
+		lines = append(lines, JournalLine{Account: rec.From, Debit: rec.Points})
+		net = net - rec.Points
+	}
+	if rec.To != "" {
+		lines = append(lines, JournalLine{Account: rec.To, Credit: rec.Points})
+		net = net + rec.Points
+	}
+	if net > 0 {
+		lines = append(lines, JournalLine{Account: issuanceAccount, Debit: net})
+	} else if net < 0 {
+		lines = append(lines, JournalLine{Account: issuanceAccount, Credit: -net})
+	}
+	return lines
+}
+
+// ============================================================================================================================
+// postJournal - store a balanced journal entry and one posting per line, none of them read back in this transaction
+// ============================================================================================================================
+func postJournal(stub *programStub, recordID string, entryType string, lines []JournalLine) error {
+	var debits, credits float64
+	for _, line := range lines {
+		debits = debits + line.Debit
+		credits = credits + line.Credit
+	}
+	if math.Abs(debits-credits) > booksTolerance {
+		return errors.New("Journal entry does not balance")
+	}
+	if debits == 0 {
+		return nil
+	}
+	class, err := txnClassOf(entryType)
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	entry := JournalEntry{ID: newID(stub, journalType), RecordID: recordID, Type: entryType, Class: class, Timestamp: timestamp, Lines: lines}
+	key, err := createCompositeKey(journalType, []string{timestampKey(timestamp), entry.ID})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(entry)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		postingKey, err := createCompositeKey(postingType, []string{line.Account, timestampKey(timestamp), entry.ID})
+		if err != nil {
+			return err
+		}
+		change := formatAmount(line.Credit - line.Debit)
+		err = stub.PutState(postingKey, []byte(change))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// getJournalCheckpoint - the folded balance of an account, zero through nothing if it was never checkpointed
+// ============================================================================================================================
+func getJournalCheckpoint(stub *programStub, account string) (JournalCheckpoint, error) {
+	var checkpoint JournalCheckpoint
+	key, err := createCompositeKey(journalCheckpointType, []string{account})
+	if err != nil {
+		return checkpoint, err
+	}
+	checkpointAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return checkpoint, errors.New("Failed to get journal checkpoint")
+	}
+	if checkpointAsBytes != nil {
+		err = unmarshalState(key, checkpointAsBytes, &checkpoint)
+		if err != nil {
+			return checkpoint, err
+		}
+	}
+	return checkpoint, nil
+}
+
+// ============================================================================================================================
+// journalBalance - an account's balance from its checkpoint plus every later posting, and the last posting key seen
+// ============================================================================================================================
+func journalBalance(stub *programStub, account string) (float64, string, error) {
+	checkpoint, err := getJournalCheckpoint(stub, account)
+	if err != nil {
+		return 0, "", err
+	}
+	prefix, err := createCompositeKey(postingType, []string{account})
+	if err != nil {
+		return 0, "", err
+	}
+	startKey := prefix
+	if checkpoint.Through != "" {
+		startKey = checkpoint.Through + compositeKeyNamespace //first key after the last folded posting
+	}
+	keysIter, err := stub.RangeQueryState(startKey, prefix+maxUnicodeRuneValue)
+	if err != nil {
+		return 0, "", errors.New("Failed to get journal postings")
+	}
+	defer keysIter.Close()
+
+	balance := checkpoint.Balance
+	through := checkpoint.Through
+	for keysIter.HasNext() {
+		key, changeAsBytes, err := keysIter.Next()
+		if err != nil {
+			return 0, "", errors.New("Failed to get journal postings")
+		}
+		change, err := parseStateFloat(key, changeAsBytes)
+		if err != nil {
+			return 0, "", err
+		}
+		balance = balance + change
+		through = key
+	}
+	return balance, through, nil
+}
+
+// ============================================================================================================================
+// Checkpoint Journal - admin only, fold the postings of the named accounts (or every entity) into their checkpoints so
+// later balance derivations scan fewer keys. Postings are kept, so every movement stays traceable.
+// ============================================================================================================================
+func (t *SimpleChaincode) checkpointJournal(stub *programStub, args []string) ([]byte, error) {
+	//    0         1
+	// "Name1", "Name2", ...   (none for every entity and the issuance account)
+	accounts := args
+	if len(accounts) == 0 {
+		var err error
+		accounts, err = journalAccounts(stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stub.log.debug("start checkpoint journal")
+	for _, account := range accounts {
+		balance, through, err := journalBalance(stub, account)
+		if err != nil {
+			return nil, err
+		}
+		if through == "" {
+			continue
+		}
+		key, err := createCompositeKey(journalCheckpointType, []string{account})
+		if err != nil {
+			return nil, err
+		}
+		jsonAsBytes, _ := json.Marshal(JournalCheckpoint{Balance: balance, Through: through})
+		err = stub.PutState(key, jsonAsBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stub.log.debug("end checkpoint journal")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// journalAccounts - every entity in the index plus the issuance account
+// ============================================================================================================================
+func journalAccounts(stub *programStub) ([]string, error) {
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	return append(entityIndex, issuanceAccount), nil
+}
+
+// ============================================================================================================================
+// Verify Books - derive every account balance from the journal and check it matches the entity, that the journal nets
+// to zero and that issuance mirrors the supply counter
+// ============================================================================================================================
+func (t *SimpleChaincode) verifyBooks(stub *programStub, args []string) ([]byte, error) {
+	accounts, err := journalAccounts(stub)
+	if err != nil {
+		return nil, err
+	}
+	supply, err := getSupply(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	report := BooksReport{Accounts: len(accounts), Supply: supply, Discrepancies: []BooksDiscrepancy{}}
+	for _, account := range accounts {
+		balance, _, err := journalBalance(stub, account)
+		if err != nil {
+			return nil, err
+		}
+		report.JournalTotal = report.JournalTotal + balance
+		if account == issuanceAccount {
+			report.Issuance = balance
+			continue
+		}
+		entity, err := getEntity(stub, account)
+		if err != nil {
+			return nil, err
+		}
+		if math.Abs(entity.PtBal-balance) > booksTolerance {
+			report.Discrepancies = append(report.Discrepancies, BooksDiscrepancy{Account: account, Entity: entity.PtBal, Journal: balance})
+		}
+	}
+	report.Balanced = len(report.Discrepancies) == 0 && math.Abs(report.JournalTotal) <= booksTolerance && math.Abs(report.Issuance+supply) <= booksTolerance
+
+	jsonAsBytes, _ := json.Marshal(report)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Get Journal Balance - an account's point balance as derived from the journal
+// ============================================================================================================================
+func (t *SimpleChaincode) getJournalBalance(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the account to query")
+	}
+	account := args[0]
+	if account != issuanceAccount {
+		canonical, err := resolveAlias(stub, account)
+		if err != nil {
+			return nil, err
+		}
+		account = canonical
+	}
+	balance, _, err := journalBalance(stub, account)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(formatAmount(balance)), nil
+}
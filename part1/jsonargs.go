@@ -0,0 +1,111 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Records passed as JSON arguments are decoded strictly so a misspelt field such as "ptBal" fails the call instead of
+// leaving the real field zero. Stored state keeps going through plain json.Unmarshal, a ledger written by a newer
+// build may carry fields this one does not know yet.
+
+// ============================================================================================================================
+// decodeJSONArg - decode one JSON value from an argument, rejecting fields the target does not have and trailing data,
+// encoding/json matches names regardless of case so field names are compared exactly as well
+// ============================================================================================================================
+func decodeJSONArg(arg string, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(arg)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		return err
+	}
+	if decoder.Decode(&json.RawMessage{}) != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+	var generic interface{}
+	err = json.Unmarshal([]byte(arg), &generic)
+	if err != nil {
+		return err
+	}
+	return checkFieldNames(generic, reflect.TypeOf(v), "")
+}
+
+// ============================================================================================================================
+// checkFieldNames - every object key in a decoded value must be the exact json name of a field of the struct it landed
+// in, path is where the value sits for the error message
+// ============================================================================================================================
+func checkFieldNames(val interface{}, typ reflect.Type, path string) error {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typed := val.(type) {
+	case []interface{}:
+		if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+			for _, item := range typed {
+				err := checkFieldNames(item, typ.Elem(), path+"[]")
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		if typ.Kind() == reflect.Map {
+			for _, key := range sortedKeys(typed) {
+				err := checkFieldNames(typed[key], typ.Elem(), path+"."+key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if typ.Kind() != reflect.Struct || typ == rawMessageType {
+			return nil
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if field.PkgPath != "" || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fields[name] = field.Type
+		}
+		for _, key := range sortedKeys(typed) {
+			fieldType, ok := fields[key]
+			if !ok {
+				return errors.New("unknown field " + strings.TrimPrefix(path+"."+key, "."))
+			}
+			err := checkFieldNames(typed[key], fieldType, path+"."+key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
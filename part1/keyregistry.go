@@ -0,0 +1,291 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// Entities bind ECDSA verification keys for signed vouchers, escrow conditions and approvals. A rotation retires the
+// current key instead of deleting it, so a signature can still be checked against the key that was active when it
+// was made.
+var publicKeyType = "pubkey" //composite key object type for entity public keys, keyed by entity then version
+
+// PublicKeyRecord is one version of an entity's verification key
+type PublicKeyRecord struct {
+	Entity       string `json:"entity"`
+	Version      int    `json:"version"`
+	KeyID        string `json:"key_id"` //hex sha256 of the PKIX key
+	Key          string `json:"key"`    //base64 PKIX public key
+	RegisteredAt int64  `json:"registered_at"`
+	RetiredAt    int64  `json:"retired_at,omitempty"` //0 while the key is current
+	TxID         string `json:"txid"`
+}
+
+// ecdsaSignature is the ASN.1 DER form of an ECDSA signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// ============================================================================================================================
+// parsePublicKey - decode a base64 PKIX public key, only ECDSA keys are accepted
+// ============================================================================================================================
+func parsePublicKey(encoded string) (*ecdsa.PublicKey, []byte, error) {
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.New("Public key must be base64 encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, nil, errors.New("Public key must be a PKIX encoded ECDSA key")
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("Public key must be a PKIX encoded ECDSA key")
+	}
+	return ecKey, der, nil
+}
+
+// ============================================================================================================================
+// getPublicKeys - every key version an entity registered, oldest first
+// ============================================================================================================================
+func getPublicKeys(stub *programStub, name string) ([]PublicKeyRecord, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, publicKeyType, []string{name})
+	if err != nil {
+		return nil, errors.New("Failed to get public keys")
+	}
+	defer keysIter.Close()
+
+	records := []PublicKeyRecord{}
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get public keys")
+		}
+		var record PublicKeyRecord
+		err = unmarshalState(key, valAsBytes, &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ============================================================================================================================
+// putPublicKey - write one key version, versions are zero padded so the key history scans in order
+// ============================================================================================================================
+func putPublicKey(stub *programStub, record PublicKeyRecord) error {
+	key, err := createCompositeKey(publicKeyType, []string{record.Entity, fmt.Sprintf("%08d", record.Version)})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(record)
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// addPublicKey - retire an entity's current key, if any, and register a new current one
+// ============================================================================================================================
+func addPublicKey(stub *programStub, entity Entity, encoded string, rotate bool) (PublicKeyRecord, error) {
+	var record PublicKeyRecord
+	_, der, err := parsePublicKey(encoded)
+	if err != nil {
+		return record, err
+	}
+	records, err := getPublicKeys(stub, entity.Name)
+	if err != nil {
+		return record, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return record, err
+	}
+	sum := sha256.Sum256(der)
+	keyID := hex.EncodeToString(sum[:])
+	for _, old := range records {
+		if old.KeyID == keyID {
+			return record, errors.New("Key " + keyID + " was already registered by " + entity.Name)
+		}
+	}
+	if len(records) > 0 {
+		current := records[len(records)-1]
+		if current.RetiredAt == 0 && !rotate {
+			return record, errors.New(entity.Name + " already has a public key, use rotate_public_key")
+		}
+		if current.RetiredAt == 0 {
+			current.RetiredAt = timestamp
+			err = putPublicKey(stub, current)
+			if err != nil {
+				return record, err
+			}
+		}
+	} else if rotate {
+		return record, errors.New(entity.Name + " has no public key to rotate, use register_public_key")
+	}
+
+	record = PublicKeyRecord{entity.Name, len(records) + 1, keyID, encoded, timestamp, 0, stub.UUID}
+	err = putPublicKey(stub, record)
+	if err != nil {
+		return record, err
+	}
+	return record, nil
+}
+
+// ============================================================================================================================
+// verifyEntitySignature - check a base64 DER signature over a message with the entity key that was active at signedAt
+// ============================================================================================================================
+func verifyEntitySignature(stub *programStub, name string, message string, signature string, signedAt int64) (PublicKeyRecord, error) {
+	var active *PublicKeyRecord
+	records, err := getPublicKeys(stub, name)
+	if err != nil {
+		return PublicKeyRecord{}, err
+	}
+	for i := range records {
+		if records[i].RegisteredAt <= signedAt && (records[i].RetiredAt == 0 || signedAt < records[i].RetiredAt) {
+			active = &records[i]
+		}
+	}
+	if active == nil {
+		return PublicKeyRecord{}, errors.New(name + " had no public key at " + strconv.FormatInt(signedAt, 10))
+	}
+	pub, _, err := parsePublicKey(active.Key)
+	if err != nil {
+		return *active, err
+	}
+	der, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return *active, errors.New("Signature must be base64 encoded")
+	}
+	var sig ecdsaSignature
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil || len(rest) > 0 || sig.R == nil || sig.S == nil {
+		return *active, errors.New("Signature must be a DER encoded ECDSA signature")
+	}
+	digest := sha256.Sum256([]byte(message))
+	if !ecdsa.Verify(pub, digest[:], sig.R, sig.S) {
+		return *active, errors.New("Signature does not match " + name + "'s key version " + strconv.Itoa(active.Version))
+	}
+	return *active, nil
+}
+
+// ============================================================================================================================
+// Register Public Key - bind an entity's first verification key
+// ============================================================================================================================
+func (t *SimpleChaincode) registerPublicKey(stub *programStub, args []string) ([]byte, error) {
+	//   0               1
+	// "Name", "base64 PKIX public key"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	record, err := addPublicKey(stub, entity, args[1], false)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("registered public key " + record.KeyID + " for " + entity.Name)
+	return []byte(record.KeyID), nil
+}
+
+// ============================================================================================================================
+// Rotate Public Key - retire an entity's current verification key and bind a new one, the old key stays in the history
+// ============================================================================================================================
+func (t *SimpleChaincode) rotatePublicKey(stub *programStub, args []string) ([]byte, error) {
+	//   0               1
+	// "Name", "base64 PKIX public key"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	record, err := addPublicKey(stub, entity, args[1], true)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("rotated public key of " + entity.Name + " to version " + strconv.Itoa(record.Version))
+	return []byte(record.KeyID), nil
+}
+
+// ============================================================================================================================
+// List Public Keys - every key version of an entity, oldest first, the current one has no retired_at
+// ============================================================================================================================
+func (t *SimpleChaincode) listPublicKeys(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	records, err := getPublicKeys(stub, entity.Name)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(records)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Verify Signature - check a signature by an entity against the key it held at a time, the current key by default
+// ============================================================================================================================
+func (t *SimpleChaincode) verifySignature(stub *programStub, args []string) ([]byte, error) {
+	//   0          1            2              3
+	// "Name", "Message", "Signature" *"2016-07-01T12:00:00Z"*
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 or 4")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	signedAt, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 4 {
+		at, err := time.Parse(time.RFC3339, args[3])
+		if err != nil {
+			return nil, errors.New("4th argument must be an RFC 3339 time such as 2016-07-01T12:00:00Z")
+		}
+		signedAt = at.Unix()
+	}
+	record, err := verifyEntitySignature(stub, entity.Name, args[1], args[2], signedAt)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(record)
+	return jsonAsBytes, nil
+}
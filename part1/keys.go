@@ -0,0 +1,116 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The v0.5 shim has no composite key support, so keys are built the same way later fabric releases do it:
+// a null byte, the object type, then each attribute terminated by a null byte. Range scans over a prefix then
+// behave like GetStateByPartialCompositeKey.
+var compositeKeyNamespace = "\x00"
+var maxUnicodeRuneValue = string(utf8.MaxRune)
+
+// Entity names are raw state keys, so every other key the chaincode writes either is a composite key or starts with
+// the system prefix, and no entity name may start with it or contain a key separator.
+var systemKeyPrefix = "_"     //prefix of every system key/value that is not a composite key
+var maxEntityNameLength = 64  //longest entity name in bytes
+var entityNameSymbols = ".-@" //characters allowed in an entity name besides letters, digits and the underscore
+
+// ============================================================================================================================
+// isReservedKey - whether a key belongs to the chaincode's internals rather than to an entity
+// ============================================================================================================================
+func isReservedKey(key string) bool {
+	return strings.HasPrefix(key, systemKeyPrefix) || strings.ContainsAny(key, compositeKeyNamespace+programSeparator)
+}
+
+// ============================================================================================================================
+// checkEntityName - error unless a name may be used for a new entity: ASCII letters, digits, the underscore and a few
+// symbols, not too long and not under the system prefix
+// ============================================================================================================================
+func checkEntityName(name string) error {
+	if len(name) <= 0 || len(name) > maxEntityNameLength {
+		return errors.New("Entity names must be 1 to " + strconv.Itoa(maxEntityNameLength) + " characters long")
+	}
+	if isReservedKey(name) {
+		return errors.New("Entity names may not start with " + systemKeyPrefix)
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' && !strings.ContainsRune(entityNameSymbols, r) {
+			return errors.New("Entity names may only contain letters, digits, _ and " + entityNameSymbols)
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// createCompositeKey - build a state key from an object type and its attributes
+// ============================================================================================================================
+func createCompositeKey(objectType string, attributes []string) (string, error) {
+	if len(objectType) <= 0 || strings.Contains(objectType, compositeKeyNamespace) {
+		return "", errors.New("Invalid composite key object type")
+	}
+	key := compositeKeyNamespace + objectType + compositeKeyNamespace
+	for _, attr := range attributes {
+		if strings.Contains(attr, compositeKeyNamespace) {
+			return "", errors.New("Composite key attribute may not contain a null byte")
+		}
+		key = key + attr + compositeKeyNamespace
+	}
+	return key, nil
+}
+
+// ============================================================================================================================
+// splitCompositeKey - undo createCompositeKey, returns the object type and attributes
+// ============================================================================================================================
+func splitCompositeKey(key string) (string, []string, error) {
+	if !strings.HasPrefix(key, compositeKeyNamespace) || !strings.HasSuffix(key, compositeKeyNamespace) {
+		return "", nil, errors.New("Not a composite key")
+	}
+	parts := strings.Split(key[1:len(key)-1], compositeKeyNamespace)
+	return parts[0], parts[1:], nil
+}
+
+// ============================================================================================================================
+// getStateByPartialCompositeKey - range scan every key that starts with the given object type and attributes
+// ============================================================================================================================
+func getStateByPartialCompositeKey(stub *programStub, objectType string, attributes []string) (*programIterator, error) {
+	startKey, err := createCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return stub.RangeQueryState(startKey, startKey+maxUnicodeRuneValue)
+}
+
+// ============================================================================================================================
+// newID - deterministic id for a child record, the tx id plus a sequence number that counts up within the transaction
+//
+// Every endorser sees the same tx id and runs the same code, so they all hand out the same ids without random numbers.
+// The sequence lives on the invocation's unit of work, so other calls running in the same container cannot disturb it.
+// ============================================================================================================================
+func newID(stub *programStub, prefix string) string {
+	id := prefix + "-" + stub.UUID + "-" + strconv.Itoa(stub.uow.nextID)
+	stub.uow.nextID++
+	return id
+}
@@ -0,0 +1,140 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Every log line is one key=value record tagged with the transaction, the function and the caller, so a peer's
+// chaincode log can be searched for everything one transaction or one identity did. The level is the program's
+// log_level config, else the CHAINCODE_LOG_LEVEL environment variable of the chaincode container, else info.
+var logLevelEnv = "CHAINCODE_LOG_LEVEL"
+var logLevels = []string{"debug", "info", "warning", "error"} //in increasing severity
+var defaultLogLevel = 1                                       //info
+
+// txLogger writes the log lines of one transaction, a nil logger writes untagged lines at the environment's level
+type txLogger struct {
+	level    int
+	txID     string
+	function string
+	program  string
+	caller   string //first 16 hex digits of the caller's certificate fingerprint
+}
+
+var chaincodeLog = &txLogger{level: envLogLevel()} //lines written outside any transaction
+
+// ============================================================================================================================
+// parseLogLevel - the index of a level name in logLevels
+// ============================================================================================================================
+func parseLogLevel(name string) (int, error) {
+	for i, level := range logLevels {
+		if strings.EqualFold(name, level) {
+			return i, nil
+		}
+	}
+	return 0, errors.New("Log level must be one of " + strings.Join(logLevels, ", "))
+}
+
+// ============================================================================================================================
+// envLogLevel - the level set in the chaincode container's environment, info when unset or unknown
+// ============================================================================================================================
+func envLogLevel() int {
+	level, err := parseLogLevel(os.Getenv(logLevelEnv))
+	if err != nil {
+		return defaultLogLevel
+	}
+	return level
+}
+
+// ============================================================================================================================
+// newTxLogger - the logger of a transaction running a function
+// ============================================================================================================================
+func newTxLogger(stub *programStub, function string) *txLogger {
+	log := &txLogger{level: envLogLevel(), txID: stub.UUID, function: function, program: stub.program}
+	if id, err := callerID(stub); err == nil && len(id) >= 16 {
+		log.caller = id[:16]
+	}
+	config, err := getConfig(stub)
+	if err == nil && config.LogLevel != "" {
+		if level, err := parseLogLevel(config.LogLevel); err == nil {
+			log.level = level
+		}
+	}
+	return log
+}
+
+// ============================================================================================================================
+// write - print one line if its level is enabled
+// ============================================================================================================================
+func (log *txLogger) write(level int, msg string) {
+	if log == nil {
+		log = chaincodeLog
+	}
+	if level < log.level {
+		return
+	}
+	line := "level=" + logLevels[level]
+	if log.txID != "" {
+		line = line + " tx=" + log.txID + " fn=" + log.function
+	}
+	if log.program != "" {
+		line = line + " program=" + log.program
+	}
+	if log.caller != "" {
+		line = line + " caller=" + log.caller
+	}
+	fmt.Println(line + " msg=" + strconv.Quote(msg))
+}
+
+func (log *txLogger) debug(msg string)   { log.write(0, msg) }
+func (log *txLogger) info(msg string)    { log.write(1, msg) }
+func (log *txLogger) warning(msg string) { log.write(2, msg) }
+func (log *txLogger) error(msg string)   { log.write(3, msg) }
+
+// ============================================================================================================================
+// Set Log Level - admin only, set the program's log level, an empty level falls back to the environment's
+// ============================================================================================================================
+func (t *SimpleChaincode) setLogLevel(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// "debug"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	if args[0] != "" {
+		if _, err := parseLogLevel(args[0]); err != nil {
+			return nil, err
+		}
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.LogLevel = strings.ToLower(args[0])
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,317 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Members trade points of one kind for another, e.g. airline restricted points for hotel restricted points. A kind
+// is a merchant category, or empty for unrestricted points. The seller's side is escrowed when the listing is
+// created: the points are locked, so neither the seller nor a second buyer can spend them, then either move to the
+// buyer on acceptance or are released when the listing is cancelled. The buyer's side moves in the accepting
+// transaction itself.
+var listingType = "listing" //composite key object type for marketplace listings, keyed by listing id
+
+// Listing is an offer of points of one kind in exchange for points of another
+type Listing struct {
+	ID          string  `json:"id"`
+	Seller      string  `json:"seller"`
+	OfferPoints float64 `json:"offer_points"`
+	OfferKind   string  `json:"offer_kind"` //category the offered points are restricted to, empty for unrestricted
+	WantPoints  float64 `json:"want_points"`
+	WantKind    string  `json:"want_kind"`
+	Status      string  `json:"status"` //open, accepted or cancelled
+	Buyer       string  `json:"buyer,omitempty"`
+	CreatedAt   int64   `json:"created_at"`
+	ClosedAt    int64   `json:"closed_at,omitempty"`
+}
+
+// ============================================================================================================================
+// getListing - fetch a marketplace listing by id
+// ============================================================================================================================
+func getListing(stub *programStub, id string) (Listing, error) {
+	var listing Listing
+	key, err := createCompositeKey(listingType, []string{id})
+	if err != nil {
+		return listing, err
+	}
+	listingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return listing, errors.New("Failed to get listing")
+	}
+	if listingAsBytes == nil {
+		return listing, errors.New("Listing " + id + " does not exist")
+	}
+	err = unmarshalState(key, listingAsBytes, &listing)
+	return listing, err
+}
+
+// ============================================================================================================================
+// putListing - write a marketplace listing back to chaincode state
+// ============================================================================================================================
+func putListing(stub *programStub, listing Listing) error {
+	key, err := createCompositeKey(listingType, []string{listing.ID})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(listing)
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// pointsOfKind - points of a kind an entity can trade, the restricted bucket for a category, otherwise the
+// transferable points
+// ============================================================================================================================
+func pointsOfKind(entity Entity, kind string) float64 {
+	if kind != "" {
+		return entity.Restricted[kind]
+	}
+	return transferablePoints(entity)
+}
+
+// ============================================================================================================================
+// takePoints - remove points of a kind from an entity's restricted bucket, unrestricted points need no bucket change
+// ============================================================================================================================
+func takePoints(entity *Entity, kind string, points float64) {
+	if kind == "" {
+		return
+	}
+	entity.Restricted[kind] = entity.Restricted[kind] - points
+	if entity.Restricted[kind] <= 0 {
+		delete(entity.Restricted, kind)
+	}
+}
+
+// ============================================================================================================================
+// givePoints - add points of a kind to an entity's restricted bucket, unrestricted points need no bucket change
+// ============================================================================================================================
+func givePoints(entity *Entity, kind string, points float64) {
+	if kind == "" {
+		return
+	}
+	if entity.Restricted == nil {
+		entity.Restricted = map[string]float64{}
+	}
+	entity.Restricted[kind] = entity.Restricted[kind] + points
+}
+
+// ============================================================================================================================
+// Create Listing - offer points of one kind for points of another, the offered points are locked until the listing
+// closes, returns the listing id
+// ============================================================================================================================
+func (t *SimpleChaincode) createListing(stub *programStub, args []string) ([]byte, error) {
+	//    0           1             2              3             4
+	// "Seller", "OfferPoints", "OfferKind", "WantPoints", "WantKind"   (kinds may be empty for unrestricted)
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5")
+	}
+	offer, err := parseAmount(args[1])
+	if (err != nil) || (offer <= 0) {
+		return nil, amountError("2nd argument must be a positive numeric string", err)
+	}
+	want, err := parseAmount(args[3])
+	if (err != nil) || (want <= 0) {
+		return nil, amountError("4th argument must be a positive numeric string", err)
+	}
+	if args[2] == args[4] {
+		return nil, errors.New("A listing must trade points of one kind for points of another")
+	}
+	seller, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotBlocked(stub, seller.Name)
+	if err != nil {
+		return nil, err
+	}
+	if pointsOfKind(seller, args[2]) < offer {
+		return nil, errors.New("Insufficient points to list")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start create listing")
+	takePoints(&seller, args[2], offer)
+	seller.Locked = seller.Locked + offer
+	err = putEntity(stub, seller)
+	if err != nil {
+		return nil, err
+	}
+	listing := Listing{ID: newID(stub, listingType), Seller: seller.Name, OfferPoints: offer, OfferKind: args[2], WantPoints: want, WantKind: args[4], Status: "open", CreatedAt: timestamp}
+	err = putListing(stub, listing)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end create listing")
+	return []byte(listing.ID), nil
+}
+
+// ============================================================================================================================
+// Accept Listing - the buyer pays the wanted points to the seller and receives the escrowed points
+// ============================================================================================================================
+func (t *SimpleChaincode) acceptListing(stub *programStub, args []string) ([]byte, error) {
+	//   0        1
+	// "ID", "Buyer"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	listing, err := getListing(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if listing.Status != "open" {
+		return nil, errors.New("Listing " + listing.ID + " is " + listing.Status)
+	}
+	buyer, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	seller, err := getEntity(stub, listing.Seller)
+	if err != nil {
+		return nil, err
+	}
+	if buyer.Name == seller.Name {
+		return nil, errors.New("A seller cannot accept its own listing")
+	}
+	err = checkNotBlocked(stub, buyer.Name, seller.Name)
+	if err != nil {
+		return nil, err
+	}
+	if pointsOfKind(buyer, listing.WantKind) < listing.WantPoints {
+		return nil, errors.New("Insufficient points to accept listing " + listing.ID)
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start accept listing")
+	buyerBefore, sellerBefore := buyer, seller
+	seller.Locked = seller.Locked - listing.OfferPoints
+	seller.PtBal = seller.PtBal - listing.OfferPoints + listing.WantPoints
+	givePoints(&seller, listing.WantKind, listing.WantPoints)
+	takePoints(&buyer, listing.WantKind, listing.WantPoints)
+	buyer.PtBal = buyer.PtBal - listing.WantPoints + listing.OfferPoints
+	givePoints(&buyer, listing.OfferKind, listing.OfferPoints)
+	err = putEntity(stub, seller)
+	if err != nil {
+		return nil, err
+	}
+	err = putEntity(stub, buyer)
+	if err != nil {
+		return nil, err
+	}
+
+	listing.Status, listing.Buyer, listing.ClosedAt = "accepted", buyer.Name, timestamp
+	err = putListing(stub, listing)
+	if err != nil {
+		return nil, err
+	}
+	reference := listingType + ":" + listing.ID
+	err = recordTxn(stub, TxnRecord{Type: "transfer", From: seller.Name, To: buyer.Name, Points: listing.OfferPoints, Reference: reference},
+		balanceChange(sellerBefore, seller), balanceChange(buyerBefore, buyer))
+	if err != nil {
+		return nil, err
+	}
+	err = recordTxn(stub, TxnRecord{Type: "transfer", From: buyer.Name, To: seller.Name, Points: listing.WantPoints, Reference: reference},
+		balanceChange(buyerBefore, buyer), balanceChange(sellerBefore, seller))
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end accept listing")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Cancel Listing - the seller withdraws an open listing and the escrowed points are released
+// ============================================================================================================================
+func (t *SimpleChaincode) cancelListing(stub *programStub, args []string) ([]byte, error) {
+	//   0        1
+	// "ID", "Seller"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	listing, err := getListing(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	seller, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if listing.Seller != seller.Name {
+		return nil, errors.New(seller.Name + " did not create listing " + listing.ID)
+	}
+	if listing.Status != "open" {
+		return nil, errors.New("Listing " + listing.ID + " is " + listing.Status)
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	seller.Locked = seller.Locked - listing.OfferPoints
+	givePoints(&seller, listing.OfferKind, listing.OfferPoints)
+	err = putEntity(stub, seller)
+	if err != nil {
+		return nil, err
+	}
+	listing.Status, listing.ClosedAt = "cancelled", timestamp
+	return nil, putListing(stub, listing)
+}
+
+// ============================================================================================================================
+// List Listings - every marketplace listing, or only those with a status such as open
+// ============================================================================================================================
+func (t *SimpleChaincode) listListings(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// "Status"   (optional)
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, listingType, []string{})
+	if err != nil {
+		return nil, errors.New("Failed to get listings")
+	}
+	defer keysIter.Close()
+
+	listings := []Listing{}
+	for keysIter.HasNext() {
+		key, listingAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get listings")
+		}
+		var listing Listing
+		err = unmarshalState(key, listingAsBytes, &listing)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 1 && listing.Status != args[0] {
+			continue
+		}
+		listings = append(listings, listing)
+	}
+	jsonAsBytes, _ := json.Marshal(listings)
+	return jsonAsBytes, nil
+}
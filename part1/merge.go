@@ -0,0 +1,185 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var aliasType = "alias" //composite key object type mapping a merged away name to the entity that absorbed it
+var mergeType = "merge" //composite key object type for merge records, keyed by survivor then merged name
+var maxAliasDepth = 16  //aliases can chain when a survivor is itself merged later
+
+// MergeRecord links the history of a merged away entity to the entity that absorbed it
+type MergeRecord struct {
+	Survivor  string  `json:"survivor"`
+	Merged    string  `json:"merged"`
+	TxnBal    float64 `json:"txnbal"` //balances moved from the merged entity
+	PtBal     float64 `json:"ptbal"`
+	TxID      string  `json:"txid"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// ============================================================================================================================
+// resolveAlias - follow merge aliases to the entity that currently holds a name, the name itself if it is not an alias
+// ============================================================================================================================
+func resolveAlias(stub *programStub, name string) (string, error) {
+	for i := 0; i < maxAliasDepth; i++ {
+		key, err := createCompositeKey(aliasType, []string{name})
+		if err != nil {
+			return "", err
+		}
+		targetAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return "", errors.New("Failed to get alias for " + name)
+		}
+		if targetAsBytes == nil {
+			return name, nil
+		}
+		name = string(targetAsBytes)
+	}
+	return "", errors.New("Alias chain too long for " + name)
+}
+
+// ============================================================================================================================
+// removeFromEntityIndex - drop a name from the list of all entities
+// ============================================================================================================================
+func removeFromEntityIndex(stub *programStub, name string) error {
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return err
+	}
+
+	for i, val := range entityIndex {
+		if val == name {
+			entityIndex = append(entityIndex[:i], entityIndex[i+1:]...)
+			break
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(entityIndex)
+	return putLargeState(stub, entityIndexStr, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Merge Entities - admin only, fold a duplicate account into the surviving one and keep the old name as an alias
+// ============================================================================================================================
+func (t *SimpleChaincode) mergeEntities(stub *programStub, args []string) ([]byte, error) {
+	//     0            1
+	// "Survivor", "Duplicate"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	stub.log.debug("start merge entities")
+	survivor, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	duplicate, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if survivor.Name == duplicate.Name {
+		return nil, errors.New("Cannot merge an entity into itself")
+	}
+	if survivor.Role != duplicate.Role {
+		return nil, errors.New("Cannot merge entities with different roles")
+	}
+	if survivor.Parent != duplicate.Parent {
+		return nil, errors.New("Cannot merge store locations of different merchants")
+	}
+	stores, err := storeNames(stub, duplicate.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(stores) > 0 {
+		return nil, errors.New("Cannot merge " + duplicate.Name + " while it has store locations")
+	}
+	if duplicate.Unvested > 0 {
+		return nil, errors.New("Cannot merge " + duplicate.Name + " while it has points vesting")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	survivorBefore := survivor
+	survivor.TxnBal = survivor.TxnBal + duplicate.TxnBal
+	survivor.PtBal = survivor.PtBal + duplicate.PtBal
+	survivor.Locked = survivor.Locked + duplicate.Locked //the duplicate's gift cards resolve their issuer to the survivor
+	//restricted points stay restricted to their category
+	for _, category := range sortedKeys(duplicate.Restricted) {
+		if survivor.Restricted == nil {
+			survivor.Restricted = map[string]float64{}
+		}
+		survivor.Restricted[category] = survivor.Restricted[category] + duplicate.Restricted[category]
+	}
+	err = putEntity(stub, survivor)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := storedEntity(stub, duplicate.Name)
+	if err != nil {
+		return nil, err
+	}
+	err = entityChanged(stub, stored, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.DelState(duplicate.Name)
+	if err != nil {
+		return nil, errors.New("Failed to delete " + duplicate.Name)
+	}
+	err = removeFromEntityIndex(stub, duplicate.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	aliasKey, err := createCompositeKey(aliasType, []string{duplicate.Name})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(aliasKey, []byte(survivor.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	merge := MergeRecord{survivor.Name, duplicate.Name, duplicate.TxnBal, duplicate.PtBal, stub.UUID, timestamp}
+	mergeKey, err := createCompositeKey(mergeType, []string{survivor.Name, duplicate.Name})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(merge)
+	err = stub.PutState(mergeKey, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	//shows up in both histories so statements still reconcile
+	err = recordTxn(stub, TxnRecord{Type: "merge", From: duplicate.Name, To: survivor.Name, Points: duplicate.PtBal, Amount: duplicate.TxnBal},
+		balanceChange(duplicate, Entity{Name: duplicate.Name}), balanceChange(survivorBefore, survivor))
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end merge entities")
+	return nil, nil
+}
@@ -0,0 +1,132 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var metadataType = "meta"  //composite key object type for entity metadata, keyed by entity then metadata key
+var maxMetadataKeyLen = 64 //metadata is for small attributes, larger documents belong off chain
+var maxMetadataValueLen = 256
+var maxMetadataKeys = 32 //per entity
+
+// ============================================================================================================================
+// getEntityMetadataMap - every metadata attribute of an entity
+// ============================================================================================================================
+func getEntityMetadataMap(stub *programStub, name string) (map[string]string, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, metadataType, []string{name})
+	if err != nil {
+		return nil, errors.New("Failed to get entity metadata")
+	}
+	defer keysIter.Close()
+
+	metadata := map[string]string{}
+	for keysIter.HasNext() {
+		key, valAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get entity metadata")
+		}
+		_, attrs, err := splitCompositeKey(key)
+		if err != nil || len(attrs) != 2 {
+			return nil, stateCorruption(key, errors.New("not an entity metadata key"))
+		}
+		metadata[attrs[1]] = string(valAsBytes)
+	}
+	return metadata, nil
+}
+
+// ============================================================================================================================
+// Set Entity Metadata - attach a small key value attribute to an entity, an empty value removes the attribute
+// ============================================================================================================================
+func (t *SimpleChaincode) setEntityMetadata(stub *programStub, args []string) ([]byte, error) {
+	//   0       1        2
+	// "Name", "Key", "Value"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(args[1]) <= 0 || len(args[1]) > maxMetadataKeyLen {
+		return nil, errors.New("2nd argument must be a non-empty string of at most " + strconv.Itoa(maxMetadataKeyLen) + " bytes")
+	}
+	if len(args[2]) > maxMetadataValueLen {
+		return nil, errors.New("3rd argument must be at most " + strconv.Itoa(maxMetadataValueLen) + " bytes")
+	}
+	key, err := createCompositeKey(metadataType, []string{entity.Name, args[1]})
+	if err != nil {
+		return nil, err
+	}
+
+	if args[2] == "" {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	metadata, err := getEntityMetadataMap(stub, entity.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := metadata[args[1]]; !ok && len(metadata) >= maxMetadataKeys {
+		return nil, errors.New(entity.Name + " already has " + strconv.Itoa(maxMetadataKeys) + " metadata attributes")
+	}
+
+	stub.log.debug("set metadata " + args[1] + " on " + entity.Name)
+	err = stub.PutState(key, []byte(args[2]))
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Entity Metadata - every metadata attribute of an entity, or the value of one key
+// ============================================================================================================================
+func (t *SimpleChaincode) getEntityMetadata(stub *programStub, args []string) ([]byte, error) {
+	//   0       1
+	// "Name", "Key"   (key optional)
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := getEntityMetadataMap(stub, entity.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 2 {
+		val, ok := metadata[args[1]]
+		if !ok {
+			return []byte("null"), nil
+		}
+		jsonAsBytes, _ := json.Marshal(val)
+		return jsonAsBytes, nil
+	}
+	jsonAsBytes, _ := json.Marshal(metadata)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,138 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics live in the chaincode process only: they count what this peer's chaincode container ran since it started,
+// are never written to the ledger and so differ from peer to peer. Function names come from callers, so only the
+// first maxMetricFunctions names get their own counters and the rest share "other".
+var maxMetricFunctions = 512
+var otherMetricFunction = "other"
+
+// FunctionMetrics counts the calls of one function
+type FunctionMetrics struct {
+	Kind        string  `json:"kind"` //invoke or query
+	Function    string  `json:"function"`
+	Invocations int64   `json:"invocations"`
+	Failures    int64   `json:"failures"`
+	TotalMillis float64 `json:"total_ms"`
+	MaxMillis   float64 `json:"max_ms"`
+}
+
+// MetricsReport is the answer to get_metrics
+type MetricsReport struct {
+	Since     int64             `json:"since"` //process start, unix seconds
+	Functions []FunctionMetrics `json:"functions"`
+}
+
+var metrics = struct {
+	sync.Mutex
+	since     time.Time
+	functions map[string]*FunctionMetrics
+}{since: time.Now(), functions: map[string]*FunctionMetrics{}}
+
+// ============================================================================================================================
+// observeCall - count one call of a function, deferred by Invoke and Query with the time the call started
+// ============================================================================================================================
+func observeCall(kind string, function string, start time.Time, err *error) {
+	elapsed := float64(time.Since(start).Nanoseconds()) / 1e6
+	metrics.Lock()
+	defer metrics.Unlock()
+	m, ok := metrics.functions[kind+" "+function]
+	if !ok && len(metrics.functions) >= maxMetricFunctions {
+		function = otherMetricFunction
+		m, ok = metrics.functions[kind+" "+function]
+	}
+	if !ok {
+		m = &FunctionMetrics{Kind: kind, Function: function}
+		metrics.functions[kind+" "+function] = m
+	}
+	m.Invocations++
+	if *err != nil {
+		m.Failures++
+	}
+	m.TotalMillis = m.TotalMillis + elapsed
+	if elapsed > m.MaxMillis {
+		m.MaxMillis = elapsed
+	}
+}
+
+// ============================================================================================================================
+// metricsSnapshot - a copy of every counter, ordered by kind then function
+// ============================================================================================================================
+func metricsSnapshot() MetricsReport {
+	metrics.Lock()
+	defer metrics.Unlock()
+	report := MetricsReport{Since: metrics.since.Unix(), Functions: []FunctionMetrics{}}
+	for _, key := range sortedKeys(metrics.functions) { //keys are the kind, a space, then the function
+		report.Functions = append(report.Functions, *metrics.functions[key])
+	}
+	return report
+}
+
+// ============================================================================================================================
+// Get Metrics - admin only, this peer's call counters since the chaincode started, as JSON or in the Prometheus text
+// exposition format
+// ============================================================================================================================
+func (t *SimpleChaincode) getMetrics(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// *"prometheus"*
+	if len(args) > 1 || (len(args) == 1 && args[0] != "prometheus" && args[0] != "json") {
+		return nil, errors.New("Expecting no argument, json or prometheus")
+	}
+	report := metricsSnapshot()
+	if len(args) == 0 || args[0] == "json" {
+		jsonAsBytes, _ := json.Marshal(report)
+		return jsonAsBytes, nil
+	}
+
+	text := "# TYPE reward_calls_total counter\n"
+	for _, m := range report.Functions {
+		text = text + "reward_calls_total" + metricLabels(m) + " " + strconv.FormatInt(m.Invocations, 10) + "\n"
+	}
+	text = text + "# TYPE reward_failures_total counter\n"
+	for _, m := range report.Functions {
+		text = text + "reward_failures_total" + metricLabels(m) + " " + strconv.FormatInt(m.Failures, 10) + "\n"
+	}
+	text = text + "# TYPE reward_latency_ms_sum counter\n"
+	for _, m := range report.Functions {
+		text = text + "reward_latency_ms_sum" + metricLabels(m) + " " + formatAmount(m.TotalMillis) + "\n"
+	}
+	text = text + "# TYPE reward_latency_ms_max gauge\n"
+	for _, m := range report.Functions {
+		text = text + "reward_latency_ms_max" + metricLabels(m) + " " + formatAmount(m.MaxMillis) + "\n"
+	}
+	jsonAsBytes, _ := json.Marshal(text)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// metricLabels - the Prometheus label set of a function's counters
+// ============================================================================================================================
+func metricLabels(m FunctionMetrics) string {
+	return "{kind=" + strconv.Quote(m.Kind) + ",function=" + strconv.Quote(m.Function) + "}"
+}
@@ -0,0 +1,95 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+// NotifyPrefs tells the off chain notifier which balance changes the entity wants to hear about
+type NotifyPrefs struct {
+	OnReceive  bool     `json:"on_receive"`
+	LowBalance *float64 `json:"low_balance,omitempty"` //notify when the point balance drops below this, nil for never
+}
+
+// NotifyHint is raised with an event when a balance change matches the entity's notification preferences
+type NotifyHint struct {
+	Entity string `json:"entity"`
+	Reason string `json:"reason"` //receive or low_balance
+}
+
+// ============================================================================================================================
+// notifyHints - the hints for every balance change that matches its entity's preferences
+// ============================================================================================================================
+func notifyHints(stub *programStub, balances []BalanceChange) []NotifyHint {
+	var hints []NotifyHint
+	for _, change := range balances {
+		entity, err := getEntity(stub, change.Entity)
+		if err != nil || entity.Name != change.Entity || entity.Notify == nil { //merged away or deleted, nobody to tell
+			continue
+		}
+		prefs := entity.Notify
+		if prefs.OnReceive && change.PtBalAfter > change.PtBalBefore {
+			hints = append(hints, NotifyHint{entity.Name, "receive"})
+		}
+		if prefs.LowBalance != nil && change.PtBalBefore >= *prefs.LowBalance && change.PtBalAfter < *prefs.LowBalance {
+			hints = append(hints, NotifyHint{entity.Name, "low_balance"})
+		}
+	}
+	return hints
+}
+
+// ============================================================================================================================
+// Set Notify Prefs - choose which balance changes to an entity carry a notify hint in their events
+// ============================================================================================================================
+func (t *SimpleChaincode) setNotifyPrefs(stub *programStub, args []string) ([]byte, error) {
+	//    0            1              2
+	// "Name", "true|false", "LowBalance|none"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	onReceive, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return nil, errors.New("2nd argument must be true or false")
+	}
+	var lowBalance *float64
+	if args[2] != "none" {
+		threshold, err := parseAmount(args[2])
+		if (err != nil) || (threshold < 0) {
+			return nil, amountError("3rd argument must be a non-negative numeric string or none", err)
+		}
+		lowBalance = &threshold
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	entity.Notify = &NotifyPrefs{onReceive, lowBalance}
+	if !onReceive && lowBalance == nil {
+		entity.Notify = nil
+	}
+	err = putEntity(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
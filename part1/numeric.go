@@ -0,0 +1,80 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Amounts cross the API as strings in one canonical form: an optional minus sign, digits and optionally a point
+// followed by more digits, e.g. 1250.5. strconv.ParseFloat alone would read "1.000" from a client that meant one
+// thousand and also takes exponents, hex, Inf and NaN, so anything else is refused rather than guessed at.
+var maxAmountLength = 32 //longest amount string accepted
+
+// ============================================================================================================================
+// parseAmount - parse an amount in canonical form, the error explains what is wrong with anything else
+// ============================================================================================================================
+func parseAmount(s string) (float64, error) {
+	if len(s) == 0 || len(s) > maxAmountLength {
+		return 0, errors.New("got \"" + s + "\", expecting a number such as 1250.5")
+	}
+	if strings.ContainsAny(s, ", '") || strings.Count(s, ".") > 1 {
+		return 0, errors.New("got \"" + s + "\", use . as the decimal separator and no digit grouping")
+	}
+	digits := strings.Split(strings.TrimPrefix(s, "-"), ".")
+	if !allDigits(digits[0]) || (len(digits) == 2 && !allDigits(digits[1])) {
+		return 0, errors.New("got \"" + s + "\", expecting a number such as 1250.5")
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// ============================================================================================================================
+// allDigits - whether a string is one or more ASCII digits
+// ============================================================================================================================
+func allDigits(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ============================================================================================================================
+// formatAmount - an amount in the canonical form parseAmount accepts, no exponent and no trailing zeros
+// ============================================================================================================================
+func formatAmount(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
+
+// ============================================================================================================================
+// amountError - an argument error, with what is wrong with the amount's format when it did not parse
+// ============================================================================================================================
+func amountError(msg string, err error) error {
+	if err == nil {
+		return errors.New(msg)
+	}
+	return errors.New(msg + ", " + err.Error())
+}
@@ -0,0 +1,234 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var merchantAppType = "merchantapp"         //composite key object type for merchant applications, keyed by application id
+var merchantAppNameType = "merchantappname" //composite key object type reserving a name while its application is pending
+
+// MerchantApplication is a request to join the program as a merchant, decided by an admin
+type MerchantApplication struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Details   json.RawMessage `json:"details"`   //business details supplied by the applicant
+	Applicant string          `json:"applicant"` //caller fingerprint, bound to the merchant entity on approval
+	Status    string          `json:"status"`    //pending, approved or rejected
+	Reason    string          `json:"reason"`
+	AppliedAt int64           `json:"applied_at"`
+	DecidedAt int64           `json:"decided_at"`
+}
+
+// ============================================================================================================================
+// getMerchantApplication - fetch a merchant application by id
+// ============================================================================================================================
+func getMerchantApplication(stub *programStub, id string) (MerchantApplication, string, error) {
+	var app MerchantApplication
+	key, err := createCompositeKey(merchantAppType, []string{id})
+	if err != nil {
+		return app, "", err
+	}
+	appAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return app, key, errors.New("Failed to get merchant application")
+	}
+	if appAsBytes == nil {
+		return app, key, errors.New("Merchant application " + id + " does not exist")
+	}
+	err = unmarshalState(key, appAsBytes, &app)
+	if err != nil {
+		return app, key, err
+	}
+	return app, key, nil
+}
+
+// ============================================================================================================================
+// Apply Merchant - ask to join as a merchant under a name, the name is reserved until an admin decides
+// ============================================================================================================================
+func (t *SimpleChaincode) applyMerchant(stub *programStub, args []string) ([]byte, error) {
+	//   0          1
+	// "Name", "{...business details json...}"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	if len(args[0]) <= 0 {
+		return nil, errors.New("1st argument must be a non-empty string")
+	}
+	if err := checkEntityName(args[0]); err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(args[1])) {
+		return nil, errors.New("2nd argument must be a JSON document")
+	}
+	if _, err := getEntity(stub, args[0]); err == nil {
+		return nil, errors.New("Entity " + args[0] + " already exists")
+	}
+	nameKey, err := createCompositeKey(merchantAppNameType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	pendingAsBytes, err := stub.GetState(nameKey)
+	if err != nil {
+		return nil, errors.New("Failed to get merchant applications")
+	}
+	if pendingAsBytes != nil {
+		return nil, errors.New("An application for " + args[0] + " is already pending")
+	}
+	applicant, err := callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start apply merchant")
+	app := MerchantApplication{ID: newID(stub, merchantAppType), Name: args[0], Details: json.RawMessage(args[1]), Applicant: applicant, Status: "pending", AppliedAt: timestamp}
+	key, err := createCompositeKey(merchantAppType, []string{app.ID})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(app)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(nameKey, []byte(app.ID))
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end apply merchant")
+	return []byte(app.ID), nil
+}
+
+// ============================================================================================================================
+// decideMerchantApplication - close a pending application and release its name reservation
+// ============================================================================================================================
+func decideMerchantApplication(stub *programStub, id string, status string, reason string) (MerchantApplication, error) {
+	app, key, err := getMerchantApplication(stub, id)
+	if err != nil {
+		return app, err
+	}
+	if app.Status != "pending" {
+		return app, errors.New("Merchant application " + app.ID + " is already " + app.Status)
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return app, err
+	}
+	app.Status = status
+	app.Reason = reason
+	app.DecidedAt = timestamp
+	jsonAsBytes, _ := json.Marshal(app)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return app, err
+	}
+	nameKey, err := createCompositeKey(merchantAppNameType, []string{app.Name})
+	if err != nil {
+		return app, err
+	}
+	return app, stub.DelState(nameKey)
+}
+
+// ============================================================================================================================
+// Approve Merchant - admin only, create the merchant entity of a pending application and bind it to the applicant
+// ============================================================================================================================
+func (t *SimpleChaincode) approveMerchant(stub *programStub, args []string) ([]byte, error) {
+	//       0              1
+	// "ApplicationID", "Note"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	app, err := decideMerchantApplication(stub, args[0], "approved", args[1])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := getEntity(stub, app.Name); err == nil {
+		return nil, errors.New("Entity " + app.Name + " already exists")
+	}
+
+	stub.log.debug("start approve merchant")
+	_, err = t.initEntity(stub, []string{app.Name, merchantRole, "0", "0"})
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.bindIdentity(stub, []string{app.Name, app.Applicant})
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end approve merchant")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Reject Merchant - admin only, turn down a pending application
+// ============================================================================================================================
+func (t *SimpleChaincode) rejectMerchant(stub *programStub, args []string) ([]byte, error) {
+	//       0              1
+	// "ApplicationID", "Reason"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	_, err := decideMerchantApplication(stub, args[0], "rejected", args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// List Merchant Applications - every application, or only those with a status such as pending
+// ============================================================================================================================
+func (t *SimpleChaincode) listMerchantApplications(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// "Status"   (optional)
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, merchantAppType, []string{})
+	if err != nil {
+		return nil, errors.New("Failed to get merchant applications")
+	}
+	defer keysIter.Close()
+
+	apps := []MerchantApplication{}
+	for keysIter.HasNext() {
+		key, appAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get merchant applications")
+		}
+		var app MerchantApplication
+		err = unmarshalState(key, appAsBytes, &app)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 1 && app.Status != args[0] {
+			continue
+		}
+		apps = append(apps, app)
+	}
+	jsonAsBytes, _ := json.Marshal(apps)
+	return jsonAsBytes, nil
+}
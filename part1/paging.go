@@ -0,0 +1,95 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+var maxScanRecords = 2000 //records a heavy query reads in one invocation before it returns what it has with a bookmark
+
+// PagingLimits are program wide page sizes that override what each paginated query has built in. The built in
+// maximum of a query still applies when the configured one is larger.
+type PagingLimits struct {
+	Default int `json:"default"` //page size when the caller gives none, 0 keeps each query's own default
+	Max     int `json:"max"`     //largest page any query returns, 0 keeps each query's own maximum
+}
+
+// ============================================================================================================================
+// pageSize - the page size a paginated query uses, from its argument or the defaults, an error when the argument is
+// not an integer between 1 and the hard maximum
+// ============================================================================================================================
+func pageSize(stub *programStub, arg string, position string, defaultSize int, maxSize int) (int, error) {
+	config, err := getConfig(stub)
+	if err != nil {
+		return 0, err
+	}
+	if config.Paging.Max > 0 && config.Paging.Max < maxSize {
+		maxSize = config.Paging.Max
+	}
+	if config.Paging.Default > 0 {
+		defaultSize = config.Paging.Default
+	}
+	if defaultSize > maxSize {
+		defaultSize = maxSize
+	}
+	if arg == "" {
+		return defaultSize, nil
+	}
+	size, err := strconv.Atoi(arg)
+	if (err != nil) || (size < 1) || (size > maxSize) {
+		return 0, errors.New(position + " argument must be an integer between 1 and " + strconv.Itoa(maxSize))
+	}
+	return size, nil
+}
+
+// ============================================================================================================================
+// Set Paging - admin only, the default page size and the hard maximum of every paginated query, 0 for the built in ones
+// ============================================================================================================================
+func (t *SimpleChaincode) setPaging(stub *programStub, args []string) ([]byte, error) {
+	//     0          1
+	// "Default", "Max"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	defaultSize, err := strconv.Atoi(args[0])
+	if (err != nil) || (defaultSize < 0) {
+		return nil, errors.New("1st argument must be a non-negative integer")
+	}
+	maxSize, err := strconv.Atoi(args[1])
+	if (err != nil) || (maxSize < 0) {
+		return nil, errors.New("2nd argument must be a non-negative integer")
+	}
+	if maxSize > 0 && defaultSize > maxSize {
+		return nil, errors.New("Default page size must not exceed the maximum")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.Paging = PagingLimits{defaultSize, maxSize}
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -24,6 +24,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
@@ -32,7 +33,9 @@ import (
 type SimpleChaincode struct {
 }
 
-var entityIndexStr = "_entityindex" //name for the key/value that will store a list of all known marbles
+var entityIndexStr = systemKeyPrefix + "entityindex" //name for the key/value that will store a list of all known marbles
+var testVarStr = systemKeyPrefix + "abc"             //init's test var, under the system prefix so no entity can collide with it
+var maxReadMany = 100                                //keys one read_many query may ask for
 
 // Entity implementation
 type Entity struct {
@@ -40,6 +43,22 @@ type Entity struct {
 	Role   string  `json:"role"`
 	TxnBal float64 `json:"txnbal"`
 	PtBal  float64 `json:"ptbal"`
+
+	PIIHash   string `json:"piihash,omitempty"` //sha256 of the member's personal data record
+	PIIPurged bool   `json:"piipurged,omitempty"`
+
+	Categories []string           `json:"categories,omitempty"` //merchants only, spending categories it belongs to
+	Parent     string             `json:"parent,omitempty"`     //store locations only, the merchant they settle through
+	Restricted map[string]float64 `json:"restricted,omitempty"` //part of PtBal only spendable at merchants of that category
+	Locked     float64            `json:"locked,omitempty"`     //part of PtBal backing gift cards, not spendable by the entity
+	Unvested   float64            `json:"unvested,omitempty"`   //part of PtBal granted with vesting that has not vested yet
+	Streaks    []PurchaseStreak   `json:"streaks,omitempty"`    //customers only, repeat purchase streaks per merchant
+
+	Notify       *NotifyPrefs `json:"notify,omitempty"`
+	LastActivity int64        `json:"last_activity,omitempty"` //tx timestamp of the last write to the record
+	LastTxID     string       `json:"last_txid,omitempty"`
+	Segments     []string     `json:"segments,omitempty"` //segment labels set by admins, e.g. VIP
+	Version      int64        `json:"version"`            //incremented on every write, for optimistic concurrency
 }
 
 // ============================================================================================================================
@@ -48,18 +67,47 @@ type Entity struct {
 func main() {
 	err := shim.Start(new(SimpleChaincode))
 	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
+		chaincodeLog.error("Error starting Simple chaincode: " + err.Error())
 	}
 }
 
 // Init - reset all the things
 // ============================================================================================================================
-func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Init(chaincodeStub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	stub, err := programStubFor(chaincodeStub) //Init resets the program the deployer belongs to
+	if err != nil {
+		return nil, err
+	}
+	stub.log = newTxLogger(stub, function)
+	_, err = t.initLedger(stub, args)
+	if err != nil {
+		return nil, err
+	}
+	return nil, stub.uow.flush()
+}
+
+// ============================================================================================================================
+// initLedger - seed a fresh program, an initialized one is only reset when an admin passes --force-reset
+// ============================================================================================================================
+func (t *SimpleChaincode) initLedger(stub *programStub, args []string) ([]byte, error) {
 	var Aval int
 	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	if len(args) != 1 && !(len(args) == 2 && args[1] == forceResetArg) {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1, or 2 with " + forceResetArg)
+	}
+	initialized, err := ledgerInitialized(stub)
+	if err != nil {
+		return nil, err
+	}
+	if initialized {
+		if len(args) != 2 {
+			return nil, errors.New("Ledger is already initialized, pass " + forceResetArg + " as an admin to reset it")
+		}
+		if callerAttribute(stub, "role") != adminRole {
+			return nil, errors.New("Only an admin may reset an initialized ledger")
+		}
+		stub.log.info(forceResetArg + ", clearing the entity index")
 	}
 
 	// Initialize the chaincode
@@ -69,93 +117,385 @@ func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args [
 	}
 
 	// Write the state to the ledger
-	err = stub.PutState("abc", []byte(strconv.Itoa(Aval))) //making a test var "abc", I find it handy to read/write to it right away to test the network
+	err = stub.PutState(testVarStr, []byte(strconv.Itoa(Aval))) //making a test var, I find it handy to read/write to it right away to test the network
 	if err != nil {
 		return nil, err
 	}
 
 	var empty []string
 	jsonAsBytes, _ := json.Marshal(empty) //marshal an emtpy array of strings to clear the index
-	err = stub.PutState(entityIndexStr, jsonAsBytes)
+	err = putLargeState(stub, entityIndexStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = putLedgerSchemaVersion(stub)
 	if err != nil {
 		return nil, err
 	}
-
 	return nil, nil
 }
 
 // Invoke a transaction
-func (t *SimpleChaincode) transfer(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *SimpleChaincode) transfer(stub *programStub, args []string) ([]byte, error) {
 	var from, to string
 	if len(args) != 4 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
 	}
 
+	//validate the arguments before touching state
 	from = args[0]
 	to = args[1]
+	txnAmt, err := parseAmount(args[2])
+	if (err != nil) || (txnAmt < 0) {
+		return nil, amountError("3rd argument must be a non-negative numeric string", err)
+	}
+	rdAmt, err := parseAmount(args[3])
+	if (err != nil) || (rdAmt < 0) {
+		return nil, amountError("4th argument must be a non-negative numeric string", err)
+	}
 
-	toAsbytes, err := stub.GetState(to)
+	//then do every read
+	toEntity, err := getEntity(stub, to) //resolves merged aliases to the surviving entity
 	if err != nil {
 		return nil, err
 	}
-
-	fromAsbytes, err := stub.GetState(from)
+	fromEntity, err := getEntity(stub, from)
+	if err != nil {
+		return nil, err
+	}
+	if fromEntity.Name == toEntity.Name {
+		return nil, errors.New("Cannot transfer to the same entity")
+	}
+	err = transferPoints(stub, TransferContext{From: fromEntity, To: toEntity, Points: rdAmt, Amount: txnAmt}, "")
 	if err != nil {
 		return nil, err
 	}
+	return nil, nil
+
+}
 
-	fmt.Println(string(fromAsbytes))
+// ============================================================================================================================
+// transferPoints - run a transfer through the pipeline: the checks, both legs and their record, then the effects. Every
+// function that moves points between two entities on the sender's behalf goes through here.
+// ============================================================================================================================
+func transferPoints(stub *programStub, tc TransferContext, reference string) error {
+	err := runTransferHooks(stub, transferChecks, &tc) //vesting, blacklist, funds, fee, limits and any registered rules
+	if err != nil {
+		return err
+	}
 
-	fromEntity := Entity{}
-	json.Unmarshal(fromAsbytes, &fromEntity)
-	fmt.Println(fromEntity)
+	//and only then write, the unit of work flushes both legs together
+	fromBefore, toBefore := tc.From, tc.To
+	tc.From.TxnBal = tc.From.TxnBal - tc.Amount
+	tc.To.TxnBal = tc.To.TxnBal + tc.Amount
+	tc.From.PtBal = tc.From.PtBal - tc.Points
+	tc.To.PtBal = tc.To.PtBal + tc.Points - tc.Fee
 
-	toEntity := Entity{}
-	json.Unmarshal(toAsbytes, &toEntity)
-	fmt.Println(toEntity)
+	err = putEntity(stub, tc.From)
+	if err != nil {
+		return err
+	}
+	err = putEntity(stub, tc.To)
+	if err != nil {
+		return err
+	}
+	err = recordTxn(stub, TxnRecord{Type: "transfer", From: tc.From.Name, To: tc.To.Name, Points: tc.Points - tc.Fee, Amount: tc.Amount, Reference: reference},
+		balanceChange(fromBefore, tc.From), balanceChange(toBefore, tc.To))
+	if err != nil {
+		return err
+	}
+	return runTransferHooks(stub, transferEffects, &tc)
+}
 
-	txnAmt, err := strconv.ParseFloat(args[2], 64)
+// Invoke implementation
+func (t *SimpleChaincode) Invoke(chaincodeStub *shim.ChaincodeStub, function string, args []string) (result []byte, err error) {
+	defer observeCall("invoke", function, time.Now(), &err)
+	stub, err := programStubFor(chaincodeStub)
 	if err != nil {
+		chaincodeLog.warning("invoke " + function + " refused: " + err.Error())
 		return nil, err
 	}
-	rdAmt, err := strconv.ParseFloat(args[3], 64)
+	stub.log = newTxLogger(stub, function)
+	stub.log.info("invoke is running")
+	err = authorize(stub, function, args)
 	if err != nil {
 		return nil, err
 	}
 
-	fromEntity.TxnBal = fromEntity.TxnBal - txnAmt
-	toEntity.TxnBal = toEntity.TxnBal + txnAmt
-	fmt.Println(fromEntity.PtBal)
-	fmt.Println("x")
-	fromEntity.PtBal = fromEntity.PtBal - rdAmt
-	toEntity.PtBal = toEntity.PtBal + rdAmt
-
-	fmt.Println(fromEntity.PtBal)
-
-	jsonAsBytes, _ := json.Marshal(fromEntity) //save new index
-	err = stub.PutState(fromEntity.Name, jsonAsBytes)
+	payload, err := t.invoke(stub, function, args)
 	if err != nil {
+		stub.log.warning("invoke failed: " + err.Error())
 		return nil, err
 	}
-	jsonAsBytes, _ = json.Marshal(toEntity) //save new index
-	err = stub.PutState(toEntity.Name, jsonAsBytes)
+	err = payBadgeBonuses(stub)
 	if err != nil {
 		return nil, err
 	}
-	return nil, nil
-
+	err = recordAudit(stub, function, args)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.uow.flush() //nothing reaches the ledger unless the whole function succeeded
+	if err != nil {
+		return nil, err
+	}
+	return buildReceipt(stub, function, payload), nil
 }
 
-// Invoke implementation
-func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
-	fmt.Println("invoke is running " + function)
-
+// ============================================================================================================================
+// invoke - run an invoke function against the unit of work
+// ============================================================================================================================
+func (t *SimpleChaincode) invoke(stub *programStub, function string, args []string) ([]byte, error) {
 	if function == "transfer" { //read a variable
 		return t.transfer(stub, args)
 	} else if function == "create_entity" {
 		return t.initEntity(stub, args)
+	} else if function == "attest_balance" {
+		return t.attestBalance(stub, args)
+	} else if function == "earn_points" {
+		return t.earnPoints(stub, args)
+	} else if function == "redeem_points" {
+		return t.redeemPoints(stub, args)
+	} else if function == "set_settlement_chaincode" {
+		return t.setSettlementChaincode(stub, args)
+	} else if function == "set_endorsement_policy" {
+		return t.setEndorsementPolicy(stub, args)
+	} else if function == "set_point_value" {
+		return t.setPointValue(stub, args)
+	} else if function == "set_member_pii" {
+		return t.setMemberPII(stub, args)
+	} else if function == "purge_member_pii" {
+		return t.purgeMemberPII(stub, args)
+	} else if function == "merge_entities" {
+		return t.mergeEntities(stub, args)
+	} else if function == "set_merchant_categories" {
+		return t.setMerchantCategories(stub, args)
+	} else if function == "grant_allowance" {
+		return t.grantAllowance(stub, args)
+	} else if function == "spend_from_allowance" {
+		return t.spendFromAllowance(stub, args)
+	} else if function == "revoke_allowance" {
+		return t.revokeAllowance(stub, args)
+	} else if function == "set_treasury" {
+		return t.setTreasury(stub, args)
+	} else if function == "propose_mint" {
+		return t.proposeMint(stub, args)
+	} else if function == "sign_mint" {
+		return t.signMint(stub, args)
+	} else if function == "mint_points" {
+		return t.mintPoints(stub, args)
+	} else if function == "burn_points" {
+		return t.burnPoints(stub, args)
+	} else if function == "set_max_supply" {
+		return t.setMaxSupply(stub, args)
+	} else if function == "erc20_transfer" {
+		return t.erc20Transfer(stub, args)
+	} else if function == "approve" {
+		return t.approve(stub, args)
+	} else if function == "transferFrom" {
+		return t.transferFrom(stub, args)
+	} else if function == "set_max_daily_earns" {
+		return t.setMaxDailyEarns(stub, args)
+	} else if function == "prune_earn_counters" {
+		return t.pruneEarnCounters(stub, args)
+	} else if function == "add_to_blacklist" {
+		return t.addToBlacklist(stub, args)
+	} else if function == "remove_from_blacklist" {
+		return t.removeFromBlacklist(stub, args)
+	} else if function == "bind_identity" {
+		return t.bindIdentity(stub, args)
+	} else if function == "set_function_policy" {
+		return t.setFunctionPolicy(stub, args)
+	} else if function == "compact_counters" {
+		return t.compactCounters(stub, args)
+	} else if function == "set_transfer_fee" {
+		return t.setTransferFee(stub, args)
+	} else if function == "create_promo_code" {
+		return t.createPromoCode(stub, args)
+	} else if function == "redeem_promo_code" {
+		return t.redeemPromoCode(stub, args)
+	} else if function == "create_gift_card" {
+		return t.createGiftCard(stub, args)
+	} else if function == "transfer_gift_card" {
+		return t.transferGiftCard(stub, args)
+	} else if function == "spend_gift_card" {
+		return t.spendGiftCard(stub, args)
+	} else if function == "open_dispute" {
+		return t.openDispute(stub, args)
+	} else if function == "resolve_dispute" {
+		return t.resolveDispute(stub, args)
+	} else if function == "create_schedule" {
+		return t.createSchedule(stub, args)
+	} else if function == "cancel_schedule" {
+		return t.cancelSchedule(stub, args)
+	} else if function == "process_due_schedules" {
+		return t.processDueSchedules(stub, args)
+	} else if function == "checkpoint_journal" {
+		return t.checkpointJournal(stub, args)
+	} else if function == "register_program" {
+		return t.registerProgram(stub, args)
+	} else if function == "propose_bridge" {
+		return t.proposeBridge(stub, args)
+	} else if function == "sign_bridge" {
+		return t.signBridge(stub, args)
+	} else if function == "bridge_points" {
+		return t.bridgePoints(stub, args)
+	} else if function == "set_entity_metadata" {
+		return t.setEntityMetadata(stub, args)
+	} else if function == "set_external_id" {
+		return t.setExternalID(stub, args)
+	} else if function == "repair_record" {
+		return t.repairRecord(stub, args)
+	} else if function == "set_amount_bounds" {
+		return t.setAmountBounds(stub, args)
+	} else if function == "apply_merchant" {
+		return t.applyMerchant(stub, args)
+	} else if function == "approve_merchant" {
+		return t.approveMerchant(stub, args)
+	} else if function == "reject_merchant" {
+		return t.rejectMerchant(stub, args)
+	} else if function == "request_points" {
+		return t.requestPoints(stub, args)
+	} else if function == "approve_request" {
+		return t.approveRequest(stub, args)
+	} else if function == "decline_request" {
+		return t.declineRequest(stub, args)
+	} else if function == "create_payment_code" {
+		return t.createPaymentCode(stub, args)
+	} else if function == "pay_with_code" {
+		return t.payWithCode(stub, args)
+	} else if function == "publish_item" {
+		return t.publishItem(stub, args)
+	} else if function == "redeem_item" {
+		return t.redeemItem(stub, args)
+	} else if function == "set_reservation_window" {
+		return t.setReservationWindow(stub, args)
+	} else if function == "reserve_item" {
+		return t.reserveItem(stub, args)
+	} else if function == "confirm_redemption" {
+		return t.confirmRedemption(stub, args)
+	} else if function == "release_expired_reservations" {
+		return t.releaseExpiredReservations(stub, args)
+	} else if function == "split_transfer" {
+		return t.splitTransfer(stub, args)
+	} else if function == "distribute_prorata" {
+		return t.distributeProRata(stub, args)
+	} else if function == "set_notify_prefs" {
+		return t.setNotifyPrefs(stub, args)
+	} else if function == "set_balance_alerts" {
+		return t.setBalanceAlerts(stub, args)
+	} else if function == "init_ledger" {
+		return t.initLedger(stub, args)
+	} else if function == "upgrade" {
+		return t.upgrade(stub, args)
+	} else if function == "rebuild_program_stats" {
+		return t.rebuildProgramStats(stub, args)
+	} else if function == "rebuild_role_index" {
+		return t.rebuildRoleIndex(stub, args)
+	} else if function == "rebuild_balance_bands" {
+		return t.rebuildBalanceBands(stub, args)
+	} else if function == "sweep_dormant_accounts" {
+		return t.sweepDormantAccounts(stub, args)
+	} else if function == "tag_segment" {
+		return t.tagSegment(stub, args)
+	} else if function == "untag_segment" {
+		return t.untagSegment(stub, args)
+	} else if function == "grant_segment" {
+		return t.grantSegment(stub, args)
+	} else if function == "adjust_segment" {
+		return t.adjustSegment(stub, args)
+	} else if function == "rebuild_segment_index" {
+		return t.rebuildSegmentIndex(stub, args)
+	} else if function == "set_adjustment_policy" {
+		return t.setAdjustmentPolicy(stub, args)
+	} else if function == "adjust_balance" {
+		return t.adjustBalance(stub, args)
+	} else if function == "preauthorize_redemption" {
+		return t.preauthorizeRedemption(stub, args)
+	} else if function == "capture_redemption" {
+		return t.captureRedemption(stub, args)
+	} else if function == "void_redemption" {
+		return t.voidRedemption(stub, args)
+	} else if function == "expire_preauthorizations" {
+		return t.expirePreauthorizations(stub, args)
+	} else if function == "update_entity" {
+		return t.updateEntity(stub, args)
+	} else if function == "import_entities_csv" {
+		return t.importEntitiesCSV(stub, args)
+	} else if function == "set_rounding_policy" {
+		return t.setRoundingPolicy(stub, args)
+	} else if function == "grant_vesting" {
+		return t.grantVesting(stub, args)
+	} else if function == "vest_points" {
+		return t.vestPoints(stub, args)
+	} else if function == "create_store" {
+		return t.createStore(stub, args)
+	} else if function == "allocate_budget" {
+		return t.allocateBudget(stub, args)
+	} else if function == "reclaim_budget" {
+		return t.reclaimBudget(stub, args)
+	} else if function == "set_feature_flag" {
+		return t.setFeatureFlag(stub, args)
+	} else if function == "anchor_document" {
+		return t.anchorDocument(stub, args)
+	} else if function == "register_public_key" {
+		return t.registerPublicKey(stub, args)
+	} else if function == "rotate_public_key" {
+		return t.rotatePublicKey(stub, args)
+	} else if function == "initiate_recovery" {
+		return t.initiateRecovery(stub, args)
+	} else if function == "complete_recovery" {
+		return t.completeRecovery(stub, args)
+	} else if function == "cancel_recovery" {
+		return t.cancelRecovery(stub, args)
+	} else if function == "set_log_level" {
+		return t.setLogLevel(stub, args)
+	} else if function == "claim_signed_earn" {
+		return t.claimSignedEarn(stub, args)
+	} else if function == "put_earn_rule" {
+		return t.putEarnRule(stub, args)
+	} else if function == "delete_earn_rule" {
+		return t.deleteEarnRule(stub, args)
+	} else if function == "put_achievement" {
+		return t.putAchievement(stub, args)
+	} else if function == "delete_achievement" {
+		return t.deleteAchievement(stub, args)
+	} else if function == "create_listing" {
+		return t.createListing(stub, args)
+	} else if function == "accept_listing" {
+		return t.acceptListing(stub, args)
+	} else if function == "cancel_listing" {
+		return t.cancelListing(stub, args)
+	} else if function == "create_auction" {
+		return t.createAuction(stub, args)
+	} else if function == "place_bid" {
+		return t.placeBid(stub, args)
+	} else if function == "reveal_bid" {
+		return t.revealBid(stub, args)
+	} else if function == "close_auction" {
+		return t.closeAuction(stub, args)
+	} else if function == "create_raffle" {
+		return t.createRaffle(stub, args)
+	} else if function == "enter_raffle" {
+		return t.enterRaffle(stub, args)
+	} else if function == "draw_raffle" {
+		return t.drawRaffle(stub, args)
+	} else if function == "refund_raffle" {
+		return t.refundRaffle(stub, args)
+	} else if function == "rate_redemption" {
+		return t.rateRedemption(stub, args)
+	} else if function == "set_fx_oracle" {
+		return t.setFXOracle(stub, args)
+	} else if function == "post_fx_rate" {
+		return t.postFXRate(stub, args)
+	} else if function == "set_withholding" {
+		return t.setWithholding(stub, args)
+	} else if function == "close_period" {
+		return t.closePeriod(stub, args)
+	} else if function == "set_paging" {
+		return t.setPaging(stub, args)
 	}
-	fmt.Println("invoke did not find func: " + function) //error
+	stub.log.warning("invoke did not find func: " + function)
 
 	return nil, errors.New("Received unknown function query")
 
@@ -163,43 +503,259 @@ func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args
 
 // Query - Our entry point for Queries
 // ============================================================================================================================
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
-	fmt.Println("query is running " + function)
+func (t *SimpleChaincode) Query(chaincodeStub *shim.ChaincodeStub, function string, args []string) (result []byte, err error) {
+	defer observeCall("query", function, time.Now(), &err)
+	stub, err := programStubFor(chaincodeStub)
+	if err != nil {
+		chaincodeLog.warning("query " + function + " refused: " + err.Error())
+		return nil, err
+	}
+	stub.log = newTxLogger(stub, function)
+	stub.readOnly = function
+	stub.log.info("query is running")
+	compress := len(args) > 0 && args[len(args)-1] == compressArg
+	if compress {
+		args = args[:len(args)-1]
+	}
+	err = authorize(stub, function, args)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := t.query(stub, function, args)
+	if err != nil {
+		return nil, err
+	}
+	return buildEnvelope(stub, payload, compress), nil
+}
 
+// ============================================================================================================================
+// query - run a query function, the caller wraps what it returns in an envelope
+// ============================================================================================================================
+func (t *SimpleChaincode) query(stub *programStub, function string, args []string) ([]byte, error) {
 	// Handle different functions
 	if function == "read" { //read a variable
 		return t.read(stub, args)
+	} else if function == "get_endorsement_policy" {
+		return t.getEndorsementPolicy(stub, args)
+	} else if function == "merchant_settlement_report" {
+		return t.merchantSettlementReport(stub, args)
+	} else if function == "get_statement" {
+		return t.getStatement(stub, args)
+	} else if function == "get_member_pii" {
+		return t.getMemberPII(stub, args)
+	} else if function == "get_allowance" {
+		return t.getAllowance(stub, args)
+	} else if function == "get_mint_proposal" {
+		return t.getMintProposal(stub, args)
+	} else if function == "get_supply" {
+		return t.getSupplyQuery(stub, args)
+	} else if function == "balanceOf" {
+		return t.balanceOf(stub, args)
+	} else if function == "allowance" {
+		return t.allowance(stub, args)
+	} else if function == "totalSupply" {
+		return t.totalSupply(stub, args)
+	} else if function == "my_entity" {
+		return t.myEntity(stub, args)
+	} else if function == "get_counter" {
+		return t.getCounter(stub, args)
+	} else if function == "get_promo_code" {
+		return t.getPromoCodeQuery(stub, args)
+	} else if function == "get_gift_card" {
+		return t.getGiftCardQuery(stub, args)
+	} else if function == "list_open_disputes" {
+		return t.listOpenDisputes(stub, args)
+	} else if function == "get_schedule" {
+		return t.getScheduleQuery(stub, args)
+	} else if function == "verify_books" {
+		return t.verifyBooks(stub, args)
+	} else if function == "get_journal_balance" {
+		return t.getJournalBalance(stub, args)
+	} else if function == "list_entities" {
+		return t.listEntities(stub, args)
+	} else if function == "get_program" {
+		return t.getProgramQuery(stub, args)
+	} else if function == "get_bridge_agreement" {
+		return t.getBridgeAgreementQuery(stub, args)
+	} else if function == "get_entity_metadata" {
+		return t.getEntityMetadata(stub, args)
+	} else if function == "find_by_external_id" {
+		return t.findByExternalID(stub, args)
+	} else if function == "ping" {
+		return t.ping(stub, args)
+	} else if function == "version" {
+		return t.version(stub, args)
+	} else if function == "search_transactions" {
+		return t.searchTransactions(stub, args)
+	} else if function == "list_merchant_apps" {
+		return t.listMerchantApplications(stub, args)
+	} else if function == "list_point_requests" {
+		return t.listPointRequests(stub, args)
+	} else if function == "list_audit_log" {
+		return t.listAuditLog(stub, args)
+	} else if function == "simulate_transfer" {
+		return t.simulateTransfer(stub, args)
+	} else if function == "list_catalog" {
+		return t.listCatalog(stub, args)
+	} else if function == "get_distribution" {
+		return t.getDistribution(stub, args)
+	} else if function == "state_checksum" {
+		return t.stateChecksum(stub, args)
+	} else if function == "get_program_stats" {
+		return t.getProgramStats(stub, args)
+	} else if function == "list_entities_by_role" {
+		return t.listEntitiesByRole(stub, args)
+	} else if function == "top_holders" {
+		return t.topHolders(stub, args)
+	} else if function == "list_recovery_cases" {
+		return t.listRecoveryCases(stub, args)
+	} else if function == "get_schema" {
+		return t.getSchema(stub, args)
+	} else if function == "verify_trial_balance" {
+		return t.verifyTrialBalance(stub, args)
+	} else if function == "get_period" {
+		return t.getPeriod(stub, args)
+	} else if function == "list_period_balances" {
+		return t.listPeriodBalances(stub, args)
+	} else if function == "tax_report" {
+		return t.taxReport(stub, args)
+	} else if function == "get_fx_rate" {
+		return t.getFXRateQuery(stub, args)
+	} else if function == "verify_receipt" {
+		return t.verifyReceipt(stub, args)
+	} else if function == "get_merchant_rating" {
+		return t.getMerchantRatingQuery(stub, args)
+	} else if function == "list_ratings" {
+		return t.listRatings(stub, args)
+	} else if function == "get_raffle" {
+		return t.getRaffleQuery(stub, args)
+	} else if function == "list_raffle_entries" {
+		return t.listRaffleEntries(stub, args)
+	} else if function == "get_auction" {
+		return t.getAuctionQuery(stub, args)
+	} else if function == "list_auctions" {
+		return t.listAuctions(stub, args)
+	} else if function == "list_listings" {
+		return t.listListings(stub, args)
+	} else if function == "list_achievements" {
+		return t.listAchievements(stub, args)
+	} else if function == "list_badges" {
+		return t.listBadges(stub, args)
+	} else if function == "get_leaderboard" {
+		return t.getLeaderboard(stub, args)
+	} else if function == "list_earn_rules" {
+		return t.listEarnRules(stub, args)
+	} else if function == "preview_earn_rules" {
+		return t.previewEarnRules(stub, args)
+	} else if function == "get_metrics" {
+		return t.getMetrics(stub, args)
+	} else if function == "list_public_keys" {
+		return t.listPublicKeys(stub, args)
+	} else if function == "verify_signature" {
+		return t.verifySignature(stub, args)
+	} else if function == "list_documents" {
+		return t.listDocuments(stub, args)
+	} else if function == "verify_document" {
+		return t.verifyDocument(stub, args)
+	} else if function == "get_feature_flags" {
+		return t.getFeatureFlags(stub, args)
+	} else if function == "corporate_statement" {
+		return t.corporateStatement(stub, args)
+	} else if function == "list_stores" {
+		return t.listStores(stub, args)
+	} else if function == "list_vesting" {
+		return t.listVesting(stub, args)
+	} else if function == "export_entities_csv" {
+		return t.exportEntitiesCSV(stub, args)
+	} else if function == "read_many" {
+		return t.readMany(stub, args)
+	} else if function == "get_preauthorization" {
+		return t.getPreauthorization(stub, args)
+	} else if function == "list_adjustments" {
+		return t.listAdjustments(stub, args)
+	} else if function == "list_segment" {
+		return t.listSegment(stub, args)
+	} else if function == "list_balance_band" {
+		return t.listBalanceBand(stub, args)
 	}
-	fmt.Println("query did not find func: " + function) //error
+	stub.log.warning("query did not find func: " + function)
 
 	return nil, errors.New("Received unknown function query")
 }
 
 // ============================================================================================================================
-// Read - read a variable from chaincode state
+// Read - read a variable from chaincode state, an entity can be projected to a comma separated list of fields
 // ============================================================================================================================
-func (t *SimpleChaincode) read(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *SimpleChaincode) read(stub *programStub, args []string) ([]byte, error) {
 	var name, jsonResp string
 	var err error
 
-	if len(args) != 1 {
+	//   0          1
+	// "Name", "name,ptbal"   (fields optional)
+	if len(args) != 1 && len(args) != 2 {
 		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
 	}
+	var fields []string
+	if len(args) == 2 {
+		fields, err = parseFields(args[1], Entity{})
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	name = args[0]
+	if isReservedKey(name) { //system records and composite keys such as member PII have their own guarded queries
+		return nil, errors.New(name + " is not an entity")
+	}
 	valAsbytes, err := stub.GetState(name) //get the var from chaincode state
 	if err != nil {
 		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
 		return nil, errors.New(jsonResp)
 	}
+	if valAsbytes == nil { //a merged entity reads as the entity it was merged into
+		canonical, err := resolveAlias(stub, name)
+		if err != nil {
+			return nil, err
+		}
+		if canonical != name {
+			valAsbytes, err = stub.GetState(canonical)
+			if err != nil {
+				return nil, errors.New("Failed to get state for " + canonical)
+			}
+		}
+	}
+	if fields != nil && valAsbytes != nil {
+		return projectFields(valAsbytes, fields)
+	}
 
 	return valAsbytes, nil //send it onward
 }
 
+// ============================================================================================================================
+// Read Many - read a list of vars in one query, returns an object of key to value, null for keys with no value
+// ============================================================================================================================
+func (t *SimpleChaincode) readMany(stub *programStub, args []string) ([]byte, error) {
+	//   0       1
+	// "Name", "Name", ...
+	if len(args) < 1 || len(args) > maxReadMany {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 to " + strconv.Itoa(maxReadMany) + " names")
+	}
+	vals := map[string]json.RawMessage{}
+	for _, name := range args {
+		valAsbytes, err := t.read(stub, []string{name})
+		if err != nil {
+			return nil, err
+		}
+		vals[name] = resultJSON(valAsbytes)
+	}
+	return stableJSON(vals)
+}
+
 // ============================================================================================================================
 // Init Entity - create a new entity, store into chaincode state
 // ============================================================================================================================
-func (t *SimpleChaincode) initEntity(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *SimpleChaincode) initEntity(stub *programStub, args []string) ([]byte, error) {
 	var err error
 
 	//   0       1       2        3
@@ -208,67 +764,238 @@ func (t *SimpleChaincode) initEntity(stub *shim.ChaincodeStub, args []string) ([
 		return nil, errors.New("Incorrect number of arguments. Expecting 5")
 	}
 
-	fmt.Println("- start init entity")
+	stub.log.debug("start init entity")
 	if len(args[0]) <= 0 {
-		fmt.Println("1st argument must be a non-empty string")
+		stub.log.debug("1st argument must be a non-empty string")
 		return nil, errors.New("1st argument must be a non-empty string")
 	}
+	err = checkEntityName(args[0])
+	if err != nil {
+		stub.log.debug(err.Error())
+		return nil, err
+	}
+	canonical, err := resolveAlias(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if canonical != args[0] {
+		stub.log.debug(args[0] + " was merged into " + canonical)
+		return nil, errors.New(args[0] + " was merged into " + canonical)
+	}
 	if len(args[1]) <= 0 {
-		fmt.Println("2nd argument must be a non-empty string")
+		stub.log.debug("2nd argument must be a non-empty string")
 		return nil, errors.New("2nd argument must be a non-empty string")
 	}
+	if args[1] == merchantRole && callerAttribute(stub, "role") != adminRole {
+		stub.log.debug("merchants are onboarded through apply_merchant")
+		return nil, errors.New("Merchants are onboarded through apply_merchant")
+	}
 	if len(args[2]) <= 0 {
-		fmt.Println("3rd argument must be a non-empty string")
+		stub.log.debug("3rd argument must be a non-empty string")
 		return nil, errors.New("3rd argument must be a non-empty string")
 	}
 	if len(args[3]) <= 0 {
-		fmt.Println("4th argument must be a non-empty string")
+		stub.log.debug("4th argument must be a non-empty string")
 		return nil, errors.New("4th argument must be a non-empty string")
 	}
 
-	txnbal, err := strconv.ParseFloat(args[2], 64)
-	fmt.Println(txnbal)
+	txnbal, err := parseAmount(args[2])
 	if (err != nil) || (txnbal < 0) {
-		fmt.Println("3rd argument must be a numeric string")
-		return nil, errors.New("3rd argument must be a numeric string")
+		stub.log.debug("3rd argument must be a numeric string")
+		return nil, amountError("3rd argument must be a numeric string", err)
 	}
 
-	ptbal, err := strconv.ParseFloat(args[3], 64)
+	ptbal, err := parseAmount(args[3])
 	if (err != nil) || (ptbal < 0) {
-		fmt.Println("4th argument must be a numeric string")
-		return nil, errors.New("4th argument must be a numeric string")
+		stub.log.debug("4th argument must be a numeric string")
+		return nil, amountError("4th argument must be a numeric string", err)
+	}
+
+	if (txnbal > 0 || ptbal > 0) && callerAttribute(stub, "role") != adminRole {
+		stub.log.debug("opening balances are set by admins")
+		return nil, errors.New("Only admins may create an entity with opening balances, points are issued by earns and mints")
+	}
+	existing, err := stub.GetState(args[0])
+	if err != nil {
+		return nil, errors.New("Failed to get state for " + args[0])
+	}
+	if existing != nil {
+		stub.log.debug(args[0] + " already exists")
+		return nil, errors.New("Entity " + args[0] + " already exists")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAmount(config.Bounds, ptbal, "4th argument")
+	if err != nil {
+		return nil, err
+	}
+	if ptbal > 0 {
+		err = checkDirectMint(config)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	entitiy := Entity{args[0], args[1], txnbal, ptbal}
-	str, err := json.Marshal(entitiy)
+	entitiy := Entity{Name: args[0], Role: args[1], TxnBal: txnbal, PtBal: ptbal}
+	err = putEntity(stub, entitiy) //store entity with name as key
+	if err != nil {
+		stub.log.error("Writing failed")
+		return nil, err
+	}
+	err = changeSupply(stub, ptbal, "create") //opening balances are new points in circulation
 	if err != nil {
 		return nil, err
 	}
-	// str := `{"name": "` + args[0] + `", "role": "` + args[1] + `", "txnbal": ` + args[2] + `, "ptbal": ` + args[3] + `}`
-	err = stub.PutState(args[0], []byte(str)) //store marble with id as key
+	err = postJournal(stub, "", "create", []JournalLine{{Account: args[0], Credit: ptbal}, {Account: issuanceAccount, Debit: ptbal}})
 	if err != nil {
-		fmt.Println("Writing failed")
 		return nil, err
 	}
 
 	//get the entity index
-	entityAsBytes, err := stub.GetState(entityIndexStr)
+	entityIndex, err := getEntityIndex(stub)
 	if err != nil {
-		fmt.Println("Failed to get entity index")
-		return nil, errors.New("Failed to get entity index")
+		stub.log.error("Failed to get entity index")
+		return nil, err
 	}
-	var entityIndex []string
-	json.Unmarshal(entityAsBytes, &entityIndex) //un stringify it aka JSON.parse()
 
 	//append
 	entityIndex = append(entityIndex, args[0]) //add entity name to index list
-	fmt.Println("! entity index: ", entityIndex)
+	stub.log.debug("entity index: " + fmt.Sprint(entityIndex))
 	jsonAsBytes, _ := json.Marshal(entityIndex)
-	err = stub.PutState(entityIndexStr, jsonAsBytes) //store name of entity
+	err = putLargeState(stub, entityIndexStr, jsonAsBytes) //store name of entity
 	if err != nil {
-		fmt.Println("Failed to write")
+		stub.log.error("Failed to write")
 		return nil, errors.New("Failed to write")
 	}
-	fmt.Println("- end init entity")
+	stub.log.debug("end init entity")
 	return nil, nil
 }
+
+// ============================================================================================================================
+// getEntity - fetch an entity from chaincode state, error if it does not exist
+// ============================================================================================================================
+func getEntity(stub *programStub, name string) (Entity, error) {
+	var entity Entity
+	if isReservedKey(name) {
+		return entity, errors.New(name + " is not an entity")
+	}
+	entityAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return entity, errors.New("Failed to get entity " + name)
+	}
+	if entityAsBytes == nil {
+		canonical, err := resolveAlias(stub, name)
+		if err != nil {
+			return entity, err
+		}
+		if canonical == name {
+			return entity, errors.New("Entity " + name + " does not exist")
+		}
+		return getEntity(stub, canonical)
+	}
+	err = unmarshalState(name, entityAsBytes, &entity)
+	if err != nil {
+		return entity, err
+	}
+	return entity, nil
+}
+
+// ============================================================================================================================
+// putEntity - write an entity back to chaincode state under its name
+// ============================================================================================================================
+func putEntity(stub *programStub, entity Entity) error {
+	prev, err := storedEntity(stub, entity.Name)
+	if err != nil {
+		return err
+	}
+	return writeEntity(stub, prev, entity)
+}
+
+// ============================================================================================================================
+// writeEntity - write an entity over the given previous version of it, nil when there is none or it cannot be decoded,
+// a new entity carries on from whatever version it holds
+// ============================================================================================================================
+func writeEntity(stub *programStub, prev *Entity, entity Entity) error {
+	err := checkBalanceBounds(stub, entity)
+	if err != nil {
+		return err
+	}
+	entity.LastActivity, err = txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	entity.LastTxID = stub.UUID
+	entity.Version = entity.Version + 1
+	if prev != nil {
+		entity.Version = prev.Version + 1
+	}
+	err = entityChanged(stub, prev, &entity)
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(entity)
+	err = stub.PutState(entity.Name, jsonAsBytes)
+	if err != nil {
+		stub.log.error("Failed to write entity " + entity.Name)
+		return err
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// storedEntity - the record currently stored under a name, without following merge aliases, nil if there is none
+// ============================================================================================================================
+func storedEntity(stub *programStub, name string) (*Entity, error) {
+	if isReservedKey(name) {
+		return nil, errors.New(name + " is not an entity")
+	}
+	entityAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return nil, errors.New("Failed to get state for " + name)
+	}
+	if entityAsBytes == nil {
+		return nil, nil
+	}
+	var entity Entity
+	err = unmarshalState(name, entityAsBytes, &entity)
+	if err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
+// ============================================================================================================================
+// entityChanged - keep the maintained counters and indexes in step with a write to an entity, entity nil when deleted
+// ============================================================================================================================
+func entityChanged(stub *programStub, prev *Entity, entity *Entity) error {
+	err := countEntityChange(stub, prev, entity)
+	if err != nil {
+		return err
+	}
+	err = indexEntityRole(stub, prev, entity)
+	if err != nil {
+		return err
+	}
+	err = indexEntityBand(stub, prev, entity)
+	if err != nil {
+		return err
+	}
+	return indexEntitySegments(stub, prev, entity)
+}
+
+// ============================================================================================================================
+// txTimestamp - seconds since epoch of the current transaction, identical on every endorsing peer
+// ============================================================================================================================
+func txTimestamp(stub *programStub) (int64, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	if ts == nil {
+		return 0, errors.New("Transaction timestamp not available")
+	}
+	return ts.Seconds, nil
+}
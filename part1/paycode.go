@@ -0,0 +1,170 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var paymentCodeType = "paycode"           //composite key object type for one time payment codes, keyed by code
+var defaultPaymentCodeTTL = int64(5 * 60) //seconds a payment code can be presented when no ttl is given
+var paymentCodeLength = 10                //hex characters, short enough to type in when a QR scan fails
+
+// PaymentCode is a single use code a customer presents to pay a fixed amount of points to the payee
+type PaymentCode struct {
+	Code      string  `json:"code"`
+	Payee     string  `json:"payee"`
+	Points    float64 `json:"points"`
+	Status    string  `json:"status"` //open, used or expired
+	PaidBy    string  `json:"paid_by"`
+	CreatedAt int64   `json:"created_at"`
+	ExpiresAt int64   `json:"expires_at"`
+}
+
+// ============================================================================================================================
+// paymentCodeFor - derive a payment code from the tx id, every peer endorsing the transaction computes the same code
+// ============================================================================================================================
+func paymentCodeFor(stub *programStub, payee string) string {
+	sum := sha256.Sum256([]byte(stub.UUID + compositeKeyNamespace + payee))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))[:paymentCodeLength]
+}
+
+// ============================================================================================================================
+// Create Payment Code - the payee asks for a fixed amount of points, returns the code to show the customer
+// ============================================================================================================================
+func (t *SimpleChaincode) createPaymentCode(stub *programStub, args []string) ([]byte, error) {
+	//    0          1           2
+	// "Payee", "Points" *"TTLSeconds"*
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	points, err := parseAmount(args[1])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("2nd argument must be a positive numeric string", err)
+	}
+	ttl := defaultPaymentCodeTTL
+	if len(args) == 3 {
+		ttl, err = strconv.ParseInt(args[2], 10, 64)
+		if (err != nil) || (ttl <= 0) {
+			return nil, errors.New("3rd argument must be a positive integer")
+		}
+	}
+	payee, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotBlocked(stub, payee.Name)
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAmount(config.Bounds, points, "2nd argument")
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	code := PaymentCode{Code: paymentCodeFor(stub, payee.Name), Payee: payee.Name, Points: points, Status: "open", CreatedAt: timestamp, ExpiresAt: timestamp + ttl}
+	key, err := createCompositeKey(paymentCodeType, []string{code.Code})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get payment code")
+	}
+	if existing != nil {
+		return nil, errors.New("Payment code already issued in this transaction")
+	}
+	jsonAsBytes, _ := json.Marshal(code)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(code.Code), nil
+}
+
+// ============================================================================================================================
+// Pay With Code - the customer presents a payment code and the points move to the payee like a transfer
+// ============================================================================================================================
+func (t *SimpleChaincode) payWithCode(stub *programStub, args []string) ([]byte, error) {
+	//     0          1
+	// "Customer", "Code"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	customer, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	key, err := createCompositeKey(paymentCodeType, []string{strings.ToUpper(args[1])})
+	if err != nil {
+		return nil, err
+	}
+	codeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get payment code")
+	}
+	if codeAsBytes == nil {
+		return nil, errors.New("Payment code " + args[1] + " does not exist")
+	}
+	var code PaymentCode
+	err = unmarshalState(key, codeAsBytes, &code)
+	if err != nil {
+		return nil, err
+	}
+	if code.Status != "open" {
+		return nil, errors.New("Payment code " + code.Code + " is " + code.Status)
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start pay with code")
+	if timestamp >= code.ExpiresAt {
+		code.Status = "expired"
+	} else {
+		_, err = t.transfer(stub, []string{customer.Name, code.Payee, "0", formatAmount(code.Points)})
+		if err != nil {
+			return nil, err
+		}
+		code.Status = "used"
+		code.PaidBy = customer.Name
+	}
+	jsonAsBytes, _ := json.Marshal(code)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end pay with code")
+	return []byte(code.Status), nil
+}
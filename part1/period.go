@@ -0,0 +1,370 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Finance closes the books one calendar month (UTC) at a time, in order, once the month is over. close_period derives
+// every account's month-end balance from the journal postings, which are keyed by time, so activity since the month
+// ended does not leak into the figures. A closed month is never rewritten: once it is closed no transaction record may
+// be dated inside it, a correction has to be booked in the open period instead.
+var periodType = "period"               //composite key object type for period snapshots, keyed by month
+var periodBalanceType = "periodbalance" //composite key object type for closing balances, keyed by month then account
+var periodClosedStr = systemKeyPrefix + "periodclosed"
+
+func init() {
+	registerTxnHook(TxnHook{"periodclose", periodCloseTxn})
+}
+
+// PeriodSnapshot is the frozen summary of a closed month
+type PeriodSnapshot struct {
+	Period   string         `json:"period"` //YYYY-MM
+	From     int64          `json:"from"`
+	To       int64          `json:"to"` //exclusive
+	Accounts int            `json:"accounts"`
+	Opening  float64        `json:"opening"` //supply in circulation when the month began
+	Closing  float64        `json:"closing"` //supply in circulation when it ended
+	Totals   []TxnTypeTotal `json:"totals"`  //journal entries booked in the month per class, Points is what they moved
+	ClosedBy string         `json:"closed_by"`
+	ClosedAt int64          `json:"closed_at"`
+	TxID     string         `json:"txid"`
+}
+
+// PeriodBalance is one account's balance at the start and the end of a closed month
+type PeriodBalance struct {
+	Account string  `json:"account"`
+	Opening float64 `json:"opening"`
+	Closing float64 `json:"closing"`
+}
+
+// PeriodClosed marks the last closed month, nothing may be dated before Through
+type PeriodClosed struct {
+	Period  string `json:"period"`
+	Through int64  `json:"through"`
+}
+
+// ============================================================================================================================
+// parsePeriod - turn a "2006-01" month into its [from, to) range of unix seconds
+// ============================================================================================================================
+func parsePeriod(period string) (int64, int64, error) {
+	month, err := time.Parse("2006-01", period)
+	if err != nil {
+		return 0, 0, errors.New("Period must be formatted as YYYY-MM")
+	}
+	return month.Unix(), month.AddDate(0, 1, 0).Unix(), nil
+}
+
+// ============================================================================================================================
+// getPeriodClosed - the last closed month, the zero value when no month was closed
+// ============================================================================================================================
+func getPeriodClosed(stub *programStub) (PeriodClosed, error) {
+	var closed PeriodClosed
+	closedAsBytes, err := stub.GetState(periodClosedStr)
+	if err != nil {
+		return closed, errors.New("Failed to get closed period")
+	}
+	if closedAsBytes == nil {
+		return closed, nil
+	}
+	err = unmarshalState(periodClosedStr, closedAsBytes, &closed)
+	return closed, err
+}
+
+// ============================================================================================================================
+// periodCloseTxn - txn hook, refuse a record dated inside a closed month
+// ============================================================================================================================
+func periodCloseTxn(stub *programStub, rec TxnRecord, balances []BalanceChange) error {
+	closed, err := getPeriodClosed(stub)
+	if err != nil {
+		return err
+	}
+	if rec.Timestamp < closed.Through {
+		return errors.New("Period " + closed.Period + " is closed, corrections must be dated in the open period")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// postingsBetween - net change of an account from its journal postings with from <= timestamp < to
+// ============================================================================================================================
+func postingsBetween(stub *programStub, account string, from int64, to int64) (float64, error) {
+	startKey, err := createCompositeKey(postingType, []string{account, timestampKey(from)})
+	if err != nil {
+		return 0, err
+	}
+	endKey, err := createCompositeKey(postingType, []string{account, timestampKey(to)})
+	if err != nil {
+		return 0, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return 0, errors.New("Failed to get journal postings")
+	}
+	defer keysIter.Close()
+
+	var change float64
+	for keysIter.HasNext() {
+		key, changeAsBytes, err := keysIter.Next()
+		if err != nil {
+			return 0, errors.New("Failed to get journal postings")
+		}
+		amount, err := parseStateFloat(key, changeAsBytes)
+		if err != nil {
+			return 0, err
+		}
+		change = change + amount
+	}
+	return change, nil
+}
+
+// ============================================================================================================================
+// periodBalances - the closing balances of a closed month by account
+// ============================================================================================================================
+func periodBalances(stub *programStub, period string) ([]PeriodBalance, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, periodBalanceType, []string{period})
+	if err != nil {
+		return nil, errors.New("Failed to get period balances")
+	}
+	defer keysIter.Close()
+
+	balances := []PeriodBalance{}
+	for keysIter.HasNext() {
+		key, balanceAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get period balances")
+		}
+		var balance PeriodBalance
+		err = unmarshalState(key, balanceAsBytes, &balance)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+	return balances, nil
+}
+
+// ============================================================================================================================
+// getPeriodBalance - one account's balances for a closed month, false if the month did not snapshot the account
+// ============================================================================================================================
+func getPeriodBalance(stub *programStub, period string, account string) (PeriodBalance, bool, error) {
+	var balance PeriodBalance
+	key, err := createCompositeKey(periodBalanceType, []string{period, account})
+	if err != nil {
+		return balance, false, err
+	}
+	balanceAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return balance, false, errors.New("Failed to get period balance")
+	}
+	if balanceAsBytes == nil {
+		return balance, false, nil
+	}
+	err = unmarshalState(key, balanceAsBytes, &balance)
+	return balance, err == nil, err
+}
+
+// ============================================================================================================================
+// periodTotals - journal entries booked with from <= timestamp < to, per class
+// ============================================================================================================================
+func periodTotals(stub *programStub, from int64, to int64) ([]TxnTypeTotal, error) {
+	startKey, err := createCompositeKey(journalType, []string{timestampKey(from)})
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(journalType, []string{timestampKey(to)})
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, errors.New("Failed to get journal entries")
+	}
+	defer keysIter.Close()
+
+	totals := map[TxnClass]*TxnTypeTotal{}
+	for keysIter.HasNext() {
+		key, entryAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get journal entries")
+		}
+		var entry JournalEntry
+		err = unmarshalState(key, entryAsBytes, &entry)
+		if err != nil {
+			return nil, err
+		}
+		total, ok := totals[entry.Class]
+		if !ok {
+			total = &TxnTypeTotal{Class: entry.Class}
+			totals[entry.Class] = total
+		}
+		total.Count++
+		for _, line := range entry.Lines {
+			total.Points = total.Points + line.Debit
+		}
+	}
+	result := []TxnTypeTotal{}
+	for _, class := range txnClassOrder {
+		if total, ok := totals[class]; ok {
+			result = append(result, *total)
+		}
+	}
+	return result, nil
+}
+
+// ============================================================================================================================
+// Close Period - admin only, freeze the closing balances and totals of a month that has ended, months close in order
+// ============================================================================================================================
+func (t *SimpleChaincode) closePeriod(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// "2016-07"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	from, to, err := parsePeriod(args[0])
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now < to {
+		return nil, errors.New("Period " + args[0] + " has not ended")
+	}
+	closed, err := getPeriodClosed(stub)
+	if err != nil {
+		return nil, err
+	}
+	if closed.Through != 0 && from != closed.Through {
+		return nil, errors.New("Periods close in order, the next one to close follows " + closed.Period)
+	}
+	actor, err := callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	opening := map[string]float64{}
+	if closed.Through != 0 {
+		prev, err := periodBalances(stub, closed.Period)
+		if err != nil {
+			return nil, err
+		}
+		for _, balance := range prev {
+			opening[balance.Account] = balance.Closing
+		}
+	}
+	accounts, err := journalAccounts(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start close period " + args[0])
+	snapshot := PeriodSnapshot{Period: args[0], From: from, To: to, ClosedBy: actor, ClosedAt: now, TxID: stub.UUID}
+	for _, account := range accounts {
+		balance := PeriodBalance{Account: account}
+		if closed.Through != 0 {
+			balance.Opening = opening[account]
+		} else {
+			balance.Opening, err = postingsBetween(stub, account, 0, from)
+			if err != nil {
+				return nil, err
+			}
+		}
+		change, err := postingsBetween(stub, account, from, to)
+		if err != nil {
+			return nil, err
+		}
+		balance.Closing = balance.Opening + change
+		if account == issuanceAccount { //issuance is minus the supply
+			snapshot.Opening, snapshot.Closing = 0-balance.Opening, 0-balance.Closing
+		}
+		key, err := createCompositeKey(periodBalanceType, []string{args[0], account})
+		if err != nil {
+			return nil, err
+		}
+		jsonAsBytes, _ := json.Marshal(balance)
+		err = stub.PutState(key, jsonAsBytes)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Accounts++
+	}
+	snapshot.Totals, err = periodTotals(stub, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := createCompositeKey(periodType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(snapshot)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ = json.Marshal(PeriodClosed{args[0], to})
+	err = stub.PutState(periodClosedStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end close period " + args[0] + ", " + strconv.Itoa(snapshot.Accounts) + " accounts")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Period - the snapshot of a closed month
+// ============================================================================================================================
+func (t *SimpleChaincode) getPeriod(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting the period to query")
+	}
+	key, err := createCompositeKey(periodType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	snapshotAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get period")
+	}
+	if snapshotAsBytes == nil {
+		return nil, errors.New("Period " + args[0] + " is not closed")
+	}
+	return snapshotAsBytes, nil
+}
+
+// ============================================================================================================================
+// List Period Balances - every account's opening and closing balance of a closed month, in account order
+// ============================================================================================================================
+func (t *SimpleChaincode) listPeriodBalances(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting the period to query")
+	}
+	balances, err := periodBalances(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(balances)
+	return jsonAsBytes, nil
+}
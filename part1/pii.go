@@ -0,0 +1,123 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// The v0.5 shim has no private data collections, so member PII lives in a separate world state record that can be
+// deleted without touching the entity. Deleting it removes it from world state only; the transaction that wrote it is
+// still in block history, so deployments that must honour erasure requests should submit PII with confidentiality
+// enabled or keep it off chain and store only the hash here.
+var piiRecordType = "pii" //composite key object type for member personal data
+
+// ============================================================================================================================
+// Set Member PII - store a member's personal data apart from the entity and anchor its hash on the entity
+// ============================================================================================================================
+func (t *SimpleChaincode) setMemberPII(stub *programStub, args []string) ([]byte, error) {
+	//    0         1
+	// "Name", "{...json...}"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(args[1])) {
+		return nil, errors.New("2nd argument must be a JSON document")
+	}
+
+	key, err := createCompositeKey(piiRecordType, []string{entity.Name})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, []byte(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(args[1]))
+	entity.PIIHash = hex.EncodeToString(sum[:])
+	entity.PIIPurged = false
+	err = putEntity(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Member PII - return a member's personal data, null once purged
+// ============================================================================================================================
+func (t *SimpleChaincode) getMemberPII(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the entity to query")
+	}
+	key, err := createCompositeKey(piiRecordType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	piiAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get member data")
+	}
+	if piiAsBytes == nil {
+		return []byte("null"), nil
+	}
+	return piiAsBytes, nil
+}
+
+// ============================================================================================================================
+// Purge Member PII - admin only, erase a member's personal data while keeping balances and the anchored hash
+// ============================================================================================================================
+func (t *SimpleChaincode) purgeMemberPII(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// "Name"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start purge member pii")
+	key, err := createCompositeKey(piiRecordType, []string{entity.Name})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return nil, errors.New("Failed to purge member data")
+	}
+
+	entity.PIIPurged = true
+	err = putEntity(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end purge member pii")
+	return nil, nil
+}
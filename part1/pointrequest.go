@@ -0,0 +1,282 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var pointRequestType = "pointrequest"         //composite key object type for merchant point requests, keyed by request id
+var pointRequestOpenType = "pointrequestopen" //composite key object type indexing open requests by customer then id
+var defaultPointRequestTTL = int64(15 * 60)   //seconds a checkout request stays payable when no ttl is given
+
+// PointRequest is a merchant asking a customer for points, paid only when the customer approves it
+type PointRequest struct {
+	ID        string  `json:"id"`
+	Merchant  string  `json:"merchant"`
+	Customer  string  `json:"customer"`
+	Points    float64 `json:"points"`
+	Memo      string  `json:"memo"`
+	Status    string  `json:"status"` //open, approved, declined or expired
+	CreatedAt int64   `json:"created_at"`
+	ExpiresAt int64   `json:"expires_at"`
+}
+
+// ============================================================================================================================
+// getPointRequest - fetch a point request by id
+// ============================================================================================================================
+func getPointRequest(stub *programStub, id string) (PointRequest, string, error) {
+	var req PointRequest
+	key, err := createCompositeKey(pointRequestType, []string{id})
+	if err != nil {
+		return req, "", err
+	}
+	reqAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return req, key, errors.New("Failed to get point request")
+	}
+	if reqAsBytes == nil {
+		return req, key, errors.New("Point request " + id + " does not exist")
+	}
+	err = unmarshalState(key, reqAsBytes, &req)
+	if err != nil {
+		return req, key, err
+	}
+	return req, key, nil
+}
+
+// ============================================================================================================================
+// closePointRequest - store the final status of a request and drop it from the customer's open requests
+// ============================================================================================================================
+func closePointRequest(stub *programStub, req PointRequest, key string, status string) error {
+	req.Status = status
+	jsonAsBytes, _ := json.Marshal(req)
+	err := stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	openKey, err := createCompositeKey(pointRequestOpenType, []string{req.Customer, req.ID})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(openKey)
+}
+
+// ============================================================================================================================
+// Request Points - a merchant asks a customer for points at checkout, returns the request id
+// ============================================================================================================================
+func (t *SimpleChaincode) requestPoints(stub *programStub, args []string) ([]byte, error) {
+	//     0           1          2           3            4
+	// "Merchant", "Customer", "Points" *"TTLSeconds"* *"Memo"*
+	if len(args) < 3 || len(args) > 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 to 5")
+	}
+	points, err := parseAmount(args[2])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+	ttl := defaultPointRequestTTL
+	if len(args) >= 4 {
+		ttl, err = strconv.ParseInt(args[3], 10, 64)
+		if (err != nil) || (ttl <= 0) {
+			return nil, errors.New("4th argument must be a positive integer")
+		}
+	}
+	memo := ""
+	if len(args) == 5 {
+		memo = args[4]
+	}
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if merchant.Role != merchantRole {
+		return nil, errors.New(merchant.Name + " is not a merchant")
+	}
+	customer, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if customer.Name == merchant.Name {
+		return nil, errors.New("Cannot request points from the same entity")
+	}
+	err = checkNotBlocked(stub, merchant.Name, customer.Name)
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAmount(config.Bounds, points, "3rd argument")
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start request points")
+	req := PointRequest{newID(stub, pointRequestType), merchant.Name, customer.Name, points, memo, "open", timestamp, timestamp + ttl}
+	key, err := createCompositeKey(pointRequestType, []string{req.ID})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(req)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	openKey, err := createCompositeKey(pointRequestOpenType, []string{req.Customer, req.ID})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(openKey, []byte{0x00})
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end request points")
+	return []byte(req.ID), nil
+}
+
+// ============================================================================================================================
+// openPointRequest - fetch a request that is addressed to the customer and still open
+// ============================================================================================================================
+func openPointRequest(stub *programStub, customer string, id string) (PointRequest, string, error) {
+	req, key, err := getPointRequest(stub, id)
+	if err != nil {
+		return req, key, err
+	}
+	if req.Customer != customer {
+		return req, key, errors.New("Point request " + req.ID + " is not addressed to " + customer)
+	}
+	if req.Status != "open" {
+		return req, key, errors.New("Point request " + req.ID + " is " + req.Status)
+	}
+	return req, key, nil
+}
+
+// ============================================================================================================================
+// Approve Request - the customer pays an open point request, the points move to the merchant like a transfer
+// ============================================================================================================================
+func (t *SimpleChaincode) approveRequest(stub *programStub, args []string) ([]byte, error) {
+	//     0           1
+	// "Customer", "RequestID"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	customer, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	req, key, err := openPointRequest(stub, customer.Name, args[1])
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp >= req.ExpiresAt {
+		err = closePointRequest(stub, req, key, "expired")
+		if err != nil {
+			return nil, err
+		}
+		return []byte("expired"), nil
+	}
+
+	stub.log.debug("start approve request")
+	_, err = t.transfer(stub, []string{req.Customer, req.Merchant, "0", formatAmount(req.Points)})
+	if err != nil {
+		return nil, err
+	}
+	err = closePointRequest(stub, req, key, "approved")
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end approve request")
+	return []byte("approved"), nil
+}
+
+// ============================================================================================================================
+// Decline Request - the customer turns down an open point request
+// ============================================================================================================================
+func (t *SimpleChaincode) declineRequest(stub *programStub, args []string) ([]byte, error) {
+	//     0           1
+	// "Customer", "RequestID"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	customer, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	req, key, err := openPointRequest(stub, customer.Name, args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = closePointRequest(stub, req, key, "declined")
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// List Point Requests - a customer's open requests, expired ones are listed until the customer acts on them
+// ============================================================================================================================
+func (t *SimpleChaincode) listPointRequests(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// "Customer"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	customer, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, pointRequestOpenType, []string{customer.Name})
+	if err != nil {
+		return nil, errors.New("Failed to get point requests")
+	}
+	defer keysIter.Close()
+
+	reqs := []PointRequest{}
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get point requests")
+		}
+		_, keyParts, err := splitCompositeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		req, _, err := getPointRequest(stub, keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	jsonAsBytes, _ := json.Marshal(reqs)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,221 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+)
+
+var merchantRole = "merchant" //entity role allowed to accept redemptions
+
+// ============================================================================================================================
+// Redeem Points - customer spends points at a merchant, fiat settlement is recorded by the settlement chaincode
+// ============================================================================================================================
+func (t *SimpleChaincode) redeemPoints(stub *programStub, args []string) ([]byte, error) {
+	//    0           1          2          3
+	// "Customer", "Merchant", "Points", "ReceiptID"
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	}
+	if len(args[3]) <= 0 {
+		return nil, errors.New("4th argument must be a non-empty string")
+	}
+
+	stub.log.debug("start redeem points")
+	amount, err := parseAmount(args[2])
+	if (err != nil) || (amount <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+
+	customer, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, err = releaseVested(stub, &customer)
+	if err != nil {
+		return nil, err
+	}
+	merchant, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if merchant.Role != merchantRole {
+		return nil, errors.New(merchant.Name + " is not a merchant")
+	}
+	if customer.Name == merchant.Name {
+		return nil, errors.New("A merchant cannot redeem points at itself")
+	}
+	err = checkNotBlocked(stub, customer.Name, merchant.Name)
+	if err != nil {
+		return nil, err
+	}
+	customerBefore, merchantBefore := customer, merchant
+	err = spendAtMerchant(&customer, merchant, amount)
+	if err != nil {
+		return nil, err
+	}
+	merchant.PtBal = merchant.PtBal + amount
+
+	err = putEntity(stub, customer)
+	if err != nil {
+		return nil, err
+	}
+	err = putEntity(stub, merchant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordTxn(stub, TxnRecord{Type: "redeem", From: customer.Name, To: merchant.Name, Points: amount, Reference: args[3]},
+		balanceChange(customerBefore, customer), balanceChange(merchantBefore, merchant))
+	if err != nil {
+		return nil, err
+	}
+
+	//settlement runs in this same transaction, if it fails the point deduction is discarded with it
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	if config.SettlementChaincode != "" {
+		_, err = stub.InvokeChaincode(config.SettlementChaincode, "record_settlement", []string{settlementMerchant(merchant), args[2], args[3]})
+		if err != nil {
+			stub.log.error("Settlement failed")
+			return nil, errors.New("Settlement failed: " + err.Error())
+		}
+	}
+
+	stub.log.debug("end redeem points")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Earn Points - merchant issues points to a customer for a purchase, plus whatever the matching earn rules add, the
+// points may be 0 when the rules alone decide the award
+// ============================================================================================================================
+func (t *SimpleChaincode) earnPoints(stub *programStub, args []string) ([]byte, error) {
+	//    0           1          2          3            4            5              6
+	// "Merchant", "Customer", "Points", "Purchase", "ReceiptID" *"Category"* *"ReceiptHash"*   (purchase may be "25.00 EUR")
+	if len(args) < 5 || len(args) > 7 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5 to 7")
+	}
+
+	stub.log.debug("start earn points")
+	points, err := parseAmount(args[2])
+	if (err != nil) || (points < 0) {
+		return nil, amountError("3rd argument must be a non-negative numeric string", err)
+	}
+	purchase, fx, err := convertPurchase(stub, args[3])
+	if err != nil {
+		return nil, err
+	}
+	receiptHash := ""
+	if len(args) == 7 {
+		receiptHash = args[6]
+	}
+	err = checkReceiptHash(stub, receiptHash, purchase)
+	if err != nil {
+		return nil, err
+	}
+
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if merchant.Role != merchantRole {
+		return nil, errors.New(merchant.Name + " is not a merchant")
+	}
+	customer, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotBlocked(stub, merchant.Name, customer.Name)
+	if err != nil {
+		return nil, err
+	}
+	err = checkEarnRate(stub, merchant.Name, customer.Name)
+	if err != nil {
+		return nil, err
+	}
+	var streak PurchaseStreak
+	if purchase > 0 { //only earns for a purchase count as a repeat purchase
+		timestamp, err := txTimestamp(stub)
+		if err != nil {
+			return nil, err
+		}
+		streak = nextStreak(customer, merchant.Name, timestamp)
+	}
+	rules, err := evalEarnRules(stub, merchant, customer.Name, streak, purchase)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules.Applied) > 0 {
+		stub.log.debug("earn rules add " + formatAmount(rules.Points) + " points")
+		points = points + rules.Points
+		err = chargeEarnRules(stub, customer.Name, rules)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if points <= 0 {
+		return nil, errors.New("No points to earn, the 3rd argument is 0 and no earn rule added any")
+	}
+
+	//issued points are a merchant liability settled in cash, they are not taken from the merchant's point balance
+	before := customer
+	customer.PtBal = customer.PtBal + points
+	if purchase > 0 {
+		recordStreak(&customer, streak)
+	}
+	if len(args) >= 6 && len(args[5]) > 0 { //restricted to merchants of this category
+		if customer.Restricted == nil {
+			customer.Restricted = map[string]float64{}
+		}
+		customer.Restricted[args[5]] = customer.Restricted[args[5]] + points
+	}
+	err = putEntity(stub, customer)
+	if err != nil {
+		return nil, err
+	}
+
+	category := ""
+	if len(args) >= 6 {
+		category = args[5]
+	}
+	err = recordTxn(stub, TxnRecord{Type: "earn", From: merchant.Name, To: customer.Name, Points: points, Amount: purchase, Reference: args[4],
+		ReceiptHash: receiptHash, FX: fx, Category: category},
+		balanceChange(before, customer))
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(stub, points, "earn")
+	if err != nil {
+		return nil, err
+	}
+	err = withholdEarn(stub, customer.Name, points, category)
+	if err != nil {
+		return nil, err
+	}
+	err = countIssued(stub, merchant.Name, points)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end earn points")
+	return nil, nil
+}
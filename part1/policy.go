@@ -0,0 +1,308 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var policyType = "policy" //composite key object type for function policies set by admins, keyed by function name
+
+// FunctionPolicy maps the caller's certificate attributes to permission to run a function
+type FunctionPolicy struct {
+	Roles     []string `json:"roles"`      //values of the "role" attribute allowed to call, empty allows any role
+	EntityArg int      `json:"entity_arg"` //argument non admins must hold as their own entity ("entityName"), -1 for none
+}
+
+var adminOnly = FunctionPolicy{Roles: []string{adminRole}, EntityArg: -1}
+var anyCaller = FunctionPolicy{EntityArg: -1} //the function itself only acts for the caller's own entity, or checks who may
+
+// defaultPolicies apply until an admin stores an override, functions not listed here are open to every caller, so every
+// invoke is listed even when anyCaller is all it needs
+var defaultPolicies = map[string]FunctionPolicy{
+	"set_settlement_chaincode": adminOnly,
+	"set_point_value":          adminOnly,
+	"set_endorsement_policy":   adminOnly,
+	"purge_member_pii":         adminOnly,
+	"merge_entities":           adminOnly,
+	"set_merchant_categories":  adminOnly,
+	"set_treasury":             adminOnly,
+	"propose_mint":             adminOnly,
+	"sign_mint":                adminOnly,
+	"mint_points":              adminOnly,
+	"burn_points":              adminOnly,
+	"set_max_supply":           adminOnly,
+	"set_max_daily_earns":      adminOnly,
+	"prune_earn_counters":      adminOnly,
+	"add_to_blacklist":         adminOnly,
+	"remove_from_blacklist":    adminOnly,
+	"bind_identity":            adminOnly,
+	"set_function_policy":      adminOnly,
+	"compact_counters":         adminOnly,
+	"set_transfer_fee":         adminOnly,
+	"state_checksum":           adminOnly,
+	"earn_points":              {Roles: []string{merchantRole, adminRole}, EntityArg: 0},
+	"redeem_points":            {EntityArg: 0},
+	"grant_allowance":          {EntityArg: 0},
+	"revoke_allowance":         {EntityArg: 0},
+	"spend_from_allowance":     {EntityArg: 1},
+	"set_member_pii":           {EntityArg: 0},
+	"get_member_pii":           {EntityArg: 0},
+	"create_promo_code":        {Roles: []string{merchantRole, adminRole}, EntityArg: 1},
+	"redeem_promo_code":        {EntityArg: 1},
+	"create_gift_card":         {EntityArg: 0},
+	"transfer_gift_card":       {EntityArg: 1},
+	"spend_gift_card":          {EntityArg: 1},
+	"open_dispute":             {EntityArg: 1},
+	"resolve_dispute":          adminOnly,
+	"create_schedule":          adminOnly,
+	"cancel_schedule":          adminOnly,
+	"process_due_schedules":    {Roles: []string{schedulerRole, adminRole}, EntityArg: -1},
+	"checkpoint_journal":       adminOnly,
+	"register_program":         adminOnly,
+	"propose_bridge":           adminOnly,
+	"sign_bridge":              adminOnly,
+	"bridge_points":            {EntityArg: 0},
+	"repair_record":            adminOnly,
+	"set_amount_bounds":        adminOnly,
+	"search_transactions":      {EntityArg: 0},
+	"approve_merchant":         adminOnly,
+	"reject_merchant":          adminOnly,
+	"list_merchant_apps":       adminOnly,
+	"request_points":           {Roles: []string{merchantRole, adminRole}, EntityArg: 0},
+	"approve_request":          {EntityArg: 0},
+	"decline_request":          {EntityArg: 0},
+	"list_point_requests":      {EntityArg: 0},
+	"create_payment_code":      {EntityArg: 0},
+	"pay_with_code":            {EntityArg: 0},
+	"list_audit_log":           adminOnly,
+	"publish_item":             {Roles: []string{merchantRole, adminRole}, EntityArg: 0},
+	"redeem_item":              {EntityArg: 0},
+	"set_reservation_window":   adminOnly,
+	"reserve_item":             {EntityArg: 0},
+	"confirm_redemption":       {EntityArg: 0},
+	"split_transfer":           {EntityArg: 0},
+	"distribute_prorata":       {EntityArg: 0},
+	"set_notify_prefs":         {EntityArg: 0},
+	"set_balance_alerts":       adminOnly,
+	"init_ledger":              adminOnly,
+	"upgrade":                  adminOnly,
+	"rebuild_program_stats":    adminOnly,
+	"rebuild_role_index":       adminOnly,
+	"rebuild_balance_bands":    adminOnly,
+	"sweep_dormant_accounts":   adminOnly,
+	"tag_segment":              adminOnly,
+	"untag_segment":            adminOnly,
+	"list_segment":             adminOnly,
+	"grant_segment":            adminOnly,
+	"adjust_segment":           adminOnly,
+	"rebuild_segment_index":    adminOnly,
+	"set_adjustment_policy":    adminOnly,
+	"adjust_balance":           adminOnly,
+	"list_adjustments":         adminOnly,
+	"preauthorize_redemption":  {EntityArg: 0},
+	"capture_redemption":       {Roles: []string{merchantRole, adminRole}, EntityArg: 0},
+	"void_redemption":          {EntityArg: 0},
+	"update_entity":            adminOnly,
+	"import_entities_csv":      adminOnly,
+	"export_entities_csv":      adminOnly,
+	"set_rounding_policy":      adminOnly,
+	"grant_vesting":            adminOnly,
+	"vest_points":              {EntityArg: 0},
+	"create_store":             {Roles: []string{merchantRole, adminRole}, EntityArg: 0},
+	"allocate_budget":          {Roles: []string{corporateRole, adminRole}, EntityArg: 0},
+	"reclaim_budget":           {Roles: []string{corporateRole, adminRole}, EntityArg: 0},
+	"corporate_statement":      {Roles: []string{corporateRole, adminRole}, EntityArg: 0},
+	"set_feature_flag":         adminOnly,
+	"anchor_document":          {EntityArg: 0},
+	"list_documents":           {EntityArg: 0},
+	"verify_document":          {EntityArg: 0},
+	"register_public_key":      {EntityArg: 0},
+	"rotate_public_key":        {EntityArg: 0},
+	"initiate_recovery":        adminOnly,
+	"complete_recovery":        adminOnly,
+	"cancel_recovery":          adminOnly,
+	"list_recovery_cases":      adminOnly,
+	"set_log_level":            adminOnly,
+	"get_metrics":              adminOnly,
+	"claim_signed_earn":        {EntityArg: 1},
+	"put_earn_rule":            adminOnly,
+	"delete_earn_rule":         adminOnly,
+	"preview_earn_rules":       {Roles: []string{merchantRole, adminRole}, EntityArg: 0},
+	"put_achievement":          adminOnly,
+	"delete_achievement":       adminOnly,
+	"list_badges":              {EntityArg: 0},
+	"create_listing":           {EntityArg: 0},
+	"accept_listing":           {EntityArg: 1},
+	"cancel_listing":           {EntityArg: 1},
+	"create_auction":           {Roles: []string{merchantRole, adminRole}, EntityArg: 0},
+	"place_bid":                {EntityArg: 1},
+	"reveal_bid":               {EntityArg: 1},
+	"create_raffle":            {Roles: []string{merchantRole, adminRole}, EntityArg: 0},
+	"enter_raffle":             {EntityArg: 1},
+	"draw_raffle":              adminOnly,
+	"refund_raffle":            anyCaller, //only ever pays entrants back, and only once the draw was missed
+	"rate_redemption":          {EntityArg: 1},
+	"set_fx_oracle":            adminOnly,
+	"set_withholding":          adminOnly,
+	"tax_report":               adminOnly,
+	"close_period":             adminOnly,
+	"set_paging":               adminOnly,
+	"set_entity_metadata":      {EntityArg: 0},
+	"get_entity_metadata":      {EntityArg: 0},
+	"set_external_id":          {EntityArg: 0},
+	"find_by_external_id":      {Roles: []string{merchantRole, adminRole}, EntityArg: -1},
+	"transfer":                 {EntityArg: 0},
+	"erc20_transfer":           anyCaller,
+	"approve":                  anyCaller,
+	"transferFrom":             anyCaller,
+	"create_entity":            anyCaller, //opening balances and merchants are admin only, initEntity checks
+	"apply_merchant":           anyCaller,
+	"attest_balance":           {EntityArg: 0},
+	"close_auction":            {Roles: []string{merchantRole, adminRole}, EntityArg: -1}, //closeAuction checks the merchant
+	"post_fx_rate":             {Roles: []string{oracleRole, adminRole}, EntityArg: -1},
+
+	//queries over one entity's history, open to the entity itself (or its merchant) and admins
+	"get_statement":              {EntityArg: 0},
+	"list_vesting":               {EntityArg: 0},
+	"merchant_settlement_report": {Roles: []string{merchantRole, adminRole}, EntityArg: 0},
+
+	//housekeeping sweeps run by the off-chain cron identity
+	"release_expired_reservations": {Roles: []string{schedulerRole, adminRole}, EntityArg: -1},
+	"expire_preauthorizations":     {Roles: []string{schedulerRole, adminRole}, EntityArg: -1},
+}
+
+// ============================================================================================================================
+// getFunctionPolicy - the policy for a function, a stored override wins over the default, nil if the function is open
+// ============================================================================================================================
+func getFunctionPolicy(stub *programStub, function string) (*FunctionPolicy, error) {
+	if function == "set_function_policy" { //never overridable, or admins could lock themselves out
+		return &adminOnly, nil
+	}
+	key, err := createCompositeKey(policyType, []string{function})
+	if err != nil {
+		return nil, err
+	}
+	policyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get function policy")
+	}
+	if policyAsBytes != nil {
+		var policy FunctionPolicy
+		err = unmarshalState(key, policyAsBytes, &policy)
+		if err != nil {
+			return nil, err
+		}
+		return &policy, nil
+	}
+	if policy, ok := defaultPolicies[function]; ok {
+		return &policy, nil
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// authorize - error unless the caller's attributes satisfy the policy of the function being run
+// ============================================================================================================================
+func authorize(stub *programStub, function string, args []string) error {
+	policy, err := getFunctionPolicy(stub, function)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return nil
+	}
+
+	role := callerAttribute(stub, "role")
+	if len(policy.Roles) > 0 {
+		allowed := false
+		for _, r := range policy.Roles {
+			if r == role {
+				allowed = true
+			}
+		}
+		if !allowed {
+			stub.log.warning("role " + role + " may not run " + function)
+			return errors.New("Caller may not run " + function)
+		}
+	}
+
+	if policy.EntityArg >= 0 {
+		if policy.EntityArg >= len(args) {
+			return errors.New("Incorrect number of arguments")
+		}
+		return checkActsFor(stub, function, args[policy.EntityArg])
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// checkActsFor - error unless the caller is an admin, the named entity itself or the merchant of the named store
+// ============================================================================================================================
+func checkActsFor(stub *programStub, function string, name string) error {
+	if callerAttribute(stub, "role") == adminRole {
+		return nil
+	}
+	caller, err := callerEntity(stub)
+	if err != nil {
+		return err
+	}
+	target, err := resolveAlias(stub, name)
+	if err != nil {
+		return err
+	}
+	if caller.Name != target && !isStoreOf(stub, target, caller.Name) {
+		stub.log.warning(caller.Name + " may not run " + function + " for " + target)
+		return errors.New("Caller may not run " + function + " for " + target)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Set Function Policy - admin only, override which roles may run a function and which argument must be their entity
+// ============================================================================================================================
+func (t *SimpleChaincode) setFunctionPolicy(stub *programStub, args []string) ([]byte, error) {
+	//     0            1          2         3
+	// "Function", "EntityArg", "Role1", "Role2", ...   (EntityArg -1 for none, no roles allows any role)
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 2")
+	}
+	if len(args[0]) <= 0 || args[0] == "set_function_policy" {
+		return nil, errors.New("1st argument must name a function other than set_function_policy")
+	}
+	entityArg, err := strconv.Atoi(args[1])
+	if (err != nil) || (entityArg < -1) {
+		return nil, errors.New("2nd argument must be an integer of -1 or more")
+	}
+
+	policy := FunctionPolicy{Roles: args[2:], EntityArg: entityArg}
+	key, err := createCompositeKey(policyType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(policy)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
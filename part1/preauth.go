@@ -0,0 +1,361 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var preauthType = "preauth"                 //composite key object type for pre-authorized redemptions, keyed by token
+var preauthDueType = "preauthdue"           //composite key object type indexing open authorizations by expiry then token
+var defaultPreauthTTL = int64(24 * 60 * 60) //seconds a terminal has to capture an authorization when no ttl is given
+var defaultPreauthBatch = 100               //authorizations expired per expire_preauthorizations call
+
+// PreAuthorization locks points for a redemption a merchant terminal captures later, possibly after being off-line
+type PreAuthorization struct {
+	Token     string  `json:"token"`
+	Customer  string  `json:"customer"`
+	Merchant  string  `json:"merchant"`
+	Points    float64 `json:"points"`   //locked on the customer while the authorization is open
+	Captured  float64 `json:"captured"` //points redeemed by the capture, the rest is unlocked
+	Status    string  `json:"status"`   //open, captured, voided or expired
+	Receipt   string  `json:"receipt"`
+	CreatedAt int64   `json:"created_at"`
+	ExpiresAt int64   `json:"expires_at"`
+}
+
+// ============================================================================================================================
+// preauthTokenFor - derive the token from the tx id, every peer endorsing the transaction computes the same token
+// ============================================================================================================================
+func preauthTokenFor(stub *programStub, customer string, merchant string) string {
+	sum := sha256.Sum256([]byte(stub.UUID + compositeKeyNamespace + customer + compositeKeyNamespace + merchant))
+	return hex.EncodeToString(sum[:])
+}
+
+// ============================================================================================================================
+// getPreauth - fetch an authorization by token
+// ============================================================================================================================
+func getPreauth(stub *programStub, token string) (PreAuthorization, string, error) {
+	var auth PreAuthorization
+	key, err := createCompositeKey(preauthType, []string{token})
+	if err != nil {
+		return auth, "", err
+	}
+	authAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return auth, key, errors.New("Failed to get authorization")
+	}
+	if authAsBytes == nil {
+		return auth, key, errors.New("Authorization does not exist")
+	}
+	err = unmarshalState(key, authAsBytes, &auth)
+	if err != nil {
+		return auth, key, err
+	}
+	return auth, key, nil
+}
+
+// ============================================================================================================================
+// closePreauth - unlock the customer's points, store the final status and drop the authorization from the expiry index
+// ============================================================================================================================
+func closePreauth(stub *programStub, auth PreAuthorization, key string, status string) error {
+	customer, err := getEntity(stub, auth.Customer)
+	if err != nil {
+		return err
+	}
+	customer.Locked = customer.Locked - auth.Points
+	err = putEntity(stub, customer)
+	if err != nil {
+		return err
+	}
+	auth.Status = status
+	jsonAsBytes, _ := json.Marshal(auth)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	dueKey, err := createCompositeKey(preauthDueType, []string{timestampKey(auth.ExpiresAt), auth.Token})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(dueKey)
+}
+
+// ============================================================================================================================
+// Preauthorize Redemption - customer locks points for a later redemption at a merchant, returns the token the terminal
+// captures with
+// ============================================================================================================================
+func (t *SimpleChaincode) preauthorizeRedemption(stub *programStub, args []string) ([]byte, error) {
+	//     0           1          2           3
+	// "Customer", "Merchant", "Points" *"TTLSeconds"*
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 or 4")
+	}
+	points, err := parseAmount(args[2])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+	ttl := defaultPreauthTTL
+	if len(args) == 4 {
+		ttl, err = strconv.ParseInt(args[3], 10, 64)
+		if (err != nil) || (ttl <= 0) {
+			return nil, errors.New("4th argument must be a positive integer")
+		}
+	}
+	customer, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	merchant, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if merchant.Role != merchantRole {
+		return nil, errors.New(merchant.Name + " is not a merchant")
+	}
+	if customer.Name == merchant.Name {
+		return nil, errors.New("A merchant cannot redeem points at itself")
+	}
+	err = checkNotBlocked(stub, customer.Name, merchant.Name)
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAmount(config.Bounds, points, "3rd argument")
+	if err != nil {
+		return nil, err
+	}
+	spend := customer //checked on a copy, the points are only locked until capture
+	err = spendAtMerchant(&spend, merchant, points)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start preauthorize redemption")
+	auth := PreAuthorization{Token: preauthTokenFor(stub, customer.Name, merchant.Name), Customer: customer.Name, Merchant: merchant.Name,
+		Points: points, Status: "open", CreatedAt: timestamp, ExpiresAt: timestamp + ttl}
+	key, err := createCompositeKey(preauthType, []string{auth.Token})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get authorization")
+	}
+	if existing != nil {
+		return nil, errors.New("Authorization already issued in this transaction")
+	}
+	customer.Locked = customer.Locked + points
+	err = putEntity(stub, customer)
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(auth)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	dueKey, err := createCompositeKey(preauthDueType, []string{timestampKey(auth.ExpiresAt), auth.Token})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(dueKey, []byte(auth.Token))
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end preauthorize redemption")
+	return []byte(auth.Token), nil
+}
+
+// ============================================================================================================================
+// Capture Redemption - the merchant redeems up to the authorized points with the token, once. The rest is unlocked.
+// ============================================================================================================================
+func (t *SimpleChaincode) captureRedemption(stub *programStub, args []string) ([]byte, error) {
+	//     0          1          2            3
+	// "Merchant", "Token", "ReceiptID" *"Points"*
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 or 4")
+	}
+	if len(args[2]) <= 0 {
+		return nil, errors.New("3rd argument must be a non-empty string")
+	}
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	auth, key, err := getPreauth(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if auth.Merchant != merchant.Name {
+		return nil, errors.New("Authorization is not for " + merchant.Name)
+	}
+	if auth.Status != "open" {
+		return nil, errors.New("Authorization is " + auth.Status)
+	}
+	points := auth.Points
+	if len(args) == 4 {
+		points, err = parseAmount(args[3])
+		if (err != nil) || (points <= 0) || (points > auth.Points) {
+			return nil, amountError("4th argument must be a positive numeric string no larger than the authorized points", err)
+		}
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp >= auth.ExpiresAt {
+		err = closePreauth(stub, auth, key, "expired")
+		if err != nil {
+			return nil, err
+		}
+		return []byte("expired"), nil
+	}
+
+	stub.log.debug("start capture redemption")
+	auth.Captured = points
+	auth.Receipt = args[2]
+	err = closePreauth(stub, auth, key, "captured")
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.redeemPoints(stub, []string{auth.Customer, auth.Merchant, formatAmount(points), args[2]})
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end capture redemption")
+	return []byte("captured"), nil
+}
+
+// ============================================================================================================================
+// Void Redemption - the customer or the merchant cancels an uncaptured authorization and the points are unlocked
+// ============================================================================================================================
+func (t *SimpleChaincode) voidRedemption(stub *programStub, args []string) ([]byte, error) {
+	//   0        1
+	// "Name", "Token"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	auth, key, err := getPreauth(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if auth.Customer != entity.Name && auth.Merchant != entity.Name {
+		return nil, errors.New("Authorization is not for " + entity.Name)
+	}
+	if auth.Status != "open" {
+		return nil, errors.New("Authorization is " + auth.Status)
+	}
+	err = closePreauth(stub, auth, key, "voided")
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Expire Preauthorizations - unlock the points of authorizations nobody captured in time, returns how many
+// ============================================================================================================================
+func (t *SimpleChaincode) expirePreauthorizations(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// "BatchSize"   (optional)
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	batch := defaultPreauthBatch
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if (err != nil) || (n <= 0) {
+			return nil, errors.New("1st argument must be a positive integer")
+		}
+		batch = n
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	//an authorization expiring exactly now can no longer be captured, so scan to the second after now
+	startKey, err := createCompositeKey(preauthDueType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(preauthDueType, []string{timestampKey(now + 1)})
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, errors.New("Failed to get expired authorizations")
+	}
+	var tokens []string
+	for keysIter.HasNext() && len(tokens) < batch {
+		_, tokenAsBytes, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return nil, errors.New("Failed to get expired authorizations")
+		}
+		tokens = append(tokens, string(tokenAsBytes))
+	}
+	keysIter.Close()
+
+	for _, token := range tokens {
+		auth, key, err := getPreauth(stub, token)
+		if err != nil {
+			return nil, err
+		}
+		err = closePreauth(stub, auth, key, "expired")
+		if err != nil {
+			return nil, err
+		}
+	}
+	stub.log.info("expired " + strconv.Itoa(len(tokens)) + " authorizations")
+	return []byte(strconv.Itoa(len(tokens))), nil
+}
+
+// ============================================================================================================================
+// Get Preauthorization - read an authorization by token
+// ============================================================================================================================
+func (t *SimpleChaincode) getPreauthorization(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting token of the authorization to query")
+	}
+	auth, _, err := getPreauth(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(auth)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,229 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// One deployment can host several independent reward programs. The caller's "program" certificate attribute picks the
+// program, and every key a program reads or writes is transparently prefixed with its id, so entities, config,
+// policies and history of one program are invisible to another and a transfer naming another program's entity simply
+// finds no such entity. Callers without the attribute use the root program, whose keys are unprefixed as before.
+var programType = "program"   //root program composite key object type for registered programs, keyed by program id
+var programSeparator = "\x01" //delimits the program id prefix, below any printable key and distinct from composite keys
+
+var programSeparatorEnd = "\x02" //first key above every program's prefixed keys, where the root program's own keys resume
+
+// Program is a reward program registered by a root admin
+type Program struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	RegisteredAt int64  `json:"registered_at"`
+}
+
+// programStub is the chaincode stub seen by one program, state access is confined to the program's key prefix and
+// goes through the transaction's unit of work. A query runs read-only: its writes would never be flushed, so writing
+// at all is a bug in the handler and fails loudly instead of being silently dropped.
+type programStub struct {
+	*shim.ChaincodeStub
+	program  string
+	uow      *unitOfWork
+	log      *txLogger
+	readOnly string //the read-only function being run, empty when writes are allowed
+}
+
+// ============================================================================================================================
+// programStubFor - the stub for the caller's program, error if the program was never registered
+// ============================================================================================================================
+func programStubFor(chaincodeStub *shim.ChaincodeStub) (*programStub, error) {
+	root := &programStub{ChaincodeStub: chaincodeStub, uow: newUnitOfWork(chaincodeStub)}
+	program := callerAttribute(root, "program")
+	if program == "" {
+		return root, nil
+	}
+	_, err := getProgram(root, program)
+	if err != nil {
+		return nil, err
+	}
+	return root.forProgram(program), nil
+}
+
+// ============================================================================================================================
+// forProgram - a stub confined to another program, only for functions such as bridges that deliberately span programs
+// ============================================================================================================================
+func (s *programStub) forProgram(program string) *programStub {
+	return &programStub{ChaincodeStub: s.ChaincodeStub, program: program, uow: s.uow, log: s.log, readOnly: s.readOnly}
+}
+
+// ============================================================================================================================
+// prefix - the key prefix of the stub's program, empty for the root program
+// ============================================================================================================================
+func (s *programStub) prefix() string {
+	if s.program == "" {
+		return ""
+	}
+	return programSeparator + s.program + programSeparator
+}
+
+// ============================================================================================================================
+// checkWritable - error with READ_ONLY when the running function may not write state
+// ============================================================================================================================
+func (s *programStub) checkWritable(key string) error {
+	if s.readOnly == "" {
+		return nil
+	}
+	s.log.error("read-only " + s.readOnly + " tried to write " + strconv.Quote(key))
+	return errors.New("READ_ONLY: " + s.readOnly + " may not write state")
+}
+
+// ============================================================================================================================
+// dryRun - a writable stub for a query that deliberately runs an invoke handler, its writes stay in the query's unit
+// of work, which is never flushed
+// ============================================================================================================================
+func (s *programStub) dryRun() *programStub {
+	return &programStub{ChaincodeStub: s.ChaincodeStub, program: s.program, uow: s.uow, log: s.log}
+}
+
+// ============================================================================================================================
+// GetState - read a key of the stub's program
+// ============================================================================================================================
+func (s *programStub) GetState(key string) ([]byte, error) {
+	return s.uow.get(s.prefix() + key)
+}
+
+// ============================================================================================================================
+// PutState - write a key of the stub's program
+// ============================================================================================================================
+func (s *programStub) PutState(key string, value []byte) error {
+	if err := s.checkWritable(key); err != nil {
+		return err
+	}
+	if err := checkEndorsement(s, key); err != nil {
+		return err
+	}
+	return s.uow.put(s.prefix()+key, value, false)
+}
+
+// ============================================================================================================================
+// DelState - delete a key of the stub's program
+// ============================================================================================================================
+func (s *programStub) DelState(key string) error {
+	if err := s.checkWritable(key); err != nil {
+		return err
+	}
+	if err := checkEndorsement(s, key); err != nil {
+		return err
+	}
+	return s.uow.put(s.prefix()+key, nil, true)
+}
+
+// ============================================================================================================================
+// RangeQueryState - range scan keys of the stub's program, an empty end key scans to the end of the program
+// ============================================================================================================================
+func (s *programStub) RangeQueryState(startKey string, endKey string) (*programIterator, error) {
+	if endKey == "" && s.program != "" {
+		endKey = maxUnicodeRuneValue
+	}
+	if endKey != "" {
+		endKey = s.prefix() + endKey
+	}
+	return s.uow.rangeQuery(s.prefix()+startKey, endKey, s.prefix())
+}
+
+// ============================================================================================================================
+// getProgram - fetch a registered program from the root program
+// ============================================================================================================================
+func getProgram(stub *programStub, id string) (Program, error) {
+	var program Program
+	key, err := createCompositeKey(programType, []string{id})
+	if err != nil {
+		return program, err
+	}
+	programAsBytes, err := stub.forProgram("").GetState(key)
+	if err != nil {
+		return program, errors.New("Failed to get program")
+	}
+	if programAsBytes == nil {
+		return program, errors.New("Program " + id + " is not registered")
+	}
+	err = unmarshalState(key, programAsBytes, &program)
+	if err != nil {
+		return program, err
+	}
+	return program, nil
+}
+
+// ============================================================================================================================
+// Register Program - root admin only, open a new reward program. Its own admins then call Init from within it.
+// ============================================================================================================================
+func (t *SimpleChaincode) registerProgram(stub *programStub, args []string) ([]byte, error) {
+	//    0         1
+	// "ProgramID", "Name"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	if stub.program != "" {
+		return nil, errors.New("Programs can only be registered from the root program")
+	}
+	if len(args[0]) <= 0 || strings.ContainsAny(args[0], compositeKeyNamespace+programSeparator) {
+		return nil, errors.New("1st argument must be a non-empty program id without control characters")
+	}
+	if _, err := getProgram(stub, args[0]); err == nil {
+		return nil, errors.New("Program " + args[0] + " is already registered")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start register program")
+	key, err := createCompositeKey(programType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(Program{ID: args[0], Name: args[1], RegisteredAt: timestamp})
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end register program")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Program - read a registered program
+// ============================================================================================================================
+func (t *SimpleChaincode) getProgramQuery(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the program to query")
+	}
+	program, err := getProgram(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(program)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,119 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// ============================================================================================================================
+// jsonFieldNames - the json names of a struct's fields, read from their tags
+// ============================================================================================================================
+func jsonFieldNames(v interface{}) map[string]bool {
+	names := map[string]bool{}
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// ============================================================================================================================
+// parseFields - split a comma separated "name,ptbal" projection, every field must be a json field of the record type
+// ============================================================================================================================
+func parseFields(arg string, record interface{}) ([]string, error) {
+	known := jsonFieldNames(record)
+	var fields []string
+	for _, field := range strings.Split(arg, ",") {
+		field = strings.TrimSpace(field)
+		if !known[field] {
+			return nil, errors.New("Unknown field " + field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// ============================================================================================================================
+// projectFields - keep only the requested fields of a json object, fields the object omitted stay omitted
+// ============================================================================================================================
+func projectFields(objAsBytes []byte, fields []string) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(objAsBytes, &obj)
+	if err != nil {
+		return nil, errors.New("Failed to decode record for projection")
+	}
+	projected := map[string]json.RawMessage{}
+	for _, field := range fields {
+		if val, ok := obj[field]; ok {
+			projected[field] = val
+		}
+	}
+	return stableJSON(projected)
+}
+
+// ============================================================================================================================
+// List Entities - every entity in the index, optionally projected to a comma separated list of fields
+// ============================================================================================================================
+func (t *SimpleChaincode) listEntities(stub *programStub, args []string) ([]byte, error) {
+	//      0
+	// "name,ptbal"   (optional)
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	var fields []string
+	if len(args) == 1 {
+		var err error
+		fields, err = parseFields(args[0], Entity{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	entities := []json.RawMessage{}
+	for _, name := range entityIndex {
+		valAsBytes, err := stub.GetState(name)
+		if err != nil {
+			return nil, errors.New("Failed to get entity " + name)
+		}
+		if valAsBytes == nil {
+			continue
+		}
+		if fields != nil {
+			valAsBytes, err = projectFields(valAsBytes, fields)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entities = append(entities, valAsBytes)
+	}
+	jsonAsBytes, _ := json.Marshal(entities)
+	return jsonAsBytes, nil
+}
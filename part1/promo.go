@@ -0,0 +1,237 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var promoType = "promo"       //composite key object type for promotional codes, keyed by code
+var promoUseType = "promouse" //composite key object type for promo code usages, keyed by code then customer
+var promoEndType = "promoend" //composite key object type indexing promo codes by the end of their window then code
+
+// PromoCode grants a fixed number of points once per customer while it is valid and under its usage limit
+type PromoCode struct {
+	Code       string  `json:"code"`
+	Issuer     string  `json:"issuer"` //merchant funding the points, empty when the program funds them
+	Points     float64 `json:"points"`
+	UsageLimit int     `json:"usage_limit"`
+	Used       int     `json:"used"`
+	ValidFrom  int64   `json:"valid_from"`
+	ValidTo    int64   `json:"valid_to"` //exclusive
+}
+
+// ============================================================================================================================
+// getPromoCode - fetch a promo code, nil if it does not exist
+// ============================================================================================================================
+func getPromoCode(stub *programStub, code string) (*PromoCode, string, error) {
+	key, err := createCompositeKey(promoType, []string{code})
+	if err != nil {
+		return nil, "", err
+	}
+	promoAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, key, errors.New("Failed to get promo code")
+	}
+	if promoAsBytes == nil {
+		return nil, key, nil
+	}
+	var promo PromoCode
+	err = unmarshalState(key, promoAsBytes, &promo)
+	if err != nil {
+		return nil, key, err
+	}
+	return &promo, key, nil
+}
+
+// ============================================================================================================================
+// Create Promo Code - admin or merchant, define a code worth N points for up to a number of customers in a date window
+// ============================================================================================================================
+func (t *SimpleChaincode) createPromoCode(stub *programStub, args []string) ([]byte, error) {
+	//   0         1          2         3            4             5
+	// "Code", "Issuer", "Points", "Limit", "2016-07-01", "2016-07-31"   (Issuer may be empty for admins)
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 6")
+	}
+	if len(args[0]) <= 0 {
+		return nil, errors.New("1st argument must be a non-empty string")
+	}
+	issuer := ""
+	if len(args[1]) > 0 {
+		merchant, err := getEntity(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+		if merchant.Role != merchantRole {
+			return nil, errors.New(merchant.Name + " is not a merchant")
+		}
+		issuer = merchant.Name
+	} else if callerAttribute(stub, "role") != adminRole {
+		return nil, errors.New("Only admins may create program funded promo codes")
+	}
+	points, err := parseAmount(args[2])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+	limit, err := strconv.Atoi(args[3])
+	if (err != nil) || (limit <= 0) {
+		return nil, errors.New("4th argument must be a positive integer")
+	}
+	from, to, err := parseDateRange(args[4], args[5])
+	if err != nil {
+		return nil, err
+	}
+
+	existing, key, err := getPromoCode(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.New("Promo code " + args[0] + " already exists")
+	}
+	jsonAsBytes, _ := json.Marshal(PromoCode{args[0], issuer, points, limit, 0, from, to})
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(promoEndType, []string{timestampKey(to), args[0]})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(endKey, []byte{0x00})
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Redeem Promo Code - credit a promo code's points to a customer, once per customer per code
+// ============================================================================================================================
+func (t *SimpleChaincode) redeemPromoCode(stub *programStub, args []string) ([]byte, error) {
+	//   0          1
+	// "Code", "Customer"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+
+	stub.log.debug("start redeem promo code")
+	promo, promoKey, err := getPromoCode(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if promo == nil {
+		return nil, errors.New("Promo code " + args[0] + " does not exist")
+	}
+	customer, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDirectMint(config)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotBlocked(stub, customer.Name, promo.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp < promo.ValidFrom || timestamp >= promo.ValidTo {
+		return nil, errors.New("Promo code " + promo.Code + " is not valid today")
+	}
+	if promo.Used >= promo.UsageLimit {
+		return nil, errors.New("Promo code " + promo.Code + " is used up")
+	}
+
+	useKey, err := createCompositeKey(promoUseType, []string{promo.Code, customer.Name})
+	if err != nil {
+		return nil, err
+	}
+	usedAsBytes, err := stub.GetState(useKey)
+	if err != nil {
+		return nil, errors.New("Failed to get promo code usage")
+	}
+	if usedAsBytes != nil {
+		return nil, errors.New(customer.Name + " already redeemed promo code " + promo.Code)
+	}
+
+	promo.Used++
+	jsonAsBytes, _ := json.Marshal(promo)
+	err = stub.PutState(promoKey, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(useKey, []byte(stub.UUID))
+	if err != nil {
+		return nil, err
+	}
+
+	before := customer
+	customer.PtBal = customer.PtBal + promo.Points
+	err = putEntity(stub, customer)
+	if err != nil {
+		return nil, err
+	}
+	//promo points are issued like earned points, a merchant issuer settles them like any other earn
+	err = recordTxn(stub, TxnRecord{Type: "earn", From: promo.Issuer, To: customer.Name, Points: promo.Points, Reference: "promo:" + promo.Code},
+		balanceChange(before, customer))
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(stub, promo.Points, "earn")
+	if err != nil {
+		return nil, err
+	}
+	if promo.Issuer != "" {
+		err = countIssued(stub, promo.Issuer, promo.Points)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stub.log.debug("end redeem promo code")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Promo Code - return a promo code and how often it was used
+// ============================================================================================================================
+func (t *SimpleChaincode) getPromoCodeQuery(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting the code to query")
+	}
+	promo, _, err := getPromoCode(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if promo == nil {
+		return []byte("null"), nil
+	}
+	jsonAsBytes, _ := json.Marshal(promo)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,443 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// A raffle draws one unit of a catalog item among the tickets entrants bought with points. When the raffle is created
+// the draw oracle commits to a seed by its sha256 hash, typically the id of a transaction or block that does not exist
+// yet, and reveals the seed in draw_raffle once entries have closed. Every entry folds its tx id into the raffle's
+// entropy, so the seed holder cannot pick an outcome it likes: the seed is fixed before any entry and the entropy only
+// after the last. The winning ticket is taken from sha256 over the seed, the entropy, the raffle id and the number of
+// tickets, it is the same on every peer and anyone can recompute it from get_raffle and list_raffle_entries. A seed
+// that is not revealed within the draw window cannot be revealed any more, refund_raffle then gives every entrant their
+// points back instead.
+var raffleType = "raffle"           //composite key object type for raffles, keyed by raffle id
+var raffleEntryType = "raffleentry" //composite key object type for raffle entries, keyed by raffle id then first ticket
+
+var raffleDrawWindow int64 = 7 * 24 * 60 * 60 //seconds after entries close in which the seed must be revealed
+
+// Raffle sells tickets for one unit of a catalog item
+type Raffle struct {
+	ID         string  `json:"id"`
+	Merchant   string  `json:"merchant"`
+	Item       string  `json:"item"`
+	TicketCost float64 `json:"ticket_cost"`
+	StartsAt   int64   `json:"starts_at"`
+	EndsAt     int64   `json:"ends_at"`   //exclusive
+	SeedHash   string  `json:"seed_hash"` //hex sha256 of the seed the oracle reveals at the draw
+	Status     string  `json:"status"`    //open, drawn or refunded
+	Tickets    int     `json:"tickets"`
+	Entropy    string  `json:"entropy"` //hex sha256 chain over the tx ids of the entries
+	Seed       string  `json:"seed,omitempty"`
+	Ticket     int     `json:"ticket,omitempty"` //winning ticket, numbered from 1
+	Winner     string  `json:"winner,omitempty"`
+	DrawnAt    int64   `json:"drawn_at,omitempty"`
+}
+
+// RaffleEntry is a run of consecutive tickets bought in one transaction
+type RaffleEntry struct {
+	Raffle  string `json:"raffle"`
+	Entrant string `json:"entrant"`
+	First   int    `json:"first"`
+	Tickets int    `json:"tickets"`
+	TxID    string `json:"txid"`
+
+	RecordID string `json:"record_id,omitempty"` //the redeem that paid for the tickets, reversed by a refund
+}
+
+// ============================================================================================================================
+// getRaffle - fetch a raffle by id
+// ============================================================================================================================
+func getRaffle(stub *programStub, id string) (Raffle, error) {
+	var raffle Raffle
+	key, err := createCompositeKey(raffleType, []string{id})
+	if err != nil {
+		return raffle, err
+	}
+	raffleAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return raffle, errors.New("Failed to get raffle")
+	}
+	if raffleAsBytes == nil {
+		return raffle, errors.New("Raffle " + id + " does not exist")
+	}
+	err = unmarshalState(key, raffleAsBytes, &raffle)
+	return raffle, err
+}
+
+// ============================================================================================================================
+// putRaffle - write a raffle back to chaincode state
+// ============================================================================================================================
+func putRaffle(stub *programStub, raffle Raffle) error {
+	key, err := createCompositeKey(raffleType, []string{raffle.ID})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(raffle)
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// raffleEntries - every entry of a raffle in ticket order
+// ============================================================================================================================
+func raffleEntries(stub *programStub, id string) ([]RaffleEntry, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, raffleEntryType, []string{id})
+	if err != nil {
+		return nil, errors.New("Failed to get raffle entries")
+	}
+	defer keysIter.Close()
+
+	entries := []RaffleEntry{}
+	for keysIter.HasNext() {
+		key, entryAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get raffle entries")
+		}
+		var entry RaffleEntry
+		err = unmarshalState(key, entryAsBytes, &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ============================================================================================================================
+// winningTicket - the ticket a revealed seed selects together with the entries' entropy, from 1 to tickets
+// ============================================================================================================================
+func winningTicket(seed string, entropy string, id string, tickets int) int {
+	sum := sha256.Sum256([]byte(seed + "|" + entropy + "|" + id + "|" + strconv.Itoa(tickets)))
+	return int(binary.BigEndian.Uint64(sum[:8])%uint64(tickets)) + 1
+}
+
+// ============================================================================================================================
+// redeemRecordID - the id of the redeem this transaction recorded for the entity with the reference, empty if none
+// ============================================================================================================================
+func redeemRecordID(stub *programStub, name string, reference string) (string, error) {
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return "", err
+	}
+	records, err := getTxnRecords(stub, name, now, now+1)
+	if err != nil {
+		return "", err
+	}
+	id := ""
+	for _, rec := range records {
+		if rec.TxID == stub.UUID && rec.Type == "redeem" && rec.Reference == reference {
+			id = rec.ID
+		}
+	}
+	return id, nil
+}
+
+// ============================================================================================================================
+// Create Raffle - merchant raffles one unit of a catalog item between two dates against a committed seed, returns the
+// raffle id
+// ============================================================================================================================
+func (t *SimpleChaincode) createRaffle(stub *programStub, args []string) ([]byte, error) {
+	//     0           1          2              3             4             5
+	// "Merchant", "ItemID", "TicketCost", "2016-07-01", "2016-07-07", "SeedHash"
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 6")
+	}
+	cost, err := parseAmount(args[2])
+	if (err != nil) || (cost <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+	from, to, err := parseDateRange(args[3], args[4])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := hex.DecodeString(args[5]); err != nil || len(args[5]) != 64 {
+		return nil, errors.New("6th argument must be a hex sha256 hash")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if to <= now {
+		return nil, errors.New("Raffle must end in the future")
+	}
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	item, key, err := getCatalogItem(stub, merchant.Name, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if item.Stock < 1 {
+		return nil, errors.New(item.ID + " is out of stock")
+	}
+
+	item.Stock = item.Stock - 1 //held for the winner
+	jsonAsBytes, _ := json.Marshal(item)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	raffle := Raffle{ID: newID(stub, raffleType), Merchant: merchant.Name, Item: item.ID, TicketCost: cost, StartsAt: from, EndsAt: to,
+		SeedHash: args[5], Status: "open"}
+	err = putRaffle(stub, raffle)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(raffle.ID), nil
+}
+
+// ============================================================================================================================
+// Enter Raffle - entrant buys tickets, the points are redeemed at the raffle's merchant straight away
+// ============================================================================================================================
+func (t *SimpleChaincode) enterRaffle(stub *programStub, args []string) ([]byte, error) {
+	//   0          1           2
+	// "ID", "Entrant", "Tickets"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	raffle, err := getRaffle(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	tickets, err := strconv.Atoi(args[2])
+	if err != nil || tickets <= 0 {
+		return nil, errors.New("3rd argument must be a positive integer")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if raffle.Status != "open" || now < raffle.StartsAt || now >= raffle.EndsAt {
+		return nil, errors.New("Raffle " + raffle.ID + " is not taking entries")
+	}
+	entrant, err := getEntity(stub, args[1]) //a merged away name enters as the entity that absorbed it
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	points := roundPoints(config.Bounds, raffle.TicketCost*float64(tickets))
+	reference := raffleType + ":" + raffle.ID
+	_, err = t.redeemPoints(stub, []string{entrant.Name, raffle.Merchant, formatAmount(points), reference})
+	if err != nil {
+		return nil, err
+	}
+	recordID, err := redeemRecordID(stub, entrant.Name, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := RaffleEntry{Raffle: raffle.ID, Entrant: entrant.Name, First: raffle.Tickets + 1, Tickets: tickets, TxID: stub.UUID, RecordID: recordID}
+	key, err := createCompositeKey(raffleEntryType, []string{raffle.ID, fmt.Sprintf("%08d", entry.First)})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(entry)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	raffle.Tickets = raffle.Tickets + tickets
+	sum := sha256.Sum256([]byte(raffle.Entropy + "|" + stub.UUID))
+	raffle.Entropy = hex.EncodeToString(sum[:])
+	return nil, putRaffle(stub, raffle)
+}
+
+// ============================================================================================================================
+// Draw Raffle - oracle reveals the committed seed once entries have closed, the ticket it selects wins the held unit,
+// which goes back into stock when nobody entered
+// ============================================================================================================================
+func (t *SimpleChaincode) drawRaffle(stub *programStub, args []string) ([]byte, error) {
+	//   0       1
+	// "ID", "Seed"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	raffle, err := getRaffle(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if raffle.Status != "open" {
+		return nil, errors.New("Raffle " + raffle.ID + " is already drawn")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now < raffle.EndsAt {
+		return nil, errors.New("Raffle " + raffle.ID + " is still taking entries")
+	}
+	if now >= raffle.EndsAt+raffleDrawWindow {
+		return nil, errors.New("Raffle " + raffle.ID + " missed its draw, use refund_raffle")
+	}
+	sum := sha256.Sum256([]byte(args[1]))
+	if hex.EncodeToString(sum[:]) != raffle.SeedHash {
+		return nil, errors.New("Seed does not match the hash committed for raffle " + raffle.ID)
+	}
+
+	stub.log.debug("start draw raffle " + raffle.ID)
+	raffle.Status, raffle.Seed, raffle.DrawnAt = "drawn", args[1], now
+	if raffle.Tickets == 0 {
+		item, key, err := getCatalogItem(stub, raffle.Merchant, raffle.Item)
+		if err != nil {
+			return nil, err
+		}
+		item.Stock = item.Stock + 1
+		jsonAsBytes, _ := json.Marshal(item)
+		err = stub.PutState(key, jsonAsBytes)
+		if err != nil {
+			return nil, err
+		}
+		return nil, putRaffle(stub, raffle)
+	}
+
+	raffle.Ticket = winningTicket(raffle.Seed, raffle.Entropy, raffle.ID, raffle.Tickets)
+	entries, err := raffleEntries(stub, raffle.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if raffle.Ticket >= entry.First && raffle.Ticket < entry.First+entry.Tickets {
+			raffle.Winner = entry.Entrant
+			break
+		}
+	}
+	if raffle.Winner == "" {
+		return nil, stateCorruption(raffleType+":"+raffle.ID, errors.New("No entry holds ticket "+strconv.Itoa(raffle.Ticket)))
+	}
+	err = putRaffle(stub, raffle)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end draw raffle " + raffle.ID + ", ticket " + strconv.Itoa(raffle.Ticket) + " of " + strconv.Itoa(raffle.Tickets))
+	return nil, emitEvent(stub, "RaffleDrawn", nil, raffle)
+}
+
+// ============================================================================================================================
+// Refund Raffle - once the draw window passed without the seed being revealed, reverse every entry's redeem and put the
+// held unit back into stock
+// ============================================================================================================================
+func (t *SimpleChaincode) refundRaffle(stub *programStub, args []string) ([]byte, error) {
+	//   0
+	// "ID"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	raffle, err := getRaffle(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if raffle.Status != "open" {
+		return nil, errors.New("Raffle " + raffle.ID + " is already " + raffle.Status)
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now < raffle.EndsAt+raffleDrawWindow {
+		return nil, errors.New("Raffle " + raffle.ID + " can still be drawn")
+	}
+	entries, err := raffleEntries(stub, raffle.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start refund raffle " + raffle.ID)
+	for _, entry := range entries {
+		if entry.RecordID == "" {
+			return nil, errors.New("Entry " + strconv.Itoa(entry.First) + " of raffle " + raffle.ID + " has no redeem to refund")
+		}
+		rec, keys, err := getTxnRecordByID(stub, entry.RecordID)
+		if err != nil {
+			return nil, err
+		}
+		if rec.Status == "reversed" || rec.Status == "disputed" { //already refunded, or being handled by the dispute
+			continue
+		}
+		err = reverseTxn(stub, rec)
+		if err != nil {
+			return nil, err
+		}
+		rec.Status = "reversed"
+		err = updateTxnRecord(stub, rec, keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	item, key, err := getCatalogItem(stub, raffle.Merchant, raffle.Item)
+	if err != nil {
+		return nil, err
+	}
+	item.Stock = item.Stock + 1
+	jsonAsBytes, _ := json.Marshal(item)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	raffle.Status = "refunded"
+	err = putRaffle(stub, raffle)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end refund raffle " + raffle.ID + ", " + strconv.Itoa(len(entries)) + " entries")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Raffle - a raffle and, once drawn, its seed, winning ticket and winner
+// ============================================================================================================================
+func (t *SimpleChaincode) getRaffleQuery(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the raffle to query")
+	}
+	raffle, err := getRaffle(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(raffle)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// List Raffle Entries - every entry of a raffle in ticket order, with get_raffle enough to recompute the draw
+// ============================================================================================================================
+func (t *SimpleChaincode) listRaffleEntries(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the raffle to query")
+	}
+	entries, err := raffleEntries(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(entries)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,141 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// The date leads the key so each earn only ever reads today's counter, and a whole day can be range deleted later.
+var earnCounterType = "earncount" //composite key object type for daily earn counters, keyed by date, merchant, customer
+
+// ============================================================================================================================
+// checkEarnRate - count an earn by this customer at this merchant today, error once the configured daily limit is hit
+// ============================================================================================================================
+func checkEarnRate(stub *programStub, merchant string, customer string) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	if config.MaxDailyEarns <= 0 {
+		return nil
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	day := time.Unix(timestamp, 0).UTC().Format("2006-01-02")
+	key, err := createCompositeKey(earnCounterType, []string{day, merchant, customer})
+	if err != nil {
+		return err
+	}
+	countAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get earn counter")
+	}
+	count := 0
+	if countAsBytes != nil {
+		count, err = strconv.Atoi(string(countAsBytes))
+		if err != nil {
+			return stateCorruption(key, err)
+		}
+	}
+	if count >= config.MaxDailyEarns {
+		stub.log.warning(customer + " hit the daily earn limit at " + merchant)
+		return errors.New("Daily earn limit of " + strconv.Itoa(config.MaxDailyEarns) + " reached for " + customer + " at " + merchant)
+	}
+	return stub.PutState(key, []byte(strconv.Itoa(count+1)))
+}
+
+// ============================================================================================================================
+// Set Max Daily Earns - admin only, limit earn transactions per customer per merchant per day, 0 removes the limit
+// ============================================================================================================================
+func (t *SimpleChaincode) setMaxDailyEarns(stub *programStub, args []string) ([]byte, error) {
+	//  0
+	// "5"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	limit, err := strconv.Atoi(args[0])
+	if (err != nil) || (limit < 0) {
+		return nil, errors.New("1st argument must be a non-negative integer")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.MaxDailyEarns = limit
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Prune Earn Counters - admin only, delete the daily earn counters of every day before the given date
+// ============================================================================================================================
+func (t *SimpleChaincode) pruneEarnCounters(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// "2016-06-01"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	_, err := time.Parse("2006-01-02", args[0])
+	if err != nil {
+		return nil, errors.New("1st argument must be formatted as YYYY-MM-DD")
+	}
+
+	startKey, err := createCompositeKey(earnCounterType, nil)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(earnCounterType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, errors.New("Failed to get earn counters")
+	}
+	var keys []string
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return nil, errors.New("Failed to get earn counters")
+		}
+		keys = append(keys, key)
+	}
+	keysIter.Close()
+
+	for _, key := range keys {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, errors.New("Failed to delete earn counter")
+		}
+	}
+	stub.log.info("pruned " + strconv.Itoa(len(keys)) + " earn counters")
+	return nil, nil
+}
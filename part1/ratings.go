@@ -0,0 +1,181 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var ratingType = "rating"                 //composite key object type for ratings, keyed by merchant then redemption record id
+var merchantRatingType = "merchantrating" //composite key object type for a merchant's aggregate score, keyed by merchant
+var maxRatingComment = 500                //characters, feedback is kept short so it cannot be used to store documents
+
+// Rating is a customer's score for one redemption, at most one per redemption record
+type Rating struct {
+	Record   string `json:"record"`
+	Merchant string `json:"merchant"`
+	Customer string `json:"customer"`
+	Stars    int    `json:"stars"` //1 to 5
+	Comment  string `json:"comment,omitempty"`
+	RatedAt  int64  `json:"rated_at"`
+}
+
+// MerchantRating aggregates every rating a merchant received
+type MerchantRating struct {
+	Merchant string  `json:"merchant"`
+	Count    int     `json:"count"`
+	Total    int     `json:"total"` //sum of stars
+	Average  float64 `json:"average"`
+	Stars    [5]int  `json:"stars"` //number of ratings with 1 to 5 stars
+}
+
+// ============================================================================================================================
+// getMerchantRating - a merchant's aggregate score, empty if nobody rated it yet
+// ============================================================================================================================
+func getMerchantRating(stub *programStub, merchant string) (MerchantRating, string, error) {
+	rating := MerchantRating{Merchant: merchant}
+	key, err := createCompositeKey(merchantRatingType, []string{merchant})
+	if err != nil {
+		return rating, "", err
+	}
+	ratingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return rating, key, errors.New("Failed to get merchant rating")
+	}
+	if ratingAsBytes == nil {
+		return rating, key, nil
+	}
+	err = unmarshalState(key, ratingAsBytes, &rating)
+	return rating, key, err
+}
+
+// ============================================================================================================================
+// Rate Redemption - the customer of a redemption scores the merchant from 1 to 5 stars, once per redemption
+// ============================================================================================================================
+func (t *SimpleChaincode) rateRedemption(stub *programStub, args []string) ([]byte, error) {
+	//     0            1          2         3
+	// "RecordID", "Customer", "Stars" *"Comment"*
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 or 4")
+	}
+	stars, err := strconv.Atoi(args[2])
+	if err != nil || stars < 1 || stars > 5 {
+		return nil, errors.New("3rd argument must be an integer from 1 to 5")
+	}
+	comment := ""
+	if len(args) == 4 {
+		comment = args[3]
+		if len(comment) > maxRatingComment {
+			return nil, errors.New("4th argument must be at most " + strconv.Itoa(maxRatingComment) + " characters")
+		}
+	}
+	rec, _, err := getTxnRecordByID(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if rec.Type != "redeem" {
+		return nil, errors.New("Only redemptions can be rated")
+	}
+	if rec.From != args[1] {
+		return nil, errors.New(args[1] + " is not the customer of " + rec.ID)
+	}
+	if rec.Status == "reversed" {
+		return nil, errors.New("Redemption " + rec.ID + " was reversed")
+	}
+	key, err := createCompositeKey(ratingType, []string{rec.To, rec.ID})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get rating")
+	}
+	if existing != nil {
+		return nil, errors.New("Redemption " + rec.ID + " is already rated")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	rating := Rating{rec.ID, rec.To, rec.From, stars, comment, timestamp}
+	jsonAsBytes, _ := json.Marshal(rating)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	aggregate, aggregateKey, err := getMerchantRating(stub, rec.To)
+	if err != nil {
+		return nil, err
+	}
+	aggregate.Count++
+	aggregate.Total = aggregate.Total + stars
+	aggregate.Stars[stars-1]++
+	aggregate.Average = float64(aggregate.Total) / float64(aggregate.Count)
+	jsonAsBytes, _ = json.Marshal(aggregate)
+	return nil, stub.PutState(aggregateKey, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Get Merchant Rating - a merchant's aggregate score
+// ============================================================================================================================
+func (t *SimpleChaincode) getMerchantRatingQuery(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the merchant to query")
+	}
+	rating, _, err := getMerchantRating(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(rating)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// List Ratings - every rating a merchant received, in redemption record order
+// ============================================================================================================================
+func (t *SimpleChaincode) listRatings(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the merchant to query")
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, ratingType, []string{args[0]})
+	if err != nil {
+		return nil, errors.New("Failed to get ratings")
+	}
+	defer keysIter.Close()
+
+	ratings := []Rating{}
+	for keysIter.HasNext() {
+		key, ratingAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get ratings")
+		}
+		var rating Rating
+		err = unmarshalState(key, ratingAsBytes, &rating)
+		if err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, rating)
+	}
+	jsonAsBytes, _ := json.Marshal(ratings)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,129 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// A client that can decompress adds compressArg as the last argument of a query. A payload of at least
+// minCompressSize bytes then comes back as a base64 string of its gzip in Data, with Meta.Encoding saying so, to keep
+// large exports and statements under the gRPC message limit. Smaller payloads are returned as they are.
+var compressArg = "--compress=gzip"
+var minCompressSize = 4096
+
+// Receipt is returned by every invoke, so clients get the outcome without a follow up query
+type Receipt struct {
+	TxID     string           `json:"txid"`
+	Function string           `json:"function"`
+	Result   json.RawMessage  `json:"result,omitempty"` //what the function itself returned, such as the id of a new record
+	Balances []ReceiptBalance `json:"balances"`         //balances after the transaction of every entity it changed
+	Fee      float64          `json:"fee"`              //transfer fees charged
+	Events   []string         `json:"events"`
+}
+
+// Envelope wraps every query response with the point of the ledger it was read at
+type Envelope struct {
+	Data json.RawMessage `json:"data"`
+	Meta EnvelopeMeta    `json:"meta"`
+}
+
+// EnvelopeMeta says which transaction and schema a query response was read under
+type EnvelopeMeta struct {
+	TxID          string `json:"txid"`
+	Timestamp     int64  `json:"timestamp,omitempty"` //0 when the peer gave the query no timestamp
+	SchemaVersion int    `json:"schema_version"`
+	Encoding      string `json:"encoding,omitempty"` //gzip+base64 when Data was compressed
+	Size          int    `json:"size,omitempty"`     //bytes of Data before it was compressed
+}
+
+// ReceiptBalance is an entity's balances once the transaction is applied
+type ReceiptBalance struct {
+	Entity  string  `json:"entity"`
+	Program string  `json:"program,omitempty"`
+	PtBal   float64 `json:"ptbal"`
+	TxnBal  float64 `json:"txnbal"`
+}
+
+// ============================================================================================================================
+// buildReceipt - summarise a successful invoke from what it returned and the events it raised
+// ============================================================================================================================
+func buildReceipt(stub *programStub, function string, payload []byte) []byte {
+	receipt := Receipt{TxID: stub.UUID, Function: function, Result: resultJSON(payload), Balances: []ReceiptBalance{}, Events: []string{}}
+
+	latest := map[string]int{} //program and entity to its position in Balances, the last change wins
+	for _, event := range stub.uow.events {
+		receipt.Events = append(receipt.Events, event.Name)
+		if rec, ok := event.Detail.(TxnRecord); ok && rec.Type == "fee" {
+			receipt.Fee = receipt.Fee + rec.Points
+		}
+		for _, change := range event.Balances {
+			balance := ReceiptBalance{change.Entity, event.Program, change.PtBalAfter, change.TxnBalAfter}
+			id := event.Program + programSeparator + change.Entity
+			if i, ok := latest[id]; ok {
+				receipt.Balances[i] = balance
+			} else {
+				latest[id] = len(receipt.Balances)
+				receipt.Balances = append(receipt.Balances, balance)
+			}
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(receipt)
+	return jsonAsBytes
+}
+
+// ============================================================================================================================
+// buildEnvelope - wrap what a query returned with the tx id, timestamp and schema version it was read under, compressed
+// when the caller asked and it is large
+// ============================================================================================================================
+func buildEnvelope(stub *programStub, payload []byte, compress bool) []byte {
+	envelope := Envelope{Data: resultJSON(payload), Meta: EnvelopeMeta{TxID: stub.UUID, SchemaVersion: schemaVersion}}
+	timestamp, err := txTimestamp(stub)
+	if err == nil {
+		envelope.Meta.Timestamp = timestamp
+	}
+	if compress && len(envelope.Data) >= minCompressSize {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(envelope.Data)
+		zw.Close()
+		envelope.Meta.Encoding, envelope.Meta.Size = "gzip+base64", len(envelope.Data)
+		envelope.Data, _ = json.Marshal(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	}
+	jsonAsBytes, _ := json.Marshal(envelope)
+	return jsonAsBytes
+}
+
+// ============================================================================================================================
+// resultJSON - a function's return value as JSON, values that are not JSON such as a new record's id become strings
+// ============================================================================================================================
+func resultJSON(payload []byte) json.RawMessage {
+	if len(payload) == 0 {
+		return nil
+	}
+	if json.Valid(payload) {
+		return payload
+	}
+	result, _ := json.Marshal(string(payload))
+	return result
+}
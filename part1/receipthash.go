@@ -0,0 +1,114 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+var receiptHashType = "receipthash" //composite key object type mapping a receipt hash to the earn it backed
+
+func init() {
+	registerTxnHook(TxnHook{"receipthash", receiptHashTxn})
+}
+
+// ReceiptVerification is the answer to whether a receipt backed a point grant
+type ReceiptVerification struct {
+	Hash     string     `json:"hash"`
+	Verified bool       `json:"verified"`
+	Record   *TxnRecord `json:"record,omitempty"` //the earn the receipt backed
+}
+
+// ============================================================================================================================
+// checkReceiptHash - error unless a receipt hash is a hex sha256, or is missing while the program does not require one
+// for purchases, earns without a purchase such as voucher redemptions never need one
+// ============================================================================================================================
+func checkReceiptHash(stub *programStub, hash string, purchase float64) error {
+	if hash == "" {
+		if purchase <= 0 {
+			return nil
+		}
+		config, err := getConfig(stub)
+		if err != nil {
+			return err
+		}
+		if config.Features.enabled(receiptHashRequiredFlag) {
+			return errors.New("A receipt hash is required to earn points")
+		}
+		return nil
+	}
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != 64 {
+		return errors.New("Receipt hash must be a hex sha256 hash")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// receiptHashTxn - txn hook, index an earn under its receipt hash, a receipt can only back one earn
+// ============================================================================================================================
+func receiptHashTxn(stub *programStub, rec TxnRecord, balances []BalanceChange) error {
+	if rec.ReceiptHash == "" {
+		return nil
+	}
+	key, err := createCompositeKey(receiptHashType, []string{rec.ReceiptHash})
+	if err != nil {
+		return err
+	}
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get receipt hash")
+	}
+	if existing != nil {
+		return errors.New("Receipt " + rec.ReceiptHash + " already backed " + string(existing))
+	}
+	return stub.PutState(key, []byte(rec.ID))
+}
+
+// ============================================================================================================================
+// Verify Receipt - whether the receipt with this sha256 backed a point grant, or the given transaction record
+// ============================================================================================================================
+func (t *SimpleChaincode) verifyReceipt(stub *programStub, args []string) ([]byte, error) {
+	//    0            1
+	// "Hash" *"RecordID"*
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	key, err := createCompositeKey(receiptHashType, []string{args[0]})
+	if err != nil {
+		return nil, err
+	}
+	idAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get receipt hash")
+	}
+	result := ReceiptVerification{Hash: args[0]}
+	if idAsBytes != nil {
+		rec, _, err := getTxnRecordByID(stub, string(idAsBytes))
+		if err != nil {
+			return nil, err
+		}
+		result.Record = &rec
+		result.Verified = len(args) == 1 || rec.ID == args[1]
+	}
+	jsonAsBytes, _ := json.Marshal(result)
+	return jsonAsBytes, nil
+}
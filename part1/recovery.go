@@ -0,0 +1,331 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// A member who lost their credentials asks the program to recover the account. An admin opens a case, which freezes
+// the entity's points until the case is closed. Once the member's identity was verified off chain the admin
+// completes the case: the old certificate fingerprints are unbound and blacklisted, the new one is bound and,
+// optionally, a new public key is rotated in. Every step stays on the case record.
+var recoveryType = "recovery"         //composite key object type for recovery cases, keyed by entity then case id
+var recoveryOpenType = "recoveryopen" //composite key object type holding the open case of a frozen entity, keyed by entity
+
+// RecoveryCase is one account recovery, open while the entity is frozen
+type RecoveryCase struct {
+	ID            string   `json:"id"`
+	Entity        string   `json:"entity"`
+	Reason        string   `json:"reason"`
+	Status        string   `json:"status"` //open, completed or cancelled
+	OpenedBy      string   `json:"opened_by"`
+	OpenedAt      int64    `json:"opened_at"`
+	ClosedBy      string   `json:"closed_by,omitempty"`
+	ClosedAt      int64    `json:"closed_at,omitempty"`
+	Note          string   `json:"note,omitempty"`
+	OldIdentities []string `json:"old_identities,omitempty"` //fingerprints unbound and blacklisted on completion
+	NewIdentity   string   `json:"new_identity,omitempty"`
+	KeyID         string   `json:"key_id,omitempty"` //public key rotated in on completion
+	TxIDs         []string `json:"txids"`
+}
+
+// ============================================================================================================================
+// checkNotFrozen - error with FROZEN_ACCOUNT if a party has an open recovery case
+// ============================================================================================================================
+func checkNotFrozen(stub *programStub, party string) error {
+	key, err := createCompositeKey(recoveryOpenType, []string{party})
+	if err != nil {
+		return err
+	}
+	caseAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get recovery case")
+	}
+	if caseAsBytes != nil {
+		stub.log.warning("frozen party " + party)
+		return errors.New("FROZEN_ACCOUNT: " + party + " is under recovery case " + string(caseAsBytes))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// getRecoveryCase - fetch a recovery case of an entity
+// ============================================================================================================================
+func getRecoveryCase(stub *programStub, name string, id string) (RecoveryCase, string, error) {
+	var rc RecoveryCase
+	key, err := createCompositeKey(recoveryType, []string{name, id})
+	if err != nil {
+		return rc, "", err
+	}
+	caseAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return rc, key, errors.New("Failed to get recovery case")
+	}
+	if caseAsBytes == nil {
+		return rc, key, errors.New("Recovery case " + id + " of " + name + " does not exist")
+	}
+	err = unmarshalState(key, caseAsBytes, &rc)
+	if err != nil {
+		return rc, key, err
+	}
+	return rc, key, nil
+}
+
+// ============================================================================================================================
+// identitiesBoundTo - every certificate fingerprint bound to an entity
+// ============================================================================================================================
+func identitiesBoundTo(stub *programStub, name string) ([]string, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, identityType, []string{})
+	if err != nil {
+		return nil, errors.New("Failed to get identity bindings")
+	}
+	defer keysIter.Close()
+
+	ids := []string{}
+	for keysIter.HasNext() {
+		key, nameAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get identity bindings")
+		}
+		if string(nameAsBytes) != name {
+			continue
+		}
+		_, keyParts, err := splitCompositeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, keyParts[0])
+	}
+	return ids, nil
+}
+
+// ============================================================================================================================
+// closeRecoveryCase - record who closed a case and why, store it and lift the freeze
+// ============================================================================================================================
+func closeRecoveryCase(stub *programStub, rc *RecoveryCase, key string, status string, note string) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	rc.ClosedBy, _ = callerID(stub)
+	rc.Status, rc.ClosedAt, rc.Note = status, timestamp, note
+	rc.TxIDs = append(rc.TxIDs, stub.UUID)
+	jsonAsBytes, _ := json.Marshal(rc)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	openKey, err := createCompositeKey(recoveryOpenType, []string{rc.Entity})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(openKey)
+}
+
+// ============================================================================================================================
+// Initiate Recovery - admin only, open a recovery case for an entity and freeze its points until the case is closed
+// ============================================================================================================================
+func (t *SimpleChaincode) initiateRecovery(stub *programStub, args []string) ([]byte, error) {
+	//   0         1
+	// "Name", "Reason"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	if len(args[1]) <= 0 {
+		return nil, errors.New("2nd argument must be a non-empty string")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotFrozen(stub, entity.Name)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start initiate recovery")
+	actor, _ := callerID(stub)
+	rc := RecoveryCase{ID: newID(stub, recoveryType), Entity: entity.Name, Reason: args[1], Status: "open", OpenedBy: actor, OpenedAt: timestamp, TxIDs: []string{stub.UUID}}
+	key, err := createCompositeKey(recoveryType, []string{entity.Name, rc.ID})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(rc)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	openKey, err := createCompositeKey(recoveryOpenType, []string{entity.Name})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(openKey, []byte(rc.ID))
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end initiate recovery")
+	return []byte(rc.ID), nil
+}
+
+// ============================================================================================================================
+// Complete Recovery - admin only, rebind a frozen entity to its owner's new identity and lift the freeze
+// ============================================================================================================================
+func (t *SimpleChaincode) completeRecovery(stub *programStub, args []string) ([]byte, error) {
+	//   0        1            2              3                    4
+	// "Name", "CaseID", "Fingerprint", "Note" *"base64 PKIX public key"*
+	if len(args) != 4 && len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 or 5")
+	}
+	if len(args[2]) <= 0 {
+		return nil, errors.New("3rd argument must be a non-empty string")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	rc, key, err := getRecoveryCase(stub, entity.Name, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if rc.Status != "open" {
+		return nil, errors.New("Recovery case " + rc.ID + " is " + rc.Status)
+	}
+	existing, err := boundEntityName(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if existing != "" && existing != entity.Name {
+		return nil, errors.New("Identity is already bound to " + existing)
+	}
+
+	stub.log.debug("start complete recovery")
+	oldIDs, err := identitiesBoundTo(stub, entity.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range oldIDs {
+		if id == args[2] {
+			continue
+		}
+		idKey, err := createCompositeKey(identityType, []string{id})
+		if err != nil {
+			return nil, err
+		}
+		err = stub.DelState(idKey)
+		if err != nil {
+			return nil, err
+		}
+		//a lost certificate may still be in someone's hands, keep it from moving points through the entityName attribute
+		_, err = t.addToBlacklist(stub, []string{id, "recovered under case " + rc.ID})
+		if err != nil {
+			return nil, err
+		}
+		rc.OldIdentities = append(rc.OldIdentities, id)
+	}
+	_, err = t.bindIdentity(stub, []string{entity.Name, args[2]})
+	if err != nil {
+		return nil, err
+	}
+	rc.NewIdentity = args[2]
+	if len(args) == 5 {
+		keys, err := getPublicKeys(stub, entity.Name)
+		if err != nil {
+			return nil, err
+		}
+		record, err := addPublicKey(stub, entity, args[4], len(keys) > 0)
+		if err != nil {
+			return nil, err
+		}
+		rc.KeyID = record.KeyID
+	}
+	err = closeRecoveryCase(stub, &rc, key, "completed", args[3])
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end complete recovery")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Cancel Recovery - admin only, close a recovery case without changing the entity's identities and lift the freeze
+// ============================================================================================================================
+func (t *SimpleChaincode) cancelRecovery(stub *programStub, args []string) ([]byte, error) {
+	//   0        1        2
+	// "Name", "CaseID", "Note"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	rc, key, err := getRecoveryCase(stub, entity.Name, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if rc.Status != "open" {
+		return nil, errors.New("Recovery case " + rc.ID + " is " + rc.Status)
+	}
+	err = closeRecoveryCase(stub, &rc, key, "cancelled", args[2])
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// List Recovery Cases - admin only, every recovery case of an entity
+// ============================================================================================================================
+func (t *SimpleChaincode) listRecoveryCases(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, recoveryType, []string{entity.Name})
+	if err != nil {
+		return nil, errors.New("Failed to get recovery cases")
+	}
+	defer keysIter.Close()
+
+	cases := []RecoveryCase{}
+	for keysIter.HasNext() {
+		key, caseAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get recovery cases")
+		}
+		var rc RecoveryCase
+		err = unmarshalState(key, caseAsBytes, &rc)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, rc)
+	}
+	jsonAsBytes, _ := json.Marshal(cases)
+	return jsonAsBytes, nil
+}
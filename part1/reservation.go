@@ -0,0 +1,327 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var reservationType = "reservation"        //composite key object type for catalog reservations, keyed by reservation id
+var reservationDueType = "reservationdue"  //composite key object type indexing open reservations by expiry then id
+var defaultReservationTTL = int64(15 * 60) //seconds a reservation holds stock and points when no window is configured
+var defaultReservationBatch = 100          //reservations released per release_expired_reservations call
+
+// Reservation holds one unit of a catalog item and its cost in the customer's points until confirmed or expired
+type Reservation struct {
+	ID        string  `json:"id"`
+	Customer  string  `json:"customer"`
+	Merchant  string  `json:"merchant"`
+	Item      string  `json:"item"`
+	Points    float64 `json:"points"` //locked on the customer until the reservation closes
+	Status    string  `json:"status"` //open, confirmed or released
+	CreatedAt int64   `json:"created_at"`
+	ExpiresAt int64   `json:"expires_at"`
+}
+
+// ============================================================================================================================
+// getReservation - fetch a reservation by id
+// ============================================================================================================================
+func getReservation(stub *programStub, id string) (Reservation, string, error) {
+	var res Reservation
+	key, err := createCompositeKey(reservationType, []string{id})
+	if err != nil {
+		return res, "", err
+	}
+	resAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return res, key, errors.New("Failed to get reservation")
+	}
+	if resAsBytes == nil {
+		return res, key, errors.New("Reservation " + id + " does not exist")
+	}
+	err = unmarshalState(key, resAsBytes, &res)
+	if err != nil {
+		return res, key, err
+	}
+	return res, key, nil
+}
+
+// ============================================================================================================================
+// closeReservation - unlock the customer's points, store the final status and drop the reservation from the expiry index
+// ============================================================================================================================
+func closeReservation(stub *programStub, res Reservation, key string, status string) error {
+	customer, err := getEntity(stub, res.Customer)
+	if err != nil {
+		return err
+	}
+	customer.Locked = customer.Locked - res.Points
+	err = putEntity(stub, customer)
+	if err != nil {
+		return err
+	}
+	res.Status = status
+	jsonAsBytes, _ := json.Marshal(res)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	dueKey, err := createCompositeKey(reservationDueType, []string{timestampKey(res.ExpiresAt), res.ID})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(dueKey)
+}
+
+// ============================================================================================================================
+// releaseReservation - close an unconfirmed reservation and put its unit back in stock
+// ============================================================================================================================
+func releaseReservation(stub *programStub, res Reservation, key string) error {
+	err := closeReservation(stub, res, key, "released")
+	if err != nil {
+		return err
+	}
+	item, itemKey, err := getCatalogItem(stub, res.Merchant, res.Item)
+	if err != nil {
+		return err
+	}
+	item.Stock = item.Stock + 1
+	jsonAsBytes, _ := json.Marshal(item)
+	return stub.PutState(itemKey, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Set Reservation Window - admin only, seconds a catalog reservation holds stock and points before it expires
+// ============================================================================================================================
+func (t *SimpleChaincode) setReservationWindow(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// "Seconds"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	ttl, err := strconv.ParseInt(args[0], 10, 64)
+	if (err != nil) || (ttl <= 0) {
+		return nil, errors.New("1st argument must be a positive integer")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.ReservationTTL = ttl
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Reserve Item - customer holds one unit of a catalog item, its cost is locked in the customer's points, returns the id
+// ============================================================================================================================
+func (t *SimpleChaincode) reserveItem(stub *programStub, args []string) ([]byte, error) {
+	//     0           1          2
+	// "Customer", "Merchant", "ItemID"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	customer, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	merchant, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if customer.Name == merchant.Name {
+		return nil, errors.New("A merchant cannot redeem points at itself")
+	}
+	err = checkNotBlocked(stub, customer.Name, merchant.Name)
+	if err != nil {
+		return nil, err
+	}
+	item, itemKey, err := getCatalogItem(stub, merchant.Name, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if item.Stock < 1 {
+		return nil, errors.New(item.ID + " is out of stock")
+	}
+	spend := customer //checked on a copy, the points are only locked until confirmation
+	err = spendAtMerchant(&spend, merchant, item.Cost)
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	ttl := config.ReservationTTL
+	if ttl <= 0 {
+		ttl = defaultReservationTTL
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start reserve item")
+	customer.Locked = customer.Locked + item.Cost
+	err = putEntity(stub, customer)
+	if err != nil {
+		return nil, err
+	}
+	item.Stock = item.Stock - 1
+	jsonAsBytes, _ := json.Marshal(item)
+	err = stub.PutState(itemKey, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	res := Reservation{newID(stub, reservationType), customer.Name, merchant.Name, item.ID, item.Cost, "open", timestamp, timestamp + ttl}
+	key, err := createCompositeKey(reservationType, []string{res.ID})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ = json.Marshal(res)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	dueKey, err := createCompositeKey(reservationDueType, []string{timestampKey(res.ExpiresAt), res.ID})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(dueKey, []byte(res.ID))
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end reserve item")
+	return []byte(res.ID), nil
+}
+
+// ============================================================================================================================
+// Confirm Redemption - customer completes a reservation, the locked points are redeemed at the merchant
+// ============================================================================================================================
+func (t *SimpleChaincode) confirmRedemption(stub *programStub, args []string) ([]byte, error) {
+	//     0              1
+	// "Customer", "ReservationID"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	customer, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	res, key, err := getReservation(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if res.Customer != customer.Name {
+		return nil, errors.New("Reservation " + res.ID + " does not belong to " + customer.Name)
+	}
+	if res.Status != "open" {
+		return nil, errors.New("Reservation " + res.ID + " is " + res.Status)
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp >= res.ExpiresAt {
+		err = releaseReservation(stub, res, key)
+		if err != nil {
+			return nil, err
+		}
+		return []byte("released"), nil
+	}
+
+	stub.log.debug("start confirm redemption")
+	err = closeReservation(stub, res, key, "confirmed")
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.redeemPoints(stub, []string{res.Customer, res.Merchant, formatAmount(res.Points), reservationType + ":" + res.ID})
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end confirm redemption")
+	return []byte("confirmed"), nil
+}
+
+// ============================================================================================================================
+// Release Expired Reservations - put expired reservations back in stock and unlock their points, returns how many
+// ============================================================================================================================
+func (t *SimpleChaincode) releaseExpiredReservations(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// "BatchSize"   (optional)
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	batch := defaultReservationBatch
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if (err != nil) || (n <= 0) {
+			return nil, errors.New("1st argument must be a positive integer")
+		}
+		batch = n
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	//a reservation expiring exactly now can no longer be confirmed, so scan to the second after now
+	startKey, err := createCompositeKey(reservationDueType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(reservationDueType, []string{timestampKey(now + 1)})
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, errors.New("Failed to get expired reservations")
+	}
+	var ids []string
+	for keysIter.HasNext() && len(ids) < batch {
+		_, idAsBytes, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return nil, errors.New("Failed to get expired reservations")
+		}
+		ids = append(ids, string(idAsBytes))
+	}
+	keysIter.Close()
+
+	for _, id := range ids {
+		res, key, err := getReservation(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		err = releaseReservation(stub, res, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stub.log.info("released " + strconv.Itoa(len(ids)) + " expired reservations")
+	return []byte(strconv.Itoa(len(ids))), nil
+}
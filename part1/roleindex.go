@@ -0,0 +1,176 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// The index key carries the role before the name so a partial key scan over a role finds its entities on LevelDB,
+// which has no rich queries.
+var roleIndexType = "role~name" //composite key object type indexing entities by role then name
+
+// ============================================================================================================================
+// indexEntityRole - move an entity's role index entry from its stored record to its new value, either may be nil
+// ============================================================================================================================
+func indexEntityRole(stub *programStub, prev *Entity, entity *Entity) error {
+	if prev != nil && entity != nil && prev.Role == entity.Role && prev.Name == entity.Name {
+		return nil
+	}
+	if prev != nil {
+		key, err := createCompositeKey(roleIndexType, []string{prev.Role, prev.Name})
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+	if entity == nil {
+		return nil
+	}
+	key, err := createCompositeKey(roleIndexType, []string{entity.Role, entity.Name})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte{0x00})
+}
+
+// ============================================================================================================================
+// List Entities By Role - every entity holding a role, in name order, optionally projected to a list of fields
+// ============================================================================================================================
+func (t *SimpleChaincode) listEntitiesByRole(stub *programStub, args []string) ([]byte, error) {
+	//    0            1
+	// "Role" *"name,ptbal"*
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	var fields []string
+	if len(args) == 2 {
+		var err error
+		fields, err = parseFields(args[1], Entity{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, roleIndexType, []string{args[0]})
+	if err != nil {
+		return nil, errors.New("Failed to get role index")
+	}
+	defer keysIter.Close()
+
+	entities := []json.RawMessage{}
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get role index")
+		}
+		_, keyParts, err := splitCompositeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		valAsBytes, err := stub.GetState(keyParts[1])
+		if err != nil {
+			return nil, errors.New("Failed to get entity " + keyParts[1])
+		}
+		if valAsBytes == nil {
+			continue
+		}
+		if fields != nil {
+			valAsBytes, err = projectFields(valAsBytes, fields)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entities = append(entities, valAsBytes)
+	}
+	jsonAsBytes, _ := json.Marshal(entities)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// rebuildEntityIndex - drop every entry of an entity index and index each entity in the entity index again
+// ============================================================================================================================
+func rebuildEntityIndex(stub *programStub, objectType string, index func(*programStub, *Entity, *Entity) error) (int, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, objectType, []string{})
+	if err != nil {
+		return 0, errors.New("Failed to get " + objectType + " index")
+	}
+	var keys []string
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return 0, errors.New("Failed to get " + objectType + " index")
+		}
+		keys = append(keys, key)
+	}
+	keysIter.Close()
+	for _, key := range keys {
+		err = stub.DelState(key)
+		if err != nil {
+			return 0, errors.New("Failed to delete " + objectType + " index entry")
+		}
+	}
+
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return 0, err
+	}
+	indexed := 0
+	for _, name := range entityIndex {
+		entityAsBytes, err := stub.GetState(name)
+		if err != nil {
+			return 0, errors.New("Failed to get entity " + name)
+		}
+		if entityAsBytes == nil {
+			continue
+		}
+		var entity Entity
+		err = unmarshalState(name, entityAsBytes, &entity)
+		if err != nil {
+			return 0, err
+		}
+		err = index(stub, nil, &entity)
+		if err != nil {
+			return 0, err
+		}
+		indexed++
+	}
+	return indexed, nil
+}
+
+// ============================================================================================================================
+// Rebuild Role Index - admin only, rebuild the role index from the entity records
+// ============================================================================================================================
+func (t *SimpleChaincode) rebuildRoleIndex(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+	indexed, err := rebuildEntityIndex(stub, roleIndexType, indexEntityRole)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.info("rebuilt role index, " + strconv.Itoa(indexed) + " entities")
+	return []byte(strconv.Itoa(indexed)), nil
+}
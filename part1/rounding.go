@@ -0,0 +1,88 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// Computed amounts such as percentage fees and bridge conversions are rounded to the configured decimal places with
+// the program's rounding mode, so partners can reproduce the ledger's numbers in their own accounting.
+var roundingModes = []string{"half-up", "floor", "bankers"}
+var roundingNoise = 1e-6 //scaled amounts this close to a boundary are treated as on it, 0.29*100 is 28.999999999999996
+
+// ============================================================================================================================
+// roundPoints - round a computed amount, such as a fee or a converted amount, to the configured decimal places
+// ============================================================================================================================
+func roundPoints(bounds AmountBounds, val float64) float64 {
+	if bounds.MaxDecimals == nil {
+		return val
+	}
+	scale := math.Pow(10, float64(*bounds.MaxDecimals))
+	scaled := val * scale
+	switch bounds.Rounding {
+	case "floor":
+		return math.Floor(scaled+roundingNoise) / scale
+	case "bankers": //halves go to the even neighbour, so a run of halves does not drift upwards
+		whole := math.Floor(scaled + roundingNoise)
+		frac := scaled - whole
+		if math.Abs(frac-0.5) < roundingNoise {
+			if math.Mod(whole, 2) != 0 {
+				whole = whole + 1
+			}
+		} else if frac > 0.5 {
+			whole = whole + 1
+		}
+		return whole / scale
+	}
+	return math.Floor(scaled+0.5+roundingNoise) / scale //half-up, also for programs configured before modes existed
+}
+
+// ============================================================================================================================
+// Set Rounding Policy - admin only, how computed amounts are rounded to the decimal places set by set_amount_bounds
+// ============================================================================================================================
+func (t *SimpleChaincode) setRoundingPolicy(stub *programStub, args []string) ([]byte, error) {
+	//          0
+	// "half-up|floor|bankers"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	known := false
+	for _, mode := range roundingModes {
+		if mode == args[0] {
+			known = true
+		}
+	}
+	if !known {
+		return nil, errors.New("1st argument must be half-up, floor or bankers")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.Bounds.Rounding = args[0]
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,330 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var schedulerRole = "scheduler"     //value of the "role" certificate attribute held by the off-chain cron identity
+var scheduleType = "schedule"       //composite key object type for recurring grants, keyed by schedule id
+var scheduleDueType = "scheduledue" //composite key object type indexing active schedules, keyed by next due time then id
+var maxScheduleCatchUp = 366        //most missed occurrences one schedule grants in a single run
+var defaultScheduleBatch = 100      //schedules processed per process_due_schedules call unless the caller asks otherwise
+
+// Schedule grants an entity the same reward every interval, e.g. a monthly loyalty bonus or a yearly birthday bonus
+type Schedule struct {
+	ID       string  `json:"id"`
+	Entity   string  `json:"entity"`
+	Points   float64 `json:"points"`
+	Interval string  `json:"interval"` //daily, weekly, monthly, yearly or a number of days such as 14d
+	Reason   string  `json:"reason"`
+	NextDue  int64   `json:"next_due"`
+	Granted  int     `json:"granted"` //occurrences granted so far
+	Active   bool    `json:"active"`
+}
+
+// ============================================================================================================================
+// nextOccurrence - the due time one interval after the given one. Calendar intervals use AddDate so a monthly schedule
+// keeps its day of month.
+// ============================================================================================================================
+func nextOccurrence(due int64, interval string) (int64, error) {
+	t := time.Unix(due, 0).UTC()
+	switch interval {
+	case "daily":
+		return t.AddDate(0, 0, 1).Unix(), nil
+	case "weekly":
+		return t.AddDate(0, 0, 7).Unix(), nil
+	case "monthly":
+		return t.AddDate(0, 1, 0).Unix(), nil
+	case "yearly":
+		return t.AddDate(1, 0, 0).Unix(), nil
+	}
+	if len(interval) > 1 && interval[len(interval)-1] == 'd' {
+		days, err := strconv.Atoi(interval[:len(interval)-1])
+		if err == nil && days > 0 {
+			return t.AddDate(0, 0, days).Unix(), nil
+		}
+	}
+	return 0, errors.New("Interval must be daily, weekly, monthly, yearly or a number of days such as 14d")
+}
+
+// ============================================================================================================================
+// getSchedule - fetch a schedule by id
+// ============================================================================================================================
+func getSchedule(stub *programStub, id string) (Schedule, error) {
+	var schedule Schedule
+	key, err := createCompositeKey(scheduleType, []string{id})
+	if err != nil {
+		return schedule, err
+	}
+	scheduleAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return schedule, errors.New("Failed to get schedule")
+	}
+	if scheduleAsBytes == nil {
+		return schedule, errors.New("Schedule " + id + " does not exist")
+	}
+	err = unmarshalState(key, scheduleAsBytes, &schedule)
+	if err != nil {
+		return schedule, err
+	}
+	return schedule, nil
+}
+
+// ============================================================================================================================
+// putSchedule - write a schedule and move its due index entry from the previous due time to the current one
+// ============================================================================================================================
+func putSchedule(stub *programStub, schedule Schedule, previousDue int64) error {
+	key, err := createCompositeKey(scheduleType, []string{schedule.ID})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(schedule)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return err
+	}
+	if previousDue > 0 {
+		oldKey, err := createCompositeKey(scheduleDueType, []string{timestampKey(previousDue), schedule.ID})
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(oldKey)
+		if err != nil {
+			return err
+		}
+	}
+	if !schedule.Active {
+		return nil
+	}
+	dueKey, err := createCompositeKey(scheduleDueType, []string{timestampKey(schedule.NextDue), schedule.ID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(dueKey, []byte(schedule.ID))
+}
+
+// ============================================================================================================================
+// Create Schedule - admin only, grant an entity points every interval starting on a date
+// ============================================================================================================================
+func (t *SimpleChaincode) createSchedule(stub *programStub, args []string) ([]byte, error) {
+	//   0        1          2            3            4
+	// "Name", "Points", "Interval", "2016-07-01", "Reason"
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	points, err := parseAmount(args[1])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("2nd argument must be a positive numeric string", err)
+	}
+	_, err = nextOccurrence(0, args[2])
+	if err != nil {
+		return nil, err
+	}
+	first, err := time.Parse("2006-01-02", args[3])
+	if err != nil {
+		return nil, errors.New("4th argument must be a date formatted as YYYY-MM-DD")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDirectMint(config)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := Schedule{ID: newID(stub, scheduleType), Entity: entity.Name, Points: points, Interval: args[2], Reason: args[4], NextDue: first.Unix(), Active: true}
+	err = putSchedule(stub, schedule, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = addToCounter(stub, 1, activeSchedulesCounter)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(schedule.ID), nil
+}
+
+// ============================================================================================================================
+// Cancel Schedule - admin only, stop a schedule from granting any further occurrences
+// ============================================================================================================================
+func (t *SimpleChaincode) cancelSchedule(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	schedule, err := getSchedule(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !schedule.Active {
+		return nil, errors.New("Schedule " + schedule.ID + " is already cancelled")
+	}
+	previousDue := schedule.NextDue
+	schedule.Active = false
+	err = putSchedule(stub, schedule, previousDue)
+	if err != nil {
+		return nil, err
+	}
+	err = addToCounter(stub, -1, activeSchedulesCounter)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Process Due Schedules - grant every occurrence that fell due at or before the tx timestamp. Run by the cron identity;
+// the result only depends on the timestamp and the ledger so every endorser grants the same points.
+// ============================================================================================================================
+func (t *SimpleChaincode) processDueSchedules(stub *programStub, args []string) ([]byte, error) {
+	//     0
+	// "BatchSize"   (optional)
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	batch := defaultScheduleBatch
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if (err != nil) || (n <= 0) {
+			return nil, errors.New("1st argument must be a positive integer")
+		}
+		batch = n
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDirectMint(config) //schedules set up before the threshold was raised stop granting too
+	if err != nil {
+		return nil, err
+	}
+
+	//the end key is exclusive, so scan to the second after now
+	startKey, err := createCompositeKey(scheduleDueType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(scheduleDueType, []string{timestampKey(now + 1)})
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, errors.New("Failed to get due schedules")
+	}
+	var ids []string
+	for keysIter.HasNext() && len(ids) < batch {
+		_, idAsBytes, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return nil, errors.New("Failed to get due schedules")
+		}
+		ids = append(ids, string(idAsBytes))
+	}
+	keysIter.Close()
+
+	stub.log.debug("start process due schedules")
+	granted := 0
+	for _, id := range ids {
+		schedule, err := getSchedule(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		n, err := grantSchedule(stub, &schedule, now)
+		if err != nil {
+			return nil, err
+		}
+		granted = granted + n
+	}
+	stub.log.debug("end process due schedules, " + strconv.Itoa(granted) + " grants")
+	return []byte(strconv.Itoa(granted)), nil
+}
+
+// ============================================================================================================================
+// grantSchedule - credit one schedule with every occurrence due by now, record each as a grant and advance its due time
+// ============================================================================================================================
+func grantSchedule(stub *programStub, schedule *Schedule, now int64) (int, error) {
+	entity, err := getEntity(stub, schedule.Entity)
+	if err != nil {
+		return 0, err
+	}
+	previousDue := schedule.NextDue
+	count := 0
+	for schedule.NextDue <= now && count < maxScheduleCatchUp {
+		next, err := nextOccurrence(schedule.NextDue, schedule.Interval)
+		if err != nil {
+			return 0, err
+		}
+		schedule.NextDue = next
+		count++
+	}
+	points := schedule.Points * float64(count)
+
+	before := entity
+	entity.PtBal = entity.PtBal + points
+	err = putEntity(stub, entity)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < count; i++ {
+		err = recordTxn(stub, TxnRecord{Type: "grant", To: entity.Name, Points: schedule.Points, Reference: schedule.ID + ":" + schedule.Reason}, balanceChange(before, entity))
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = changeSupply(stub, points, "grant")
+	if err != nil {
+		return 0, err
+	}
+	schedule.Granted = schedule.Granted + count
+	err = putSchedule(stub, *schedule, previousDue)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ============================================================================================================================
+// Get Schedule - read a schedule by id
+// ============================================================================================================================
+func (t *SimpleChaincode) getScheduleQuery(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the schedule to query")
+	}
+	schedule, err := getSchedule(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(schedule)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,207 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+var schemaIDPrefix = "urn:reward-chaincode:schema:" //followed by the schema version and the type name
+
+// schemaTypes are the records clients read and write, by the name get_schema takes, the schemas are generated from
+// the structs so they can never drift from what the chaincode marshals
+var schemaTypes = map[string]interface{}{
+	"entity":          Entity{},
+	"transaction":     TxnRecord{},
+	"campaign":        EarnRule{},
+	"config":          Config{},
+	"journal_entry":   JournalEntry{},
+	"gift_card":       GiftCard{},
+	"promo_code":      PromoCode{},
+	"catalog_item":    CatalogItem{},
+	"reservation":     Reservation{},
+	"dispute":         Dispute{},
+	"vesting_grant":   VestingGrant{},
+	"point_request":   PointRequest{},
+	"listing":         Listing{},
+	"auction":         Auction{},
+	"bid":             Bid{},
+	"raffle":          Raffle{},
+	"raffle_entry":    RaffleEntry{},
+	"rating":          Rating{},
+	"period_snapshot": PeriodSnapshot{},
+	"statement":       Statement{},
+	"event":           EventPayload{},
+	"envelope":        Envelope{},
+}
+
+// JSONSchema is the subset of draft-07 the generated schemas use
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Definitions          map[string]*JSONSchema `json:"definitions,omitempty"`
+}
+
+// SchemaDocument is the answer to get_schema, the schemas of this build's record types
+type SchemaDocument struct {
+	SchemaVersion    int                    `json:"schema_version"` //bumped whenever the layout of stored records changes
+	ChaincodeVersion string                 `json:"chaincode_version"`
+	Types            map[string]*JSONSchema `json:"types"`
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// ============================================================================================================================
+// typeSchema - the schema of one record type, named struct types it uses are put in definitions and referenced
+// ============================================================================================================================
+func typeSchema(name string, record interface{}) *JSONSchema {
+	typ := reflect.TypeOf(record)
+	definitions := map[string]*JSONSchema{}
+	schema := structSchema(typ, definitions)
+	schema.Schema = jsonSchemaDraft
+	schema.ID = schemaIDPrefix + "v" + strconv.Itoa(schemaVersion) + ":" + name
+	schema.Title = typ.Name()
+	if len(definitions) > 0 {
+		schema.Definitions = definitions
+	}
+	return schema
+}
+
+// ============================================================================================================================
+// valueSchema - the schema of a field's value as encoding/json writes it
+// ============================================================================================================================
+func valueSchema(typ reflect.Type, definitions map[string]*JSONSchema) *JSONSchema {
+	if typ == rawMessageType {
+		return &JSONSchema{} //any JSON value
+	}
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return valueSchema(typ.Elem(), definitions)
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice:
+		return &JSONSchema{Type: "array", Items: valueSchema(typ.Elem(), definitions)}
+	case reflect.Array:
+		length := typ.Len()
+		return &JSONSchema{Type: "array", Items: valueSchema(typ.Elem(), definitions), MinItems: &length, MaxItems: &length}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: valueSchema(typ.Elem(), definitions)}
+	case reflect.Struct:
+		if typ.Name() == "" {
+			return structSchema(typ, definitions)
+		}
+		if _, ok := definitions[typ.Name()]; !ok {
+			definitions[typ.Name()] = nil //marks it in progress so a type that refers to itself stops here
+			definitions[typ.Name()] = structSchema(typ, definitions)
+		}
+		return &JSONSchema{Ref: "#/definitions/" + typ.Name()}
+	}
+	return &JSONSchema{} //interface{} holds any JSON value
+}
+
+// ============================================================================================================================
+// structSchema - an object with a property per exported field, embedded structs are flattened the way encoding/json
+// does, fields that are neither omitempty nor pointers are always written so they are required
+// ============================================================================================================================
+func structSchema(typ reflect.Type, definitions map[string]*JSONSchema) *JSONSchema {
+	schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if field.Anonymous && tag[0] == "" && field.Type.Kind() == reflect.Struct {
+			embedded := structSchema(field.Type, definitions)
+			for name, prop := range embedded.Properties {
+				schema.Properties[name] = prop
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = valueSchema(field.Type, definitions)
+		omitempty := false
+		for _, option := range tag[1:] {
+			omitempty = omitempty || option == "omitempty"
+		}
+		if !omitempty && field.Type.Kind() != reflect.Ptr {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// ============================================================================================================================
+// Get Schema - JSON Schema of the record types, or of the named ones, for clients to generate models and validate
+// payloads against the schema version on chain
+// ============================================================================================================================
+func (t *SimpleChaincode) getSchema(stub *programStub, args []string) ([]byte, error) {
+	//       0
+	// *"entity,transaction"*
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0 or 1")
+	}
+	names := sortedKeys(schemaTypes)
+	if len(args) == 1 && len(args[0]) > 0 {
+		names = nil
+		for _, name := range strings.Split(args[0], ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := schemaTypes[name]; !ok {
+				return nil, errors.New("Unknown record type " + name + ", expecting one of " + strings.Join(sortedKeys(schemaTypes), ", "))
+			}
+			names = append(names, name)
+		}
+	}
+
+	doc := SchemaDocument{SchemaVersion: schemaVersion, ChaincodeVersion: chaincodeVersion, Types: map[string]*JSONSchema{}}
+	for _, name := range names {
+		doc.Types[name] = typeSchema(name, schemaTypes[name])
+	}
+	jsonAsBytes, _ := json.Marshal(doc)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,105 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+var defaultSearchLimit = 100 //records returned by search_transactions unless the caller asks for fewer or more
+var maxSearchLimit = 1000
+
+// TxnSearchResult is the records search_transactions matched and per type totals over them
+type TxnSearchResult struct {
+	Records []TxnRecord    `json:"records"`
+	Totals  []TxnTypeTotal `json:"totals"`
+}
+
+// ============================================================================================================================
+// Search Transactions - an entity's transaction records, optionally only those with one counterparty, of some types or
+// within a date range, with per type totals. Only the entity's own (txn, entity, timestamp) keys in the range are scanned.
+// ============================================================================================================================
+func (t *SimpleChaincode) searchTransactions(stub *programStub, args []string) ([]byte, error) {
+	//    0            1               2               3             4           5
+	// "Entity", "Counterparty", "EARN,REDEEM", "2016-06-01", "2016-06-30", "Limit"   (all but entity may be empty)
+	if len(args) < 1 || len(args) > 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 to 6")
+	}
+	for len(args) < 6 {
+		args = append(args, "")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	counterparty := args[1]
+	if counterparty != "" {
+		counterparty, err = resolveAlias(stub, counterparty)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	from, to := int64(0), int64(math.MaxInt64)
+	if args[3] != "" || args[4] != "" {
+		start, end := args[3], args[4]
+		if start == "" {
+			start = "1970-01-01"
+		}
+		if end == "" {
+			end = "9999-12-31"
+		}
+		from, to, err = parseDateRange(start, end)
+		if err != nil {
+			return nil, err
+		}
+	}
+	types, err := parseTxnTypes(args[2])
+	if err != nil {
+		return nil, err
+	}
+	limit, err := pageSize(stub, args[5], "6th", defaultSearchLimit, maxSearchLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := getTxnRecords(stub, entity.Name, from, to)
+	if err != nil {
+		return nil, err
+	}
+	result := TxnSearchResult{Records: []TxnRecord{}}
+	for _, rec := range records {
+		if len(result.Records) >= limit {
+			break
+		}
+		if !matchesTxnTypes(rec, types) {
+			continue
+		}
+		if counterparty != "" && rec.From != counterparty && rec.To != counterparty {
+			continue
+		}
+		result.Records = append(result.Records, rec)
+	}
+	result.Totals = txnTypeTotals(result.Records)
+	jsonAsBytes, _ := json.Marshal(result)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,347 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var segmentIndexType = "segment~name" //composite key object type indexing entities by segment label then name
+var defaultSegmentPageSize = 100
+var maxSegmentPageSize = 500
+
+// SegmentRun is the result of one page of a bulk segment operation, pass the bookmark back to run the next page
+type SegmentRun struct {
+	Entities int      `json:"entities"` //members credited or debited on this page
+	Points   float64  `json:"points"`   //net points added to circulation
+	Skipped  []string `json:"skipped"`  //members without enough spendable points for a debit
+	Bookmark string   `json:"bookmark"` //last member processed, empty once the whole segment was processed
+}
+
+// ============================================================================================================================
+// hasSegment - true if the entity is tagged with the segment label
+// ============================================================================================================================
+func hasSegment(entity Entity, label string) bool {
+	for _, val := range entity.Segments {
+		if val == label {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// indexEntitySegments - move an entity's segment index entries from its stored record to its new value, either may be nil
+// ============================================================================================================================
+func indexEntitySegments(stub *programStub, prev *Entity, entity *Entity) error {
+	if prev != nil && (entity == nil || prev.Name != entity.Name) {
+		for _, label := range prev.Segments {
+			err := delSegmentKey(stub, label, prev.Name)
+			if err != nil {
+				return err
+			}
+		}
+		prev = nil
+	}
+	if entity == nil {
+		return nil
+	}
+	if prev != nil {
+		for _, label := range prev.Segments {
+			if !hasSegment(*entity, label) {
+				err := delSegmentKey(stub, label, entity.Name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, label := range entity.Segments {
+		if prev == nil || !hasSegment(*prev, label) {
+			key, err := createCompositeKey(segmentIndexType, []string{label, entity.Name})
+			if err != nil {
+				return err
+			}
+			err = stub.PutState(key, []byte{0x00})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// delSegmentKey - remove one entity from the index of one segment
+// ============================================================================================================================
+func delSegmentKey(stub *programStub, label string, name string) error {
+	key, err := createCompositeKey(segmentIndexType, []string{label, name})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// ============================================================================================================================
+// segmentMembers - names tagged with a segment label in name order after the bookmark, at most limit of them (0 for no
+// limit), and whether more members follow
+// ============================================================================================================================
+func segmentMembers(stub *programStub, label string, bookmark string, limit int) ([]string, bool, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, segmentIndexType, []string{label})
+	if err != nil {
+		return nil, false, errors.New("Failed to get segment " + label)
+	}
+	defer keysIter.Close()
+
+	var names []string
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, false, errors.New("Failed to get segment " + label)
+		}
+		_, keyParts, err := splitCompositeKey(key)
+		if err != nil {
+			return nil, false, err
+		}
+		if keyParts[1] <= bookmark {
+			continue
+		}
+		if limit > 0 && len(names) == limit {
+			return names, true, nil
+		}
+		names = append(names, keyParts[1])
+	}
+	return names, false, nil
+}
+
+// ============================================================================================================================
+// Tag Segment - admin only, add entities to a segment such as VIP or Q3-churn-risk
+// ============================================================================================================================
+func (t *SimpleChaincode) tagSegment(stub *programStub, args []string) ([]byte, error) {
+	return setSegment(stub, args, true)
+}
+
+// ============================================================================================================================
+// Untag Segment - admin only, remove entities from a segment
+// ============================================================================================================================
+func (t *SimpleChaincode) untagSegment(stub *programStub, args []string) ([]byte, error) {
+	return setSegment(stub, args, false)
+}
+
+// ============================================================================================================================
+// setSegment - tag or untag each named entity with the segment label, entities already in the wanted state are left alone
+// ============================================================================================================================
+func setSegment(stub *programStub, args []string, tag bool) ([]byte, error) {
+	//    0        1        2
+	// "Label", "Name", "Name", ...
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 2")
+	}
+	if len(args[0]) <= 0 {
+		return nil, errors.New("1st argument must be a non-empty string")
+	}
+	label := args[0]
+	changed := 0
+	for _, name := range args[1:] {
+		entity, err := getEntity(stub, name)
+		if err != nil {
+			return nil, err
+		}
+		if hasSegment(entity, label) == tag {
+			continue
+		}
+		if tag {
+			entity.Segments = append(append([]string(nil), entity.Segments...), label)
+		} else {
+			var segments []string
+			for _, val := range entity.Segments {
+				if val != label {
+					segments = append(segments, val)
+				}
+			}
+			entity.Segments = segments
+		}
+		err = putEntity(stub, entity)
+		if err != nil {
+			return nil, err
+		}
+		changed++
+	}
+	return []byte(strconv.Itoa(changed)), nil
+}
+
+// ============================================================================================================================
+// List Segment - admin only, every entity in a segment, in name order, optionally projected to a list of fields
+// ============================================================================================================================
+func (t *SimpleChaincode) listSegment(stub *programStub, args []string) ([]byte, error) {
+	//    0            1
+	// "Label" *"name,ptbal"*
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	var fields []string
+	if len(args) == 2 {
+		var err error
+		fields, err = parseFields(args[1], Entity{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	names, _, err := segmentMembers(stub, args[0], "", 0)
+	if err != nil {
+		return nil, err
+	}
+	entities := []json.RawMessage{}
+	for _, name := range names {
+		valAsBytes, err := stub.GetState(name)
+		if err != nil {
+			return nil, errors.New("Failed to get entity " + name)
+		}
+		if valAsBytes == nil {
+			continue
+		}
+		if fields != nil {
+			valAsBytes, err = projectFields(valAsBytes, fields)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entities = append(entities, valAsBytes)
+	}
+	jsonAsBytes, _ := json.Marshal(entities)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Grant Segment - admin only, credit every member of a segment the same number of new points, a page at a time
+// ============================================================================================================================
+func (t *SimpleChaincode) grantSegment(stub *programStub, args []string) ([]byte, error) {
+	return bulkSegment(stub, args, "grant")
+}
+
+// ============================================================================================================================
+// Adjust Segment - admin only, credit or with a negative amount debit every member of a segment, a page at a time.
+// Members without enough spendable points for a debit are skipped and listed in the result.
+// ============================================================================================================================
+func (t *SimpleChaincode) adjustSegment(stub *programStub, args []string) ([]byte, error) {
+	return bulkSegment(stub, args, "adjust")
+}
+
+// ============================================================================================================================
+// bulkSegment - apply one grant or adjustment to a page of segment members, recording a txn for each of them
+// ============================================================================================================================
+func bulkSegment(stub *programStub, args []string, txType string) ([]byte, error) {
+	//    0         1         2           3             4
+	// "Label", "Points", "Reason" *"PageSize"* *"Bookmark"*
+	if len(args) < 3 || len(args) > 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 to 5")
+	}
+	points, err := parseAmount(args[1])
+	if (err != nil) || (points == 0) || (txType == "grant" && points < 0) {
+		if txType == "grant" {
+			return nil, amountError("2nd argument must be a positive numeric string", err)
+		}
+		return nil, amountError("2nd argument must be a non-zero numeric string", err)
+	}
+	if len(args[2]) <= 0 {
+		return nil, errors.New("3rd argument must be a non-empty string")
+	}
+	if points > 0 {
+		config, err := getConfig(stub)
+		if err != nil {
+			return nil, err
+		}
+		err = checkDirectMint(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pageSize := defaultSegmentPageSize
+	if len(args) >= 4 && args[3] != "" {
+		pageSize, err = strconv.Atoi(args[3])
+		if (err != nil) || (pageSize < 1) || (pageSize > maxSegmentPageSize) {
+			return nil, errors.New("4th argument must be an integer between 1 and " + strconv.Itoa(maxSegmentPageSize))
+		}
+	}
+	bookmark := ""
+	if len(args) == 5 {
+		bookmark = args[4]
+	}
+
+	names, more, err := segmentMembers(stub, args[0], bookmark, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("start " + txType + " segment " + args[0])
+	run := SegmentRun{Skipped: []string{}}
+	for _, name := range names {
+		entity, err := getEntity(stub, name)
+		if err != nil {
+			return nil, err
+		}
+		if points < 0 && transferablePoints(entity) < -points {
+			run.Skipped = append(run.Skipped, entity.Name)
+			continue
+		}
+		before := entity
+		entity.PtBal = entity.PtBal + points
+		err = putEntity(stub, entity)
+		if err != nil {
+			return nil, err
+		}
+		rec := TxnRecord{Type: txType, To: entity.Name, Points: points, Reference: args[0] + ":" + args[2]}
+		if points < 0 {
+			rec = TxnRecord{Type: txType, From: entity.Name, Points: -points, Reference: args[0] + ":" + args[2]}
+		}
+		err = recordTxn(stub, rec, balanceChange(before, entity))
+		if err != nil {
+			return nil, err
+		}
+		run.Entities++
+		run.Points = run.Points + points
+	}
+	err = changeSupply(stub, run.Points, txType)
+	if err != nil {
+		return nil, err
+	}
+	if more {
+		run.Bookmark = names[len(names)-1]
+	}
+	stub.log.debug("end " + txType + " segment " + args[0] + ", " + strconv.Itoa(run.Entities) + " entities")
+	jsonAsBytes, _ := json.Marshal(run)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Rebuild Segment Index - admin only, rebuild the segment index from the entity records
+// ============================================================================================================================
+func (t *SimpleChaincode) rebuildSegmentIndex(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+	indexed, err := rebuildEntityIndex(stub, segmentIndexType, indexEntitySegments)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.info("rebuilt segment index, " + strconv.Itoa(indexed) + " entities")
+	return []byte(strconv.Itoa(indexed)), nil
+}
@@ -0,0 +1,87 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+var maxSplitRecipients = 100 //legs per split_transfer, keeps one transaction's write set bounded
+
+// ============================================================================================================================
+// Split Transfer - move points from one sender to many recipients in one transaction, all legs apply or none do
+// ============================================================================================================================
+func (t *SimpleChaincode) splitTransfer(stub *programStub, args []string) ([]byte, error) {
+	//     0           1            2          3            4
+	// "Sender", "Recipient1", "Points1", "Recipient2", "Points2", ...
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting a sender then recipient and points pairs")
+	}
+	if (len(args)-1)/2 > maxSplitRecipients {
+		return nil, errors.New("At most " + strconv.Itoa(maxSplitRecipients) + " recipients per split transfer")
+	}
+	sender, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0.0
+	seen := map[string]bool{}
+	for i := 1; i < len(args); i = i + 2 {
+		points, err := parseAmount(args[i+1])
+		if (err != nil) || (points <= 0) {
+			return nil, amountError("Points for "+args[i]+" must be a positive numeric string", err)
+		}
+		recipient, err := getEntity(stub, args[i])
+		if err != nil {
+			return nil, err
+		}
+		if seen[recipient.Name] {
+			return nil, errors.New(recipient.Name + " is listed more than once")
+		}
+		seen[recipient.Name] = true
+		total = total + points
+	}
+	released, err := releaseVested(stub, &sender) //as transfer does, vested points count towards the split
+	if err != nil {
+		return nil, err
+	}
+	if released > 0 {
+		err = putEntity(stub, sender)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if transferablePoints(sender) < total {
+		return nil, errors.New("Insufficient points for a split of " + formatAmount(total))
+	}
+
+	//each leg is an ordinary transfer, the unit of work keeps them all or nothing
+	stub.log.debug("start split transfer")
+	for i := 1; i < len(args); i = i + 2 {
+		_, err = t.transfer(stub, []string{sender.Name, args[i], "0", args[i+1]})
+		if err != nil {
+			return nil, errors.New("Transfer to " + args[i] + " failed: " + err.Error())
+		}
+	}
+	stub.log.debug("end split transfer")
+	return nil, nil
+}
@@ -0,0 +1,310 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Program statistics are read from counters kept up to date as entities and transactions are written, so the query
+// costs the same whatever the size of the ledger. Ledgers written before the counters existed are brought in line once
+// with rebuild_program_stats.
+var entityCountCounter = "entities"      //counter name, then role
+var pointsTotalCounter = "points"        //counter name, then role, sum of PtBal
+var txnBalTotalCounter = "txnbal"        //counter name, then role, sum of TxnBal
+var txnCountCounter = "txns"             //counter name, then YYYY-MM-DD, transaction records written that day
+var activeSchedulesCounter = "schedules" //counter name, active recurring grants
+var defaultStatsDays = 30                //days of transactions counted when no window is given
+var maxStatsDays = 366
+
+// RoleStats are the counters of the entities holding one role
+type RoleStats struct {
+	Entities  int     `json:"entities"`
+	PtBal     float64 `json:"ptbal"`
+	TxnBal    float64 `json:"txnbal"`
+	AvgPtBal  float64 `json:"avg_ptbal"`
+	AvgTxnBal float64 `json:"avg_txnbal"`
+}
+
+// ProgramStats is the answer to get_program_stats
+type ProgramStats struct {
+	Roles           map[string]RoleStats `json:"roles"`
+	Entities        int                  `json:"entities"`
+	PtBal           float64              `json:"ptbal"`
+	AvgPtBal        float64              `json:"avg_ptbal"`
+	Days            int                  `json:"days"`
+	Transactions    int                  `json:"transactions"` //records written over the last Days days, today included
+	ActiveSchedules int                  `json:"active_schedules"`
+	LivePromoCodes  int                  `json:"live_promo_codes"` //promo codes whose window has not ended
+}
+
+// ============================================================================================================================
+// statsDay - day a timestamp falls on, the bucket of the daily transaction counter
+// ============================================================================================================================
+func statsDay(timestamp int64) string {
+	return time.Unix(timestamp, 0).UTC().Format("2006-01-02")
+}
+
+// ============================================================================================================================
+// countEntityChange - move the role counters from an entity's stored record to its new value, either may be nil.
+// Only the net change is written, so a balance update adds one delta per balance and none to the entity count.
+// ============================================================================================================================
+func countEntityChange(stub *programStub, prev *Entity, entity *Entity) error {
+	if prev != nil && entity != nil && prev.Role == entity.Role {
+		err := addToCounter(stub, entity.PtBal-prev.PtBal, pointsTotalCounter, entity.Role)
+		if err != nil {
+			return err
+		}
+		return addToCounter(stub, entity.TxnBal-prev.TxnBal, txnBalTotalCounter, entity.Role)
+	}
+	if prev != nil {
+		err := countEntity(stub, *prev, -1)
+		if err != nil {
+			return err
+		}
+	}
+	if entity != nil {
+		return countEntity(stub, *entity, 1)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// countEntity - add an entity to its role counters, or take it out with sign -1
+// ============================================================================================================================
+func countEntity(stub *programStub, entity Entity, sign float64) error {
+	err := addToCounter(stub, sign, entityCountCounter, entity.Role)
+	if err != nil {
+		return err
+	}
+	err = addToCounter(stub, sign*entity.PtBal, pointsTotalCounter, entity.Role)
+	if err != nil {
+		return err
+	}
+	return addToCounter(stub, sign*entity.TxnBal, txnBalTotalCounter, entity.Role)
+}
+
+// ============================================================================================================================
+// counterRoles - every role that has an entity counter, from the counter keys rather than the entities
+// ============================================================================================================================
+func counterRoles(stub *programStub) ([]string, error) {
+	seen := map[string]bool{}
+	for _, objectType := range []string{counterType, counterDeltaType} {
+		keysIter, err := getStateByPartialCompositeKey(stub, objectType, []string{entityCountCounter})
+		if err != nil {
+			return nil, errors.New("Failed to get counters")
+		}
+		for keysIter.HasNext() {
+			key, _, err := keysIter.Next()
+			if err != nil {
+				keysIter.Close()
+				return nil, errors.New("Failed to get counters")
+			}
+			_, attrs, err := splitCompositeKey(key)
+			if err == nil && len(attrs) >= 2 {
+				seen[attrs[1]] = true
+			}
+		}
+		keysIter.Close()
+	}
+	return sortedKeys(seen), nil
+}
+
+// ============================================================================================================================
+// Get Program Stats - entity counts and balances by role, recent transaction volume and running campaigns
+// ============================================================================================================================
+func (t *SimpleChaincode) getProgramStats(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// *"Days"*
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at most 1")
+	}
+	stats := ProgramStats{Roles: map[string]RoleStats{}, Days: defaultStatsDays}
+	if len(args) == 1 {
+		days, err := strconv.Atoi(args[0])
+		if (err != nil) || (days < 1) || (days > maxStatsDays) {
+			return nil, errors.New("1st argument must be an integer between 1 and " + strconv.Itoa(maxStatsDays))
+		}
+		stats.Days = days
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := counterRoles(stub)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		count, err := readCounter(stub, entityCountCounter, role)
+		if err != nil {
+			return nil, err
+		}
+		if count <= 0 {
+			continue
+		}
+		rs := RoleStats{Entities: int(count)}
+		rs.PtBal, err = readCounter(stub, pointsTotalCounter, role)
+		if err != nil {
+			return nil, err
+		}
+		rs.TxnBal, err = readCounter(stub, txnBalTotalCounter, role)
+		if err != nil {
+			return nil, err
+		}
+		rs.AvgPtBal = rs.PtBal / count
+		rs.AvgTxnBal = rs.TxnBal / count
+		stats.Roles[role] = rs
+		stats.Entities = stats.Entities + rs.Entities
+		stats.PtBal = stats.PtBal + rs.PtBal
+	}
+	if stats.Entities > 0 {
+		stats.AvgPtBal = stats.PtBal / float64(stats.Entities)
+	}
+
+	for i := 0; i < stats.Days; i++ {
+		count, err := readCounter(stub, txnCountCounter, statsDay(now-int64(i)*24*60*60))
+		if err != nil {
+			return nil, err
+		}
+		stats.Transactions = stats.Transactions + int(count)
+	}
+	schedules, err := readCounter(stub, activeSchedulesCounter)
+	if err != nil {
+		return nil, err
+	}
+	stats.ActiveSchedules = int(schedules)
+
+	startKey, err := createCompositeKey(promoEndType, []string{timestampKey(now + 1)})
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(promoEndType, nil)
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey+maxUnicodeRuneValue)
+	if err != nil {
+		return nil, errors.New("Failed to get promo codes")
+	}
+	defer keysIter.Close()
+	for keysIter.HasNext() {
+		_, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get promo codes")
+		}
+		stats.LivePromoCodes++
+	}
+
+	jsonAsBytes, _ := json.Marshal(stats)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Rebuild Program Stats - admin only, recount the entity and schedule counters from the records themselves
+// ============================================================================================================================
+func (t *SimpleChaincode) rebuildProgramStats(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+
+	stub.log.debug("start rebuild program stats")
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]float64{}
+	ptBals := map[string]float64{}
+	txnBals := map[string]float64{}
+	for _, name := range entityIndex {
+		entityAsBytes, err := stub.GetState(name)
+		if err != nil {
+			return nil, errors.New("Failed to get state for " + name)
+		}
+		if entityAsBytes == nil {
+			continue
+		}
+		var entity Entity
+		err = unmarshalState(name, entityAsBytes, &entity)
+		if err != nil {
+			return nil, err
+		}
+		counts[entity.Role] = counts[entity.Role] + 1
+		ptBals[entity.Role] = ptBals[entity.Role] + entity.PtBal
+		txnBals[entity.Role] = txnBals[entity.Role] + entity.TxnBal
+	}
+
+	roles, err := counterRoles(stub)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles { //roles that no longer have entities go back to zero
+		if _, ok := counts[role]; !ok {
+			counts[role] = 0
+		}
+	}
+	for _, role := range sortedKeys(counts) {
+		err = setCounter(stub, counts[role], entityCountCounter, role)
+		if err != nil {
+			return nil, err
+		}
+		err = setCounter(stub, ptBals[role], pointsTotalCounter, role)
+		if err != nil {
+			return nil, err
+		}
+		err = setCounter(stub, txnBals[role], txnBalTotalCounter, role)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	active := 0
+	keysIter, err := getStateByPartialCompositeKey(stub, scheduleType, []string{})
+	if err != nil {
+		return nil, errors.New("Failed to get schedules")
+	}
+	for keysIter.HasNext() {
+		key, scheduleAsBytes, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return nil, errors.New("Failed to get schedules")
+		}
+		var schedule Schedule
+		err = unmarshalState(key, scheduleAsBytes, &schedule)
+		if err != nil {
+			keysIter.Close()
+			return nil, err
+		}
+		if schedule.Active {
+			active++
+		}
+	}
+	keysIter.Close()
+	err = setCounter(stub, float64(active), activeSchedulesCounter)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end rebuild program stats")
+	return nil, nil
+}
@@ -0,0 +1,195 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var storeType = "store" //composite key object type linking a merchant to its store locations, keyed by parent then store
+
+// ============================================================================================================================
+// countIssued - add points a merchant issued to its issued counter, and to its parent's when it is a store location
+// ============================================================================================================================
+func countIssued(stub *programStub, merchant string, points float64) error {
+	err := addToCounter(stub, points, "issued", merchant)
+	if err != nil {
+		return err
+	}
+	entity, err := storedEntity(stub, merchant)
+	if err != nil {
+		return err
+	}
+	if entity == nil || entity.Parent == "" {
+		return nil
+	}
+	return addToCounter(stub, points, "issued", entity.Parent)
+}
+
+// ============================================================================================================================
+// settlementMerchant - the merchant that settles in cash for a merchant, its parent when it is a store location
+// ============================================================================================================================
+func settlementMerchant(merchant Entity) string {
+	if merchant.Parent != "" {
+		return merchant.Parent
+	}
+	return merchant.Name
+}
+
+// ============================================================================================================================
+// isStoreOf - true if the entity is a store location of the merchant, so the merchant may act for it
+// ============================================================================================================================
+func isStoreOf(stub *programStub, name string, parent string) bool {
+	entity, err := storedEntity(stub, name)
+	return err == nil && entity != nil && entity.Parent != "" && entity.Parent == parent
+}
+
+// ============================================================================================================================
+// storeNames - the store locations of a merchant, in name order
+// ============================================================================================================================
+func storeNames(stub *programStub, parent string) ([]string, error) {
+	keysIter, err := getStateByPartialCompositeKey(stub, storeType, []string{parent})
+	if err != nil {
+		return nil, errors.New("Failed to get stores of " + parent)
+	}
+	defer keysIter.Close()
+
+	var names []string
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get stores of " + parent)
+		}
+		_, keyParts, err := splitCompositeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, keyParts[1])
+	}
+	return names, nil
+}
+
+// ============================================================================================================================
+// Create Store - a merchant adds a store location, a merchant entity of its own that settles through the parent. The
+// store accepts the parent's categories unless others are given.
+// ============================================================================================================================
+func (t *SimpleChaincode) createStore(stub *programStub, args []string) ([]byte, error) {
+	//    0         1           2
+	// "Parent", "Store" *"Category", ...*
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 2")
+	}
+	parent, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if parent.Role != merchantRole {
+		return nil, errors.New(parent.Name + " is not a merchant")
+	}
+	if parent.Parent != "" {
+		return nil, errors.New(parent.Name + " is itself a store of " + parent.Parent)
+	}
+	if len(args[1]) <= 0 {
+		return nil, errors.New("2nd argument must be a non-empty string")
+	}
+	err = checkEntityName(args[1])
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := resolveAlias(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	existing, err := storedEntity(stub, canonical)
+	if err != nil {
+		return nil, err
+	}
+	if canonical != args[1] || existing != nil {
+		return nil, errors.New(args[1] + " already exists")
+	}
+
+	stub.log.debug("start create store")
+	store := Entity{Name: args[1], Role: merchantRole, Parent: parent.Name, Categories: parent.Categories}
+	if len(args) > 2 {
+		store.Categories = nil
+		for _, category := range args[2:] {
+			if len(category) <= 0 {
+				return nil, errors.New("Categories must be non-empty strings")
+			}
+			if !hasCategory(store, category) {
+				store.Categories = append(store.Categories, category)
+			}
+		}
+	}
+	err = putEntity(stub, store)
+	if err != nil {
+		return nil, err
+	}
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	entityIndex = append(entityIndex, store.Name)
+	jsonAsBytes, _ := json.Marshal(entityIndex)
+	err = putLargeState(stub, entityIndexStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := createCompositeKey(storeType, []string{parent.Name, store.Name})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, []byte{0x00})
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end create store")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// List Stores - the store locations of a merchant
+// ============================================================================================================================
+func (t *SimpleChaincode) listStores(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the merchant to query")
+	}
+	parent, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	names, err := storeNames(stub, parent.Name)
+	if err != nil {
+		return nil, err
+	}
+	stores := []Entity{}
+	for _, name := range names {
+		store, err := storedEntity(stub, name)
+		if err != nil {
+			return nil, err
+		}
+		if store != nil {
+			stores = append(stores, *store)
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(stores)
+	return jsonAsBytes, nil
+}
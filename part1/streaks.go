@@ -0,0 +1,119 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+var maxStreakMerchants = 32 //streaks kept per customer, the merchant earned at longest ago is dropped first
+
+// PurchaseStreak is a customer's run of earns with a purchase at one merchant, on consecutive UTC days and in
+// consecutive weeks starting Monday, as of the last such earn
+type PurchaseStreak struct {
+	Merchant     string `json:"merchant"`
+	Days         int    `json:"days"`
+	LongestDays  int    `json:"longest_days"`
+	Weeks        int    `json:"weeks"`
+	LongestWeeks int    `json:"longest_weeks"`
+	LastEarn     int64  `json:"last_earn"`
+}
+
+// ============================================================================================================================
+// streakDay - the UTC day number of a timestamp
+// ============================================================================================================================
+func streakDay(timestamp int64) int64 {
+	return timestamp / secondsPerDay
+}
+
+// ============================================================================================================================
+// streakWeek - the number of the Monday starting week of a timestamp, day 0 of the unix epoch was a Thursday
+// ============================================================================================================================
+func streakWeek(timestamp int64) int64 {
+	return (streakDay(timestamp) + 3) / 7
+}
+
+// ============================================================================================================================
+// extendStreak - a streak after an earn at the timestamp, a second earn in the same day or week leaves that run as is
+// ============================================================================================================================
+func extendStreak(streak PurchaseStreak, timestamp int64) PurchaseStreak {
+	if streak.LastEarn == 0 {
+		streak.Days, streak.Weeks = 1, 1
+	} else {
+		switch streakDay(timestamp) - streakDay(streak.LastEarn) {
+		case 0:
+		case 1:
+			streak.Days++
+		default:
+			streak.Days = 1
+		}
+		switch streakWeek(timestamp) - streakWeek(streak.LastEarn) {
+		case 0:
+		case 1:
+			streak.Weeks++
+		default:
+			streak.Weeks = 1
+		}
+	}
+	if streak.Days > streak.LongestDays {
+		streak.LongestDays = streak.Days
+	}
+	if streak.Weeks > streak.LongestWeeks {
+		streak.LongestWeeks = streak.Weeks
+	}
+	streak.LastEarn = timestamp
+	return streak
+}
+
+// ============================================================================================================================
+// nextStreak - the customer's streak at the merchant once an earn at the timestamp is counted, nothing is changed
+// ============================================================================================================================
+func nextStreak(customer Entity, merchant string, timestamp int64) PurchaseStreak {
+	for _, streak := range customer.Streaks {
+		if streak.Merchant == merchant {
+			return extendStreak(streak, timestamp)
+		}
+	}
+	return extendStreak(PurchaseStreak{Merchant: merchant}, timestamp)
+}
+
+// ============================================================================================================================
+// recordStreak - store an updated streak on the customer, streaks stay in merchant order
+// ============================================================================================================================
+func recordStreak(customer *Entity, streak PurchaseStreak) {
+	for i := range customer.Streaks {
+		if customer.Streaks[i].Merchant == streak.Merchant {
+			customer.Streaks[i] = streak
+			return
+		}
+	}
+	if len(customer.Streaks) >= maxStreakMerchants {
+		oldest := 0
+		for i := range customer.Streaks {
+			if customer.Streaks[i].LastEarn < customer.Streaks[oldest].LastEarn {
+				oldest = i
+			}
+		}
+		customer.Streaks = append(customer.Streaks[:oldest], customer.Streaks[oldest+1:]...)
+	}
+	i := 0
+	for i < len(customer.Streaks) && customer.Streaks[i].Merchant < streak.Merchant {
+		i++
+	}
+	customer.Streaks = append(customer.Streaks, PurchaseStreak{})
+	copy(customer.Streaks[i+1:], customer.Streaks[i:])
+	customer.Streaks[i] = streak
+}
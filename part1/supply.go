@@ -0,0 +1,224 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+var supplyCounter = "supply" //delta counter holding the total points in circulation
+
+// SupplyEvent is the payload of the SupplyChanged chaincode event
+type SupplyEvent struct {
+	Change float64 `json:"change"`
+	Total  float64 `json:"total,omitempty"` //only known when a supply cap made us read the counter
+	Reason string  `json:"reason"`          //create, earn, mint, burn, reversal, grant or bridge
+	TxID   string  `json:"txid"`
+}
+
+// ============================================================================================================================
+// getSupply - total points in circulation
+// ============================================================================================================================
+func getSupply(stub *programStub) (float64, error) {
+	return readCounter(stub, supplyCounter)
+}
+
+// ============================================================================================================================
+// changeSupply - add (or with a negative change remove) points from circulation and emit a SupplyChanged event. The
+// supply is only read, and so only contended, when a maximum supply is configured and the change grows it.
+// ============================================================================================================================
+func changeSupply(stub *programStub, change float64, reason string) error {
+	if change == 0 {
+		return nil
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	event := SupplyEvent{Change: change, Reason: reason, TxID: stub.UUID}
+	if change > 0 && config.MaxSupply > 0 {
+		supply, err := getSupply(stub)
+		if err != nil {
+			return err
+		}
+		if supply+change > config.MaxSupply {
+			stub.log.warning("Supply cap reached")
+			return errors.New("Maximum supply of " + formatAmount(config.MaxSupply) + " points would be exceeded")
+		}
+		event.Total = supply + change
+	}
+
+	err = addToCounter(stub, change, supplyCounter)
+	if err != nil {
+		return err
+	}
+	return emitEvent(stub, "SupplyChanged", nil, event)
+}
+
+// ============================================================================================================================
+// Set Max Supply - admin only, cap the total points in circulation, 0 removes the cap
+// ============================================================================================================================
+func (t *SimpleChaincode) setMaxSupply(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// "1000000"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	maxSupply, err := parseAmount(args[0])
+	if (err != nil) || (maxSupply < 0) {
+		return nil, amountError("1st argument must be a non-negative numeric string", err)
+	}
+	supply, err := getSupply(stub)
+	if err != nil {
+		return nil, err
+	}
+	if maxSupply > 0 && maxSupply < supply {
+		return nil, errors.New("Maximum supply is below the points already in circulation")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.MaxSupply = maxSupply
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// checkDirectMint - error when new points may only come into circulation through signed mint proposals, every path that
+// creates points on a single caller's authority checks this before it credits anything
+// ============================================================================================================================
+func checkDirectMint(config Config) error {
+	if config.Features.enabled(approvalsRequiredFlag) {
+		return errors.New("Minting requires approval, use propose_mint")
+	}
+	if config.MintThreshold > 1 {
+		return errors.New("Minting requires " + strconv.Itoa(config.MintThreshold) + " signatures, use propose_mint")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Mint Points - admin only, credit new points to the treasury, only allowed when minting needs a single signature
+// ============================================================================================================================
+func (t *SimpleChaincode) mintPoints(stub *programStub, args []string) ([]byte, error) {
+	//    0          1
+	// "Points", "Reason"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	points, err := parseAmount(args[0])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("1st argument must be a positive numeric string", err)
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	if config.Treasury == "" {
+		return nil, errors.New("Treasury is not configured")
+	}
+	err = checkDirectMint(config)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start mint points")
+	treasury, err := getEntity(stub, config.Treasury)
+	if err != nil {
+		return nil, err
+	}
+	before := treasury
+	treasury.PtBal = treasury.PtBal + points
+	err = putEntity(stub, treasury)
+	if err != nil {
+		return nil, err
+	}
+	err = recordTxn(stub, TxnRecord{Type: "mint", To: treasury.Name, Points: points, Reference: args[1]}, balanceChange(before, treasury))
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(stub, points, "mint")
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end mint points")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Burn Points - admin only, remove points from an entity and from circulation
+// ============================================================================================================================
+func (t *SimpleChaincode) burnPoints(stub *programStub, args []string) ([]byte, error) {
+	//   0        1          2
+	// "Name", "Points", "Reason"
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	points, err := parseAmount(args[1])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("2nd argument must be a positive numeric string", err)
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if transferablePoints(entity) < points {
+		return nil, errors.New("Insufficient points")
+	}
+
+	stub.log.debug("start burn points")
+	before := entity
+	entity.PtBal = entity.PtBal - points
+	err = putEntity(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	err = recordTxn(stub, TxnRecord{Type: "burn", From: entity.Name, Points: points, Reference: args[2]}, balanceChange(before, entity))
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(stub, -points, "burn")
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end burn points")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Get Supply - total points in circulation and the configured cap
+// ============================================================================================================================
+func (t *SimpleChaincode) getSupplyQuery(stub *programStub, args []string) ([]byte, error) {
+	supply, err := getSupply(stub)
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(`{"total": ` + formatAmount(supply) + `, "max": ` + formatAmount(config.MaxSupply) + `}`), nil
+}
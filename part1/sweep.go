@@ -0,0 +1,155 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var defaultSweepPageSize = 100
+var maxSweepPageSize = 500
+
+// DormantAccount is an entity found by a sweep, with the points it lost when a reclaim pool was given
+type DormantAccount struct {
+	Name         string  `json:"name"`
+	LastActivity int64   `json:"last_activity"`
+	PtBal        float64 `json:"ptbal"`
+	Expired      float64 `json:"expired"`
+}
+
+// SweepPage is one page of sweep_dormant_accounts, pass the bookmark back to sweep the next one
+type SweepPage struct {
+	Dormant  []DormantAccount `json:"dormant"`
+	Scanned  int              `json:"scanned"`
+	Bookmark string           `json:"bookmark"` //last name scanned, empty once every entity was scanned
+}
+
+// ============================================================================================================================
+// Sweep Dormant Accounts - admin only, find entities with no balance change for N days, a page of names at a time in
+// name order, and move their spendable points to the reclaim pool when one is given. Entities written before activity
+// was tracked have no last activity; the sweep starts their clock instead of treating them as dormant.
+// ============================================================================================================================
+func (t *SimpleChaincode) sweepDormantAccounts(stub *programStub, args []string) ([]byte, error) {
+	//    0          1             2             3
+	// "Days" *"ReclaimPool"* *"PageSize"* *"Bookmark"*
+	if len(args) < 1 || len(args) > 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 to 4")
+	}
+	days, err := strconv.Atoi(args[0])
+	if (err != nil) || (days < 1) {
+		return nil, errors.New("1st argument must be a positive integer")
+	}
+	var pool *Entity
+	if len(args) >= 2 && args[1] != "" {
+		entity, err := getEntity(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+		pool = &entity
+		config, err := getConfig(stub)
+		if err != nil {
+			return nil, err
+		}
+		if !config.Features.enabled(expiryEnabledFlag) {
+			return nil, errors.New("Point expiry is disabled, sweep without a reclaim pool")
+		}
+	}
+	pageSize := defaultSweepPageSize
+	if len(args) >= 3 && args[2] != "" {
+		pageSize, err = strconv.Atoi(args[2])
+		if (err != nil) || (pageSize < 1) || (pageSize > maxSweepPageSize) {
+			return nil, errors.New("3rd argument must be an integer between 1 and " + strconv.Itoa(maxSweepPageSize))
+		}
+	}
+	bookmark := ""
+	if len(args) == 4 {
+		bookmark = args[3]
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := now - int64(days)*24*60*60
+
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+	names := namesAfter(entityIndex, bookmark)
+
+	stub.log.debug("start sweep dormant accounts")
+	page := SweepPage{Dormant: []DormantAccount{}}
+	for i := 0; i < len(names) && page.Scanned < pageSize; i++ {
+		page.Scanned++
+		page.Bookmark = names[i]
+		if pool != nil && names[i] == pool.Name {
+			continue
+		}
+		entity, err := storedEntity(stub, names[i])
+		if err != nil {
+			return nil, err
+		}
+		if entity == nil {
+			continue
+		}
+		if entity.LastActivity == 0 {
+			err = writeEntity(stub, entity, *entity) //starts the dormancy clock now
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if entity.LastActivity > cutoff {
+			continue
+		}
+
+		dormant := DormantAccount{entity.Name, entity.LastActivity, entity.PtBal, 0}
+		expired := entity.PtBal - entity.Locked - entity.Unvested //gift card backing and vesting grants stay put
+		if pool != nil && expired > 0 {
+			before, poolBefore := *entity, *pool
+			entity.PtBal = entity.PtBal - expired
+			entity.Restricted = nil
+			pool.PtBal = pool.PtBal + expired
+			err = putEntity(stub, *entity)
+			if err != nil {
+				return nil, err
+			}
+			err = putEntity(stub, *pool)
+			if err != nil {
+				return nil, err
+			}
+			err = recordTxn(stub, TxnRecord{Type: "expire", From: entity.Name, To: pool.Name, Points: expired},
+				balanceChange(before, *entity), balanceChange(poolBefore, *pool))
+			if err != nil {
+				return nil, err
+			}
+			dormant.Expired = expired
+		}
+		page.Dormant = append(page.Dormant, dormant)
+	}
+	if page.Scanned >= len(names) {
+		page.Bookmark = ""
+	}
+	stub.log.debug("end sweep dormant accounts, " + strconv.Itoa(len(page.Dormant)) + " dormant")
+	jsonAsBytes, _ := json.Marshal(page)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,186 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// WithholdingRule withholds part of large earns for a tax authority where reward points are taxable. Only the part of
+// an earn above the threshold is taxed, so an earn just over it does not lose more than one just under.
+type WithholdingRule struct {
+	Percent   float64 `json:"percent"`   //of the earn above the threshold, 0 disables withholding
+	Threshold float64 `json:"threshold"` //points per earn that are never withheld
+	Authority string  `json:"authority"` //entity the withheld points are credited to
+}
+
+// TaxReportLine is what was withheld from one member over the report period
+type TaxReportLine struct {
+	Member   string  `json:"member"`
+	Earns    int     `json:"earns"`
+	Withheld float64 `json:"withheld"`
+}
+
+// TaxReport totals the points withheld for a tax authority over a period
+type TaxReport struct {
+	Authority string          `json:"authority"`
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Earns     int             `json:"earns"`
+	Withheld  float64         `json:"withheld"`
+	Members   []TaxReportLine `json:"members"` //in member name order
+}
+
+// ============================================================================================================================
+// withholdEarn - move the withholding due on an earn from the member to the tax authority, out of the category the earn
+// was restricted to first
+// ============================================================================================================================
+func withholdEarn(stub *programStub, member string, points float64, category string) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	rule := config.Withholding
+	if rule.Percent <= 0 || points <= rule.Threshold || rule.Authority == member {
+		return nil
+	}
+	withheld := roundPoints(config.Bounds, (points-rule.Threshold)*rule.Percent/100)
+	if withheld <= 0 {
+		return nil
+	}
+	customer, err := getEntity(stub, member)
+	if err != nil {
+		return err
+	}
+	authority, err := getEntity(stub, rule.Authority)
+	if err != nil {
+		return err
+	}
+
+	stub.log.debug("withholding " + formatAmount(withheld) + " points for " + authority.Name)
+	customerBefore, authorityBefore := customer, authority
+	customer.PtBal = customer.PtBal - withheld
+	if category != "" && customer.Restricted[category] > 0 {
+		customer.Restricted[category] = customer.Restricted[category] - withheld
+		if customer.Restricted[category] <= booksTolerance {
+			delete(customer.Restricted, category)
+		}
+	}
+	authority.PtBal = authority.PtBal + withheld
+	err = putEntity(stub, customer)
+	if err != nil {
+		return err
+	}
+	err = putEntity(stub, authority)
+	if err != nil {
+		return err
+	}
+	return recordTxn(stub, TxnRecord{Type: "withholding", From: customer.Name, To: authority.Name, Points: withheld},
+		balanceChange(customerBefore, customer), balanceChange(authorityBefore, authority))
+}
+
+// ============================================================================================================================
+// Set Withholding - admin only, withhold a percentage of the part of each earn above a threshold for a tax authority
+// ============================================================================================================================
+func (t *SimpleChaincode) setWithholding(stub *programStub, args []string) ([]byte, error) {
+	//     0           1            2
+	// "Authority", "Percent", "Threshold"   (percent 0 disables withholding)
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3")
+	}
+	percent, err := parseAmount(args[1])
+	if (err != nil) || (percent < 0) || (percent >= 100) {
+		return nil, amountError("2nd argument must be a non-negative numeric string below 100", err)
+	}
+	threshold, err := parseAmount(args[2])
+	if (err != nil) || (threshold < 0) {
+		return nil, amountError("3rd argument must be a non-negative numeric string", err)
+	}
+	authority, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.Withholding = WithholdingRule{percent, threshold, authority.Name}
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// Tax Report - points withheld for the tax authority between two dates, per member
+// ============================================================================================================================
+func (t *SimpleChaincode) taxReport(stub *programStub, args []string) ([]byte, error) {
+	//      0             1              2
+	// "2016-07-01", "2016-07-31" *"Authority"*   (defaults to the configured authority)
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	from, to, err := parseDateRange(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	authority := config.Withholding.Authority
+	if len(args) == 3 {
+		authority = args[2]
+	}
+	if authority == "" {
+		return nil, errors.New("No tax authority is configured")
+	}
+	records, err := getTxnRecords(stub, authority, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := TaxReport{Authority: authority, From: args[0], To: args[1], Members: []TaxReportLine{}}
+	members := map[string]*TaxReportLine{}
+	for _, rec := range records {
+		if rec.Type != "withholding" || rec.To != authority {
+			continue
+		}
+		line, ok := members[rec.From]
+		if !ok {
+			line = &TaxReportLine{Member: rec.From}
+			members[rec.From] = line
+		}
+		line.Earns++
+		line.Withheld = line.Withheld + rec.Points
+		report.Earns++
+		report.Withheld = report.Withheld + rec.Points
+	}
+	for _, name := range sortedKeys(members) {
+		report.Members = append(report.Members, *members[name])
+	}
+	stub.log.debug("tax report for " + authority + ", " + strconv.Itoa(report.Earns) + " withholdings")
+	jsonAsBytes, _ := json.Marshal(report)
+	return jsonAsBytes, nil
+}
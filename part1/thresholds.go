@@ -0,0 +1,105 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// AlertLevels are the program wide point balances whose crossing raises a dedicated event
+type AlertLevels struct {
+	Floor *float64  `json:"floor,omitempty"` //BalanceBelowFloor when a balance drops below it, nil for none
+	Tiers []float64 `json:"tiers,omitempty"` //TierCrossed when a balance rises to or past one, ascending
+}
+
+// ThresholdCrossing is the detail of a BalanceBelowFloor or TierCrossed event
+type ThresholdCrossing struct {
+	Entity      string  `json:"entity"`
+	Threshold   float64 `json:"threshold"`
+	PtBalBefore float64 `json:"ptbal_before"`
+	PtBalAfter  float64 `json:"ptbal_after"`
+}
+
+// ============================================================================================================================
+// emitThresholdEvents - raise an event for every configured threshold a balance change crossed
+// ============================================================================================================================
+func emitThresholdEvents(stub *programStub, balances []BalanceChange) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	alerts := config.Alerts
+	for _, change := range balances {
+		if alerts.Floor != nil && change.PtBalBefore >= *alerts.Floor && change.PtBalAfter < *alerts.Floor {
+			err = emitEvent(stub, "BalanceBelowFloor", []BalanceChange{change}, ThresholdCrossing{change.Entity, *alerts.Floor, change.PtBalBefore, change.PtBalAfter})
+			if err != nil {
+				return err
+			}
+		}
+		for _, tier := range alerts.Tiers {
+			if change.PtBalBefore < tier && change.PtBalAfter >= tier {
+				err = emitEvent(stub, "TierCrossed", []BalanceChange{change}, ThresholdCrossing{change.Entity, tier, change.PtBalBefore, change.PtBalAfter})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Set Balance Alerts - admin only, the floor and tier boundaries whose crossing raises an event
+// ============================================================================================================================
+func (t *SimpleChaincode) setBalanceAlerts(stub *programStub, args []string) ([]byte, error) {
+	//        0            1        2
+	// "Floor|none", "Tier1", "Tier2", ...
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting at least 1")
+	}
+	var alerts AlertLevels
+	if args[0] != "none" {
+		floor, err := parseAmount(args[0])
+		if (err != nil) || (floor < 0) {
+			return nil, amountError("1st argument must be a non-negative numeric string or none", err)
+		}
+		alerts.Floor = &floor
+	}
+	for i := 1; i < len(args); i++ {
+		tier, err := parseAmount(args[i])
+		if (err != nil) || (tier <= 0) {
+			return nil, amountError("Tier boundaries must be positive numeric strings", err)
+		}
+		alerts.Tiers = append(alerts.Tiers, tier)
+	}
+	sort.Float64s(alerts.Tiers)
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.Alerts = alerts
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
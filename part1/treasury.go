@@ -0,0 +1,257 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+var mintProposalType = "mintproposal" //composite key object type for treasury mint proposals, keyed by proposal id
+var defaultMintProposalTTL = int64(7 * 24 * 60 * 60)
+
+// MintProposal is a request to mint points into the treasury, executed once enough admins have signed it
+type MintProposal struct {
+	ID        string   `json:"id"`
+	Points    float64  `json:"points"`
+	Reason    string   `json:"reason"`
+	Signers   []string `json:"signers"` //caller fingerprints of the admins that signed, proposer first
+	Threshold int      `json:"threshold"`
+	Status    string   `json:"status"` //open, executed or expired
+	CreatedAt int64    `json:"created_at"`
+	ExpiresAt int64    `json:"expires_at"`
+}
+
+// ============================================================================================================================
+// Set Treasury - admin only, name the treasury entity and the M of N signatures needed to mint into it
+// ============================================================================================================================
+func (t *SimpleChaincode) setTreasury(stub *programStub, args []string) ([]byte, error) {
+	//    0          1          2
+	// "Name", "Threshold" *"TTLSeconds"*
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	treasury, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	threshold, err := strconv.Atoi(args[1])
+	if (err != nil) || (threshold < 1) {
+		return nil, errors.New("2nd argument must be a positive integer")
+	}
+	ttl := defaultMintProposalTTL
+	if len(args) == 3 {
+		ttl, err = strconv.ParseInt(args[2], 10, 64)
+		if (err != nil) || (ttl <= 0) {
+			return nil, errors.New("3rd argument must be a positive integer")
+		}
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.Treasury = treasury.Name
+	config.MintThreshold = threshold
+	config.MintProposalTTL = ttl
+	err = putConfig(stub, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getMintProposalRecord - fetch a mint proposal by id
+// ============================================================================================================================
+func getMintProposalRecord(stub *programStub, id string) (MintProposal, string, error) {
+	var proposal MintProposal
+	key, err := createCompositeKey(mintProposalType, []string{id})
+	if err != nil {
+		return proposal, "", err
+	}
+	proposalAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return proposal, key, errors.New("Failed to get mint proposal")
+	}
+	if proposalAsBytes == nil {
+		return proposal, key, errors.New("Mint proposal " + id + " does not exist")
+	}
+	err = unmarshalState(key, proposalAsBytes, &proposal)
+	if err != nil {
+		return proposal, key, err
+	}
+	return proposal, key, nil
+}
+
+// ============================================================================================================================
+// Propose Mint - admin only, open a proposal to mint points into the treasury, the proposer's signature counts
+// ============================================================================================================================
+func (t *SimpleChaincode) proposeMint(stub *programStub, args []string) ([]byte, error) {
+	//    0          1
+	// "Points", "Reason"
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	}
+	signer, err := callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	points, err := parseAmount(args[0])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("1st argument must be a positive numeric string", err)
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	if config.Treasury == "" {
+		return nil, errors.New("Treasury is not configured")
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := config.MintThreshold
+	if config.Features.enabled(approvalsRequiredFlag) && threshold < 2 {
+		threshold = 2 //the proposer's signature is not an approval
+	}
+
+	stub.log.debug("start propose mint")
+	proposal := MintProposal{
+		ID:        newID(stub, mintProposalType),
+		Points:    points,
+		Reason:    args[1],
+		Signers:   []string{signer},
+		Threshold: threshold,
+		Status:    "open",
+		CreatedAt: timestamp,
+		ExpiresAt: timestamp + config.MintProposalTTL,
+	}
+	err = t.executeMintIfReady(stub, &proposal, config)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end propose mint")
+	return []byte(proposal.ID), nil
+}
+
+// ============================================================================================================================
+// Sign Mint - admin only, add a signature to an open mint proposal, it executes when the threshold is reached
+// ============================================================================================================================
+func (t *SimpleChaincode) signMint(stub *programStub, args []string) ([]byte, error) {
+	//    0
+	// "ProposalID"
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	signer, err := callerID(stub)
+	if err != nil {
+		return nil, err
+	}
+	proposal, key, err := getMintProposalRecord(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if proposal.Status != "open" {
+		return nil, errors.New("Mint proposal is " + proposal.Status)
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp >= proposal.ExpiresAt {
+		proposal.Status = "expired"
+		jsonAsBytes, _ := json.Marshal(proposal)
+		err = stub.PutState(key, jsonAsBytes)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(proposal.Status), nil
+	}
+	for _, s := range proposal.Signers {
+		if s == signer {
+			return nil, errors.New("Caller already signed this proposal")
+		}
+	}
+
+	proposal.Signers = append(proposal.Signers, signer)
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.executeMintIfReady(stub, &proposal, config)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(proposal.Status), nil
+}
+
+// ============================================================================================================================
+// executeMintIfReady - credit the treasury once a proposal has enough signatures, then store the proposal
+// ============================================================================================================================
+func (t *SimpleChaincode) executeMintIfReady(stub *programStub, proposal *MintProposal, config Config) error {
+	if len(proposal.Signers) >= proposal.Threshold {
+		treasury, err := getEntity(stub, config.Treasury)
+		if err != nil {
+			return err
+		}
+		before := treasury
+		treasury.PtBal = treasury.PtBal + proposal.Points
+		err = putEntity(stub, treasury)
+		if err != nil {
+			return err
+		}
+		err = recordTxn(stub, TxnRecord{Type: "mint", To: treasury.Name, Points: proposal.Points, Reference: proposal.ID}, balanceChange(before, treasury))
+		if err != nil {
+			return err
+		}
+		err = changeSupply(stub, proposal.Points, "mint")
+		if err != nil {
+			return err
+		}
+		proposal.Status = "executed"
+		stub.log.info("mint proposal " + proposal.ID + " executed")
+	}
+
+	key, err := createCompositeKey(mintProposalType, []string{proposal.ID})
+	if err != nil {
+		return err
+	}
+	jsonAsBytes, _ := json.Marshal(proposal)
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// Get Mint Proposal - return a mint proposal by id
+// ============================================================================================================================
+func (t *SimpleChaincode) getMintProposal(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id of the proposal to query")
+	}
+	proposal, _, err := getMintProposalRecord(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(proposal)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,178 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+// TrialDiscrepancy is something verify_trial_balance found that does not add up
+type TrialDiscrepancy struct {
+	Kind     string  `json:"kind"` //entry, posting, total or supply
+	EntryID  string  `json:"entry_id,omitempty"`
+	RecordID string  `json:"record_id,omitempty"`
+	Account  string  `json:"account,omitempty"`
+	Expected float64 `json:"expected"`
+	Actual   float64 `json:"actual"`
+}
+
+// TrialBalance is the result of verify_trial_balance
+type TrialBalance struct {
+	From          int64              `json:"from"`
+	To            int64              `json:"to"` //exclusive
+	Entries       int                `json:"entries"`
+	Debits        float64            `json:"debits"`
+	Credits       float64            `json:"credits"`
+	Supply        float64            `json:"supply"`         //points in circulation at To according to the journal
+	SupplyChecked string             `json:"supply_checked"` //counter, snapshot, or empty when neither covers To
+	Balanced      bool               `json:"balanced"`
+	Discrepancies []TrialDiscrepancy `json:"discrepancies"`
+}
+
+// ============================================================================================================================
+// Verify Trial Balance - sum every journal debit and credit of a period, check each entry and the period net to zero and
+// that every line was posted to its account, and check the supply the journal implies at the end of the period against
+// the supply counter, or against the snapshot when the period ends with a closed month
+// ============================================================================================================================
+func (t *SimpleChaincode) verifyTrialBalance(stub *programStub, args []string) ([]byte, error) {
+	//      0             1
+	// "2016-07-01", "2016-07-31"   or   "2016-07"
+	var from, to int64
+	var err error
+	if len(args) == 1 {
+		from, to, err = parsePeriod(args[0])
+	} else if len(args) == 2 {
+		from, to, err = parseDateRange(args[0], args[1])
+	} else {
+		return nil, errors.New("Incorrect number of arguments. Expecting a month or a start and end date")
+	}
+	if err != nil {
+		return nil, err
+	}
+	startKey, err := createCompositeKey(journalType, []string{timestampKey(from)})
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := createCompositeKey(journalType, []string{timestampKey(to)})
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, errors.New("Failed to get journal entries")
+	}
+	defer keysIter.Close()
+
+	report := TrialBalance{From: from, To: to, Discrepancies: []TrialDiscrepancy{}}
+	for keysIter.HasNext() {
+		key, entryAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get journal entries")
+		}
+		var entry JournalEntry
+		err = unmarshalState(key, entryAsBytes, &entry)
+		if err != nil {
+			return nil, err
+		}
+		report.Entries++
+		var debits, credits float64
+		for _, line := range entry.Lines {
+			debits = debits + line.Debit
+			credits = credits + line.Credit
+			postingKey, err := createCompositeKey(postingType, []string{line.Account, timestampKey(entry.Timestamp), entry.ID})
+			if err != nil {
+				return nil, err
+			}
+			changeAsBytes, err := stub.GetState(postingKey)
+			if err != nil {
+				return nil, errors.New("Failed to get journal posting")
+			}
+			posted := 0.0
+			if changeAsBytes != nil {
+				posted, err = parseStateFloat(postingKey, changeAsBytes)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if changeAsBytes == nil || math.Abs(posted-(line.Credit-line.Debit)) > booksTolerance {
+				report.Discrepancies = append(report.Discrepancies, TrialDiscrepancy{Kind: "posting", EntryID: entry.ID, RecordID: entry.RecordID,
+					Account: line.Account, Expected: line.Credit - line.Debit, Actual: posted})
+			}
+		}
+		if math.Abs(debits-credits) > booksTolerance {
+			report.Discrepancies = append(report.Discrepancies, TrialDiscrepancy{Kind: "entry", EntryID: entry.ID, RecordID: entry.RecordID,
+				Expected: debits, Actual: credits})
+		}
+		report.Debits = report.Debits + debits
+		report.Credits = report.Credits + credits
+	}
+	if math.Abs(report.Debits-report.Credits) > booksTolerance {
+		report.Discrepancies = append(report.Discrepancies, TrialDiscrepancy{Kind: "total", Expected: report.Debits, Actual: report.Credits})
+	}
+
+	issuance, err := postingsBetween(stub, issuanceAccount, 0, to)
+	if err != nil {
+		return nil, err
+	}
+	report.Supply = 0 - issuance //issuance is minus the supply
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	expected, checked := 0.0, ""
+	if to > now { //nothing can be dated after now, so the counter is the supply at To
+		expected, err = getSupply(stub)
+		if err != nil {
+			return nil, err
+		}
+		checked = "counter"
+	} else {
+		period := time.Unix(to-1, 0).UTC().Format("2006-01")
+		snapshotKey, err := createCompositeKey(periodType, []string{period})
+		if err != nil {
+			return nil, err
+		}
+		snapshotAsBytes, err := stub.GetState(snapshotKey)
+		if err != nil {
+			return nil, errors.New("Failed to get period")
+		}
+		if snapshotAsBytes != nil {
+			var snapshot PeriodSnapshot
+			err = unmarshalState(snapshotKey, snapshotAsBytes, &snapshot)
+			if err != nil {
+				return nil, err
+			}
+			if snapshot.To == to {
+				expected, checked = snapshot.Closing, "snapshot"
+			}
+		}
+	}
+	report.SupplyChecked = checked
+	if checked != "" && math.Abs(expected-report.Supply) > booksTolerance {
+		report.Discrepancies = append(report.Discrepancies, TrialDiscrepancy{Kind: "supply", Expected: expected, Actual: report.Supply})
+	}
+	report.Balanced = len(report.Discrepancies) == 0
+
+	jsonAsBytes, _ := json.Marshal(report)
+	return jsonAsBytes, nil
+}
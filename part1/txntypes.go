@@ -0,0 +1,164 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// TxnClass is the formal type of a transaction record, every record type used by the chaincode maps to exactly one
+type TxnClass string
+
+// The transaction classes, in the order per-type totals are reported
+const (
+	TxnEarn     TxnClass = "EARN"
+	TxnRedeem   TxnClass = "REDEEM"
+	TxnTransfer TxnClass = "TRANSFER"
+	TxnAdjust   TxnClass = "ADJUST"
+	TxnExpire   TxnClass = "EXPIRE"
+	TxnFee      TxnClass = "FEE"
+	TxnReversal TxnClass = "REVERSAL"
+	TxnMint     TxnClass = "MINT"
+	TxnBurn     TxnClass = "BURN"
+)
+
+var txnClassOrder = []TxnClass{TxnEarn, TxnRedeem, TxnTransfer, TxnAdjust, TxnExpire, TxnFee, TxnReversal, TxnMint, TxnBurn}
+
+// record type to its class, a record type missing here cannot be recorded
+var txnClasses = map[string]TxnClass{
+	"earn":        TxnEarn,
+	"grant":       TxnEarn, //vesting and scheduled grants reward a member like an earn, only without a merchant
+	"redeem":      TxnRedeem,
+	"transfer":    TxnTransfer,
+	"merge":       TxnTransfer, //the merged away balance moves to the survivor
+	"adjust":      TxnAdjust,
+	"expire":      TxnExpire,
+	"fee":         TxnFee,
+	"withholding": TxnFee, //tax withheld from an earn for the tax authority
+	"reversal":    TxnReversal,
+	"mint":        TxnMint,
+	"create":      TxnMint, //opening balances booked when an entity is created
+	"badge":       TxnEarn, //bonus points that came with a badge
+	"burn":        TxnBurn,
+}
+
+// TxnTypeTotal is the number of records of one class and the points and cash they moved
+type TxnTypeTotal struct {
+	Class  TxnClass `json:"class"`
+	Count  int      `json:"count"`
+	Points float64  `json:"points"`
+	Amount float64  `json:"amount"`
+}
+
+// ============================================================================================================================
+// txnClassOf - the class of a record type
+// ============================================================================================================================
+func txnClassOf(recType string) (TxnClass, error) {
+	class, ok := txnClasses[recType]
+	if !ok {
+		return "", errors.New("Unknown transaction type " + recType)
+	}
+	return class, nil
+}
+
+// ============================================================================================================================
+// stampTxnClass - fill in the class of a record stored before classes existed
+// ============================================================================================================================
+func stampTxnClass(rec *TxnRecord) {
+	if rec.Class == "" {
+		rec.Class = txnClasses[rec.Type]
+	}
+}
+
+// ============================================================================================================================
+// parseTxnTypes - a comma separated filter of classes ("EARN,REDEEM") or record types ("grant"), nil for an empty
+// filter which matches everything
+// ============================================================================================================================
+func parseTxnTypes(arg string) ([]string, error) {
+	if arg == "" {
+		return nil, nil
+	}
+	var filter []string
+	for _, val := range strings.Split(arg, ",") {
+		val = strings.TrimSpace(val)
+		if _, ok := txnClasses[val]; ok {
+			filter = append(filter, val)
+			continue
+		}
+		class := TxnClass(strings.ToUpper(val))
+		if !hasTxnClass(class) {
+			var names []string
+			for _, c := range txnClassOrder {
+				names = append(names, string(c))
+			}
+			return nil, errors.New("Unknown transaction type " + val + ", expecting one of " + strings.Join(names, ", "))
+		}
+		filter = append(filter, string(class))
+	}
+	return filter, nil
+}
+
+// ============================================================================================================================
+// hasTxnClass - true if the class is one of the transaction classes
+// ============================================================================================================================
+func hasTxnClass(class TxnClass) bool {
+	for _, c := range txnClassOrder {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// matchesTxnTypes - true if the record's class or record type is in the filter, or the filter is empty
+// ============================================================================================================================
+func matchesTxnTypes(rec TxnRecord, filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, val := range filter {
+		if val == rec.Type || TxnClass(val) == rec.Class {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// txnTypeTotals - per class totals of a list of records, every class is listed so callers need not check for gaps
+// ============================================================================================================================
+func txnTypeTotals(records []TxnRecord) []TxnTypeTotal {
+	totals := make([]TxnTypeTotal, len(txnClassOrder))
+	for i, class := range txnClassOrder {
+		totals[i].Class = class
+	}
+	for _, rec := range records {
+		for i := range totals {
+			if totals[i].Class == rec.Class {
+				totals[i].Count++
+				totals[i].Points = totals[i].Points + rec.Points
+				totals[i].Amount = totals[i].Amount + rec.Amount
+			}
+		}
+	}
+	return totals
+}
@@ -0,0 +1,235 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Every invoke runs against a unit of work: writes are collected in memory, reads see the pending writes, and the
+// writes only reach the ledger in one flush after the function returned without error. A function that fails half
+// way therefore never leaves the first leg of a transfer written without the second, whatever the peer does with
+// failed transactions, and a query can never write at all since its unit of work is never flushed.
+type unitOfWork struct {
+	stub   *shim.ChaincodeStub
+	writes map[string]pendingWrite
+	order  []string       //keys in the order first written, so the flush is deterministic
+	nextID int            //sequence number of the next child record id handed out by newID
+	events []EventPayload //raised so far, published together by flush
+}
+
+// pendingWrite is a buffered PutState, or a DelState when deleted is set
+type pendingWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// savepoint is what a unit of work had buffered at some point, an attempt that fails part way can be rolled back to
+// it without failing the whole invocation
+type savepoint struct {
+	writes map[string]pendingWrite
+	order  int
+	events int
+}
+
+// programIterator walks a range scan merged with the pending writes as it goes, so a caller that stops early only read
+// the ledger keys it used. Keys have the program prefix removed so callers can split them as composite keys.
+type programIterator struct {
+	ledger  *shim.StateRangeQueryIterator
+	pending []string //pending keys in range when the scan started, in key order
+	writes  []pendingWrite
+	prefix  string
+
+	ledgerKey string //ledger key read ahead but not yet merged, if peeked
+	ledgerVal []byte
+	peeked    bool
+
+	key   string //next key to hand out, if ready
+	val   []byte
+	ready bool
+	err   error
+}
+
+// ============================================================================================================================
+// newUnitOfWork - an empty unit of work over the chaincode stub
+// ============================================================================================================================
+func newUnitOfWork(stub *shim.ChaincodeStub) *unitOfWork {
+	return &unitOfWork{stub: stub, writes: map[string]pendingWrite{}}
+}
+
+// ============================================================================================================================
+// get - the pending value of a key, or the ledger value if it was not written in this unit of work
+// ============================================================================================================================
+func (u *unitOfWork) get(key string) ([]byte, error) {
+	if w, ok := u.writes[key]; ok {
+		if w.deleted {
+			return nil, nil
+		}
+		return w.value, nil
+	}
+	return u.stub.GetState(key)
+}
+
+// ============================================================================================================================
+// put - buffer a write
+// ============================================================================================================================
+func (u *unitOfWork) put(key string, value []byte, deleted bool) error {
+	if len(key) <= 0 {
+		return errors.New("Key must not be empty")
+	}
+	if _, ok := u.writes[key]; !ok {
+		u.order = append(u.order, key)
+	}
+	u.writes[key] = pendingWrite{append([]byte(nil), value...), deleted}
+	return nil
+}
+
+// ============================================================================================================================
+// rangeQuery - the ledger keys in [startKey, endKey) overlaid with the pending writes, in key order
+// ============================================================================================================================
+func (u *unitOfWork) rangeQuery(startKey string, endKey string, prefix string) (*programIterator, error) {
+	keysIter, err := u.stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &programIterator{ledger: keysIter, prefix: prefix}
+	for key := range u.writes {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			it.pending = append(it.pending, key)
+		}
+	}
+	sort.Strings(it.pending)
+	for _, key := range it.pending {
+		it.writes = append(it.writes, u.writes[key])
+	}
+	return it, nil
+}
+
+// ============================================================================================================================
+// savepoint - remember the pending writes and events so far
+// ============================================================================================================================
+func (u *unitOfWork) savepoint() savepoint {
+	writes := make(map[string]pendingWrite, len(u.writes))
+	for key, w := range u.writes {
+		writes[key] = w
+	}
+	return savepoint{writes, len(u.order), len(u.events)}
+}
+
+// ============================================================================================================================
+// rollback - drop every write and event buffered since the savepoint, ids handed out since stay used
+// ============================================================================================================================
+func (u *unitOfWork) rollback(sp savepoint) {
+	u.writes = sp.writes
+	u.order = u.order[:sp.order]
+	u.events = u.events[:sp.events]
+}
+
+// ============================================================================================================================
+// flush - write every pending change to the ledger, once, in the order the keys were first written
+// ============================================================================================================================
+func (u *unitOfWork) flush() error {
+	for _, key := range u.order {
+		w := u.writes[key]
+		var err error
+		if w.deleted {
+			err = u.stub.DelState(key)
+		} else {
+			err = u.stub.PutState(key, w.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	u.writes = map[string]pendingWrite{}
+	u.order = nil
+	if len(u.events) > 0 { //left in place for the receipt
+		jsonAsBytes, _ := json.Marshal(u.events)
+		return u.stub.SetEvent(u.events[0].Name, jsonAsBytes)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// advance - merge the next ledger key with the pending writes, a pending write replaces the ledger key it equals and a
+// pending delete hides it
+// ============================================================================================================================
+func (it *programIterator) advance() {
+	for !it.ready && it.err == nil {
+		if !it.peeked && it.ledger.HasNext() {
+			it.ledgerKey, it.ledgerVal, it.err = it.ledger.Next()
+			if it.err != nil {
+				return
+			}
+			it.peeked = true
+		}
+		if len(it.pending) > 0 && (!it.peeked || it.pending[0] <= it.ledgerKey) {
+			key, w := it.pending[0], it.writes[0]
+			it.pending, it.writes = it.pending[1:], it.writes[1:]
+			if it.peeked && key == it.ledgerKey {
+				it.peeked = false
+			}
+			if !w.deleted {
+				it.key, it.val, it.ready = key, w.value, true
+			}
+		} else if it.peeked {
+			it.key, it.val, it.ready = it.ledgerKey, it.ledgerVal, true
+			it.peeked = false
+		} else {
+			return
+		}
+	}
+}
+
+// ============================================================================================================================
+// HasNext - whether Next has another key, or an error to return
+// ============================================================================================================================
+func (it *programIterator) HasNext() bool {
+	it.advance()
+	return it.ready || it.err != nil
+}
+
+// ============================================================================================================================
+// Next - the next key, without the program prefix, and its value
+// ============================================================================================================================
+func (it *programIterator) Next() (string, []byte, error) {
+	it.advance()
+	if it.err != nil {
+		return "", nil, it.err
+	}
+	if !it.ready {
+		return "", nil, errors.New("Range query iterator is exhausted")
+	}
+	it.ready = false
+	return strings.TrimPrefix(it.key, it.prefix), it.val, nil
+}
+
+// ============================================================================================================================
+// Close - release the underlying ledger iterator
+// ============================================================================================================================
+func (it *programIterator) Close() error {
+	return it.ledger.Close()
+}
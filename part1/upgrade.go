@@ -0,0 +1,75 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+// The v0.5 shim has no chaincode upgrade lifecycle and contractapi is not available to this tree, so Init only ever
+// seeds a fresh program and upgrading is an explicit admin invoke run once the new build is deployed. migrations maps
+// a schema version to the step that brings a ledger from the version before it up to that one, every step must keep
+// all existing data.
+var migrations = map[int]func(stub *programStub) error{
+	1: func(stub *programStub) error { return nil }, //ledgers from before the schema version was recorded are already v1
+}
+
+// ============================================================================================================================
+// Upgrade - admin only, run every migration between the ledger's schema version and this build's, returns the version
+// ============================================================================================================================
+func (t *SimpleChaincode) upgrade(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 0")
+	}
+	initialized, err := ledgerInitialized(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !initialized {
+		return nil, errors.New("Ledger is not initialized, use init_ledger")
+	}
+	ledgerVersion, err := getLedgerSchemaVersion(stub)
+	if err != nil {
+		return nil, err
+	}
+	if ledgerVersion > schemaVersion {
+		return nil, errors.New("Ledger schema " + strconv.Itoa(ledgerVersion) + " is newer than this build's " + strconv.Itoa(schemaVersion))
+	}
+
+	stub.log.debug("start upgrade")
+	for version := ledgerVersion + 1; version <= schemaVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, errors.New("No migration to schema " + strconv.Itoa(version))
+		}
+		err = migrate(stub)
+		if err != nil {
+			return nil, errors.New("Migration to schema " + strconv.Itoa(version) + " failed: " + err.Error())
+		}
+		stub.log.info("migrated to schema " + strconv.Itoa(version))
+	}
+	err = putLedgerSchemaVersion(stub)
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end upgrade")
+	return []byte(strconv.Itoa(schemaVersion)), nil
+}
@@ -0,0 +1,164 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// chaincodeVersion and schemaVersion are bumped by hand with each release, buildCommit is stamped at build time with
+// go build -ldflags "-X main.buildCommit=<commit>"
+var chaincodeVersion = "1.4.0"
+var schemaVersion = 1 //layout of the records in world state, bumped whenever a release needs a data migration
+var buildCommit = "unknown"
+
+var schemaVersionStr = systemKeyPrefix + "schema_version" //name for the key/value that records the schema the ledger was written with
+var maxSelfCheckProblems = 20                             //stop collecting once this many problems were found
+var forceResetArg = "--force-reset"                       //extra Init argument that allows resetting an initialized ledger
+
+// VersionInfo is the answer to version and ping
+type VersionInfo struct {
+	Version        string   `json:"version"`
+	SchemaVersion  int      `json:"schema_version"`        //schema this build writes
+	LedgerSchema   int      `json:"ledger_schema_version"` //schema recorded on the ledger, 0 if never recorded
+	BuildCommit    string   `json:"build_commit"`
+	Healthy        bool     `json:"healthy"`
+	EntitiesTested int      `json:"entities_tested,omitempty"`
+	Problems       []string `json:"problems,omitempty"`
+}
+
+// ============================================================================================================================
+// getLedgerSchemaVersion - the schema version recorded on the ledger, 0 for ledgers written before it was recorded
+// ============================================================================================================================
+func getLedgerSchemaVersion(stub *programStub) (int, error) {
+	versionAsBytes, err := stub.GetState(schemaVersionStr)
+	if err != nil {
+		return 0, errors.New("Failed to get schema version")
+	}
+	if versionAsBytes == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(versionAsBytes))
+	if err != nil {
+		return 0, stateCorruption(schemaVersionStr, err)
+	}
+	return version, nil
+}
+
+// ============================================================================================================================
+// ledgerInitialized - whether Init already ran against this program's state, ledgers from before the schema version was
+// recorded are recognised by their config or a non-empty entity index
+// ============================================================================================================================
+func ledgerInitialized(stub *programStub) (bool, error) {
+	for _, key := range []string{schemaVersionStr, configStr} {
+		valAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return false, errors.New("Failed to get " + key)
+		}
+		if valAsBytes != nil {
+			return true, nil
+		}
+	}
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return false, err
+	}
+	return len(entityIndex) > 0, nil
+}
+
+// ============================================================================================================================
+// putLedgerSchemaVersion - record that the ledger now follows this build's schema
+// ============================================================================================================================
+func putLedgerSchemaVersion(stub *programStub) error {
+	return stub.PutState(schemaVersionStr, []byte(strconv.Itoa(schemaVersion)))
+}
+
+// ============================================================================================================================
+// selfCheck - look for state this build cannot make sense of: an unreadable config or entity index, indexed entities
+// that are missing or undecodable, and balances that break their invariants
+// ============================================================================================================================
+func selfCheck(stub *programStub) (int, []string) {
+	var problems []string
+	if _, err := getConfig(stub); err != nil {
+		problems = append(problems, err.Error())
+	}
+	entityIndex, err := getEntityIndex(stub)
+	if err != nil {
+		return 0, append(problems, err.Error())
+	}
+
+	tested := 0
+	for _, name := range entityIndex {
+		if len(problems) >= maxSelfCheckProblems {
+			break
+		}
+		tested++
+		valAsBytes, err := stub.GetState(name)
+		if err != nil || valAsBytes == nil {
+			problems = append(problems, "Indexed entity "+name+" is missing")
+			continue
+		}
+		var entity Entity
+		err = json.Unmarshal(valAsBytes, &entity)
+		if err != nil {
+			problems = append(problems, "Entity "+name+" cannot be decoded")
+			continue
+		}
+		if entity.Name != name {
+			problems = append(problems, "Entity stored under "+name+" is named "+entity.Name)
+		}
+		if entity.PtBal < 0 || entity.Locked < 0 || entity.Unvested < 0 || entity.Locked+entity.Unvested+restrictedTotal(entity) > entity.PtBal {
+			problems = append(problems, "Entity "+name+" has inconsistent point balances")
+		}
+	}
+	return tested, problems
+}
+
+// ============================================================================================================================
+// Ping - cheap liveness check reporting which build answered
+// ============================================================================================================================
+func (t *SimpleChaincode) ping(stub *programStub, args []string) ([]byte, error) {
+	jsonAsBytes, _ := json.Marshal(VersionInfo{Version: chaincodeVersion, SchemaVersion: schemaVersion, BuildCommit: buildCommit, Healthy: true})
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Version - the build a peer is running, the schema it found on the ledger and a state consistency self-check
+// ============================================================================================================================
+func (t *SimpleChaincode) version(stub *programStub, args []string) ([]byte, error) {
+	info := VersionInfo{Version: chaincodeVersion, SchemaVersion: schemaVersion, BuildCommit: buildCommit}
+	ledgerSchema, err := getLedgerSchemaVersion(stub)
+	if err != nil {
+		info.Problems = append(info.Problems, err.Error())
+	}
+	info.LedgerSchema = ledgerSchema
+	if ledgerSchema > schemaVersion {
+		info.Problems = append(info.Problems, "Ledger was written by a newer schema than this build understands")
+	}
+	tested, problems := selfCheck(stub)
+	info.EntitiesTested = tested
+	info.Problems = append(info.Problems, problems...)
+	info.Healthy = len(info.Problems) == 0
+
+	jsonAsBytes, _ := json.Marshal(info)
+	return jsonAsBytes, nil
+}
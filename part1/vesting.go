@@ -0,0 +1,249 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+)
+
+var vestingType = "vesting" //composite key object type for vesting grants, keyed by entity then grant id
+var secondsPerDay = int64(24 * 60 * 60)
+
+// VestingGrant credits points that unlock linearly from the start to the end once the cliff has passed
+type VestingGrant struct {
+	ID       string  `json:"id"`
+	Entity   string  `json:"entity"`
+	Points   float64 `json:"points"`
+	Released float64 `json:"released"` //points vested so far, the rest counts in the entity's Unvested
+	Start    int64   `json:"start"`
+	CliffAt  int64   `json:"cliff_at"` //nothing vests before this time
+	EndAt    int64   `json:"end_at"`   //everything has vested at this time
+	Reason   string  `json:"reason"`
+}
+
+// ============================================================================================================================
+// vestedPoints - how much of a grant has vested by the given time
+// ============================================================================================================================
+func vestedPoints(bounds AmountBounds, grant VestingGrant, now int64) float64 {
+	if now < grant.CliffAt {
+		return 0
+	}
+	if now >= grant.EndAt {
+		return grant.Points
+	}
+	vested := roundPoints(bounds, grant.Points*float64(now-grant.Start)/float64(grant.EndAt-grant.Start))
+	return math.Min(vested, grant.Points)
+}
+
+// ============================================================================================================================
+// releaseVested - release whatever the entity's grants have vested by the tx timestamp into its spendable points, the
+// caller writes the entity. Grants that have fully vested are deleted.
+// ============================================================================================================================
+func releaseVested(stub *programStub, entity *Entity) (float64, error) {
+	if entity.Unvested <= 0 {
+		return 0, nil
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return 0, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return 0, err
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, vestingType, []string{entity.Name})
+	if err != nil {
+		return 0, errors.New("Failed to get vesting grants")
+	}
+	var keys []string
+	var grants []VestingGrant
+	for keysIter.HasNext() {
+		key, grantAsBytes, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return 0, errors.New("Failed to get vesting grants")
+		}
+		var grant VestingGrant
+		err = unmarshalState(key, grantAsBytes, &grant)
+		if err != nil {
+			keysIter.Close()
+			return 0, err
+		}
+		keys = append(keys, key)
+		grants = append(grants, grant)
+	}
+	keysIter.Close()
+
+	var released float64
+	for i, grant := range grants {
+		vested := vestedPoints(config.Bounds, grant, now)
+		if vested <= grant.Released {
+			continue
+		}
+		released = released + vested - grant.Released
+		grant.Released = vested
+		if grant.Released >= grant.Points {
+			err = stub.DelState(keys[i])
+		} else {
+			grantAsBytes, _ := json.Marshal(grant)
+			err = stub.PutState(keys[i], grantAsBytes)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	entity.Unvested = entity.Unvested - released
+	if entity.Unvested < 1e-9 { //float noise once everything has vested
+		entity.Unvested = 0
+	}
+	return released, nil
+}
+
+// ============================================================================================================================
+// Grant Vesting - admin only, credit new points that cannot be spent until they vest, nothing before the cliff and
+// then linearly until the end of the vesting period
+// ============================================================================================================================
+func (t *SimpleChaincode) grantVesting(stub *programStub, args []string) ([]byte, error) {
+	//   0        1           2              3            4
+	// "Name", "Points", "CliffDays", "VestingDays", "Reason"
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5")
+	}
+	points, err := parseAmount(args[1])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("2nd argument must be a positive numeric string", err)
+	}
+	cliff, err := strconv.ParseInt(args[2], 10, 64)
+	if (err != nil) || (cliff < 0) {
+		return nil, errors.New("3rd argument must be a non-negative integer")
+	}
+	days, err := strconv.ParseInt(args[3], 10, 64)
+	if (err != nil) || (days <= 0) || (days < cliff) {
+		return nil, errors.New("4th argument must be a positive integer no smaller than the cliff")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDirectMint(config)
+	if err != nil {
+		return nil, err
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	stub.log.debug("start grant vesting")
+	grant := VestingGrant{newID(stub, vestingType), entity.Name, points, 0, now, now + cliff*secondsPerDay, now + days*secondsPerDay, args[4]}
+	key, err := createCompositeKey(vestingType, []string{entity.Name, grant.ID})
+	if err != nil {
+		return nil, err
+	}
+	jsonAsBytes, _ := json.Marshal(grant)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	before := entity
+	entity.PtBal = entity.PtBal + points
+	entity.Unvested = entity.Unvested + points
+	err = putEntity(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	err = recordTxn(stub, TxnRecord{Type: "grant", To: entity.Name, Points: points, Reference: grant.ID + ":" + grant.Reason}, balanceChange(before, entity))
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(stub, points, "grant")
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end grant vesting")
+	return []byte(grant.ID), nil
+}
+
+// ============================================================================================================================
+// Vest Points - release an entity's vested points so they can be spent, returns the points released. Transfers and
+// redemptions release vested points on their own so calling this is only needed to refresh the balance shown.
+// ============================================================================================================================
+func (t *SimpleChaincode) vestPoints(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	released, err := releaseVested(stub, &entity)
+	if err != nil {
+		return nil, err
+	}
+	if released > 0 {
+		err = putEntity(stub, entity)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []byte(formatAmount(released)), nil
+}
+
+// ============================================================================================================================
+// List Vesting - an entity's grants that have not fully vested yet
+// ============================================================================================================================
+func (t *SimpleChaincode) listVesting(stub *programStub, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the entity to query")
+	}
+	entity, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := getStateByPartialCompositeKey(stub, vestingType, []string{entity.Name})
+	if err != nil {
+		return nil, errors.New("Failed to get vesting grants")
+	}
+	defer keysIter.Close()
+
+	grants := []VestingGrant{}
+	for keysIter.HasNext() {
+		key, grantAsBytes, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get vesting grants")
+		}
+		var grant VestingGrant
+		err = unmarshalState(key, grantAsBytes, &grant)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	jsonAsBytes, _ := json.Marshal(grants)
+	return jsonAsBytes, nil
+}
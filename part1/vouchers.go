@@ -0,0 +1,113 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A merchant without connectivity signs an earn voucher offline and hands it to the customer, who claims it later.
+// The merchant signs, with its current registered public key, the sha256 of
+//
+//	earn_voucher|<program>|<merchant>|<customer>|<points>|<nonce>
+//
+// and each nonce pays out once per merchant. The program id is empty for the root program; naming it keeps a voucher
+// from being claimed in another program hosting a merchant of the same name.
+var voucherType = "voucher" //composite key object type for claimed vouchers, keyed by merchant then nonce
+var maxVoucherNonceLen = 64
+
+// VoucherClaim records that a merchant's voucher nonce was paid out
+type VoucherClaim struct {
+	Merchant  string  `json:"merchant"`
+	Customer  string  `json:"customer"`
+	Points    float64 `json:"points"`
+	Nonce     string  `json:"nonce"`
+	TxID      string  `json:"txid"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// ============================================================================================================================
+// voucherMessage - the string a merchant signs for an earn voucher
+// ============================================================================================================================
+func voucherMessage(stub *programStub, merchant string, customer string, points string, nonce string) string {
+	return strings.Join([]string{"earn_voucher", stub.program, merchant, customer, points, nonce}, "|")
+}
+
+// ============================================================================================================================
+// Claim Signed Earn - credit a customer with the points of an earn voucher signed off-chain by a merchant
+// ============================================================================================================================
+func (t *SimpleChaincode) claimSignedEarn(stub *programStub, args []string) ([]byte, error) {
+	//    0           1          2        3           4
+	// "Merchant", "Customer", "Points", "Nonce", "Signature"
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 5")
+	}
+	points, err := parseAmount(args[2])
+	if (err != nil) || (points <= 0) {
+		return nil, amountError("3rd argument must be a positive numeric string", err)
+	}
+	if len(args[3]) <= 0 || len(args[3]) > maxVoucherNonceLen || strings.Contains(args[3], "|") {
+		return nil, errors.New("4th argument must be a nonce of 1 to " + strconv.Itoa(maxVoucherNonceLen) + " bytes without |")
+	}
+	merchant, err := getEntity(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	customer, err := getEntity(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	//the voucher names the accounts as the merchant knew them, the key is the merchant's current one
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	_, err = verifyEntitySignature(stub, merchant.Name, voucherMessage(stub, args[0], args[1], args[2], args[3]), args[4], timestamp)
+	if err != nil {
+		return nil, err
+	}
+	key, err := createCompositeKey(voucherType, []string{merchant.Name, args[3]})
+	if err != nil {
+		return nil, err
+	}
+	claimedAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get voucher")
+	}
+	if claimedAsBytes != nil {
+		return nil, errors.New("Voucher " + args[3] + " of " + merchant.Name + " was already claimed")
+	}
+
+	stub.log.debug("start claim signed earn")
+	jsonAsBytes, _ := json.Marshal(VoucherClaim{merchant.Name, customer.Name, points, args[3], stub.UUID, timestamp})
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.earnPoints(stub, []string{merchant.Name, customer.Name, args[2], "0", "voucher:" + args[3]})
+	if err != nil {
+		return nil, err
+	}
+	stub.log.debug("end claim signed earn")
+	return nil, nil
+}